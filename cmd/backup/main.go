@@ -8,11 +8,51 @@ import (
 	cli "github.com/urfave/cli/v2"
 )
 
+func kmsFlags() []cli.Flag {
+	return []cli.Flag{
+		&cli.StringFlag{
+			Name:    "wrapped-restic-password",
+			Usage:   "Base64 encoded restic password wrapped with Cloud KMS, takes precedence over restic-password",
+			EnvVars: []string{"RESTIC_WRAPPED_PASSWORD"},
+		},
+		&cli.StringFlag{
+			Name:    "kms-key",
+			Usage:   "Full resource name of the Cloud KMS key that unwraps the restic password",
+			EnvVars: []string{"RESTIC_PASSWORD_KMS_KEY"},
+		},
+		&cli.StringFlag{
+			Name:    "credentials",
+			Usage:   "Path to the Google Cloud credentials file used for Cloud KMS",
+			EnvVars: []string{"GOOGLE_APPLICATION_CREDENTIALS"},
+		},
+	}
+}
+
+func hookFlags() []cli.Flag {
+	return []cli.Flag{
+		&cli.StringFlag{
+			Name:    "hooks",
+			Usage:   "YAML file with pre and post backup hooks",
+			EnvVars: []string{"BACKUP_HOOKS_FILE"},
+		},
+		&cli.StringFlag{
+			Name:    "report",
+			Usage:   "File to write the JSON run report, including hook results",
+			EnvVars: []string{"BACKUP_REPORT_FILE"},
+		},
+		&cli.StringFlag{
+			Name:    "kubeconfig",
+			Usage:   "Path to the kubeconfig file used by scale hooks, uses the in-cluster configuration if empty",
+			EnvVars: []string{"KUBECONFIG"},
+		},
+	}
+}
+
 func getArangoDBBackupCommand() *cli.Command {
 	return &cli.Command{
 		Name:  "arangodb-backup",
 		Usage: "Backup ArangoDB database",
-		Flags: []cli.Flag{
+		Flags: append([]cli.Flag{
 			&cli.StringFlag{
 				Name:     "user",
 				Aliases:  []string{"u"},
@@ -55,7 +95,7 @@ func getArangoDBBackupCommand() *cli.Command {
 				Usage:   "Restic repository password (reads from RESTIC_PASSWORD env var if not provided)",
 				EnvVars: []string{"RESTIC_PASSWORD"},
 			},
-		},
+		}, append(kmsFlags(), hookFlags()...)...),
 		Action: func(cCtx *cli.Context) error {
 			return backup.ArangoDBBackupAction(cCtx, cCtx.Int("port"))
 		},
@@ -66,7 +106,7 @@ func getRedisBackupCommand() *cli.Command {
 	return &cli.Command{
 		Name:  "redis-backup",
 		Usage: "Backup Redis database",
-		Flags: []cli.Flag{
+		Flags: append([]cli.Flag{
 			&cli.StringFlag{
 				Name:     "host",
 				Usage:    "Redis host address",
@@ -89,7 +129,7 @@ func getRedisBackupCommand() *cli.Command {
 				Usage:   "Restic repository password (reads from RESTIC_PASSWORD env var if not provided)",
 				EnvVars: []string{"RESTIC_PASSWORD"},
 			},
-		},
+		}, append(kmsFlags(), hookFlags()...)...),
 		Action: backup.RedisBackupAction,
 	}
 }
@@ -98,6 +138,12 @@ func setupApp() *cli.App {
 	return &cli.App{
 		Name:  "backup",
 		Usage: "Backup tools for ArangoDB and Redis databases",
+		Flags: []cli.Flag{
+			&cli.BoolFlag{
+				Name:  "dry-run",
+				Usage: "Check connectivity and print the backup commands without running them, needs restic 0.17 or later",
+			},
+		},
 		Commands: []*cli.Command{
 			getArangoDBBackupCommand(),
 			getRedisBackupCommand(),
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"io"
+	"log/slog"
+
+	"github.com/dictybase-docker/cluster-ops/internal/custodian"
+	"github.com/urfave/cli/v2"
+)
+
+func backupAuditCommand(logger *slog.Logger) *cli.Command {
+	return &cli.Command{
+		Name:  "backup-audit",
+		Usage: "Show which resources, pod volumes and claims the velero schedules back up",
+		Flags: append(namespacesFlags(), []cli.Flag{
+			&cli.StringFlag{
+				Name:  "velero-namespace",
+				Usage: "Namespace of the velero schedules and backups",
+				Value: "velero",
+			},
+			&cli.StringFlag{
+				Name:  "schedule",
+				Usage: "Only audit this schedule",
+			},
+			&cli.StringFlag{
+				Name:  "label",
+				Usage: "Backup exclusion label as key=value",
+				Value: "velero.io/exclude-from-backup=true",
+			},
+			outputFormatFlag(),
+		}...),
+		Action: func(cliCtx *cli.Context) error {
+			key, value, err := custodian.ParseLabel(cliCtx.String("label"))
+			if err != nil {
+				return cli.Exit(err.Error(), 2)
+			}
+			opts := custodian.BackupAuditOptions{
+				Format:          cliCtx.String("output"),
+				VeleroNamespace: cliCtx.String("velero-namespace"),
+				Schedule:        cliCtx.String("schedule"),
+				Namespaces:      selectedNamespaces(cliCtx),
+				LabelKey:        key,
+				LabelValue:      value,
+			}
+			if err := custodian.ValidateFormat(opts.Format); err != nil {
+				return cli.Exit(err.Error(), 2)
+			}
+			config := newCustodianConfig(cliCtx, logger)
+			config.Namespace = ""
+			return forEachCluster(
+				cliCtx,
+				config,
+				opts.Format,
+				func(_ string, cus *custodian.Custodian, out io.Writer) error {
+					if err := cus.BackupAudit(cliCtx.Context, opts, out); err != nil {
+						return cli.Exit(err.Error(), 2)
+					}
+					return nil
+				},
+			)
+		},
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"time"
+
+	"github.com/dictybase-docker/cluster-ops/internal/custodian"
+	"github.com/urfave/cli/v2"
+)
+
+func certsCommand(logger *slog.Logger) *cli.Command {
+	return &cli.Command{
+		Name:  "certs",
+		Usage: "Report the expiry of TLS secrets, the ingresses using them and ingress hosts without a matching certificate",
+		Flags: append(namespacesFlags(), []cli.Flag{
+			&cli.IntFlag{
+				Name:  "threshold",
+				Usage: "Exit with an error when a certificate expires in fewer days",
+				Value: 14,
+			},
+			outputFormatFlag(),
+		}...),
+		Action: func(cliCtx *cli.Context) error {
+			format := cliCtx.String("output")
+			if err := custodian.ValidateFormat(format); err != nil {
+				return cli.Exit(err.Error(), 2)
+			}
+			config := newCustodianConfig(cliCtx, logger)
+			config.Namespace = ""
+			threshold := cliCtx.Int("threshold")
+			return forEachCluster(
+				cliCtx,
+				config,
+				format,
+				func(_ string, cus *custodian.Custodian, out io.Writer) error {
+					report, err := cus.FindCertificates(
+						cliCtx.Context,
+						selectedNamespaces(cliCtx),
+						time.Now(),
+					)
+					if err != nil {
+						return cli.Exit(err.Error(), 2)
+					}
+					if err := custodian.WriteCertificates(out, format, report); err != nil {
+						return cli.Exit(err.Error(), 2)
+					}
+					if expiring := report.Expiring(threshold); len(expiring) > 0 {
+						return cli.Exit(
+							fmt.Sprintf(
+								"%d certificates expire within %d days or are invalid",
+								len(expiring),
+								threshold,
+							),
+							1,
+						)
+					}
+					return nil
+				},
+			)
+		},
+	}
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/dictybase-docker/cluster-ops/internal/custodian"
+	"github.com/urfave/cli/v2"
+)
+
+// clusterFlags select the kubeconfig contexts a command runs against
+func clusterFlags() []cli.Flag {
+	return []cli.Flag{
+		&cli.StringSliceFlag{
+			Name:  "context",
+			Usage: "Kubeconfig context to use instead of the current one, can be repeated to run against several clusters",
+		},
+		&cli.BoolFlag{
+			Name:  "all-contexts",
+			Usage: "Run against every context of the kubeconfig",
+		},
+	}
+}
+
+// kubeContexts returns the selected contexts, a single empty name stands
+// for the current context or the in-cluster config
+func kubeContexts(cliCtx *cli.Context) ([]string, error) {
+	if cliCtx.Bool("all-contexts") {
+		return custodian.KubeContexts(cliCtx.String("kubeconfig"))
+	}
+	if contexts := cliCtx.StringSlice("context"); len(contexts) > 0 {
+		return contexts, nil
+	}
+	return []string{""}, nil
+}
+
+// clusterRunFunc runs a command against one cluster, cluster is empty when
+// a single cluster is selected
+type clusterRunFunc func(cluster string, cus *custodian.Custodian, out io.Writer) error
+
+// forEachCluster runs the command against a single cluster with its output
+// going to stdout as is. With several clusters it runs concurrently against
+// each of them, merges their output with a cluster column and reports the
+// clusters that failed without stopping the others.
+func forEachCluster(
+	cliCtx *cli.Context,
+	config custodian.CustodianConfig,
+	format string,
+	run clusterRunFunc,
+) error {
+	return runClusters(cliCtx, config, format, false, run)
+}
+
+// streamEachCluster is forEachCluster for commands that follow logs, the
+// lines of every cluster are written as they come with a cluster prefix
+// instead of once all clusters are done
+func streamEachCluster(
+	cliCtx *cli.Context,
+	config custodian.CustodianConfig,
+	run clusterRunFunc,
+) error {
+	return runClusters(cliCtx, config, custodian.FormatTable, true, run)
+}
+
+func runClusters(
+	cliCtx *cli.Context,
+	config custodian.CustodianConfig,
+	format string,
+	stream bool,
+	run clusterRunFunc,
+) error {
+	contexts, err := kubeContexts(cliCtx)
+	if err != nil {
+		return cli.Exit(err.Error(), 2)
+	}
+	if len(contexts) == 1 {
+		config.Context = contexts[0]
+		cus, err := custodian.NewCustodian(config)
+		if err != nil {
+			return cli.Exit(err.Error(), 2)
+		}
+		return run("", cus, os.Stdout)
+	}
+
+	outputs := make([]*custodian.ClusterOutput, len(contexts))
+	var wg sync.WaitGroup
+	var stdoutMu sync.Mutex
+	for idx, name := range contexts {
+		output := &custodian.ClusterOutput{Cluster: name}
+		if stream {
+			output = custodian.StreamClusterOutput(name, os.Stdout, &stdoutMu)
+		}
+		outputs[idx] = output
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			clusterConfig := config
+			clusterConfig.Context = output.Cluster
+			clusterConfig.Logger = config.Logger.With("cluster", output.Cluster)
+			clusterConfig.LogOptions.OutputDir = clusterPath(
+				config.LogOptions.OutputDir,
+				output.Cluster,
+			)
+			cus, err := custodian.NewCustodian(clusterConfig)
+			if err != nil {
+				output.Err = err
+				return
+			}
+			output.Err = errors.Join(run(output.Cluster, cus, output), output.Flush())
+		}()
+	}
+	wg.Wait()
+	if err := custodian.WriteClusterOutputs(os.Stdout, os.Stderr, format, outputs); err != nil {
+		return cli.Exit(err.Error(), 2)
+	}
+	return clusterExit(config.Logger, outputs)
+}
+
+// clusterExit logs the error of every failed cluster and exits with the
+// highest exit code among them
+func clusterExit(logger *slog.Logger, outputs []*custodian.ClusterOutput) error {
+	failed, code := 0, 0
+	for _, output := range outputs {
+		if output.Err == nil {
+			continue
+		}
+		failed++
+		logger.Error("Command failed", "cluster", output.Cluster, "error", output.Err)
+		clusterCode := 2
+		var exitErr cli.ExitCoder
+		if errors.As(output.Err, &exitErr) {
+			clusterCode = exitErr.ExitCode()
+		}
+		code = max(code, clusterCode)
+	}
+	if failed == 0 {
+		return nil
+	}
+	return cli.Exit(fmt.Sprintf("failed on %d of %d clusters", failed, len(outputs)), code)
+}
+
+// clusterPath keeps the files of each cluster in their own directory when
+// several clusters are selected
+func clusterPath(dir, cluster string) string {
+	if len(dir) == 0 || len(cluster) == 0 {
+		return dir
+	}
+	return filepath.Join(dir, cluster)
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/dictybase-docker/cluster-ops/internal/custodian"
+	"github.com/urfave/cli/v2"
+)
+
+func controllerCommand(logger *slog.Logger) *cli.Command {
+	hostname, _ := os.Hostname()
+	return &cli.Command{
+		Name:  "controller",
+		Usage: "Watch namespaces and apply the backup exclusion label and volume excludes annotation as resources change",
+		Flags: append(backupLabelFlags(), []cli.Flag{
+			&cli.StringSliceFlag{
+				Name:  "resource",
+				Usage: "Resource to label as resource or resource.group, can be repeated",
+				Value: cli.NewStringSlice(
+					"pods",
+					"persistentvolumeclaims",
+					"services",
+					"secrets",
+					"configmaps",
+					"serviceaccounts",
+					"deployments.apps",
+					"statefulsets.apps",
+					"replicasets.apps",
+				),
+			},
+			&cli.StringSliceFlag{
+				Name:  "volume-types",
+				Usage: "Volume types to add to the volume excludes annotation (secret, configMap, projected, emptyDir, ephemeral)",
+				Value: cli.NewStringSlice(custodian.DefaultVolumeTypes...),
+			},
+			&cli.StringSliceFlag{
+				Name:  "storage-class",
+				Usage: "Add persistent volume claims of this storage class to the volume excludes annotation, can be repeated",
+			},
+			&cli.StringFlag{
+				Name:  "address",
+				Usage: "Address serving /healthz, /readyz and /metrics",
+				Value: ":8080",
+			},
+			&cli.DurationFlag{
+				Name:  "resync",
+				Usage: "Interval to re-apply the rules to every watched resource",
+				Value: 10 * time.Minute,
+			},
+			&cli.BoolFlag{
+				Name:  "leader-elect",
+				Usage: "Only run the rules in the replica holding the lease",
+			},
+			&cli.StringFlag{
+				Name:  "lease-name",
+				Usage: "Name of the leader election lease",
+				Value: "custodian-controller",
+			},
+			&cli.StringFlag{
+				Name:    "lease-namespace",
+				Usage:   "Namespace of the leader election lease",
+				EnvVars: []string{"POD_NAMESPACE"},
+				Value:   "default",
+			},
+			&cli.StringFlag{
+				Name:    "identity",
+				Usage:   "Identity of this replica in the leader election",
+				EnvVars: []string{"POD_NAME"},
+				Value:   hostname,
+			},
+		}...),
+		Action: func(cliCtx *cli.Context) error {
+			label, err := newBackupLabelOptions(cliCtx)
+			if err != nil {
+				return cli.Exit(err.Error(), 2)
+			}
+			opts := custodian.ControllerOptions{
+				Namespaces: label.Namespaces,
+				Resources:  cliCtx.StringSlice("resource"),
+				Label:      label,
+				Volumes: custodian.VolumeExcludeOptions{
+					VolumeTypes:    cliCtx.StringSlice("volume-types"),
+					StorageClasses: cliCtx.StringSlice("storage-class"),
+					DryRun:         label.DryRun,
+				},
+				Address:        cliCtx.String("address"),
+				Resync:         cliCtx.Duration("resync"),
+				LeaderElection: cliCtx.Bool("leader-elect"),
+				LeaseName:      cliCtx.String("lease-name"),
+				LeaseNamespace: cliCtx.String("lease-namespace"),
+				Identity:       cliCtx.String("identity"),
+			}
+			if err := custodian.ValidateVolumeTypes(opts.Volumes.VolumeTypes); err != nil {
+				return cli.Exit(err.Error(), 2)
+			}
+			// the controller serves one cluster, it is deployed to each of them
+			contexts, err := kubeContexts(cliCtx)
+			if err != nil {
+				return cli.Exit(err.Error(), 2)
+			}
+			if len(contexts) > 1 {
+				return cli.Exit("the controller runs against a single context", 2)
+			}
+			config := newCustodianConfig(cliCtx, logger)
+			config.Namespace = ""
+			config.Context = contexts[0]
+			cus, err := custodian.NewCustodian(config)
+			if err != nil {
+				return cli.Exit(err.Error(), 2)
+			}
+			if err := cus.RunController(cliCtx.Context, opts); err != nil {
+				return cli.Exit(err.Error(), 2)
+			}
+			return nil
+		},
+	}
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+
+	"github.com/dictybase-docker/cluster-ops/internal/custodian"
+	"github.com/urfave/cli/v2"
+)
+
+func dbStatusCommand(logger *slog.Logger) *cli.Command {
+	return &cli.Command{
+		Name:  "db-status",
+		Usage: "Report the health and backups of the CloudNativePG, ArangoDB and Redis databases, exits with an error when any is unhealthy",
+		Flags: append(namespacesFlags(), outputFormatFlag()),
+		Action: func(cliCtx *cli.Context) error {
+			format := cliCtx.String("output")
+			if err := custodian.ValidateFormat(format); err != nil {
+				return cli.Exit(err.Error(), 2)
+			}
+			config := newCustodianConfig(cliCtx, logger)
+			config.Namespace = ""
+			return forEachCluster(
+				cliCtx,
+				config,
+				format,
+				func(_ string, cus *custodian.Custodian, out io.Writer) error {
+					statuses, err := cus.DatabaseStatuses(cliCtx.Context, selectedNamespaces(cliCtx))
+					if err != nil {
+						return cli.Exit(err.Error(), 2)
+					}
+					if err := custodian.WriteDatabaseStatuses(out, format, statuses); err != nil {
+						return cli.Exit(err.Error(), 2)
+					}
+					if unhealthy := custodian.UnhealthyDatabases(statuses); len(unhealthy) > 0 {
+						return cli.Exit(
+							fmt.Sprintf("%d of %d databases are unhealthy", len(unhealthy), len(statuses)),
+							1,
+						)
+					}
+					return nil
+				},
+			)
+		},
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/dictybase-docker/cluster-ops/internal/custodian"
+	"github.com/urfave/cli/v2"
+)
+
+func eventsCommand(logger *slog.Logger) *cli.Command {
+	return &cli.Command{
+		Name:  "events",
+		Usage: "Group warning events by object and reason and flag containers with climbing restart counts",
+		Flags: append(namespacesFlags(), []cli.Flag{
+			&cli.StringSliceFlag{
+				Name:  "reason",
+				Usage: "Only report warnings with the reason, e.g. BackOff, FailedMount, FailedScheduling or OOMKilled, can be repeated",
+			},
+			&cli.DurationFlag{
+				Name:  "since",
+				Usage: "Ignore warnings and restarts older than the duration, zero keeps all of them",
+				Value: time.Hour,
+			},
+			&cli.IntFlag{
+				Name:  "min-restarts",
+				Usage: "Restart count from which a recently restarted container is flagged",
+				Value: 3,
+			},
+			&cli.BoolFlag{
+				Name:  "watch",
+				Usage: "Keep reporting every interval and flag containers restarting meanwhile",
+			},
+			&cli.DurationFlag{
+				Name:  "interval",
+				Usage: "Time between the reports of watch",
+				Value: time.Minute,
+			},
+			outputFormatFlag(),
+		}...),
+		Action: func(cliCtx *cli.Context) error {
+			format := cliCtx.String("output")
+			if err := custodian.ValidateFormat(format); err != nil {
+				return cli.Exit(err.Error(), 2)
+			}
+			opts := custodian.EventOptions{
+				Namespaces:  selectedNamespaces(cliCtx),
+				Reasons:     cliCtx.StringSlice("reason"),
+				Since:       cliCtx.Duration("since"),
+				MinRestarts: int32(cliCtx.Int("min-restarts")),
+			}
+			config := newCustodianConfig(cliCtx, logger)
+			config.Namespace = ""
+			if cliCtx.Bool("watch") {
+				return watchEvents(cliCtx, config, format, opts)
+			}
+			return forEachCluster(
+				cliCtx,
+				config,
+				format,
+				func(_ string, cus *custodian.Custodian, out io.Writer) error {
+					report, err := cus.EventReport(cliCtx.Context, opts, time.Now())
+					if err != nil {
+						return cli.Exit(err.Error(), 2)
+					}
+					if err := custodian.WriteEvents(out, format, report); err != nil {
+						return cli.Exit(err.Error(), 2)
+					}
+					return nil
+				},
+			)
+		},
+	}
+}
+
+// watchEvents streams the reports, it runs against a single cluster as the
+// output of several clusters is only merged once they finish
+func watchEvents(
+	cliCtx *cli.Context,
+	config custodian.CustodianConfig,
+	format string,
+	opts custodian.EventOptions,
+) error {
+	contexts, err := kubeContexts(cliCtx)
+	if err != nil {
+		return cli.Exit(err.Error(), 2)
+	}
+	if len(contexts) > 1 {
+		return cli.Exit("watching events runs against a single context", 2)
+	}
+	config.Context = contexts[0]
+	cus, err := custodian.NewCustodian(config)
+	if err != nil {
+		return cli.Exit(err.Error(), 2)
+	}
+	err = cus.WatchEvents(
+		cliCtx.Context,
+		opts,
+		cliCtx.Duration("interval"),
+		func(report *custodian.EventReport) error {
+			if format != custodian.FormatJSON {
+				fmt.Fprintf(os.Stdout, "# %s\n", report.Time.UTC().Format(time.RFC3339))
+			}
+			return custodian.WriteEvents(os.Stdout, format, report)
+		},
+	)
+	if err != nil {
+		return cli.Exit(err.Error(), 2)
+	}
+	return nil
+}
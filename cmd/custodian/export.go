@@ -0,0 +1,140 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+
+	"github.com/dictybase-docker/cluster-ops/internal/custodian"
+	"github.com/urfave/cli/v2"
+)
+
+func exportCommand(logger *slog.Logger) *cli.Command {
+	return &cli.Command{
+		Name:  "export",
+		Usage: "Write the namespaced resources as clean YAML manifests, one file per object",
+		Flags: append(namespacesFlags(), []cli.Flag{
+			&cli.StringFlag{
+				Name:    "dir",
+				Aliases: []string{"d"},
+				Usage:   "Empty directory to write the manifests to",
+			},
+			&cli.BoolFlag{
+				Name:  "reveal-secrets",
+				Usage: "Keep the data of secrets instead of redacting it",
+			},
+		}...),
+		Action: func(cliCtx *cli.Context) error {
+			// not a required flag, the diff subcommand does not need it
+			if cliCtx.String("dir") == "" {
+				return cli.Exit("export needs a --dir to write to", 2)
+			}
+			config := newCustodianConfig(cliCtx, logger)
+			config.Namespace = ""
+			opts := custodian.ExportOptions{
+				Namespaces:    selectedNamespaces(cliCtx),
+				RevealSecrets: cliCtx.Bool("reveal-secrets"),
+			}
+			return forEachCluster(
+				cliCtx,
+				config,
+				custodian.FormatTable,
+				func(cluster string, cus *custodian.Custodian, _ io.Writer) error {
+					dir := clusterPath(cliCtx.String("dir"), cluster)
+					if _, err := cus.Export(cliCtx.Context, opts, dir); err != nil {
+						return cli.Exit(err.Error(), 2)
+					}
+					return nil
+				},
+			)
+		},
+		Subcommands: []*cli.Command{exportDiffCommand(logger)},
+	}
+}
+
+func exportDiffCommand(logger *slog.Logger) *cli.Command {
+	return &cli.Command{
+		Name:      "diff",
+		Usage:     "Compare two exports, or an export with the live state, exits with 1 on differences",
+		ArgsUsage: "<export-dir> [<export-dir>]",
+		Flags: []cli.Flag{
+			&cli.BoolFlag{
+				Name:  "reveal-secrets",
+				Usage: "Compare the data of secrets, for exports written with --reveal-secrets",
+			},
+			outputFormatFlag(),
+		},
+		Action: func(cliCtx *cli.Context) error {
+			format := cliCtx.String("output")
+			if err := custodian.ValidateFormat(format); err != nil {
+				return cli.Exit(err.Error(), 2)
+			}
+			if cliCtx.NArg() < 1 || cliCtx.NArg() > 2 {
+				return cli.Exit("diff needs one or two export directories", 2)
+			}
+			if cliCtx.NArg() == 2 {
+				from, err := custodian.ReadExport(cliCtx.Args().Get(0))
+				if err != nil {
+					return cli.Exit(err.Error(), 2)
+				}
+				to, err := custodian.ReadExport(cliCtx.Args().Get(1))
+				if err != nil {
+					return cli.Exit(err.Error(), 2)
+				}
+				return writeExportDiffs(
+					os.Stdout,
+					format,
+					from,
+					to,
+					cliCtx.Args().Get(0),
+					cliCtx.Args().Get(1),
+				)
+			}
+			config := newCustodianConfig(cliCtx, logger)
+			config.Namespace = ""
+			return forEachCluster(
+				cliCtx,
+				config,
+				format,
+				func(cluster string, cus *custodian.Custodian, out io.Writer) error {
+					// exports of several clusters have a directory per cluster
+					fromDir := clusterPath(cliCtx.Args().Get(0), cluster)
+					from, err := custodian.ReadExport(fromDir)
+					if err != nil {
+						return cli.Exit(err.Error(), 2)
+					}
+					to, err := cus.LiveExport(cliCtx.Context, custodian.ExportOptions{
+						Namespaces:    custodian.ExportNamespaces(from),
+						RevealSecrets: cliCtx.Bool("reveal-secrets"),
+					})
+					if err != nil {
+						return cli.Exit(err.Error(), 2)
+					}
+					return writeExportDiffs(out, format, from, to, fromDir, "live")
+				},
+			)
+		},
+	}
+}
+
+// writeExportDiffs writes the differences and exits with 1 when there are
+// any, like diff does
+func writeExportDiffs(
+	out io.Writer,
+	format string,
+	from, to map[string][]byte,
+	fromName, toName string,
+) error {
+	diffs, err := custodian.DiffExports(from, to, fromName, toName)
+	if err != nil {
+		return cli.Exit(err.Error(), 2)
+	}
+	if err := custodian.WriteExportDiffs(out, format, diffs); err != nil {
+		return cli.Exit(err.Error(), 2)
+	}
+	if len(diffs) > 0 {
+		return cli.Exit(fmt.Sprintf("%d manifests differ", len(diffs)), 1)
+	}
+	return nil
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"log/slog"
+	"time"
+
+	"github.com/dictybase-docker/cluster-ops/internal/custodian"
+	"github.com/nats-io/nats.go"
+	"github.com/urfave/cli/v2"
+)
+
+func forwardCommand(logger *slog.Logger) *cli.Command {
+	return &cli.Command{
+		Name:  "forward",
+		Usage: "Publish warning events, failed jobs and unhealthy databases as deduplicated alerts to a NATS subject",
+		Flags: append(namespacesFlags(), []cli.Flag{
+			&cli.StringFlag{
+				Name:    "nats-url",
+				Usage:   "Address of the NATS server",
+				EnvVars: []string{"NATS_URL"},
+				Value:   nats.DefaultURL,
+			},
+			&cli.StringFlag{
+				Name:  "subject",
+				Usage: "NATS subject the alerts are published to as orders, the event-messenger consumers open an issue and send an email for them",
+				Value: custodian.DefaultAlertSubject,
+			},
+			&cli.StringFlag{
+				Name:     "recipient",
+				Usage:    "Email address the alerts are sent to",
+				EnvVars:  []string{"ALERT_RECIPIENT"},
+				Required: true,
+			},
+			&cli.StringFlag{
+				Name:  "state-configmap",
+				Usage: "ConfigMap keeping the published alerts across restarts",
+				Value: custodian.DefaultAlertStateConfigMap,
+			},
+			&cli.StringFlag{
+				Name:    "state-namespace",
+				Usage:   "Namespace of the state configmap",
+				EnvVars: []string{"POD_NAMESPACE"},
+				Value:   "dev",
+			},
+			&cli.StringFlag{
+				Name:  "cluster",
+				Usage: "Name of the cluster in the alerts, defaults to the kubeconfig context",
+			},
+			&cli.StringSliceFlag{
+				Name:  "reason",
+				Usage: "Only forward warning events with the reason, can be repeated",
+			},
+			&cli.DurationFlag{
+				Name:  "interval",
+				Usage: "Time between the checks",
+				Value: time.Minute,
+			},
+			&cli.DurationFlag{
+				Name:  "since",
+				Usage: "Ignore warnings and failed jobs older than the duration",
+				Value: time.Hour,
+			},
+			&cli.DurationFlag{
+				Name:  "repeat-after",
+				Usage: "Minimum time before an alert that changed is published again",
+				Value: 6 * time.Hour,
+			},
+			&cli.IntFlag{
+				Name:  "max-per-minute",
+				Usage: "Maximum number of alerts published per minute, zero disables the limit",
+				Value: 10,
+			},
+		}...),
+		Action: func(cliCtx *cli.Context) error {
+			// the forwarder runs in each cluster like the controller
+			contexts, err := kubeContexts(cliCtx)
+			if err != nil {
+				return cli.Exit(err.Error(), 2)
+			}
+			if len(contexts) > 1 {
+				return cli.Exit("forward runs against a single context", 2)
+			}
+			config := newCustodianConfig(cliCtx, logger)
+			config.Namespace = ""
+			config.Context = contexts[0]
+			cus, err := custodian.NewCustodian(config)
+			if err != nil {
+				return cli.Exit(err.Error(), 2)
+			}
+			conn, err := nats.Connect(
+				cliCtx.String("nats-url"),
+				nats.Name("custodian-forward"),
+				nats.MaxReconnects(-1),
+			)
+			if err != nil {
+				return cli.Exit("failed to connect to nats: "+err.Error(), 2)
+			}
+			defer conn.Close()
+
+			cluster := cliCtx.String("cluster")
+			if len(cluster) == 0 {
+				cluster = contexts[0]
+			}
+			err = cus.ForwardAlerts(cliCtx.Context, conn, custodian.ForwardOptions{
+				Namespaces:     selectedNamespaces(cliCtx),
+				Reasons:        cliCtx.StringSlice("reason"),
+				Subject:        cliCtx.String("subject"),
+				Recipient:      cliCtx.String("recipient"),
+				StateConfigMap: cliCtx.String("state-configmap"),
+				StateNamespace: cliCtx.String("state-namespace"),
+				Cluster:        cluster,
+				Interval:       cliCtx.Duration("interval"),
+				Since:          cliCtx.Duration("since"),
+				RepeatAfter:    cliCtx.Duration("repeat-after"),
+				MaxPerMinute:   cliCtx.Int("max-per-minute"),
+			})
+			if err != nil {
+				return cli.Exit(err.Error(), 2)
+			}
+			return nil
+		},
+	}
+}
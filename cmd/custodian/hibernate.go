@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"io"
+	"log/slog"
+
+	"github.com/dictybase-docker/cluster-ops/internal/custodian"
+	"github.com/urfave/cli/v2"
+)
+
+type hibernationAction func(
+	cus *custodian.Custodian,
+	ctx context.Context,
+	opts custodian.HibernateOptions,
+) ([]custodian.HibernationChange, error)
+
+func hibernateCommand(logger *slog.Logger) *cli.Command {
+	return &cli.Command{
+		Name:   "hibernate",
+		Usage:  "Scale a namespace to zero, suspend its cronjobs and hibernate its databases, recording the previous state; fails for databases that can not be stopped",
+		Flags:  hibernationFlags(),
+		Action: hibernationRunner(logger, (*custodian.Custodian).Hibernate),
+	}
+}
+
+func resumeCommand(logger *slog.Logger) *cli.Command {
+	return &cli.Command{
+		Name:   "resume",
+		Usage:  "Restore the state a namespace had before it was hibernated",
+		Flags:  hibernationFlags(),
+		Action: hibernationRunner(logger, (*custodian.Custodian).Resume),
+	}
+}
+
+func hibernationFlags() []cli.Flag {
+	return []cli.Flag{
+		&cli.StringFlag{
+			Name:     "namespace",
+			Aliases:  []string{"n"},
+			Usage:    "Namespace to hibernate or resume",
+			Required: true,
+		},
+		&cli.BoolFlag{
+			Name:  "dry-run",
+			Usage: "Only show the changes",
+		},
+		outputFormatFlag(),
+	}
+}
+
+func hibernationRunner(logger *slog.Logger, action hibernationAction) cli.ActionFunc {
+	return func(cliCtx *cli.Context) error {
+		format := cliCtx.String("output")
+		if err := custodian.ValidateFormat(format); err != nil {
+			return cli.Exit(err.Error(), 2)
+		}
+		opts := custodian.HibernateOptions{
+			Namespace: cliCtx.String("namespace"),
+			DryRun:    cliCtx.Bool("dry-run"),
+		}
+		return forEachCluster(
+			cliCtx,
+			newCustodianConfig(cliCtx, logger),
+			format,
+			func(_ string, cus *custodian.Custodian, out io.Writer) error {
+				changes, err := action(cus, cliCtx.Context, opts)
+				if werr := custodian.WriteHibernation(out, format, changes); werr != nil {
+					return cli.Exit(werr.Error(), 2)
+				}
+				if err != nil {
+					return cli.Exit(err.Error(), 2)
+				}
+				return nil
+			},
+		)
+	}
+}
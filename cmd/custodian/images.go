@@ -0,0 +1,64 @@
+package main
+
+import (
+	"io"
+	"log/slog"
+	"os"
+	"sync"
+
+	"github.com/dictybase-docker/cluster-ops/internal/custodian"
+	"github.com/urfave/cli/v2"
+)
+
+func imagesCommand(logger *slog.Logger) *cli.Command {
+	return &cli.Command{
+		Name:  "images",
+		Usage: "List the container images of the workloads and the drift between namespaces or clusters",
+		Flags: append(namespacesFlags(), []cli.Flag{
+			&cli.BoolFlag{
+				Name:  "resolve-digests",
+				Usage: "Add the digests the running pods pulled",
+			},
+			outputFormatFlag(),
+		}...),
+		Action: func(cliCtx *cli.Context) error {
+			format := cliCtx.String("output")
+			if err := custodian.ValidateFormat(format); err != nil {
+				return cli.Exit(err.Error(), 2)
+			}
+			config := newCustodianConfig(cliCtx, logger)
+			config.Namespace = ""
+			var mu sync.Mutex
+			var report custodian.ImageReport
+			// the images of all clusters are needed to find the drift, the
+			// clusters only collect them
+			clusterErr := forEachCluster(
+				cliCtx,
+				config,
+				custodian.FormatTable,
+				func(cluster string, cus *custodian.Custodian, _ io.Writer) error {
+					images, err := cus.ListImages(
+						cliCtx.Context,
+						selectedNamespaces(cliCtx),
+						cliCtx.Bool("resolve-digests"),
+					)
+					if err != nil {
+						return cli.Exit(err.Error(), 2)
+					}
+					for idx := range images {
+						images[idx].Cluster = cluster
+					}
+					mu.Lock()
+					defer mu.Unlock()
+					report.Images = append(report.Images, images...)
+					return nil
+				},
+			)
+			report.Drift = custodian.FindImageDrift(report.Images)
+			if err := custodian.WriteImages(os.Stdout, format, report); err != nil {
+				return cli.Exit(err.Error(), 2)
+			}
+			return clusterErr
+		},
+	}
+}
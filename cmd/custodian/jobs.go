@@ -0,0 +1,145 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"time"
+
+	"github.com/dictybase-docker/cluster-ops/internal/custodian"
+	"github.com/urfave/cli/v2"
+)
+
+func jobsCommand(logger *slog.Logger) *cli.Command {
+	return &cli.Command{
+		Name:  "jobs",
+		Usage: "Inspect Kubernetes jobs",
+		Subcommands: []*cli.Command{
+			jobsReportCommand(logger),
+		},
+	}
+}
+
+func jobsReportCommand(logger *slog.Logger) *cli.Command {
+	return &cli.Command{
+		Name:  "report",
+		Usage: "Report job status, duration and retries with triage details for failed jobs",
+		Flags: []cli.Flag{
+			namespaceFlag(),
+			&cli.StringFlag{
+				Name:    "label",
+				Aliases: []string{"l"},
+				Usage:   "Only report jobs matching this label selector",
+			},
+			&cli.BoolFlag{
+				Name:  "failed-only",
+				Usage: "Only report failed jobs",
+			},
+			&cli.Int64Flag{
+				Name:  "log-lines",
+				Usage: "Number of log lines to include for failed containers",
+				Value: 20,
+			},
+			&cli.IntFlag{
+				Name:  "events",
+				Usage: "Number of recent events to include for failed jobs",
+				Value: 10,
+			},
+			outputFormatFlag(),
+		},
+		Action: func(cliCtx *cli.Context) error {
+			opts := custodian.JobReportOptions{
+				Format:     cliCtx.String("output"),
+				FailedOnly: cliCtx.Bool("failed-only"),
+				LogLines:   cliCtx.Int64("log-lines"),
+				EventCount: cliCtx.Int("events"),
+			}
+			if err := custodian.ValidateFormat(opts.Format); err != nil {
+				return cli.Exit(err.Error(), 2)
+			}
+			config := newCustodianConfig(cliCtx, logger)
+			config.Label = cliCtx.String("label")
+			return forEachCluster(
+				cliCtx,
+				config,
+				opts.Format,
+				func(_ string, cus *custodian.Custodian, out io.Writer) error {
+					if err := cus.JobsReport(cliCtx.Context, opts, out); err != nil {
+						return cli.Exit(err.Error(), 2)
+					}
+					return nil
+				},
+			)
+		},
+	}
+}
+
+func cleanupJobsCommand(logger *slog.Logger) *cli.Command {
+	return &cli.Command{
+		Name:  "cleanup-jobs",
+		Usage: "Delete finished jobs and their pods older than a given age",
+		Flags: []cli.Flag{
+			namespaceFlag(),
+			&cli.StringFlag{
+				Name:    "label",
+				Aliases: []string{"l"},
+				Usage:   "Only clean up jobs matching this label selector",
+			},
+			&cli.DurationFlag{
+				Name:  "older-than",
+				Usage: "Minimum time since the job finished",
+				Value: 24 * time.Hour,
+			},
+			&cli.StringFlag{
+				Name:  "status",
+				Usage: "Jobs to delete, one of completed, failed or all",
+				Value: "completed",
+			},
+			&cli.IntFlag{
+				Name:  "keep-last",
+				Usage: "Number of most recent finished jobs to keep for every CronJob",
+			},
+			&cli.StringFlag{
+				Name:  "archive-dir",
+				Usage: "Directory to write <job>/<pod>/<container>[.previous].log files before deletion",
+			},
+			&cli.BoolFlag{
+				Name:  "dry-run",
+				Usage: "Only print the jobs that would be deleted",
+			},
+		},
+		Action: func(cliCtx *cli.Context) error {
+			opts := custodian.CleanupJobsOptions{
+				OlderThan:  cliCtx.Duration("older-than"),
+				KeepLast:   cliCtx.Int("keep-last"),
+				ArchiveDir: cliCtx.String("archive-dir"),
+				DryRun:     cliCtx.Bool("dry-run"),
+			}
+			switch status := cliCtx.String("status"); status {
+			case "completed":
+				opts.Completed = true
+			case "failed":
+				opts.Failed = true
+			case "all":
+				opts.Completed, opts.Failed = true, true
+			default:
+				return cli.Exit(fmt.Sprintf("unsupported status %q", status), 2)
+			}
+			config := newCustodianConfig(cliCtx, logger)
+			config.Label = cliCtx.String("label")
+			return forEachCluster(
+				cliCtx,
+				config,
+				custodian.FormatTable,
+				func(cluster string, cus *custodian.Custodian, _ io.Writer) error {
+					clusterOpts := opts
+					clusterOpts.ArchiveDir = clusterPath(opts.ArchiveDir, cluster)
+					if err := cus.CleanupJobs(cliCtx.Context, clusterOpts); err != nil {
+						return cli.Exit(err.Error(), 2)
+					}
+					return nil
+				},
+			)
+		},
+	}
+}
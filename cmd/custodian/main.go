@@ -1,9 +1,13 @@
 package main
 
 import (
+	"context"
 	"fmt"
+	"io"
 	"log/slog"
 	"os"
+	"os/signal"
+	"syscall"
 
 	"github.com/dictybase-docker/cluster-ops/internal/custodian"
 	"github.com/urfave/cli/v2"
@@ -16,7 +20,7 @@ func initLogger() *slog.Logger {
 func excludeVolumesFromBackupCommand(logger *slog.Logger) *cli.Command {
 	return &cli.Command{
 		Name:  "exclude-volumes-from-backup",
-		Usage: "Add 'backup.velero.io/backup-volumes-excludes' annotation to pods",
+		Usage: "Add 'backup.velero.io/backup-volumes-excludes' annotation to pods or their owning workloads",
 		Flags: []cli.Flag{
 			&cli.StringFlag{
 				Name:    "namespace",
@@ -24,17 +28,41 @@ func excludeVolumesFromBackupCommand(logger *slog.Logger) *cli.Command {
 				Usage:   "Kubernetes namespace to search in",
 				Value:   "dev",
 			},
+			&cli.StringSliceFlag{
+				Name:  "volume-types",
+				Usage: "Volume types to exclude (secret, configMap, projected, emptyDir, ephemeral)",
+				Value: cli.NewStringSlice(custodian.DefaultVolumeTypes...),
+			},
+			&cli.StringSliceFlag{
+				Name:  "storage-class",
+				Usage: "Exclude persistent volume claims of this storage class, can be repeated",
+			},
+			&cli.BoolFlag{
+				Name:  "dry-run",
+				Usage: "Show the annotations that would be added without applying them",
+			},
 		},
 		Action: func(cliCtx *cli.Context) error {
-			config := newCustodianConfig(cliCtx, logger)
-			cus, err := custodian.NewCustodian(config)
-			if err != nil {
-				return cli.Exit(err.Error(), 2)
+			opts := custodian.VolumeExcludeOptions{
+				VolumeTypes:    cliCtx.StringSlice("volume-types"),
+				StorageClasses: cliCtx.StringSlice("storage-class"),
+				DryRun:         cliCtx.Bool("dry-run"),
 			}
-			if err := cus.ExcludeVolumesFromBackup(); err != nil {
+			if err := custodian.ValidateVolumeTypes(opts.VolumeTypes); err != nil {
 				return cli.Exit(err.Error(), 2)
 			}
-			return nil
+			config := newCustodianConfig(cliCtx, logger)
+			return forEachCluster(
+				cliCtx,
+				config,
+				custodian.FormatTable,
+				func(_ string, cus *custodian.Custodian, _ io.Writer) error {
+					if err := cus.ExcludeVolumesFromBackup(cliCtx.Context, opts); err != nil {
+						return cli.Exit(err.Error(), 2)
+					}
+					return nil
+				},
+			)
 		},
 	}
 }
@@ -50,6 +78,24 @@ func newCustodianConfig(
 	}
 }
 
+func namespaceFlag() cli.Flag {
+	return &cli.StringFlag{
+		Name:    "namespace",
+		Aliases: []string{"n"},
+		Usage:   "Kubernetes namespace to search in",
+		Value:   "dev",
+	}
+}
+
+func outputFormatFlag() cli.Flag {
+	return &cli.StringFlag{
+		Name:    "output",
+		Aliases: []string{"o"},
+		Usage:   "Output format, one of table, json or markdown",
+		Value:   custodian.FormatTable,
+	}
+}
+
 func extractLogCommand(logger *slog.Logger) *cli.Command {
 	return &cli.Command{
 		Name:  "extract-log",
@@ -67,45 +113,176 @@ func extractLogCommand(logger *slog.Logger) *cli.Command {
 				Usage:   "Kubernetes namespace to search in",
 				Value:   "dev",
 			},
+			&cli.StringFlag{
+				Name:    "output-dir",
+				Aliases: []string{"d"},
+				Usage:   "Directory to write <job>/<pod>/<container>[.previous].log files, logs are printed if empty",
+			},
+			&cli.DurationFlag{
+				Name:  "since",
+				Usage: "Only return logs newer than this duration, e.g. 1h",
+			},
+			&cli.Int64Flag{
+				Name:  "tail",
+				Usage: "Number of lines from the end of the logs to show, all lines if zero",
+			},
+			&cli.BoolFlag{
+				Name:  "timestamps",
+				Usage: "Include timestamps on each line of the logs",
+			},
+			&cli.BoolFlag{
+				Name:    "follow",
+				Aliases: []string{"f"},
+				Usage:   "Stream the logs of the matching jobs until they complete, fail or are deleted, fails if no job matches",
+			},
+			&cli.BoolFlag{
+				Name:  "no-color",
+				Usage: "Disable colored pod/container prefixes in follow mode",
+			},
 		},
 		Action: func(cliCtx *cli.Context) error {
 			config := newCustodianConfig(cliCtx, logger)
 			config.Label = cliCtx.String("label")
-			cus, err := custodian.NewCustodian(config)
-			if err != nil {
-				return cli.Exit(err.Error(), 2)
+			config.LogOptions = custodian.LogOptions{
+				OutputDir:  cliCtx.String("output-dir"),
+				Since:      cliCtx.Duration("since"),
+				Tail:       cliCtx.Int64("tail"),
+				Timestamps: cliCtx.Bool("timestamps"),
+				NoColor:    cliCtx.Bool("no-color"),
 			}
-			if err := cus.SearchAndExtractLogs(cliCtx); err != nil {
-				return cli.Exit(err.Error(), 2)
-			}
-			return nil
+			return streamEachCluster(
+				cliCtx,
+				config,
+				func(_ string, cus *custodian.Custodian, out io.Writer) error {
+					if cliCtx.Bool("follow") {
+						if err := cus.FollowJobLogs(cliCtx.Context, out); err != nil {
+							return cli.Exit(err.Error(), 1)
+						}
+						return nil
+					}
+					if err := cus.SearchAndExtractLogs(cliCtx); err != nil {
+						return cli.Exit(err.Error(), 2)
+					}
+					return nil
+				},
+			)
 		},
 	}
 }
 
-func excludeFromBackupCommand(logger *slog.Logger) *cli.Command {
-	return &cli.Command{
-		Name:  "exclude-from-backup",
-		Usage: "Add 'velero.io/exclude-from-backup=true' label",
-		Flags: []cli.Flag{
-			&cli.StringFlag{
-				Name:    "namespace",
-				Aliases: []string{"n"},
-				Usage:   "Kubernetes namespace to search in",
-				Value:   "dev",
-			},
+// namespacesFlags selects one or more namespaces, or all of them
+func namespacesFlags() []cli.Flag {
+	return []cli.Flag{
+		&cli.StringSliceFlag{
+			Name:    "namespace",
+			Aliases: []string{"n"},
+			Usage:   "Kubernetes namespaces to search in, can be repeated",
+			Value:   cli.NewStringSlice("dev"),
 		},
-		Action: func(cliCtx *cli.Context) error {
-			config := newCustodianConfig(cliCtx, logger)
-			cus, err := custodian.NewCustodian(config)
-			if err != nil {
-				return cli.Exit(err.Error(), 2)
-			}
-			if err := cus.ExcludeFromBackup(); err != nil {
-				return cli.Exit(err.Error(), 2)
-			}
-			return nil
+		&cli.BoolFlag{
+			Name:    "all-namespaces",
+			Aliases: []string{"A"},
+			Usage:   "Search in all namespaces",
+		},
+	}
+}
+
+// selectedNamespaces returns the namespaces given by namespacesFlags, an
+// empty list means all namespaces
+func selectedNamespaces(cliCtx *cli.Context) []string {
+	if cliCtx.Bool("all-namespaces") {
+		return nil
+	}
+	return cliCtx.StringSlice("namespace")
+}
+
+func backupLabelFlags() []cli.Flag {
+	return append(namespacesFlags(), []cli.Flag{
+		&cli.StringFlag{
+			Name:    "selector",
+			Aliases: []string{"s"},
+			Usage:   "Label selector of the resources to update",
+			Value:   "app.kubernetes.io/name=kube-arangodb",
 		},
+		&cli.StringFlag{
+			Name:  "label",
+			Usage: "Backup exclusion label as key=value",
+			Value: "velero.io/exclude-from-backup=true",
+		},
+		&cli.BoolFlag{
+			Name:  "dry-run",
+			Usage: "Only print the resources that would be updated",
+		},
+	}...)
+}
+
+// backupResourceFlag limits the labelled resources, the controller has its
+// own flag with the resources it watches by default
+func backupResourceFlag() cli.Flag {
+	return &cli.StringSliceFlag{
+		Name:  "resource",
+		Usage: "Only label this resource, as resource or resource.group, can be repeated",
+	}
+}
+
+func newBackupLabelOptions(
+	cliCtx *cli.Context,
+) (custodian.BackupLabelOptions, error) {
+	key, value, err := custodian.ParseLabel(cliCtx.String("label"))
+	if err != nil {
+		return custodian.BackupLabelOptions{}, err
+	}
+	opts := custodian.BackupLabelOptions{
+		Selector:   cliCtx.String("selector"),
+		LabelKey:   key,
+		LabelValue: value,
+		Namespaces: selectedNamespaces(cliCtx),
+		Resources:  cliCtx.StringSlice("resource"),
+		DryRun:     cliCtx.Bool("dry-run"),
+	}
+	return opts, nil
+}
+
+func backupLabelAction(
+	logger *slog.Logger,
+	run func(*custodian.Custodian, custodian.BackupLabelOptions) error,
+) cli.ActionFunc {
+	return func(cliCtx *cli.Context) error {
+		opts, err := newBackupLabelOptions(cliCtx)
+		if err != nil {
+			return cli.Exit(err.Error(), 2)
+		}
+		config := newCustodianConfig(cliCtx, logger)
+		config.Namespace = ""
+		return forEachCluster(
+			cliCtx,
+			config,
+			custodian.FormatTable,
+			func(_ string, cus *custodian.Custodian, _ io.Writer) error {
+				if err := run(cus, opts); err != nil {
+					return cli.Exit(err.Error(), 2)
+				}
+				return nil
+			},
+		)
+	}
+}
+
+func excludeFromBackupCommand(logger *slog.Logger) *cli.Command {
+	return &cli.Command{
+		Name:   "exclude-from-backup",
+		Usage:  "Add the backup exclusion label, 'velero.io/exclude-from-backup=true' by default",
+		Flags:  append(backupLabelFlags(), backupResourceFlag()),
+		Action: backupLabelAction(logger, (*custodian.Custodian).ExcludeFromBackup),
+	}
+}
+
+func includeInBackupCommand(logger *slog.Logger) *cli.Command {
+	return &cli.Command{
+		Name:   "include-in-backup",
+		Usage:  "Remove the backup exclusion label added by exclude-from-backup",
+		Flags:  append(backupLabelFlags(), backupResourceFlag()),
+		Action: backupLabelAction(logger, (*custodian.Custodian).IncludeInBackup),
 	}
 }
 
@@ -115,23 +292,47 @@ func main() {
 	app := &cli.App{
 		Name:  "custodian",
 		Usage: "Kubernetes cluster management tool",
-		Flags: []cli.Flag{
+		Flags: append([]cli.Flag{
 			&cli.StringFlag{
 				Name:    "kubeconfig",
 				Aliases: []string{"k"},
-				Usage:   "Path to the kubeconfig file",
+				Usage:   "Path to the kubeconfig file, the in-cluster config or ~/.kube/config are used if empty",
 				EnvVars: []string{"KUBECONFIG"},
 				Value:   "",
 			},
-		},
+		}, clusterFlags()...),
 		Commands: []*cli.Command{
 			extractLogCommand(logger),
 			excludeFromBackupCommand(logger),
+			includeInBackupCommand(logger),
 			excludeVolumesFromBackupCommand(logger),
+			jobsCommand(logger),
+			cleanupJobsCommand(logger),
+			backupAuditCommand(logger),
+			controllerCommand(logger),
+			policyCommand(logger),
+			orphansCommand(logger),
+			unusedConfigCommand(logger),
+			certsCommand(logger),
+			imagesCommand(logger),
+			workloadAuditCommand(logger),
+			hibernateCommand(logger),
+			resumeCommand(logger),
+			runCronJobCommand(logger),
+			exportCommand(logger),
+			dbStatusCommand(logger),
+			eventsCommand(logger),
+			forwardCommand(logger),
 		},
 	}
 
-	err := app.Run(os.Args)
+	ctx, stop := signal.NotifyContext(
+		context.Background(),
+		os.Interrupt,
+		syscall.SIGTERM,
+	)
+	err := app.RunContext(ctx, os.Args)
+	stop()
 	if err != nil {
 		fmt.Fprint(os.Stderr, err)
 		os.Exit(1)
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+
+	"github.com/dictybase-docker/cluster-ops/internal/custodian"
+	"github.com/urfave/cli/v2"
+)
+
+func orphansCommand(logger *slog.Logger) *cli.Command {
+	return &cli.Command{
+		Name:  "orphans",
+		Usage: "Find claims not mounted by any pod and released, failed or unclaimed volumes",
+		Flags: append(namespacesFlags(), []cli.Flag{
+			outputFormatFlag(),
+			&cli.BoolFlag{
+				Name:  "delete",
+				Usage: "Delete the orphans after confirmation",
+			},
+			&cli.BoolFlag{
+				Name:    "yes",
+				Aliases: []string{"y"},
+				Usage:   "Delete without asking for confirmation",
+			},
+			&cli.BoolFlag{
+				Name:  "dry-run",
+				Usage: "Only show the orphans that would be deleted",
+			},
+		}...),
+		Action: func(cliCtx *cli.Context) error {
+			format := cliCtx.String("output")
+			if err := custodian.ValidateFormat(format); err != nil {
+				return cli.Exit(err.Error(), 2)
+			}
+			config := newCustodianConfig(cliCtx, logger)
+			config.Namespace = ""
+			return forEachCluster(
+				cliCtx,
+				config,
+				format,
+				func(cluster string, cus *custodian.Custodian, out io.Writer) error {
+					orphans, err := cus.FindOrphans(cliCtx.Context, selectedNamespaces(cliCtx))
+					if err != nil {
+						return cli.Exit(err.Error(), 2)
+					}
+					if err := custodian.WriteOrphans(out, format, orphans); err != nil {
+						return cli.Exit(err.Error(), 2)
+					}
+					if !cliCtx.Bool("delete") || len(orphans) == 0 {
+						return nil
+					}
+					dryRun := cliCtx.Bool("dry-run")
+					if !dryRun && !cliCtx.Bool("yes") {
+						// the clusters run concurrently, they cannot share the prompt
+						if len(cluster) > 0 {
+							return cli.Exit("deleting on several clusters needs --yes", 2)
+						}
+						question := fmt.Sprintf("Delete %d orphans?", len(orphans))
+						if !confirm(os.Stdin, os.Stderr, question) {
+							logger.Info("Deletion cancelled")
+							return nil
+						}
+					}
+					if err := cus.DeleteOrphans(cliCtx.Context, orphans, dryRun); err != nil {
+						return cli.Exit(err.Error(), 2)
+					}
+					return nil
+				},
+			)
+		},
+	}
+}
+
+// confirm asks a yes/no question, anything but yes declines
+func confirm(in io.Reader, out io.Writer, question string) bool {
+	fmt.Fprintf(out, "%s [y/N] ", question)
+	answer, err := bufio.NewReader(in).ReadString('\n')
+	if err != nil && len(answer) == 0 {
+		return false
+	}
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "y", "yes":
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+
+	"github.com/dictybase-docker/cluster-ops/internal/custodian"
+	"github.com/urfave/cli/v2"
+)
+
+func policyCommand(logger *slog.Logger) *cli.Command {
+	return &cli.Command{
+		Name:  "policy",
+		Usage: "Evaluate declarative custodian policies",
+		Subcommands: []*cli.Command{
+			{
+				Name:   "plan",
+				Usage:  "Show the changes and findings of a policy without applying them",
+				Flags:  policyFlags(),
+				Action: policyAction(logger, false),
+			},
+			{
+				Name:   "apply",
+				Usage:  "Apply the changes of a policy",
+				Flags:  policyFlags(),
+				Action: policyAction(logger, true),
+			},
+			{
+				Name:  "list",
+				Usage: "List the bundled policies",
+				Action: func(cliCtx *cli.Context) error {
+					for _, name := range custodian.BundledPolicyNames() {
+						fmt.Println(name)
+					}
+					return nil
+				},
+			},
+		},
+	}
+}
+
+func policyFlags() []cli.Flag {
+	return []cli.Flag{
+		&cli.StringFlag{
+			Name:    "file",
+			Aliases: []string{"f"},
+			Usage:   "Policy file in YAML",
+		},
+		&cli.StringFlag{
+			Name:    "bundled",
+			Aliases: []string{"b"},
+			Usage:   "Name of a bundled policy, see policy list",
+		},
+		&cli.StringSliceFlag{
+			Name:    "namespace",
+			Aliases: []string{"n"},
+			Usage:   "Namespaces overriding the ones of every rule, can be repeated",
+		},
+		outputFormatFlag(),
+	}
+}
+
+func loadPolicy(cliCtx *cli.Context) (*custodian.Policy, error) {
+	file, bundled := cliCtx.String("file"), cliCtx.String("bundled")
+	switch {
+	case len(file) > 0 && len(bundled) > 0:
+		return nil, fmt.Errorf("use either --file or --bundled")
+	case len(file) > 0:
+		return custodian.LoadPolicy(file)
+	case len(bundled) > 0:
+		return custodian.LoadBundledPolicy(bundled)
+	default:
+		return nil, fmt.Errorf("a policy is required, use --file or --bundled")
+	}
+}
+
+func policyAction(logger *slog.Logger, apply bool) cli.ActionFunc {
+	return func(cliCtx *cli.Context) error {
+		opts := custodian.PolicyOptions{
+			Apply:      apply,
+			Format:     cliCtx.String("output"),
+			Namespaces: cliCtx.StringSlice("namespace"),
+		}
+		if err := custodian.ValidateFormat(opts.Format); err != nil {
+			return cli.Exit(err.Error(), 2)
+		}
+		policy, err := loadPolicy(cliCtx)
+		if err != nil {
+			return cli.Exit(err.Error(), 2)
+		}
+		config := newCustodianConfig(cliCtx, logger)
+		config.Namespace = ""
+		return forEachCluster(
+			cliCtx,
+			config,
+			opts.Format,
+			func(_ string, cus *custodian.Custodian, out io.Writer) error {
+				if err := cus.RunPolicy(cliCtx.Context, policy, opts, out); err != nil {
+					return cli.Exit(err.Error(), 2)
+				}
+				return nil
+			},
+		)
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"log/slog"
+	"time"
+
+	"github.com/dictybase-docker/cluster-ops/internal/custodian"
+	"github.com/urfave/cli/v2"
+)
+
+func runCronJobCommand(logger *slog.Logger) *cli.Command {
+	return &cli.Command{
+		Name:      "run-cronjob",
+		Usage:     "Start a job from a cronjob, stream its logs and exit with its status",
+		ArgsUsage: "<name>",
+		Flags: []cli.Flag{
+			namespaceFlag(),
+			&cli.DurationFlag{
+				Name:  "timeout",
+				Usage: "Maximum time to wait for the job to finish",
+				Value: time.Hour,
+			},
+			&cli.BoolFlag{
+				Name:  "no-color",
+				Usage: "Disable the colored log prefixes",
+			},
+		},
+		Action: func(cliCtx *cli.Context) error {
+			if cliCtx.NArg() != 1 {
+				return cli.Exit("run-cronjob needs the name of the cronjob", 2)
+			}
+			config := newCustodianConfig(cliCtx, logger)
+			config.LogOptions = custodian.LogOptions{NoColor: cliCtx.Bool("no-color")}
+			return streamEachCluster(
+				cliCtx,
+				config,
+				func(_ string, cus *custodian.Custodian, out io.Writer) error {
+					err := cus.RunCronJob(
+						cliCtx.Context,
+						cliCtx.Args().First(),
+						cliCtx.Duration("timeout"),
+						out,
+					)
+					var failed *custodian.JobFailedError
+					switch {
+					case errors.As(err, &failed):
+						return cli.Exit(err.Error(), failed.ExitCode)
+					case err != nil:
+						return cli.Exit(err.Error(), 2)
+					}
+					return nil
+				},
+			)
+		},
+	}
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"io"
+	"log/slog"
+
+	"github.com/dictybase-docker/cluster-ops/internal/custodian"
+	"github.com/urfave/cli/v2"
+)
+
+func unusedConfigCommand(logger *slog.Logger) *cli.Command {
+	return &cli.Command{
+		Name:  "unused-config",
+		Usage: "Report secrets and config maps that no workload, ingress, service account or known custom resource refers to",
+		Flags: append(namespacesFlags(), outputFormatFlag()),
+		Action: func(cliCtx *cli.Context) error {
+			format := cliCtx.String("output")
+			if err := custodian.ValidateFormat(format); err != nil {
+				return cli.Exit(err.Error(), 2)
+			}
+			config := newCustodianConfig(cliCtx, logger)
+			config.Namespace = ""
+			return forEachCluster(
+				cliCtx,
+				config,
+				format,
+				func(_ string, cus *custodian.Custodian, out io.Writer) error {
+					unused, err := cus.FindUnusedConfig(cliCtx.Context, selectedNamespaces(cliCtx))
+					if err != nil {
+						return cli.Exit(err.Error(), 2)
+					}
+					if err := custodian.WriteUnusedConfig(out, format, unused); err != nil {
+						return cli.Exit(err.Error(), 2)
+					}
+					return nil
+				},
+			)
+		},
+	}
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"io"
+	"log/slog"
+
+	"github.com/dictybase-docker/cluster-ops/internal/custodian"
+	"github.com/urfave/cli/v2"
+)
+
+func workloadAuditCommand(logger *slog.Logger) *cli.Command {
+	return &cli.Command{
+		Name:  "workload-audit",
+		Usage: "Report containers missing requests, limits, probes or a security context, markdown output is a checklist",
+		Flags: append(namespacesFlags(), outputFormatFlag()),
+		Action: func(cliCtx *cli.Context) error {
+			format := cliCtx.String("output")
+			if err := custodian.ValidateFormat(format); err != nil {
+				return cli.Exit(err.Error(), 2)
+			}
+			config := newCustodianConfig(cliCtx, logger)
+			config.Namespace = ""
+			return forEachCluster(
+				cliCtx,
+				config,
+				format,
+				func(_ string, cus *custodian.Custodian, out io.Writer) error {
+					audits, err := cus.AuditWorkloads(cliCtx.Context, selectedNamespaces(cliCtx))
+					if err != nil {
+						return cli.Exit(err.Error(), 2)
+					}
+					if err := custodian.WriteWorkloadAudit(out, format, audits); err != nil {
+						return cli.Exit(err.Error(), 2)
+					}
+					return nil
+				},
+			)
+		},
+	}
+}
@@ -32,6 +32,100 @@ func getCommands() []*cli.Command {
 		analyzeRolesCommand(),
 		findOrCreateKopsBucketCommand(),
 		createKeyringAndKeyCommand(),
+		generateDataKeyCommand(),
+		wrapDataKeyCommand(),
+		rotateDataKeyCommand(),
+	}
+}
+
+func dataKeyFlags() []cli.Flag {
+	return []cli.Flag{
+		&cli.StringFlag{
+			Name:     "project-id",
+			Aliases:  []string{"p"},
+			Usage:    "Google Cloud project ID",
+			Required: true,
+		},
+		&cli.StringFlag{
+			Name:     "keyring-name",
+			Aliases:  []string{"k"},
+			Usage:    "Name of the keyring holding the wrapping key",
+			Required: true,
+		},
+		&cli.StringFlag{
+			Name:     "key-name",
+			Aliases:  []string{"n"},
+			Usage:    "Name of the key used to wrap the data key",
+			Required: true,
+		},
+		&cli.StringFlag{
+			Name:    "location",
+			Aliases: []string{"l"},
+			Usage:   "Location of the keyring and key",
+			Value:   "us-central1",
+		},
+		&cli.StringFlag{
+			Name:    "credentials",
+			Aliases: []string{"c"},
+			Usage:   "Path to the Google Cloud credentials file (can also be set via GOOGLE_APPLICATION_CREDENTIALS env var)",
+			EnvVars: []string{"GOOGLE_APPLICATION_CREDENTIALS"},
+		},
+	}
+}
+
+func generateDataKeyCommand() *cli.Command {
+	return &cli.Command{
+		Name:   "generate-data-key",
+		Usage:  "Generate a random data key and wrap it with a Cloud KMS key",
+		Action: gcp.GenerateDataKey,
+		Flags: append(dataKeyFlags(), &cli.StringFlag{
+			Name:     "output",
+			Aliases:  []string{"o"},
+			Usage:    "File to write the base64 encoded wrapped data key",
+			Required: true,
+		}),
+	}
+}
+
+func wrapDataKeyCommand() *cli.Command {
+	return &cli.Command{
+		Name:   "wrap-data-key",
+		Usage:  "Wrap an existing secret, such as a restic password, with a Cloud KMS key",
+		Action: gcp.WrapDataKey,
+		Flags: append(dataKeyFlags(),
+			&cli.StringFlag{
+				Name:     "input",
+				Aliases:  []string{"i"},
+				Usage:    "File with the plaintext secret, use - to read from stdin",
+				Required: true,
+			},
+			&cli.StringFlag{
+				Name:     "output",
+				Aliases:  []string{"o"},
+				Usage:    "File to write the base64 encoded wrapped data key",
+				Required: true,
+			},
+		),
+	}
+}
+
+func rotateDataKeyCommand() *cli.Command {
+	return &cli.Command{
+		Name:   "rotate-data-key",
+		Usage:  "Rewrap a data key with the primary version of a Cloud KMS key",
+		Action: gcp.RotateDataKey,
+		Flags: append(dataKeyFlags(),
+			&cli.StringFlag{
+				Name:     "file",
+				Aliases:  []string{"f"},
+				Usage:    "File with the base64 encoded wrapped data key, rewritten in place",
+				Required: true,
+			},
+			&cli.BoolFlag{
+				Name:  "new-key-version",
+				Usage: "Create a new primary key version before rewrapping",
+			},
+		),
 	}
 }
 
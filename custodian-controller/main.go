@@ -0,0 +1,306 @@
+package main
+
+import (
+	"fmt"
+
+	appsv1 "github.com/pulumi/pulumi-kubernetes/sdk/v4/go/kubernetes/apps/v1"
+	corev1 "github.com/pulumi/pulumi-kubernetes/sdk/v4/go/kubernetes/core/v1"
+	metav1 "github.com/pulumi/pulumi-kubernetes/sdk/v4/go/kubernetes/meta/v1"
+	rbacv1 "github.com/pulumi/pulumi-kubernetes/sdk/v4/go/kubernetes/rbac/v1"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi/config"
+)
+
+const appName = "custodian-controller"
+
+type ControllerConfig struct {
+	Namespace string
+	Replicas  int
+	Image     struct {
+		Name       string
+		Tag        string
+		PullPolicy string
+	}
+	WatchNamespaces []string
+	Selector        string
+	Label           string
+	Port            int
+}
+
+type Controller struct {
+	Config *ControllerConfig
+}
+
+func ReadConfig(ctx *pulumi.Context) (*ControllerConfig, error) {
+	conf := config.New(ctx, "")
+	controllerConfig := &ControllerConfig{}
+	if err := conf.TryObject("properties", controllerConfig); err != nil {
+		return nil, fmt.Errorf(
+			"failed to read custodian-controller config: %w",
+			err,
+		)
+	}
+	return controllerConfig, nil
+}
+
+func NewController(config *ControllerConfig) *Controller {
+	return &Controller{
+		Config: config,
+	}
+}
+
+func (ctl *Controller) Install(ctx *pulumi.Context) error {
+	serviceAccount, err := ctl.createServiceAccount(ctx)
+	if err != nil {
+		return err
+	}
+	rbac, err := ctl.createRBAC(ctx, serviceAccount)
+	if err != nil {
+		return err
+	}
+	return ctl.createDeployment(ctx, serviceAccount, rbac)
+}
+
+func (ctl *Controller) createServiceAccount(
+	ctx *pulumi.Context,
+) (*corev1.ServiceAccount, error) {
+	serviceAccount, err := corev1.NewServiceAccount(
+		ctx,
+		appName,
+		&corev1.ServiceAccountArgs{Metadata: ctl.metadata(appName)},
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error creating service account: %w", err)
+	}
+	return serviceAccount, nil
+}
+
+// createRBAC grants cluster wide access to the watched resources and
+// namespaced access to the leader election lease
+func (ctl *Controller) createRBAC(
+	ctx *pulumi.Context,
+	serviceAccount *corev1.ServiceAccount,
+) ([]pulumi.Resource, error) {
+	clusterRole, err := rbacv1.NewClusterRole(
+		ctx,
+		appName,
+		&rbacv1.ClusterRoleArgs{
+			Metadata: &metav1.ObjectMetaArgs{Name: pulumi.String(appName)},
+			Rules: rbacv1.PolicyRuleArray{
+				&rbacv1.PolicyRuleArgs{
+					ApiGroups: pulumi.ToStringArray([]string{""}),
+					Resources: pulumi.ToStringArray([]string{
+						"pods",
+						"persistentvolumeclaims",
+						"services",
+						"secrets",
+						"configmaps",
+						"serviceaccounts",
+					}),
+					Verbs: pulumi.ToStringArray([]string{
+						"get", "list", "watch", "patch",
+					}),
+				},
+				&rbacv1.PolicyRuleArgs{
+					ApiGroups: pulumi.ToStringArray([]string{"apps"}),
+					Resources: pulumi.ToStringArray([]string{
+						"deployments",
+						"statefulsets",
+						"replicasets",
+					}),
+					Verbs: pulumi.ToStringArray([]string{
+						"get", "list", "watch", "patch",
+					}),
+				},
+				&rbacv1.PolicyRuleArgs{
+					ApiGroups: pulumi.ToStringArray([]string{""}),
+					Resources: pulumi.ToStringArray([]string{"namespaces"}),
+					Verbs:     pulumi.ToStringArray([]string{"get", "list"}),
+				},
+			},
+		},
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error creating cluster role: %w", err)
+	}
+	clusterRoleBinding, err := rbacv1.NewClusterRoleBinding(
+		ctx,
+		appName,
+		&rbacv1.ClusterRoleBindingArgs{
+			Metadata: &metav1.ObjectMetaArgs{Name: pulumi.String(appName)},
+			RoleRef: &rbacv1.RoleRefArgs{
+				ApiGroup: pulumi.String("rbac.authorization.k8s.io"),
+				Kind:     pulumi.String("ClusterRole"),
+				Name:     clusterRole.Metadata.Name().Elem(),
+			},
+			Subjects: ctl.subjects(serviceAccount),
+		},
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error creating cluster role binding: %w", err)
+	}
+
+	leaseRole, err := rbacv1.NewRole(ctx, appName+"-lease", &rbacv1.RoleArgs{
+		Metadata: ctl.metadata(appName + "-lease"),
+		Rules: rbacv1.PolicyRuleArray{
+			&rbacv1.PolicyRuleArgs{
+				ApiGroups: pulumi.ToStringArray([]string{"coordination.k8s.io"}),
+				Resources: pulumi.ToStringArray([]string{"leases"}),
+				Verbs: pulumi.ToStringArray([]string{
+					"get", "create", "update",
+				}),
+			},
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error creating lease role: %w", err)
+	}
+	leaseRoleBinding, err := rbacv1.NewRoleBinding(
+		ctx,
+		appName+"-lease",
+		&rbacv1.RoleBindingArgs{
+			Metadata: ctl.metadata(appName + "-lease"),
+			RoleRef: &rbacv1.RoleRefArgs{
+				ApiGroup: pulumi.String("rbac.authorization.k8s.io"),
+				Kind:     pulumi.String("Role"),
+				Name:     leaseRole.Metadata.Name().Elem(),
+			},
+			Subjects: ctl.subjects(serviceAccount),
+		},
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error creating lease role binding: %w", err)
+	}
+	return []pulumi.Resource{clusterRoleBinding, leaseRoleBinding}, nil
+}
+
+func (ctl *Controller) subjects(
+	serviceAccount *corev1.ServiceAccount,
+) rbacv1.SubjectArray {
+	return rbacv1.SubjectArray{
+		&rbacv1.SubjectArgs{
+			Kind:      pulumi.String("ServiceAccount"),
+			Name:      serviceAccount.Metadata.Name().Elem(),
+			Namespace: pulumi.String(ctl.Config.Namespace),
+		},
+	}
+}
+
+func (ctl *Controller) createDeployment(
+	ctx *pulumi.Context,
+	serviceAccount *corev1.ServiceAccount,
+	dependencies []pulumi.Resource,
+) error {
+	labels := pulumi.StringMap{"app": pulumi.String(appName)}
+	_, err := appsv1.NewDeployment(ctx, appName, &appsv1.DeploymentArgs{
+		Metadata: ctl.metadata(appName),
+		Spec: &appsv1.DeploymentSpecArgs{
+			Replicas: pulumi.Int(ctl.Config.Replicas),
+			Selector: &metav1.LabelSelectorArgs{MatchLabels: labels},
+			Template: &corev1.PodTemplateSpecArgs{
+				Metadata: &metav1.ObjectMetaArgs{Labels: labels},
+				Spec: &corev1.PodSpecArgs{
+					ServiceAccountName: serviceAccount.Metadata.Name(),
+					Containers: corev1.ContainerArray{
+						ctl.container(),
+					},
+				},
+			},
+		},
+	}, pulumi.DependsOn(dependencies))
+	if err != nil {
+		return fmt.Errorf("error creating %s deployment: %w", appName, err)
+	}
+	return nil
+}
+
+func (ctl *Controller) container() *corev1.ContainerArgs {
+	port := ctl.Config.Port
+	return &corev1.ContainerArgs{
+		Name: pulumi.String(appName),
+		Image: pulumi.String(
+			fmt.Sprintf("%s:%s", ctl.Config.Image.Name, ctl.Config.Image.Tag),
+		),
+		ImagePullPolicy: pulumi.String(ctl.Config.Image.PullPolicy),
+		Args:            ctl.args(),
+		Env: corev1.EnvVarArray{
+			fieldEnv("POD_NAME", "metadata.name"),
+			fieldEnv("POD_NAMESPACE", "metadata.namespace"),
+		},
+		Ports: corev1.ContainerPortArray{
+			&corev1.ContainerPortArgs{
+				Name:          pulumi.String("http"),
+				ContainerPort: pulumi.Int(port),
+			},
+		},
+		LivenessProbe:  httpProbe("/healthz", port),
+		ReadinessProbe: httpProbe("/readyz", port),
+		Resources: &corev1.ResourceRequirementsArgs{
+			Requests: pulumi.StringMap{
+				"cpu":    pulumi.String("50m"),
+				"memory": pulumi.String("64Mi"),
+			},
+			Limits: pulumi.StringMap{
+				"memory": pulumi.String("256Mi"),
+			},
+		},
+	}
+}
+
+func (ctl *Controller) args() pulumi.StringArray {
+	args := []string{
+		"controller",
+		"--leader-elect",
+		"--address", fmt.Sprintf(":%d", ctl.Config.Port),
+		"--selector", ctl.Config.Selector,
+		"--label", ctl.Config.Label,
+	}
+	for _, namespace := range ctl.Config.WatchNamespaces {
+		args = append(args, "--namespace", namespace)
+	}
+	return pulumi.ToStringArray(args)
+}
+
+func (ctl *Controller) metadata(name string) *metav1.ObjectMetaArgs {
+	return &metav1.ObjectMetaArgs{
+		Name:      pulumi.String(name),
+		Namespace: pulumi.String(ctl.Config.Namespace),
+		Labels: pulumi.StringMap{
+			"app": pulumi.String(appName),
+		},
+	}
+}
+
+func fieldEnv(name, fieldPath string) *corev1.EnvVarArgs {
+	return &corev1.EnvVarArgs{
+		Name: pulumi.String(name),
+		ValueFrom: &corev1.EnvVarSourceArgs{
+			FieldRef: &corev1.ObjectFieldSelectorArgs{
+				FieldPath: pulumi.String(fieldPath),
+			},
+		},
+	}
+}
+
+func httpProbe(path string, port int) *corev1.ProbeArgs {
+	return &corev1.ProbeArgs{
+		HttpGet: &corev1.HTTPGetActionArgs{
+			Path: pulumi.String(path),
+			Port: pulumi.Int(port),
+		},
+		InitialDelaySeconds: pulumi.Int(5),
+		PeriodSeconds:       pulumi.Int(10),
+	}
+}
+
+func Run(ctx *pulumi.Context) error {
+	config, err := ReadConfig(ctx)
+	if err != nil {
+		return err
+	}
+	return NewController(config).Install(ctx)
+}
+
+func main() {
+	pulumi.Run(Run)
+}
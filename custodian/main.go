@@ -0,0 +1,306 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	batchv1 "github.com/pulumi/pulumi-kubernetes/sdk/v4/go/kubernetes/batch/v1"
+	corev1 "github.com/pulumi/pulumi-kubernetes/sdk/v4/go/kubernetes/core/v1"
+	metav1 "github.com/pulumi/pulumi-kubernetes/sdk/v4/go/kubernetes/meta/v1"
+	rbacv1 "github.com/pulumi/pulumi-kubernetes/sdk/v4/go/kubernetes/rbac/v1"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi/config"
+)
+
+type CustodianConfig struct {
+	Namespace       string
+	VeleroNamespace string
+	Image           struct {
+		Name       string
+		Tag        string
+		PullPolicy string
+	}
+	Tasks []Task
+}
+
+// Task runs a custodian command on a schedule
+type Task struct {
+	Name     string
+	Command  string
+	Schedule string
+	Args     []string
+	// Resources the command may change, as resource or resource.group, the
+	// role of commands that change resources only covers these
+	Resources []string
+}
+
+type Custodian struct {
+	Config *CustodianConfig
+}
+
+func ReadConfig(ctx *pulumi.Context) (*CustodianConfig, error) {
+	conf := config.New(ctx, "")
+	custodianConfig := &CustodianConfig{}
+	if err := conf.TryObject("properties", custodianConfig); err != nil {
+		return nil, fmt.Errorf("failed to read custodian config: %w", err)
+	}
+	return custodianConfig, nil
+}
+
+func NewCustodian(config *CustodianConfig) *Custodian {
+	return &Custodian{
+		Config: config,
+	}
+}
+
+func (cus *Custodian) Install(ctx *pulumi.Context) error {
+	for _, task := range cus.Config.Tasks {
+		if err := cus.installTask(ctx, task); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// installTask creates the service account of the task, bound to roles with
+// only the verbs its command needs, and the cronjob running it
+func (cus *Custodian) installTask(ctx *pulumi.Context, task Task) error {
+	scopes, err := permissionsFor(task)
+	if err != nil {
+		return fmt.Errorf("task %s: %w", task.Name, err)
+	}
+	name := "custodian-" + task.Name
+	serviceAccount, err := corev1.NewServiceAccount(
+		ctx,
+		name,
+		&corev1.ServiceAccountArgs{
+			Metadata: cus.metadata(name, cus.Config.Namespace, task),
+		},
+	)
+	if err != nil {
+		return fmt.Errorf("error creating service account %s: %w", name, err)
+	}
+
+	var bindings []pulumi.Resource
+	for scope, permissions := range scopes {
+		var binding pulumi.Resource
+		switch scope {
+		case ScopeCluster:
+			binding, err = cus.createClusterRole(
+				ctx,
+				name+"-"+cus.Config.Namespace,
+				task,
+				permissions,
+				serviceAccount,
+			)
+		case ScopeVelero:
+			binding, err = cus.createRole(
+				ctx,
+				name+"-velero",
+				cus.Config.VeleroNamespace,
+				task,
+				permissions,
+				serviceAccount,
+			)
+		default:
+			binding, err = cus.createRole(
+				ctx,
+				name,
+				cus.Config.Namespace,
+				task,
+				permissions,
+				serviceAccount,
+			)
+		}
+		if err != nil {
+			return err
+		}
+		bindings = append(bindings, binding)
+	}
+
+	return cus.createCronJob(ctx, name, task, serviceAccount, bindings)
+}
+
+func (cus *Custodian) createRole(
+	ctx *pulumi.Context,
+	name, namespace string,
+	task Task,
+	permissions []Permission,
+	serviceAccount *corev1.ServiceAccount,
+) (pulumi.Resource, error) {
+	role, err := rbacv1.NewRole(ctx, name, &rbacv1.RoleArgs{
+		Metadata: cus.metadata(name, namespace, task),
+		Rules:    policyRules(permissions),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error creating role %s: %w", name, err)
+	}
+	binding, err := rbacv1.NewRoleBinding(ctx, name, &rbacv1.RoleBindingArgs{
+		Metadata: cus.metadata(name, namespace, task),
+		RoleRef: &rbacv1.RoleRefArgs{
+			ApiGroup: pulumi.String("rbac.authorization.k8s.io"),
+			Kind:     pulumi.String("Role"),
+			Name:     role.Metadata.Name().Elem(),
+		},
+		Subjects: cus.subjects(serviceAccount),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error creating role binding %s: %w", name, err)
+	}
+	return binding, nil
+}
+
+// createClusterRole grants the permissions on cluster scoped resources, the
+// name includes the namespace as cluster roles are shared by all stacks
+func (cus *Custodian) createClusterRole(
+	ctx *pulumi.Context,
+	name string,
+	task Task,
+	permissions []Permission,
+	serviceAccount *corev1.ServiceAccount,
+) (pulumi.Resource, error) {
+	metadata := &metav1.ObjectMetaArgs{
+		Name:   pulumi.String(name),
+		Labels: cus.labels(task),
+	}
+	role, err := rbacv1.NewClusterRole(ctx, name, &rbacv1.ClusterRoleArgs{
+		Metadata: metadata,
+		Rules:    policyRules(permissions),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error creating cluster role %s: %w", name, err)
+	}
+	binding, err := rbacv1.NewClusterRoleBinding(
+		ctx,
+		name,
+		&rbacv1.ClusterRoleBindingArgs{
+			Metadata: metadata,
+			RoleRef: &rbacv1.RoleRefArgs{
+				ApiGroup: pulumi.String("rbac.authorization.k8s.io"),
+				Kind:     pulumi.String("ClusterRole"),
+				Name:     role.Metadata.Name().Elem(),
+			},
+			Subjects: cus.subjects(serviceAccount),
+		},
+	)
+	if err != nil {
+		return nil, fmt.Errorf(
+			"error creating cluster role binding %s: %w",
+			name,
+			err,
+		)
+	}
+	return binding, nil
+}
+
+func policyRules(permissions []Permission) rbacv1.PolicyRuleArray {
+	rules := make(rbacv1.PolicyRuleArray, 0, len(permissions))
+	for _, permission := range permissions {
+		rules = append(rules, &rbacv1.PolicyRuleArgs{
+			ApiGroups: pulumi.ToStringArray(permission.APIGroups),
+			Resources: pulumi.ToStringArray(permission.Resources),
+			Verbs:     pulumi.ToStringArray(permission.Verbs),
+		})
+	}
+	return rules
+}
+
+func (cus *Custodian) subjects(
+	serviceAccount *corev1.ServiceAccount,
+) rbacv1.SubjectArray {
+	return rbacv1.SubjectArray{
+		&rbacv1.SubjectArgs{
+			Kind:      pulumi.String("ServiceAccount"),
+			Name:      serviceAccount.Metadata.Name().Elem(),
+			Namespace: pulumi.String(cus.Config.Namespace),
+		},
+	}
+}
+
+func (cus *Custodian) createCronJob(
+	ctx *pulumi.Context,
+	name string,
+	task Task,
+	serviceAccount *corev1.ServiceAccount,
+	dependencies []pulumi.Resource,
+) error {
+	_, err := batchv1.NewCronJob(ctx, name, &batchv1.CronJobArgs{
+		Metadata: cus.metadata(name, cus.Config.Namespace, task),
+		Spec: &batchv1.CronJobSpecArgs{
+			Schedule:                   pulumi.String(task.Schedule),
+			ConcurrencyPolicy:          pulumi.String("Forbid"),
+			SuccessfulJobsHistoryLimit: pulumi.Int(3),
+			FailedJobsHistoryLimit:     pulumi.Int(3),
+			JobTemplate: &batchv1.JobTemplateSpecArgs{
+				Spec: &batchv1.JobSpecArgs{
+					BackoffLimit: pulumi.Int(1),
+					Template: &corev1.PodTemplateSpecArgs{
+						Metadata: &metav1.ObjectMetaArgs{
+							Labels: cus.labels(task),
+						},
+						Spec: &corev1.PodSpecArgs{
+							ServiceAccountName: serviceAccount.Metadata.Name(),
+							RestartPolicy:      pulumi.String("Never"),
+							Containers: corev1.ContainerArray{
+								cus.container(task),
+							},
+						},
+					},
+				},
+			},
+		},
+	}, pulumi.DependsOn(dependencies))
+	if err != nil {
+		return fmt.Errorf("error creating cronjob %s: %w", name, err)
+	}
+	return nil
+}
+
+func (cus *Custodian) container(task Task) *corev1.ContainerArgs {
+	args := strings.Fields(task.Command)
+	args = append(args, "--namespace", cus.Config.Namespace)
+	if resourceFlagCommands[task.Command] {
+		for _, resource := range task.Resources {
+			args = append(args, "--resource", resource)
+		}
+	}
+	args = append(args, task.Args...)
+	return &corev1.ContainerArgs{
+		Name: pulumi.String("custodian"),
+		Image: pulumi.String(
+			fmt.Sprintf("%s:%s", cus.Config.Image.Name, cus.Config.Image.Tag),
+		),
+		ImagePullPolicy: pulumi.String(cus.Config.Image.PullPolicy),
+		Args:            pulumi.ToStringArray(args),
+	}
+}
+
+func (cus *Custodian) metadata(
+	name, namespace string,
+	task Task,
+) *metav1.ObjectMetaArgs {
+	return &metav1.ObjectMetaArgs{
+		Name:      pulumi.String(name),
+		Namespace: pulumi.String(namespace),
+		Labels:    cus.labels(task),
+	}
+}
+
+func (cus *Custodian) labels(task Task) pulumi.StringMap {
+	return pulumi.StringMap{
+		"app":            pulumi.String("custodian"),
+		"custodian/task": pulumi.String(task.Name),
+	}
+}
+
+func Run(ctx *pulumi.Context) error {
+	config, err := ReadConfig(ctx)
+	if err != nil {
+		return err
+	}
+	return NewCustodian(config).Install(ctx)
+}
+
+func main() {
+	pulumi.Run(Run)
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Permission is a policy rule needed by a custodian command
+type Permission struct {
+	APIGroups []string
+	Resources []string
+	Verbs     []string
+	// Scope places the rule in the task namespace when empty, in the velero
+	// namespace or cluster wide
+	Scope string
+	// TaskResources takes the API groups and resources from the resources
+	// of the task instead of granting the verbs on every resource
+	TaskResources bool
+	// Flag grants the permission only to the tasks passing it in their
+	// arguments
+	Flag string
+}
+
+// Scopes of the permissions
+const (
+	ScopeVelero  = "velero"
+	ScopeCluster = "cluster"
+)
+
+// commandPermissions lists the verbs each custodian command uses, keyed by
+// the command as given on the command line
+var commandPermissions = map[string][]Permission{
+	"extract-log": {
+		{APIGroups: []string{"batch"}, Resources: []string{"jobs"}, Verbs: []string{"list", "watch"}},
+		{APIGroups: []string{""}, Resources: []string{"pods"}, Verbs: []string{"list", "watch"}},
+		{APIGroups: []string{""}, Resources: []string{"pods/log"}, Verbs: []string{"get"}},
+	},
+	"exclude-from-backup": {
+		{Verbs: []string{"list", "patch"}, TaskResources: true},
+	},
+	"include-in-backup": {
+		{Verbs: []string{"list", "patch"}, TaskResources: true},
+	},
+	"exclude-volumes-from-backup": {
+		{APIGroups: []string{""}, Resources: []string{"pods"}, Verbs: []string{"list", "patch"}},
+		{APIGroups: []string{""}, Resources: []string{"persistentvolumeclaims"}, Verbs: []string{"get"}},
+		{APIGroups: []string{"apps"}, Resources: []string{"replicasets"}, Verbs: []string{"get"}},
+		{APIGroups: []string{"apps"}, Resources: []string{"deployments", "statefulsets"}, Verbs: []string{"get", "patch"}},
+	},
+	"jobs report": {
+		{APIGroups: []string{"batch"}, Resources: []string{"jobs"}, Verbs: []string{"list"}},
+		{APIGroups: []string{""}, Resources: []string{"pods", "events"}, Verbs: []string{"list"}},
+		{APIGroups: []string{""}, Resources: []string{"pods/log"}, Verbs: []string{"get"}},
+	},
+	"cleanup-jobs": {
+		{APIGroups: []string{"batch"}, Resources: []string{"jobs"}, Verbs: []string{"list", "delete"}},
+		{APIGroups: []string{""}, Resources: []string{"pods"}, Verbs: []string{"list"}},
+		{APIGroups: []string{""}, Resources: []string{"pods/log"}, Verbs: []string{"get"}},
+	},
+	// the resources read by the bundled policies
+	"policy plan": {
+		{APIGroups: []string{""}, Resources: []string{"pods", "persistentvolumeclaims", "services", "secrets", "configmaps", "serviceaccounts"}, Verbs: []string{"list"}},
+		{APIGroups: []string{"apps"}, Resources: []string{"deployments", "statefulsets", "replicasets"}, Verbs: []string{"list"}},
+		{APIGroups: []string{"batch"}, Resources: []string{"jobs"}, Verbs: []string{"list"}},
+	},
+	"policy apply": {
+		{Verbs: []string{"list", "patch", "delete"}, TaskResources: true},
+		// extractLogs reads the pods of the matched jobs
+		{APIGroups: []string{""}, Resources: []string{"pods"}, Verbs: []string{"list"}},
+		{APIGroups: []string{""}, Resources: []string{"pods/log"}, Verbs: []string{"get"}},
+	},
+	"orphans": {
+		{APIGroups: []string{""}, Resources: []string{"pods"}, Verbs: []string{"list"}},
+		{APIGroups: []string{""}, Resources: []string{"persistentvolumeclaims"}, Verbs: []string{"list", "get"}},
+		{APIGroups: []string{""}, Resources: []string{"persistentvolumes"}, Verbs: []string{"list"}, Scope: ScopeCluster},
+		{APIGroups: []string{""}, Resources: []string{"persistentvolumeclaims"}, Verbs: []string{"delete"}, Flag: "--delete"},
+		{APIGroups: []string{""}, Resources: []string{"persistentvolumes"}, Verbs: []string{"delete"}, Scope: ScopeCluster, Flag: "--delete"},
+	},
+	"unused-config": {
+		{APIGroups: []string{""}, Resources: []string{"pods", "serviceaccounts", "secrets", "configmaps"}, Verbs: []string{"list"}},
+		{APIGroups: []string{"apps"}, Resources: []string{"deployments", "statefulsets", "daemonsets"}, Verbs: []string{"list"}},
+		{APIGroups: []string{"batch"}, Resources: []string{"cronjobs"}, Verbs: []string{"list"}},
+		{APIGroups: []string{"networking.k8s.io"}, Resources: []string{"ingresses"}, Verbs: []string{"list"}},
+		{APIGroups: []string{"postgresql.cnpg.io"}, Resources: []string{"clusters"}, Verbs: []string{"list"}},
+		{APIGroups: []string{"database.arangodb.com"}, Resources: []string{"arangodeployments"}, Verbs: []string{"list"}},
+	},
+	"certs": {
+		{APIGroups: []string{""}, Resources: []string{"secrets"}, Verbs: []string{"list"}},
+		{APIGroups: []string{"networking.k8s.io"}, Resources: []string{"ingresses"}, Verbs: []string{"list"}},
+	},
+	"images": {
+		{APIGroups: []string{""}, Resources: []string{"pods"}, Verbs: []string{"list"}},
+		{APIGroups: []string{"apps"}, Resources: []string{"deployments", "statefulsets", "daemonsets"}, Verbs: []string{"list"}},
+		{APIGroups: []string{"batch"}, Resources: []string{"cronjobs"}, Verbs: []string{"list"}},
+	},
+	"workload-audit": {
+		{APIGroups: []string{""}, Resources: []string{"pods"}, Verbs: []string{"get"}},
+		{APIGroups: []string{"apps"}, Resources: []string{"deployments", "statefulsets", "daemonsets"}, Verbs: []string{"list"}},
+		{APIGroups: []string{"apps"}, Resources: []string{"replicasets"}, Verbs: []string{"get"}},
+		{APIGroups: []string{"batch"}, Resources: []string{"cronjobs"}, Verbs: []string{"list"}},
+		{APIGroups: []string{"metrics.k8s.io"}, Resources: []string{"pods"}, Verbs: []string{"list"}},
+	},
+	"hibernate": {
+		{APIGroups: []string{"apps"}, Resources: []string{"deployments"}, Verbs: []string{"list", "patch"}},
+		{APIGroups: []string{"apps"}, Resources: []string{"statefulsets"}, Verbs: []string{"list", "get", "patch"}},
+		{APIGroups: []string{"batch"}, Resources: []string{"cronjobs"}, Verbs: []string{"list", "patch"}},
+		{APIGroups: []string{"postgresql.cnpg.io"}, Resources: []string{"clusters"}, Verbs: []string{"list", "patch"}},
+		{APIGroups: []string{"redis.redis.opstreelabs.in"}, Resources: []string{"redis"}, Verbs: []string{"list", "patch"}},
+		{APIGroups: []string{"database.arangodb.com"}, Resources: []string{"arangodeployments"}, Verbs: []string{"list"}},
+	},
+	"resume": {
+		{APIGroups: []string{"apps"}, Resources: []string{"deployments"}, Verbs: []string{"list", "patch"}},
+		{APIGroups: []string{"apps"}, Resources: []string{"statefulsets"}, Verbs: []string{"list", "get", "patch"}},
+		{APIGroups: []string{"batch"}, Resources: []string{"cronjobs"}, Verbs: []string{"list", "patch"}},
+		{APIGroups: []string{"postgresql.cnpg.io"}, Resources: []string{"clusters"}, Verbs: []string{"list", "patch"}},
+		{APIGroups: []string{"redis.redis.opstreelabs.in"}, Resources: []string{"redis"}, Verbs: []string{"list", "patch"}},
+		{APIGroups: []string{"database.arangodb.com"}, Resources: []string{"arangodeployments"}, Verbs: []string{"list"}},
+	},
+	"run-cronjob": {
+		{APIGroups: []string{"batch"}, Resources: []string{"cronjobs"}, Verbs: []string{"get"}},
+		{APIGroups: []string{"batch"}, Resources: []string{"cronjobs/finalizers"}, Verbs: []string{"update"}},
+		{APIGroups: []string{"batch"}, Resources: []string{"jobs"}, Verbs: []string{"create", "list", "watch"}},
+		{APIGroups: []string{""}, Resources: []string{"pods"}, Verbs: []string{"list", "watch"}},
+		{APIGroups: []string{""}, Resources: []string{"pods/log"}, Verbs: []string{"get"}},
+	},
+	"export": {
+		{APIGroups: []string{"*"}, Resources: []string{"*"}, Verbs: []string{"list"}},
+	},
+	"db-status": {
+		{APIGroups: []string{"postgresql.cnpg.io"}, Resources: []string{"clusters", "backups", "scheduledbackups"}, Verbs: []string{"list"}},
+		{APIGroups: []string{"database.arangodb.com"}, Resources: []string{"arangodeployments"}, Verbs: []string{"list"}},
+		{APIGroups: []string{"redis.redis.opstreelabs.in"}, Resources: []string{"redis"}, Verbs: []string{"list"}},
+		{APIGroups: []string{"apps"}, Resources: []string{"statefulsets"}, Verbs: []string{"get"}},
+	},
+	"events": {
+		{APIGroups: []string{""}, Resources: []string{"events", "pods"}, Verbs: []string{"list"}},
+	},
+	"forward": {
+		{APIGroups: []string{""}, Resources: []string{"events", "pods"}, Verbs: []string{"list"}},
+		{APIGroups: []string{""}, Resources: []string{"configmaps"}, Verbs: []string{"get", "create", "update"}},
+		{APIGroups: []string{"batch"}, Resources: []string{"jobs"}, Verbs: []string{"list"}},
+		{APIGroups: []string{"postgresql.cnpg.io"}, Resources: []string{"clusters", "backups", "scheduledbackups"}, Verbs: []string{"list"}},
+		{APIGroups: []string{"database.arangodb.com"}, Resources: []string{"arangodeployments"}, Verbs: []string{"list"}},
+		{APIGroups: []string{"redis.redis.opstreelabs.in"}, Resources: []string{"redis"}, Verbs: []string{"list"}},
+		{APIGroups: []string{"apps"}, Resources: []string{"statefulsets"}, Verbs: []string{"get"}},
+	},
+	"backup-audit": {
+		{APIGroups: []string{"*"}, Resources: []string{"*"}, Verbs: []string{"list"}},
+		{APIGroups: []string{"velero.io"}, Resources: []string{"schedules", "backups"}, Verbs: []string{"get", "list"}, Scope: ScopeVelero},
+	},
+}
+
+// resourceFlagCommands are limited to the resources of the task with the
+// --resource flag, the other commands find them on their own, e.g. from the
+// kinds of a policy
+var resourceFlagCommands = map[string]bool{
+	"exclude-from-backup": true,
+	"include-in-backup":   true,
+}
+
+// permissionsFor groups the permissions of the task command by their scope
+func permissionsFor(task Task) (map[string][]Permission, error) {
+	permissions, ok := commandPermissions[task.Command]
+	if !ok {
+		commands := make([]string, 0, len(commandPermissions))
+		for name := range commandPermissions {
+			commands = append(commands, name)
+		}
+		sort.Strings(commands)
+		return nil, fmt.Errorf(
+			"unsupported custodian command %q, use one of %s",
+			task.Command,
+			strings.Join(commands, ", "),
+		)
+	}
+	scopes := make(map[string][]Permission)
+	for _, permission := range permissions {
+		if len(permission.Flag) > 0 && !hasFlag(task.Args, permission.Flag) {
+			continue
+		}
+		if !permission.TaskResources {
+			scopes[permission.Scope] = append(scopes[permission.Scope], permission)
+			continue
+		}
+		if len(task.Resources) == 0 {
+			return nil, fmt.Errorf(
+				"command %q changes resources, list them in the resources of the task",
+				task.Command,
+			)
+		}
+		scopes[permission.Scope] = append(
+			scopes[permission.Scope],
+			taskResourcePermissions(task.Resources, permission)...,
+		)
+	}
+	return scopes, nil
+}
+
+// taskResourcePermissions grants the verbs of the permission on the
+// resources, given as resource or resource.group, with a rule per group
+func taskResourcePermissions(resources []string, permission Permission) []Permission {
+	groups := make(map[string][]string)
+	for _, name := range resources {
+		resource, group, _ := strings.Cut(name, ".")
+		groups[group] = append(groups[group], resource)
+	}
+	names := make([]string, 0, len(groups))
+	for group := range groups {
+		names = append(names, group)
+	}
+	sort.Strings(names)
+	permissions := make([]Permission, 0, len(names))
+	for _, group := range names {
+		permissions = append(permissions, Permission{
+			APIGroups: []string{group},
+			Resources: groups[group],
+			Verbs:     permission.Verbs,
+			Scope:     permission.Scope,
+		})
+	}
+	return permissions
+}
+
+// hasFlag checks for a flag, also given as --flag=value, in the arguments
+func hasFlag(args []string, flag string) bool {
+	for _, arg := range args {
+		if arg == flag || strings.HasPrefix(arg, flag+"=") {
+			return true
+		}
+	}
+	return false
+}
@@ -12,25 +12,45 @@ import (
 func ArangoDBBackupAction(cltx *cli.Context, port int) error {
 	config := extractConfig(cltx)
 
-	if err := setResticPassword(config.ResticPassword); err != nil {
+	if err := resolveResticPassword(cltx, config.ResticPassword); err != nil {
 		return cli.Exit(err.Error(), 2)
 	}
 
-	if err := ensureRepositoryExists(config.Repository); err != nil {
+	hooks, err := LoadHookConfig(cltx.String("hooks"))
+	if err != nil {
 		return cli.Exit(err.Error(), 2)
 	}
 
-	if err := runArangoDump(config); err != nil {
-		return cli.Exit(err.Error(), 2)
+	if cltx.Bool("dry-run") {
+		logDryRunHooks(hooks)
+		if err := arangoDBDryRun(config); err != nil {
+			return cli.Exit(err.Error(), 2)
+		}
+		return nil
 	}
 
-	if err := backupToRestic(config.Repository, config.Output); err != nil {
+	err = runWithHooks(cltx, hooks, func() error {
+		return arangoDBBackup(config)
+	})
+	if err != nil {
 		return cli.Exit(err.Error(), 2)
 	}
 
 	return nil
 }
 
+// arangoDBBackup initializes the repository when needed, dumps the
+// databases and uploads the dump
+func arangoDBBackup(config arangoDBConfig) error {
+	if err := ensureRepositoryExists(config.Repository); err != nil {
+		return err
+	}
+	if err := runArangoDump(config); err != nil {
+		return err
+	}
+	return backupToRestic(config.Repository, config.Output)
+}
+
 type arangoDBConfig struct {
 	User           string
 	Password       string
@@ -73,7 +93,7 @@ func ensureRepositoryExists(repository string) error {
 				"output",
 				string(initOutput),
 			)
-			return err
+			return fmt.Errorf("failed to initialize repository %s: %w", repository, err)
 		}
 		slog.Info("Repository initialized successfully")
 	} else {
@@ -125,8 +145,12 @@ func buildArangoDumpArgs(config arangoDBConfig) []string {
 	}
 }
 
+func buildResticBackupArgs(repository, output string) []string {
+	return []string{"-r", repository, "backup", output}
+}
+
 func backupToRestic(repository, output string) error {
-	cmd := exec.Command("restic", "-r", repository, "backup", output)
+	cmd := exec.Command("restic", buildResticBackupArgs(repository, output)...)
 	backupOutput, err := cmd.CombinedOutput()
 	if err != nil {
 		slog.Error(
@@ -0,0 +1,227 @@
+package backup
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/exec"
+	"slices"
+	"strings"
+	"time"
+
+	cli "github.com/urfave/cli/v2"
+)
+
+const (
+	redactedValue = "[REDACTED]"
+	// restic exits with this code when the repository does not exist, only
+	// since 0.17, older versions exit with 1 for any error
+	resticExitRepoNotFound = 10
+	connectivityTimeout    = 10 * time.Second
+)
+
+// minResticVersion is the first restic version with a dedicated exit code
+// for a missing repository
+var minResticVersion = [2]int{0, 17}
+
+// sensitiveArgs lists the command line options whose values are never printed
+var sensitiveArgs = []string{"--server.password", "--password", "-a"}
+
+func arangoDBDryRun(config arangoDBConfig) error {
+	if err := validateConfig(config); err != nil {
+		return err
+	}
+	logDryRunStart()
+
+	if err := checkArangoDBConnectivity(config); err != nil {
+		return err
+	}
+	slog.Info("ArangoDB server is reachable", "server", config.Server)
+
+	if err := checkRepositoryDryRun(config.Repository); err != nil {
+		return err
+	}
+
+	logDryRunCommand("arangodump", buildArangoDumpArgs(config))
+	logDryRunCommand(
+		"restic",
+		buildResticBackupArgs(config.Repository, config.Output),
+	)
+	return nil
+}
+
+func redisDryRun(host string, port int, repository string) error {
+	sanitizedRepo, sanitizedHost, sanitizedPort, err := validateAndSanitizeInputs(
+		repository,
+		host,
+		port,
+	)
+	if err != nil {
+		return cli.Exit(err.Error(), 2)
+	}
+	logDryRunStart()
+
+	rdb := createRedisClient(sanitizedHost, sanitizedPort)
+	defer rdb.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), connectivityTimeout)
+	defer cancel()
+	if err := rdb.Ping(ctx).Err(); err != nil {
+		return cli.Exit(fmt.Sprintf("Failed to reach Redis: %v", err), 2)
+	}
+	slog.Info("Redis server is reachable", "host", sanitizedHost)
+
+	if err := checkRepositoryDryRun(sanitizedRepo); err != nil {
+		return cli.Exit(err.Error(), 2)
+	}
+
+	slog.Info("Dry run: would execute", "command", "BGSAVE")
+	slog.Info(
+		"Dry run: would execute",
+		"command",
+		fmt.Sprintf(
+			"%s | %s",
+			commandLine("redis-cli", buildRedisCliArgs(sanitizedHost, sanitizedPort)),
+			commandLine("restic", buildResticStdinArgs(sanitizedRepo)),
+		),
+	)
+	return nil
+}
+
+func logDryRunStart() {
+	resticPassword := "unset"
+	if _, ok := os.LookupEnv("RESTIC_PASSWORD"); ok {
+		resticPassword = redactedValue
+	}
+	slog.Info(
+		"Dry run: no repository will be initialized and no data will be written",
+		"RESTIC_PASSWORD",
+		resticPassword,
+	)
+}
+
+func checkArangoDBConnectivity(config arangoDBConfig) error {
+	ctx, cancel := context.WithTimeout(context.Background(), connectivityTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(
+		ctx,
+		http.MethodGet,
+		fmt.Sprintf("http://%s:%d/_api/version", config.Server, config.Port),
+		nil,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to create ArangoDB request: %w", err)
+	}
+	req.SetBasicAuth(config.User, config.Password)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to reach ArangoDB: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf(
+			"unexpected response from ArangoDB: %s",
+			resp.Status,
+		)
+	}
+	return nil
+}
+
+// checkRepositoryDryRun verifies that the restic repository can be opened
+// with read-only commands. A missing repository is only reported, as a real
+// run would initialize it. Telling a missing repository apart from other
+// failures needs restic 0.17 or later, older versions are rejected.
+func checkRepositoryDryRun(repository string) error {
+	if err := checkResticVersion(); err != nil {
+		return err
+	}
+	args := []string{"-r", repository, "cat", "config"}
+	output, err := exec.Command("restic", args...).CombinedOutput()
+	if err == nil {
+		slog.Info("Repository is accessible", "repository", repository)
+		return nil
+	}
+
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) &&
+		exitErr.ExitCode() == resticExitRepoNotFound {
+		slog.Warn(
+			"Repository does not exist, a real run would initialize it",
+			"repository",
+			repository,
+		)
+		logDryRunCommand("restic", []string{"-r", repository, "init"})
+		return nil
+	}
+
+	return fmt.Errorf(
+		"failed to access repository %s: %w: %s",
+		repository,
+		err,
+		strings.TrimSpace(string(output)),
+	)
+}
+
+// checkResticVersion fails for restic versions without the exit code of a
+// missing repository
+func checkResticVersion() error {
+	output, err := exec.Command("restic", "version").Output()
+	if err != nil {
+		return fmt.Errorf("failed to get restic version: %w", err)
+	}
+	// restic 0.17.3 compiled with go1.23.1 on linux/amd64
+	fields := strings.Fields(string(output))
+	if len(fields) < 2 || fields[0] != "restic" {
+		return fmt.Errorf("unexpected restic version %q", strings.TrimSpace(string(output)))
+	}
+	var version [2]int
+	if _, err := fmt.Sscanf(fields[1], "%d.%d", &version[0], &version[1]); err != nil {
+		return fmt.Errorf("unexpected restic version %q: %w", fields[1], err)
+	}
+	if slices.Compare(version[:], minResticVersion[:]) < 0 {
+		return fmt.Errorf(
+			"dry run needs restic %d.%d or later to detect a missing repository, found %s",
+			minResticVersion[0],
+			minResticVersion[1],
+			fields[1],
+		)
+	}
+	return nil
+}
+
+func logDryRunCommand(name string, args []string) {
+	slog.Info("Dry run: would execute", "command", commandLine(name, args))
+}
+
+// commandLine renders the command as it would be typed in a shell, with the
+// values of sensitive options redacted.
+func commandLine(name string, args []string) string {
+	redacted := redactArgs(args)
+	parts := make([]string, 0, len(redacted)+1)
+	parts = append(parts, name)
+	for _, arg := range redacted {
+		if strings.ContainsAny(arg, " \t\"'") {
+			arg = fmt.Sprintf("%q", arg)
+		}
+		parts = append(parts, arg)
+	}
+	return strings.Join(parts, " ")
+}
+
+func redactArgs(args []string) []string {
+	redacted := slices.Clone(args)
+	for idx := 0; idx < len(redacted); idx++ {
+		if slices.Contains(sensitiveArgs, redacted[idx]) &&
+			idx+1 < len(redacted) {
+			redacted[idx+1] = redactedValue
+			idx++
+		}
+	}
+	return redacted
+}
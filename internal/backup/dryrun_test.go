@@ -0,0 +1,138 @@
+package backup
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/urfave/cli/v2"
+)
+
+// fakeRestic puts a restic script on the path that reports the version and
+// exits with the code for any other command
+func fakeRestic(t *testing.T, version string, code int) {
+	t.Helper()
+	dir := t.TempDir()
+	script := fmt.Sprintf(`#!/bin/sh
+if [ "$1" = version ]; then
+	echo "restic %s compiled with go1.22.5 on linux/amd64"
+	exit 0
+fi
+echo "Fatal: repository error" >&2
+exit %d
+`, version, code)
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "restic"), []byte(script), 0o755))
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+}
+
+func TestRedactArgs(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		want []string
+	}{
+		{
+			name: "arangodump password",
+			args: []string{"--server.username", "root", "--server.password", "s3cret"},
+			want: []string{"--server.username", "root", "--server.password", redactedValue},
+		},
+		{
+			name: "redis-cli password",
+			args: []string{"-h", "redis", "-a", "s3cret", "--rdb", "-"},
+			want: []string{"-h", "redis", "-a", redactedValue, "--rdb", "-"},
+		},
+		{
+			name: "password as last argument",
+			args: []string{"--password"},
+			want: []string{"--password"},
+		},
+		{
+			name: "value named like an option",
+			args: []string{"--password", "-a", "-h", "redis"},
+			want: []string{"--password", redactedValue, "-h", "redis"},
+		},
+		{
+			name: "nothing sensitive",
+			args: []string{"-r", "gs:bucket:/arangodb", "backup", "/data"},
+			want: []string{"-r", "gs:bucket:/arangodb", "backup", "/data"},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			args := append([]string{}, tc.args...)
+			assert.Equal(t, tc.want, redactArgs(args))
+			assert.Equal(t, tc.args, args, "the arguments are left untouched")
+		})
+	}
+}
+
+func TestCommandLine(t *testing.T) {
+	tests := []struct {
+		name    string
+		command string
+		args    []string
+		want    string
+	}{
+		{
+			name:    "redacted password",
+			command: "arangodump",
+			args:    buildArangoDumpArgs(arangoDBConfig{User: "root", Password: "s3cret", Server: "arangodb", Port: 8529, Output: "/data"}),
+			want:    "arangodump --all-databases --server.username root --server.password [REDACTED] --server.endpoint http+tcp://arangodb:8529 --output-directory /data --overwrite",
+		},
+		{
+			name:    "quoted argument",
+			command: "restic",
+			args:    []string{"-r", "/backups/my repo", "backup", "--tag", `it's`},
+			want:    `restic -r "/backups/my repo" backup --tag "it's"`,
+		},
+		{
+			name:    "quoted password is still redacted",
+			command: "redis-cli",
+			args:    []string{"-a", "pass word"},
+			want:    "redis-cli -a [REDACTED]",
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.want, commandLine(tc.command, tc.args))
+			assert.NotContains(t, commandLine(tc.command, tc.args), "s3cret")
+		})
+	}
+}
+
+func TestCheckRepositoryDryRun(t *testing.T) {
+	tests := []struct {
+		name    string
+		version string
+		code    int
+		wantErr string
+	}{
+		{name: "accessible", version: "0.17.3", code: 0},
+		{name: "missing repository", version: "0.17.0", code: resticExitRepoNotFound},
+		{name: "wrong password", version: "0.17.3", code: 12, wantErr: "failed to access repository"},
+		{name: "other failure", version: "0.18.0", code: 1, wantErr: "Fatal: repository error"},
+		{name: "old restic", version: "0.16.4", code: 0, wantErr: "needs restic 0.17 or later"},
+		{name: "old restic with missing repository", version: "0.9.6", code: resticExitRepoNotFound, wantErr: "found 0.9.6"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			fakeRestic(t, tc.version, tc.code)
+			err := checkRepositoryDryRun("/backups/redis")
+			if len(tc.wantErr) == 0 {
+				assert.NoError(t, err)
+				return
+			}
+			assert.ErrorContains(t, err, tc.wantErr)
+		})
+	}
+}
+
+func TestRedisDryRunExitsOnInvalidInput(t *testing.T) {
+	err := redisDryRun("redis", 6379, "")
+	var exitErr cli.ExitCoder
+	require.ErrorAs(t, err, &exitErr)
+	assert.Equal(t, 2, exitErr.ExitCode())
+}
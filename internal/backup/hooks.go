@@ -0,0 +1,383 @@
+package backup
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/urfave/cli/v2"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/clientcmd"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	defaultHookTimeout = 5 * time.Minute
+	prePhase           = "pre"
+	postPhase          = "post"
+)
+
+// HookConfig lists the hooks that run before and after a backup. Post hooks
+// always run, even when a pre hook or the backup itself failed.
+type HookConfig struct {
+	Pre  []Hook `json:"pre"`
+	Post []Hook `json:"post"`
+}
+
+// Hook is a single action run around a backup, exactly one of Exec, HTTP or
+// Scale has to be set
+type Hook struct {
+	Name    string     `json:"name"`
+	Timeout string     `json:"timeout"`
+	Exec    *ExecHook  `json:"exec,omitempty"`
+	HTTP    *HTTPHook  `json:"http,omitempty"`
+	Scale   *ScaleHook `json:"scale,omitempty"`
+}
+
+// ExecHook runs a command without a shell, use ["sh", "-c", "..."] for one
+type ExecHook struct {
+	Command []string `json:"command"`
+}
+
+// HTTPHook sends a request and expects a 2xx response
+type HTTPHook struct {
+	Method  string            `json:"method"`
+	URL     string            `json:"url"`
+	Headers map[string]string `json:"headers"`
+	Body    string            `json:"body"`
+}
+
+// ScaleHook scales deployments to the given replicas. Without replicas, the
+// deployments are restored to the counts recorded by an earlier scale hook.
+type ScaleHook struct {
+	Namespace   string   `json:"namespace"`
+	Deployments []string `json:"deployments"`
+	Replicas    *int32   `json:"replicas,omitempty"`
+	Wait        bool     `json:"wait"`
+}
+
+type hookRunner struct {
+	kubeconfig       string
+	clientset        kubernetes.Interface
+	previousReplicas map[string]int32
+}
+
+// LoadHookConfig reads the YAML hook configuration, an empty path means no
+// hooks
+func LoadHookConfig(file string) (*HookConfig, error) {
+	config := &HookConfig{}
+	if len(file) == 0 {
+		return config, nil
+	}
+	content, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read hooks file: %w", err)
+	}
+	if err := yaml.UnmarshalStrict(content, config); err != nil {
+		return nil, fmt.Errorf("failed to parse hooks file: %w", err)
+	}
+	for _, hook := range append(config.Pre, config.Post...) {
+		if err := hook.validate(); err != nil {
+			return nil, err
+		}
+	}
+	return config, nil
+}
+
+func (hook Hook) validate() error {
+	count := 0
+	for _, set := range []bool{hook.Exec != nil, hook.HTTP != nil, hook.Scale != nil} {
+		if set {
+			count++
+		}
+	}
+	if count != 1 {
+		return fmt.Errorf(
+			"hook %q must define exactly one of exec, http or scale",
+			hook.Name,
+		)
+	}
+	if _, err := hook.timeout(); err != nil {
+		return err
+	}
+	switch {
+	case hook.Exec != nil && len(hook.Exec.Command) == 0:
+		return fmt.Errorf("exec hook %q has no command", hook.Name)
+	case hook.HTTP != nil && len(hook.HTTP.URL) == 0:
+		return fmt.Errorf("http hook %q has no url", hook.Name)
+	case hook.Scale != nil && len(hook.Scale.Deployments) == 0:
+		return fmt.Errorf("scale hook %q has no deployments", hook.Name)
+	}
+	return nil
+}
+
+func (hook Hook) kind() string {
+	switch {
+	case hook.Exec != nil:
+		return "exec"
+	case hook.HTTP != nil:
+		return "http"
+	default:
+		return "scale"
+	}
+}
+
+func (hook Hook) timeout() (time.Duration, error) {
+	if len(hook.Timeout) == 0 {
+		return defaultHookTimeout, nil
+	}
+	timeout, err := time.ParseDuration(hook.Timeout)
+	if err != nil {
+		return 0, fmt.Errorf("invalid timeout for hook %q: %w", hook.Name, err)
+	}
+	return timeout, nil
+}
+
+// runWithHooks runs the pre hooks, the backup and then the post hooks. The
+// backup is skipped when a pre hook fails, the post hooks always run.
+func runWithHooks(
+	cltx *cli.Context,
+	hooks *HookConfig,
+	backupFn func() error,
+) error {
+	runner := &hookRunner{
+		kubeconfig:       cltx.String("kubeconfig"),
+		previousReplicas: make(map[string]int32),
+	}
+	return runner.run(cltx.Command.Name, hooks, cltx.String("report"), backupFn)
+}
+
+// run runs the hooks around the backup and writes the report of the command
+// to the report file when given
+func (hrn *hookRunner) run(
+	command string,
+	hooks *HookConfig,
+	reportFile string,
+	backupFn func() error,
+) error {
+	report := newRunReport(command)
+	err := hrn.runPhase(prePhase, hooks.Pre, report)
+	if err == nil {
+		err = backupFn()
+	}
+	err = errors.Join(err, hrn.runPhase(postPhase, hooks.Post, report))
+
+	report.finish(err)
+	if reportErr := report.write(reportFile); reportErr != nil {
+		err = errors.Join(err, reportErr)
+	}
+	return err
+}
+
+// runPhase runs the hooks in order. Pre hooks stop at the first failure,
+// post hooks keep going so every pre hook gets a chance to be undone.
+func (hrn *hookRunner) runPhase(
+	phase string,
+	hooks []Hook,
+	report *RunReport,
+) error {
+	var errs []error
+	for _, hook := range hooks {
+		err := hrn.runHook(phase, hook, report)
+		if err == nil {
+			continue
+		}
+		errs = append(errs, err)
+		if phase == prePhase {
+			break
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (hrn *hookRunner) runHook(
+	phase string,
+	hook Hook,
+	report *RunReport,
+) error {
+	timeout, _ := hook.timeout()
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	started := time.Now()
+	slog.Info("Running hook", "phase", phase, "name", hook.Name, "type", hook.kind())
+	var err error
+	switch {
+	case hook.Exec != nil:
+		err = runExecHook(ctx, hook.Exec)
+	case hook.HTTP != nil:
+		err = runHTTPHook(ctx, hook.HTTP)
+	default:
+		err = hrn.runScaleHook(ctx, hook.Scale)
+	}
+	report.addHook(phase, hook, started, err)
+	if err != nil {
+		slog.Error("Hook failed", "phase", phase, "name", hook.Name, "error", err)
+		return fmt.Errorf("%s hook %q failed: %w", phase, hook.Name, err)
+	}
+	return nil
+}
+
+func runExecHook(ctx context.Context, hook *ExecHook) error {
+	cmd := exec.CommandContext(ctx, hook.Command[0], hook.Command[1:]...)
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%w: %s", err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+func runHTTPHook(ctx context.Context, hook *HTTPHook) error {
+	method := hook.Method
+	if len(method) == 0 {
+		method = http.MethodPost
+	}
+	req, err := http.NewRequestWithContext(
+		ctx,
+		method,
+		hook.URL,
+		bytes.NewBufferString(hook.Body),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	for key, value := range hook.Headers {
+		req.Header.Set(key, value)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("unexpected response status %s", resp.Status)
+	}
+	return nil
+}
+
+func (hrn *hookRunner) runScaleHook(ctx context.Context, hook *ScaleHook) error {
+	clientset, err := hrn.kubernetesClient()
+	if err != nil {
+		return err
+	}
+	deployments := clientset.AppsV1().Deployments(hook.Namespace)
+	for _, name := range hook.Deployments {
+		key := fmt.Sprintf("%s/%s", hook.Namespace, name)
+		scale, err := deployments.GetScale(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return fmt.Errorf("failed to get scale of %s: %w", key, err)
+		}
+
+		replicas, ok := hrn.targetReplicas(hook, key)
+		if !ok {
+			slog.Warn("No recorded replicas to restore", "deployment", key)
+			continue
+		}
+		if _, ok := hrn.previousReplicas[key]; !ok {
+			hrn.previousReplicas[key] = scale.Spec.Replicas
+		}
+		scale.Spec.Replicas = replicas
+		if _, err := deployments.UpdateScale(
+			ctx, name, scale, metav1.UpdateOptions{},
+		); err != nil {
+			return fmt.Errorf("failed to scale %s: %w", key, err)
+		}
+		slog.Info("Scaled deployment", "deployment", key, "replicas", replicas)
+
+		if hook.Wait {
+			if err := waitForReplicas(ctx, clientset, hook.Namespace, name, replicas); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (hrn *hookRunner) targetReplicas(hook *ScaleHook, key string) (int32, bool) {
+	if hook.Replicas != nil {
+		return *hook.Replicas, true
+	}
+	previous, ok := hrn.previousReplicas[key]
+	return previous, ok
+}
+
+func waitForReplicas(
+	ctx context.Context,
+	clientset kubernetes.Interface,
+	namespace, name string,
+	replicas int32,
+) error {
+	err := wait.PollUntilContextCancel(
+		ctx,
+		2*time.Second,
+		true,
+		func(ctx context.Context) (bool, error) {
+			deployment, err := clientset.AppsV1().
+				Deployments(namespace).
+				Get(ctx, name, metav1.GetOptions{})
+			if err != nil {
+				return false, err
+			}
+			status := deployment.Status
+			return status.Replicas == replicas &&
+				status.ReadyReplicas == replicas, nil
+		},
+	)
+	if err != nil {
+		return fmt.Errorf(
+			"deployment %s/%s did not reach %d replicas: %w",
+			namespace,
+			name,
+			replicas,
+			err,
+		)
+	}
+	return nil
+}
+
+// kubernetesClient uses the kubeconfig when given and the in-cluster
+// configuration otherwise
+func (hrn *hookRunner) kubernetesClient() (kubernetes.Interface, error) {
+	if hrn.clientset != nil {
+		return hrn.clientset, nil
+	}
+	config, err := clientcmd.BuildConfigFromFlags("", hrn.kubeconfig)
+	if err != nil {
+		return nil, fmt.Errorf("error building kubeconfig: %w", err)
+	}
+	clientset, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return nil, fmt.Errorf("error creating Kubernetes client: %w", err)
+	}
+	hrn.clientset = clientset
+	return clientset, nil
+}
+
+func logDryRunHooks(hooks *HookConfig) {
+	phases := []struct {
+		name  string
+		hooks []Hook
+	}{
+		{name: prePhase, hooks: hooks.Pre},
+		{name: postPhase, hooks: hooks.Post},
+	}
+	for _, phase := range phases {
+		for _, hook := range phase.hooks {
+			slog.Info(
+				"Dry run: would run hook",
+				"phase", phase.name,
+				"name", hook.Name,
+				"type", hook.kind(),
+			)
+		}
+	}
+}
@@ -0,0 +1,203 @@
+package backup
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	autoscalingv1 "k8s.io/api/autoscaling/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func newTestRunner() *hookRunner {
+	return &hookRunner{previousReplicas: make(map[string]int32)}
+}
+
+func execHook(name string, command ...string) Hook {
+	return Hook{Name: name, Exec: &ExecHook{Command: command}}
+}
+
+func readReport(t *testing.T, file string) RunReport {
+	t.Helper()
+	content, err := os.ReadFile(file)
+	require.NoError(t, err)
+	var report RunReport
+	require.NoError(t, json.Unmarshal(content, &report))
+	return report
+}
+
+func TestPreHooksStopAtFirstFailure(t *testing.T) {
+	dir := t.TempDir()
+	skipped := filepath.Join(dir, "skipped")
+	reportFile := filepath.Join(dir, "report.json")
+	hooks := &HookConfig{
+		Pre: []Hook{
+			execHook("ok", "true"),
+			execHook("broken", "false"),
+			execHook("never", "touch", skipped),
+		},
+		Post: []Hook{execHook("cleanup", "true")},
+	}
+
+	backupRan := false
+	err := newTestRunner().run("redis", hooks, reportFile, func() error {
+		backupRan = true
+		return nil
+	})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), `pre hook "broken" failed`)
+	assert.False(t, backupRan, "the backup is skipped after a failed pre hook")
+	assert.NoFileExists(t, skipped)
+
+	report := readReport(t, reportFile)
+	assert.Equal(t, "redis", report.Command)
+	assert.Equal(t, statusFailed, report.Status)
+	assert.Contains(t, report.Error, `pre hook "broken" failed`)
+	require.Len(t, report.Hooks, 3)
+	assert.Equal(t, HookResult{
+		Phase: prePhase, Name: "ok", Type: "exec", Status: statusSucceeded,
+		Duration: report.Hooks[0].Duration,
+	}, report.Hooks[0])
+	assert.Equal(t, statusFailed, report.Hooks[1].Status)
+	assert.Contains(t, report.Hooks[1].Error, "exit status 1")
+	assert.Equal(t, postPhase, report.Hooks[2].Phase)
+	assert.Equal(t, statusSucceeded, report.Hooks[2].Status)
+	assert.False(t, report.Finished.Before(report.Started))
+}
+
+func TestPostHooksRunWhenBackupFails(t *testing.T) {
+	var requests int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		assert.Equal(t, http.MethodPost, r.Method)
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+	dir := t.TempDir()
+	marker := filepath.Join(dir, "post")
+	reportFile := filepath.Join(dir, "report.json")
+	hooks := &HookConfig{
+		Post: []Hook{
+			{Name: "notify", HTTP: &HTTPHook{URL: server.URL}},
+			execHook("restore", "touch", marker),
+		},
+	}
+
+	backupErr := errors.New("dump failed")
+	err := newTestRunner().run("arangodb", hooks, reportFile, func() error {
+		return backupErr
+	})
+	assert.ErrorIs(t, err, backupErr)
+	assert.Contains(t, err.Error(), `post hook "notify" failed`)
+	assert.Equal(t, 1, requests)
+	assert.FileExists(t, marker, "post hooks keep going after a failed one")
+
+	report := readReport(t, reportFile)
+	assert.Equal(t, statusFailed, report.Status)
+	require.Len(t, report.Hooks, 2)
+	assert.Contains(t, report.Hooks[0].Error, "500 Internal Server Error")
+	assert.Equal(t, statusSucceeded, report.Hooks[1].Status)
+}
+
+func TestRepositoryInitFailureIsReported(t *testing.T) {
+	fakeRestic(t, "0.17.3", 1)
+	dir := t.TempDir()
+	marker := filepath.Join(dir, "post")
+	reportFile := filepath.Join(dir, "report.json")
+	hooks := &HookConfig{Post: []Hook{execHook("restore", "touch", marker)}}
+
+	err := newTestRunner().run("arangodb", hooks, reportFile, func() error {
+		return arangoDBBackup(arangoDBConfig{Repository: "/backups/arangodb"})
+	})
+	assert.ErrorContains(t, err, "failed to initialize repository /backups/arangodb")
+	assert.FileExists(t, marker, "post hooks run after a failed init")
+
+	report := readReport(t, reportFile)
+	assert.Equal(t, statusFailed, report.Status)
+	assert.Contains(t, report.Error, "failed to initialize repository")
+}
+
+func TestScaleHookRestoresPreviousReplicas(t *testing.T) {
+	clientset := fake.NewSimpleClientset(&appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: "modware-annotation", Namespace: "dev"},
+	})
+	// the fake clientset does not serve the scale subresource
+	replicas := map[string]int32{"modware-annotation": 3}
+	var updates []int32
+	clientset.PrependReactor("get", "deployments", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		get := action.(k8stesting.GetAction)
+		if get.GetSubresource() != "scale" {
+			return false, nil, nil
+		}
+		return true, &autoscalingv1.Scale{
+			ObjectMeta: metav1.ObjectMeta{Name: get.GetName(), Namespace: get.GetNamespace()},
+			Spec:       autoscalingv1.ScaleSpec{Replicas: replicas[get.GetName()]},
+		}, nil
+	})
+	clientset.PrependReactor("update", "deployments", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		update := action.(k8stesting.UpdateAction)
+		if update.GetSubresource() != "scale" {
+			return false, nil, nil
+		}
+		scale := update.GetObject().(*autoscalingv1.Scale)
+		replicas[scale.Name] = scale.Spec.Replicas
+		updates = append(updates, scale.Spec.Replicas)
+		return true, scale, nil
+	})
+
+	zero := int32(0)
+	scale := &ScaleHook{Namespace: "dev", Deployments: []string{"modware-annotation"}}
+	hooks := &HookConfig{
+		Pre: []Hook{{Name: "stop", Scale: &ScaleHook{
+			Namespace:   scale.Namespace,
+			Deployments: scale.Deployments,
+			Replicas:    &zero,
+		}}},
+		Post: []Hook{{Name: "start", Scale: scale}},
+	}
+	runner := newTestRunner()
+	runner.clientset = clientset
+
+	err := runner.run("redis", hooks, "", func() error {
+		assert.Zero(t, replicas["modware-annotation"], "scaled down during the backup")
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []int32{0, 3}, updates)
+	assert.Equal(t, int32(3), runner.previousReplicas["dev/modware-annotation"])
+}
+
+func TestHookTimeout(t *testing.T) {
+	reportFile := filepath.Join(t.TempDir(), "report.json")
+	hooks := &HookConfig{
+		Pre: []Hook{{
+			Name:    "slow",
+			Timeout: "100ms",
+			Exec:    &ExecHook{Command: []string{"sleep", "10"}},
+		}},
+	}
+
+	started := time.Now()
+	err := newTestRunner().run("redis", hooks, reportFile, func() error {
+		t.Fatal("the backup must not run after a timed out pre hook")
+		return nil
+	})
+	require.Error(t, err)
+	assert.Less(t, time.Since(started), 5*time.Second)
+
+	report := readReport(t, reportFile)
+	require.Len(t, report.Hooks, 1)
+	assert.Equal(t, statusFailed, report.Hooks[0].Status)
+	assert.Contains(t, report.Hooks[0].Error, "killed")
+}
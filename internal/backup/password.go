@@ -0,0 +1,55 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+
+	"github.com/dictybase-docker/cluster-ops/internal/gcp"
+	cli "github.com/urfave/cli/v2"
+)
+
+// resolveResticPassword sets RESTIC_PASSWORD, either by unwrapping a KMS
+// wrapped data key or from the plain password
+func resolveResticPassword(cltx *cli.Context, password string) error {
+	wrapped := cltx.String("wrapped-restic-password")
+	if len(wrapped) == 0 {
+		return setResticPassword(password)
+	}
+	keyName := cltx.String("kms-key")
+	if len(keyName) == 0 {
+		return fmt.Errorf("kms-key is required to unwrap the restic password")
+	}
+
+	unwrapped, err := unwrapResticPassword(
+		keyName,
+		wrapped,
+		cltx.String("credentials"),
+	)
+	if err != nil {
+		return err
+	}
+	slog.Info("Unwrapped restic password", "key", keyName)
+	return os.Setenv("RESTIC_PASSWORD", string(unwrapped))
+}
+
+func unwrapResticPassword(keyName, wrapped, credentials string) ([]byte, error) {
+	ciphertext, err := gcp.DecodeWrappedDataKey(wrapped)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx := context.Background()
+	client, err := gcp.NewKMSClient(ctx, credentials)
+	if err != nil {
+		return nil, err
+	}
+	defer client.Close()
+
+	return gcp.UnwrapDataKey(gcp.DataKeyParams{
+		Ctx:     ctx,
+		Client:  client,
+		KeyName: keyName,
+	}, ciphertext)
+}
@@ -19,28 +19,28 @@ func RedisBackupAction(cltx *cli.Context) error {
 	repository := cltx.String("repository")
 	resticPassword := cltx.String("restic-password")
 
-	if err := setupResticPassword(resticPassword); err != nil {
-		return err
+	if err := resolveResticPassword(cltx, resticPassword); err != nil {
+		return cli.Exit(err.Error(), 2)
 	}
 
-	if err := initializeResticRepository(repository); err != nil {
-		return err
+	hooks, err := LoadHookConfig(cltx.String("hooks"))
+	if err != nil {
+		return cli.Exit(err.Error(), 2)
 	}
 
-	return performRedisBackup(host, port, repository)
-}
+	if cltx.Bool("dry-run") {
+		logDryRunHooks(hooks)
+		return redisDryRun(host, port, repository)
+	}
 
-func setupResticPassword(resticPassword string) error {
-	if _, ok := os.LookupEnv("RESTIC_PASSWORD"); !ok &&
-		len(resticPassword) > 0 {
-		if err := os.Setenv("RESTIC_PASSWORD", resticPassword); err != nil {
-			slog.Error(
-				"Failed to set RESTIC_PASSWORD environment variable",
-				"error",
-				err,
-			)
+	err = runWithHooks(cltx, hooks, func() error {
+		if err := initializeResticRepository(repository); err != nil {
 			return err
 		}
+		return performRedisBackup(host, port, repository)
+	})
+	if err != nil {
+		return cli.Exit(err.Error(), 2)
 	}
 	return nil
 }
@@ -50,10 +50,7 @@ func initializeResticRepository(repository string) error {
 	if err := cmd.Run(); err != nil {
 		cmd = exec.Command("restic", "-r", repository, "init")
 		if err := cmd.Run(); err != nil {
-			return cli.Exit(
-				fmt.Sprintf("failed to initialize repository %s", err.Error()),
-				2,
-			)
+			return fmt.Errorf("failed to initialize repository %s: %w", repository, err)
 		}
 		slog.Info("Repository initialized successfully")
 	} else {
@@ -149,21 +146,8 @@ func waitForBGSaveCompletion(ctx context.Context, rdb *redis.Client) error {
 }
 
 func runBackupCommands(repository, host string, port int) error {
-	redisCliArgs := []string{
-		"-h", host,
-		"-p", fmt.Sprintf("%d", port),
-		"--rdb", "-",
-	}
-	redisCli := exec.Command("redis-cli", redisCliArgs...)
-
-	resticArgs := []string{
-		"-r", repository,
-		"backup",
-		"--stdin",
-		"--stdin-filename", "redis-backup.rdb",
-		"--tag", "redis-backup",
-	}
-	restic := exec.Command("restic", resticArgs...)
+	redisCli := exec.Command("redis-cli", buildRedisCliArgs(host, port)...)
+	restic := exec.Command("restic", buildResticStdinArgs(repository)...)
 
 	var err error
 	restic.Stdin, err = redisCli.StdoutPipe()
@@ -193,6 +177,24 @@ func runBackupCommands(repository, host string, port int) error {
 	return nil
 }
 
+func buildRedisCliArgs(host string, port int) []string {
+	return []string{
+		"-h", host,
+		"-p", fmt.Sprintf("%d", port),
+		"--rdb", "-",
+	}
+}
+
+func buildResticStdinArgs(repository string) []string {
+	return []string{
+		"-r", repository,
+		"backup",
+		"--stdin",
+		"--stdin-filename", "redis-backup.rdb",
+		"--tag", "redis-backup",
+	}
+}
+
 func validateAndSanitizeHost(host string) (string, error) {
 	// Simple validation: check if the host is not empty and doesn't contain spaces
 	if len(host) == 0 {
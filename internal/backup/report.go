@@ -0,0 +1,105 @@
+package backup
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"time"
+)
+
+const (
+	statusSucceeded = "succeeded"
+	statusFailed    = "failed"
+)
+
+// RunReport summarizes a backup run, including the result of every hook
+type RunReport struct {
+	Command  string       `json:"command"`
+	Status   string       `json:"status"`
+	Error    string       `json:"error,omitempty"`
+	Started  time.Time    `json:"started"`
+	Finished time.Time    `json:"finished"`
+	Hooks    []HookResult `json:"hooks"`
+}
+
+// HookResult is the outcome of a single hook
+type HookResult struct {
+	Phase    string `json:"phase"`
+	Name     string `json:"name"`
+	Type     string `json:"type"`
+	Status   string `json:"status"`
+	Error    string `json:"error,omitempty"`
+	Duration string `json:"duration"`
+}
+
+func newRunReport(command string) *RunReport {
+	return &RunReport{
+		Command: command,
+		Started: time.Now(),
+		Hooks:   make([]HookResult, 0),
+	}
+}
+
+func (rpt *RunReport) addHook(
+	phase string,
+	hook Hook,
+	started time.Time,
+	err error,
+) {
+	result := HookResult{
+		Phase:    phase,
+		Name:     hook.Name,
+		Type:     hook.kind(),
+		Status:   statusSucceeded,
+		Duration: time.Since(started).Round(time.Millisecond).String(),
+	}
+	if err != nil {
+		result.Status = statusFailed
+		result.Error = err.Error()
+	}
+	rpt.Hooks = append(rpt.Hooks, result)
+}
+
+func (rpt *RunReport) finish(err error) {
+	rpt.Finished = time.Now()
+	rpt.Status = statusSucceeded
+	if err != nil {
+		rpt.Status = statusFailed
+		rpt.Error = err.Error()
+	}
+}
+
+// write logs the report and, when a file is given, stores it there as JSON
+func (rpt *RunReport) write(file string) error {
+	for _, hook := range rpt.Hooks {
+		slog.Info(
+			"Hook result",
+			"phase", hook.Phase,
+			"name", hook.Name,
+			"type", hook.Type,
+			"status", hook.Status,
+			"duration", hook.Duration,
+			"error", hook.Error,
+		)
+	}
+	slog.Info(
+		"Backup run report",
+		"command", rpt.Command,
+		"status", rpt.Status,
+		"duration", rpt.Finished.Sub(rpt.Started).Round(time.Millisecond),
+		"hooks", len(rpt.Hooks),
+	)
+	if len(file) == 0 {
+		return nil
+	}
+
+	content, err := json.MarshalIndent(rpt, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode run report: %w", err)
+	}
+	if err := os.WriteFile(file, content, 0o644); err != nil {
+		return fmt.Errorf("failed to write run report: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,583 @@
+package custodian
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/discovery"
+)
+
+// VolumesIncludesAnnotation opts pod volumes in to velero file system backup
+const VolumesIncludesAnnotation = "backup.velero.io/backup-volumes"
+
+// ScheduleNameLabel is set by velero on the backups created by a schedule
+const ScheduleNameLabel = "velero.io/schedule-name"
+
+// Audit statuses of resources and volumes
+const (
+	AuditIncluded = "included"
+	AuditExcluded = "excluded"
+)
+
+// veleroExcludedResources are never backed up by velero, whatever the
+// schedule includes, they are named as resource.group
+var veleroExcludedResources = []string{
+	"nodes",
+	"events",
+	"events.events.k8s.io",
+	"backups.velero.io",
+	"restores.velero.io",
+	"resticrepositories.velero.io",
+	"backuprepositories.velero.io",
+	"csinodes.storage.k8s.io",
+	"volumeattachments.storage.k8s.io",
+}
+
+var (
+	scheduleGVR = schema.GroupVersionResource{
+		Group:    "velero.io",
+		Version:  "v1",
+		Resource: "schedules",
+	}
+	backupGVR = schema.GroupVersionResource{
+		Group:    "velero.io",
+		Version:  "v1",
+		Resource: "backups",
+	}
+)
+
+// BackupAuditOptions selects the schedules and namespaces to audit
+type BackupAuditOptions struct {
+	Format          string
+	VeleroNamespace string
+	// Schedule limits the audit to a single schedule
+	Schedule string
+	// Namespaces to audit, an empty list means all namespaces
+	Namespaces []string
+	// LabelKey and LabelValue form the backup exclusion label
+	LabelKey   string
+	LabelValue string
+}
+
+// BackupAudit is the result of auditing the velero schedules
+type BackupAudit struct {
+	Schedules []ScheduleSummary `json:"schedules"`
+	Entries   []AuditEntry      `json:"entries"`
+}
+
+// ScheduleSummary describes a velero schedule and its latest backups
+type ScheduleSummary struct {
+	Name            string     `json:"name"`
+	Schedule        string     `json:"schedule"`
+	Paused          bool       `json:"paused"`
+	LastBackup      *time.Time `json:"lastBackup,omitempty"`
+	LastBackupPhase string     `json:"lastBackupPhase,omitempty"`
+	LastSuccessful  *time.Time `json:"lastSuccessful,omitempty"`
+}
+
+// AuditEntry tells whether a resource or pod volume is part of the backups
+// of a schedule
+type AuditEntry struct {
+	Schedule  string `json:"schedule"`
+	Kind      string `json:"kind"`
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+	Status    string `json:"status"`
+	Reason    string `json:"reason,omitempty"`
+}
+
+// backupScope holds the parts of a backup spec that decide what is backed up
+type backupScope struct {
+	includedNamespaces       []string
+	excludedNamespaces       []string
+	includedResources        []string
+	excludedResources        []string
+	labelSelector            labels.Selector
+	orLabelSelectors         []labels.Selector
+	defaultVolumesToFsBackup bool
+}
+
+// auditItem is a namespaced resource found in the cluster
+type auditItem struct {
+	resource string
+	group    string
+	object   *unstructured.Unstructured
+}
+
+// BackupAudit evaluates the velero schedules against the resources of the
+// namespaces and reports what every schedule includes or excludes
+func (cus *Custodian) BackupAudit(
+	ctx context.Context,
+	opts BackupAuditOptions,
+	out io.Writer,
+) error {
+	audit, err := cus.buildBackupAudit(ctx, opts)
+	if err != nil {
+		return err
+	}
+	switch opts.Format {
+	case FormatJSON:
+		return writeJSON(out, audit)
+	default:
+		return writeBackupAudit(out, opts.Format, audit)
+	}
+}
+
+func (cus *Custodian) buildBackupAudit(
+	ctx context.Context,
+	opts BackupAuditOptions,
+) (*BackupAudit, error) {
+	schedules, err := cus.listSchedules(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	items, err := cus.listAuditItems(ctx, opts.Namespaces)
+	if err != nil {
+		return nil, err
+	}
+
+	audit := &BackupAudit{}
+	for idx := range schedules {
+		schedule := &schedules[idx]
+		summary, err := cus.scheduleSummary(ctx, opts.VeleroNamespace, schedule)
+		if err != nil {
+			return nil, err
+		}
+		audit.Schedules = append(audit.Schedules, summary)
+
+		scope, err := newBackupScope(schedule)
+		if err != nil {
+			return nil, fmt.Errorf(
+				"invalid backup template in schedule %s: %w",
+				schedule.GetName(),
+				err,
+			)
+		}
+		for _, item := range items {
+			entries, err := scope.evaluate(item, opts)
+			if err != nil {
+				return nil, err
+			}
+			for _, entry := range entries {
+				entry.Schedule = schedule.GetName()
+				audit.Entries = append(audit.Entries, entry)
+			}
+		}
+	}
+	return audit, nil
+}
+
+func (cus *Custodian) listSchedules(
+	ctx context.Context,
+	opts BackupAuditOptions,
+) ([]unstructured.Unstructured, error) {
+	client := cus.dynamicClient.Resource(scheduleGVR).
+		Namespace(opts.VeleroNamespace)
+	if len(opts.Schedule) > 0 {
+		schedule, err := client.Get(ctx, opts.Schedule, metav1.GetOptions{})
+		if err != nil {
+			return nil, fmt.Errorf(
+				"failed to get schedule %s: %w",
+				opts.Schedule,
+				err,
+			)
+		}
+		return []unstructured.Unstructured{*schedule}, nil
+	}
+	list, err := client.List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list velero schedules: %w", err)
+	}
+	sort.Slice(list.Items, func(i, j int) bool {
+		return list.Items[i].GetName() < list.Items[j].GetName()
+	})
+	return list.Items, nil
+}
+
+// scheduleSummary looks up the backups of the schedule for the time of the
+// latest and latest successful one
+func (cus *Custodian) scheduleSummary(
+	ctx context.Context,
+	veleroNamespace string,
+	schedule *unstructured.Unstructured,
+) (ScheduleSummary, error) {
+	cron, _, _ := unstructured.NestedString(schedule.Object, "spec", "schedule")
+	paused, _, _ := unstructured.NestedBool(schedule.Object, "spec", "paused")
+	summary := ScheduleSummary{
+		Name:     schedule.GetName(),
+		Schedule: cron,
+		Paused:   paused,
+	}
+
+	backups, err := cus.dynamicClient.Resource(backupGVR).
+		Namespace(veleroNamespace).
+		List(ctx, metav1.ListOptions{
+			LabelSelector: ScheduleNameLabel + "=" + schedule.GetName(),
+		})
+	if err != nil {
+		return summary, fmt.Errorf(
+			"failed to list backups of schedule %s: %w",
+			schedule.GetName(),
+			err,
+		)
+	}
+	for _, backup := range backups.Items {
+		created := backup.GetCreationTimestamp().Time
+		phase, _, _ := unstructured.NestedString(backup.Object, "status", "phase")
+		if summary.LastBackup == nil || created.After(*summary.LastBackup) {
+			summary.LastBackup = &created
+			summary.LastBackupPhase = phase
+		}
+		if phase != "Completed" {
+			continue
+		}
+		completed := nestedTime(backup.Object, "status", "completionTimestamp")
+		if completed == nil {
+			completed = &created
+		}
+		if summary.LastSuccessful == nil || completed.After(*summary.LastSuccessful) {
+			summary.LastSuccessful = completed
+		}
+	}
+	return summary, nil
+}
+
+func nestedTime(obj map[string]any, fields ...string) *time.Time {
+	value, found, err := unstructured.NestedString(obj, fields...)
+	if err != nil || !found {
+		return nil
+	}
+	parsed, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return nil
+	}
+	return &parsed
+}
+
+// listAuditItems lists every namespaced resource of the namespaces that can
+// be listed
+func (cus *Custodian) listAuditItems(
+	ctx context.Context,
+	namespaces []string,
+) ([]auditItem, error) {
+	resources, err := cus.discoveryClient.ServerPreferredNamespacedResources()
+	if err != nil {
+		if !discovery.IsGroupDiscoveryFailedError(err) {
+			return nil, fmt.Errorf("failed to get server resources: %w", err)
+		}
+		cus.logger.Warn("Some API groups could not be discovered", "error", err)
+	}
+	if len(namespaces) == 0 {
+		namespaces = []string{metav1.NamespaceAll}
+	}
+
+	var items []auditItem
+	for _, list := range resources {
+		gv, err := schema.ParseGroupVersion(list.GroupVersion)
+		if err != nil {
+			return nil, fmt.Errorf(
+				"invalid groupVersion %s: %w",
+				list.GroupVersion,
+				err,
+			)
+		}
+		for _, resource := range list.APIResources {
+			if strings.Contains(resource.Name, "/") ||
+				!cus.hasVerbs(resource, "list") {
+				continue
+			}
+			gvr := gv.WithResource(resource.Name)
+			for _, namespace := range namespaces {
+				objects, err := cus.dynamicClient.Resource(gvr).
+					Namespace(namespace).
+					List(ctx, metav1.ListOptions{})
+				if err != nil {
+					if apierrors.IsNotFound(err) || apierrors.IsForbidden(err) {
+						cus.logger.Warn(
+							"Skipping resource",
+							"resource", resource.Name,
+							"group", gv.Group,
+							"error", err,
+						)
+						continue
+					}
+					return nil, fmt.Errorf(
+						"failed to list resources for %s: %w",
+						resource.Name,
+						err,
+					)
+				}
+				for idx := range objects.Items {
+					items = append(items, auditItem{
+						resource: resource.Name,
+						group:    gv.Group,
+						object:   &objects.Items[idx],
+					})
+				}
+			}
+		}
+	}
+	sort.SliceStable(items, func(i, j int) bool {
+		left, right := items[i].object, items[j].object
+		if left.GetNamespace() != right.GetNamespace() {
+			return left.GetNamespace() < right.GetNamespace()
+		}
+		return items[i].resource < items[j].resource
+	})
+	return items, nil
+}
+
+func newBackupScope(schedule *unstructured.Unstructured) (*backupScope, error) {
+	template, _, err := unstructured.NestedMap(
+		schedule.Object,
+		"spec",
+		"template",
+	)
+	if err != nil {
+		return nil, err
+	}
+	scope := &backupScope{}
+	scope.includedNamespaces, _, _ = unstructured.NestedStringSlice(
+		template, "includedNamespaces",
+	)
+	scope.excludedNamespaces, _, _ = unstructured.NestedStringSlice(
+		template, "excludedNamespaces",
+	)
+	scope.includedResources, _, _ = unstructured.NestedStringSlice(
+		template, "includedResources",
+	)
+	scope.excludedResources, _, _ = unstructured.NestedStringSlice(
+		template, "excludedResources",
+	)
+	scope.defaultVolumesToFsBackup, _, _ = unstructured.NestedBool(
+		template, "defaultVolumesToFsBackup",
+	)
+
+	if selector, found, _ := unstructured.NestedMap(template, "labelSelector"); found {
+		scope.labelSelector, err = labelSelector(selector)
+		if err != nil {
+			return nil, err
+		}
+	}
+	orSelectors, _, _ := unstructured.NestedSlice(template, "orLabelSelectors")
+	for _, raw := range orSelectors {
+		selector, ok := raw.(map[string]any)
+		if !ok {
+			continue
+		}
+		parsed, err := labelSelector(selector)
+		if err != nil {
+			return nil, err
+		}
+		scope.orLabelSelectors = append(scope.orLabelSelectors, parsed)
+	}
+	return scope, nil
+}
+
+func labelSelector(raw map[string]any) (labels.Selector, error) {
+	var selector metav1.LabelSelector
+	err := runtime.DefaultUnstructuredConverter.FromUnstructured(raw, &selector)
+	if err != nil {
+		return nil, fmt.Errorf("invalid label selector: %w", err)
+	}
+	return metav1.LabelSelectorAsSelector(&selector)
+}
+
+// evaluate returns the audit entry of the item, followed by the entries of
+// its volumes when it is a pod
+func (scope *backupScope) evaluate(
+	item auditItem,
+	opts BackupAuditOptions,
+) ([]AuditEntry, error) {
+	obj := item.object
+	status, reason := scope.resourceStatus(item, opts)
+	entries := []AuditEntry{{
+		Kind:      obj.GetKind(),
+		Namespace: obj.GetNamespace(),
+		Name:      obj.GetName(),
+		Status:    status,
+		Reason:    reason,
+	}}
+	if item.resource != "pods" || len(item.group) > 0 {
+		return entries, nil
+	}
+
+	var pod corev1.Pod
+	err := runtime.DefaultUnstructuredConverter.FromUnstructured(obj.Object, &pod)
+	if err != nil {
+		return nil, fmt.Errorf("failed to convert pod %s: %w", obj.GetName(), err)
+	}
+	for _, volume := range pod.Spec.Volumes {
+		status, reason := scope.volumeStatus(&pod, volume, status)
+		entries = append(entries, AuditEntry{
+			Kind:      "PodVolume",
+			Namespace: pod.Namespace,
+			Name:      pod.Name + "/" + volume.Name,
+			Status:    status,
+			Reason:    reason,
+		})
+	}
+	return entries, nil
+}
+
+func (scope *backupScope) resourceStatus(
+	item auditItem,
+	opts BackupAuditOptions,
+) (string, string) {
+	obj := item.object
+	namespace := obj.GetNamespace()
+	switch {
+	case !matchesAny(scope.includedNamespaces, true, namespace):
+		return AuditExcluded, "namespace not included"
+	case matchesAny(scope.excludedNamespaces, false, namespace):
+		return AuditExcluded, "namespace excluded"
+	}
+
+	qualified := item.resource
+	if len(item.group) > 0 {
+		qualified += "." + item.group
+	}
+	names := []string{item.resource, item.resource + "." + item.group}
+	switch {
+	case slices.Contains(veleroExcludedResources, qualified):
+		return AuditExcluded, "never backed up by velero"
+	case !matchesAny(scope.includedResources, true, names...):
+		return AuditExcluded, "resource not included"
+	case matchesAny(scope.excludedResources, false, names...):
+		return AuditExcluded, "resource excluded"
+	}
+
+	itemLabels := obj.GetLabels()
+	if value, ok := itemLabels[opts.LabelKey]; ok && value == opts.LabelValue {
+		return AuditExcluded, fmt.Sprintf(
+			"label %s=%s",
+			opts.LabelKey,
+			opts.LabelValue,
+		)
+	}
+	if !scope.selects(labels.Set(itemLabels)) {
+		return AuditExcluded, "label selector does not match"
+	}
+	return AuditIncluded, ""
+}
+
+// selects applies the label selector or, when given, any of the or label
+// selectors
+func (scope *backupScope) selects(set labels.Set) bool {
+	if len(scope.orLabelSelectors) > 0 {
+		for _, selector := range scope.orLabelSelectors {
+			if selector.Matches(set) {
+				return true
+			}
+		}
+		return false
+	}
+	return scope.labelSelector == nil || scope.labelSelector.Matches(set)
+}
+
+// volumeStatus decides how velero treats a pod volume: file system backup,
+// a snapshot of its claim, or nothing at all
+func (scope *backupScope) volumeStatus(
+	pod *corev1.Pod,
+	volume corev1.Volume,
+	podStatus string,
+) (string, string) {
+	if podStatus == AuditExcluded {
+		return AuditExcluded, "pod excluded"
+	}
+	if slices.Contains(annotationList(pod, VolumesExcludesAnnotation), volume.Name) {
+		return AuditExcluded, VolumesExcludesAnnotation + " annotation"
+	}
+	if volume.HostPath != nil || volume.Secret != nil ||
+		volume.ConfigMap != nil || volume.Projected != nil ||
+		volume.DownwardAPI != nil {
+		return AuditExcluded, "volume type is never backed up"
+	}
+	if scope.defaultVolumesToFsBackup {
+		return AuditIncluded, "file system backup"
+	}
+	if slices.Contains(annotationList(pod, VolumesIncludesAnnotation), volume.Name) {
+		return AuditIncluded, "file system backup, " + VolumesIncludesAnnotation + " annotation"
+	}
+	if claim := volume.PersistentVolumeClaim; claim != nil {
+		return AuditIncluded, "snapshot of claim " + claim.ClaimName
+	}
+	return AuditExcluded, "not opted in to file system backup"
+}
+
+func annotationList(pod *corev1.Pod, annotation string) []string {
+	var values []string
+	for _, value := range strings.Split(pod.Annotations[annotation], ",") {
+		if value = strings.TrimSpace(value); len(value) > 0 {
+			values = append(values, value)
+		}
+	}
+	return values
+}
+
+// matchesAny reports whether any of the names matches the patterns, an empty
+// pattern list gives the fallback
+func matchesAny(patterns []string, fallback bool, names ...string) bool {
+	if len(patterns) == 0 {
+		return fallback
+	}
+	for _, pattern := range patterns {
+		if pattern == "*" {
+			return true
+		}
+		for _, name := range names {
+			if strings.EqualFold(pattern, name) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func writeBackupAudit(out io.Writer, format string, audit *BackupAudit) error {
+	scheduleRows := make([][]string, 0, len(audit.Schedules))
+	for _, schedule := range audit.Schedules {
+		scheduleRows = append(scheduleRows, []string{
+			schedule.Name,
+			valueOrDash(schedule.Schedule),
+			fmt.Sprintf("%t", schedule.Paused),
+			valueOrDash(formatTime(schedule.LastBackup)),
+			valueOrDash(schedule.LastBackupPhase),
+			valueOrDash(formatTime(schedule.LastSuccessful)),
+		})
+	}
+	err := writeTable(out, format, []string{
+		"SCHEDULE", "CRON", "PAUSED", "LAST BACKUP", "PHASE", "LAST SUCCESSFUL",
+	}, scheduleRows)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(out)
+
+	entryRows := make([][]string, 0, len(audit.Entries))
+	for _, entry := range audit.Entries {
+		entryRows = append(entryRows, []string{
+			entry.Schedule,
+			entry.Kind,
+			entry.Namespace,
+			entry.Name,
+			entry.Status,
+			valueOrDash(entry.Reason),
+		})
+	}
+	return writeTable(out, format, []string{
+		"SCHEDULE", "KIND", "NAMESPACE", "NAME", "STATUS", "REASON",
+	}, entryRows)
+}
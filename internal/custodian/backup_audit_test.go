@@ -0,0 +1,115 @@
+package custodian
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func TestBackupScopeEvaluate(t *testing.T) {
+	schedule := &unstructured.Unstructured{Object: map[string]any{
+		"spec": map[string]any{
+			"template": map[string]any{
+				"includedNamespaces": []any{"dev"},
+				"excludedResources":  []any{"cronjobs.batch", "jobs.batch"},
+			},
+		},
+	}}
+	scope, err := newBackupScope(schedule)
+	require.NoError(t, err)
+	opts := BackupAuditOptions{
+		LabelKey:   "velero.io/exclude-from-backup",
+		LabelValue: "true",
+	}
+	pod := &unstructured.Unstructured{Object: map[string]any{
+		"apiVersion": "v1",
+		"kind":       "Pod",
+		"metadata": map[string]any{
+			"name":      "api",
+			"namespace": "dev",
+			"annotations": map[string]any{
+				VolumesExcludesAnnotation: "scratch",
+			},
+		},
+		"spec": map[string]any{
+			"containers": []any{map[string]any{"name": "api"}},
+			"volumes": []any{
+				map[string]any{"name": "scratch", "emptyDir": map[string]any{}},
+				map[string]any{"name": "tls", "secret": map[string]any{}},
+				map[string]any{
+					"name":                  "data",
+					"persistentVolumeClaim": map[string]any{"claimName": "api-data"},
+				},
+			},
+		},
+	}}
+	entries, err := scope.evaluate(auditItem{resource: "pods", object: pod}, opts)
+	require.NoError(t, err)
+	require.Len(t, entries, 4)
+	assert.Equal(t, AuditIncluded, entries[0].Status)
+	assert.Equal(t, AuditExcluded, entries[1].Status)
+	assert.Equal(t, AuditExcluded, entries[2].Status)
+	assert.Equal(t, AuditIncluded, entries[3].Status)
+	assert.Equal(t, "snapshot of claim api-data", entries[3].Reason)
+
+	job := &unstructured.Unstructured{Object: map[string]any{
+		"kind":     "Job",
+		"metadata": map[string]any{"name": "load", "namespace": "dev"},
+	}}
+	entries, err = scope.evaluate(
+		auditItem{resource: "jobs", group: "batch", object: job},
+		opts,
+	)
+	require.NoError(t, err)
+	assert.Equal(t, "resource excluded", entries[0].Reason)
+
+	secret := &unstructured.Unstructured{Object: map[string]any{
+		"kind": "Secret",
+		"metadata": map[string]any{
+			"name":      "arangodb",
+			"namespace": "dev",
+			"labels":    map[string]any{"velero.io/exclude-from-backup": "true"},
+		},
+	}}
+	entries, err = scope.evaluate(auditItem{resource: "secrets", object: secret}, opts)
+	require.NoError(t, err)
+	assert.Equal(t, AuditExcluded, entries[0].Status)
+
+	secret.SetNamespace("staging")
+	entries, err = scope.evaluate(auditItem{resource: "secrets", object: secret}, opts)
+	require.NoError(t, err)
+	assert.Equal(t, "namespace not included", entries[0].Reason)
+
+	event := &unstructured.Unstructured{Object: map[string]any{
+		"kind":     "Event",
+		"metadata": map[string]any{"name": "api.17f", "namespace": "dev"},
+	}}
+	for _, group := range []string{"", "events.k8s.io"} {
+		entries, err = scope.evaluate(
+			auditItem{resource: "events", group: group, object: event},
+			opts,
+		)
+		require.NoError(t, err)
+		assert.Equal(t, AuditExcluded, entries[0].Status, group)
+		assert.Equal(t, "never backed up by velero", entries[0].Reason, group)
+	}
+
+	backup := &unstructured.Unstructured{Object: map[string]any{
+		"kind":     "Backup",
+		"metadata": map[string]any{"name": "dicty-daily", "namespace": "dev"},
+	}}
+	entries, err = scope.evaluate(
+		auditItem{resource: "backups", group: "velero.io", object: backup},
+		opts,
+	)
+	require.NoError(t, err)
+	assert.Equal(t, AuditExcluded, entries[0].Status)
+	entries, err = scope.evaluate(
+		auditItem{resource: "backups", group: "postgresql.cnpg.io", object: backup},
+		opts,
+	)
+	require.NoError(t, err)
+	assert.Equal(t, AuditIncluded, entries[0].Status, "only the velero backups are left out")
+}
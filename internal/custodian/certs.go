@@ -0,0 +1,298 @@
+package custodian
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+)
+
+// Reasons for reporting an ingress host without a matching certificate
+const (
+	HostNoSecret      = "no tls secret"
+	HostMissingSecret = "secret not found"
+	HostInvalidCert   = "invalid certificate"
+	HostNotCovered    = "host not in certificate"
+)
+
+// Certificate is the leaf certificate of a TLS secret
+type Certificate struct {
+	Namespace string    `json:"namespace"`
+	Secret    string    `json:"secret"`
+	Subject   string    `json:"subject,omitempty"`
+	SANs      []string  `json:"sans,omitempty"`
+	Issuer    string    `json:"issuer,omitempty"`
+	NotAfter  time.Time `json:"notAfter"`
+	DaysLeft  int       `json:"daysLeft"`
+	Ingresses []string  `json:"ingresses,omitempty"`
+	Error     string    `json:"error,omitempty"`
+	cert      *x509.Certificate
+}
+
+// MissingHost is an ingress TLS host no certificate of the cluster covers
+type MissingHost struct {
+	Namespace string `json:"namespace"`
+	Ingress   string `json:"ingress"`
+	Host      string `json:"host"`
+	Secret    string `json:"secret,omitempty"`
+	Reason    string `json:"reason"`
+}
+
+// CertReport holds the certificates and the uncovered ingress hosts
+type CertReport struct {
+	Certificates []Certificate `json:"certificates"`
+	MissingHosts []MissingHost `json:"missingHosts"`
+}
+
+// Expiring returns the certificates expiring in less than the given days,
+// invalid certificates are included as they cannot be trusted either
+func (rep CertReport) Expiring(days int) []Certificate {
+	var expiring []Certificate
+	for _, cert := range rep.Certificates {
+		if cert.Error != "" || cert.DaysLeft < days {
+			expiring = append(expiring, cert)
+		}
+	}
+	return expiring
+}
+
+// FindCertificates parses the kubernetes.io/tls secrets of the namespaces
+// and matches them with the TLS sections of the ingresses
+func (cus *Custodian) FindCertificates(
+	ctx context.Context,
+	namespaces []string,
+	now time.Time,
+) (CertReport, error) {
+	if len(namespaces) == 0 {
+		namespaces = []string{metav1.NamespaceAll}
+	}
+	var report CertReport
+	for _, namespace := range namespaces {
+		certs, err := cus.tlsCertificates(ctx, namespace, now)
+		if err != nil {
+			return report, err
+		}
+		missing, err := cus.matchIngresses(ctx, namespace, certs)
+		if err != nil {
+			return report, err
+		}
+		for _, key := range sortedKeys(certs) {
+			report.Certificates = append(report.Certificates, *certs[key])
+		}
+		report.MissingHosts = append(report.MissingHosts, missing...)
+	}
+	sort.SliceStable(report.Certificates, func(i, j int) bool {
+		return report.Certificates[i].NotAfter.Before(report.Certificates[j].NotAfter)
+	})
+	return report, nil
+}
+
+// tlsCertificates parses the secrets of the namespace keyed by
+// namespace/name
+func (cus *Custodian) tlsCertificates(
+	ctx context.Context,
+	namespace string,
+	now time.Time,
+) (map[string]*Certificate, error) {
+	secrets, err := cus.clientset.CoreV1().
+		Secrets(namespace).
+		List(ctx, metav1.ListOptions{
+			FieldSelector: fields.OneTermEqualSelector(
+				"type",
+				string(corev1.SecretTypeTLS),
+			).String(),
+		})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list tls secrets: %w", err)
+	}
+	certs := make(map[string]*Certificate)
+	for _, secret := range secrets.Items {
+		if secret.Type != corev1.SecretTypeTLS {
+			continue
+		}
+		cert := &Certificate{Namespace: secret.Namespace, Secret: secret.Name}
+		parsed, err := parseLeafCertificate(secret.Data[corev1.TLSCertKey])
+		if err != nil {
+			cert.Error = err.Error()
+			cus.logger.Warn(
+				"Failed to parse certificate",
+				"namespace", secret.Namespace,
+				"secret", secret.Name,
+				"error", err,
+			)
+		} else {
+			cert.cert = parsed
+			cert.Subject = certName(parsed.Subject.CommonName, parsed.Subject.String())
+			cert.Issuer = certName(parsed.Issuer.CommonName, parsed.Issuer.String())
+			cert.SANs = subjectAltNames(parsed)
+			cert.NotAfter = parsed.NotAfter
+			cert.DaysLeft = int(parsed.NotAfter.Sub(now).Hours() / 24)
+		}
+		certs[secret.Namespace+"/"+secret.Name] = cert
+	}
+	return certs, nil
+}
+
+// matchIngresses records the ingresses using each certificate and returns
+// the TLS hosts without a valid certificate
+func (cus *Custodian) matchIngresses(
+	ctx context.Context,
+	namespace string,
+	certs map[string]*Certificate,
+) ([]MissingHost, error) {
+	ingresses, err := cus.clientset.NetworkingV1().
+		Ingresses(namespace).
+		List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list ingresses: %w", err)
+	}
+	var missing []MissingHost
+	for _, ingress := range ingresses.Items {
+		for _, tls := range ingress.Spec.TLS {
+			cert, ok := certs[ingress.Namespace+"/"+tls.SecretName]
+			if ok && !slices.Contains(cert.Ingresses, ingress.Name) {
+				cert.Ingresses = append(cert.Ingresses, ingress.Name)
+			}
+			for _, host := range tls.Hosts {
+				reason := hostCoverage(tls.SecretName, cert, host)
+				if reason == "" {
+					continue
+				}
+				missing = append(missing, MissingHost{
+					Namespace: ingress.Namespace,
+					Ingress:   ingress.Name,
+					Host:      host,
+					Secret:    tls.SecretName,
+					Reason:    reason,
+				})
+			}
+		}
+	}
+	return missing, nil
+}
+
+// hostCoverage returns why the certificate does not serve the host, or an
+// empty string when it does
+func hostCoverage(secretName string, cert *Certificate, host string) string {
+	switch {
+	case secretName == "":
+		return HostNoSecret
+	case cert == nil:
+		return HostMissingSecret
+	case cert.cert == nil:
+		return HostInvalidCert
+	case cert.cert.VerifyHostname(host) != nil:
+		return HostNotCovered
+	default:
+		return ""
+	}
+}
+
+// parseLeafCertificate parses the first certificate of a PEM chain
+func parseLeafCertificate(data []byte) (*x509.Certificate, error) {
+	for len(data) > 0 {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse certificate: %w", err)
+		}
+		return cert, nil
+	}
+	return nil, errors.New("no PEM certificate found")
+}
+
+func certName(commonName, distinguished string) string {
+	if commonName != "" {
+		return commonName
+	}
+	return distinguished
+}
+
+func subjectAltNames(cert *x509.Certificate) []string {
+	names := append([]string{}, cert.DNSNames...)
+	for _, ip := range cert.IPAddresses {
+		names = append(names, ip.String())
+	}
+	return names
+}
+
+func sortedKeys[V any](values map[string]V) []string {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// WriteCertificates renders the certificates followed by the hosts without
+// a matching certificate
+func WriteCertificates(out io.Writer, format string, report CertReport) error {
+	if format == FormatJSON {
+		if report.Certificates == nil {
+			report.Certificates = []Certificate{}
+		}
+		if report.MissingHosts == nil {
+			report.MissingHosts = []MissingHost{}
+		}
+		return writeJSON(out, report)
+	}
+	rows := make([][]string, 0, len(report.Certificates))
+	for _, cert := range report.Certificates {
+		expiry, days := "-", "-"
+		if cert.Error == "" {
+			expiry = formatTime(&cert.NotAfter)
+			days = strconv.Itoa(cert.DaysLeft)
+		}
+		rows = append(rows, []string{
+			cert.Namespace,
+			cert.Secret,
+			valueOrDash(cert.Subject),
+			valueOrDash(strings.Join(cert.SANs, ",")),
+			valueOrDash(cert.Issuer),
+			expiry,
+			days,
+			valueOrDash(strings.Join(cert.Ingresses, ",")),
+			valueOrDash(cert.Error),
+		})
+	}
+	err := writeTable(out, format, []string{
+		"NAMESPACE", "SECRET", "SUBJECT", "SANS", "ISSUER",
+		"EXPIRES", "DAYS", "INGRESSES", "ERROR",
+	}, rows)
+	if err != nil || len(report.MissingHosts) == 0 {
+		return err
+	}
+	fmt.Fprintln(out)
+	hosts := make([][]string, 0, len(report.MissingHosts))
+	for _, host := range report.MissingHosts {
+		hosts = append(hosts, []string{
+			host.Namespace,
+			host.Ingress,
+			host.Host,
+			valueOrDash(host.Secret),
+			host.Reason,
+		})
+	}
+	return writeTable(out, format, []string{
+		"NAMESPACE", "INGRESS", "HOST", "SECRET", "REASON",
+	}, hosts)
+}
@@ -0,0 +1,81 @@
+package custodian
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func tlsSecret(t *testing.T, name string, notAfter time.Time, hosts ...string) *corev1.Secret {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: hosts[0]},
+		DNSNames:     hosts,
+		NotBefore:    notAfter.Add(-90 * 24 * time.Hour),
+		NotAfter:     notAfter,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	return &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "dev"},
+		Type:       corev1.SecretTypeTLS,
+		Data: map[string][]byte{
+			corev1.TLSCertKey: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		},
+	}
+}
+
+func TestFindCertificates(t *testing.T) {
+	now := time.Now()
+	ingress := &networkingv1.Ingress{
+		ObjectMeta: metav1.ObjectMeta{Name: "frontend", Namespace: "dev"},
+		Spec: networkingv1.IngressSpec{TLS: []networkingv1.IngressTLS{
+			{Hosts: []string{"dictybase.dev", "www.dictybase.dev"}, SecretName: "dictybase-tls"},
+			{Hosts: []string{"api.dictybase.dev"}, SecretName: "api-tls"},
+		}},
+	}
+	clientset := fake.NewSimpleClientset(
+		ingress,
+		tlsSecret(t, "dictybase-tls", now.Add(60*24*time.Hour), "dictybase.dev"),
+		tlsSecret(t, "minio-tls", now.Add(5*24*time.Hour+time.Hour), "*.minio.dictybase.dev"),
+		&corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "broken-tls", Namespace: "dev"},
+			Type:       corev1.SecretTypeTLS,
+		},
+	)
+	cus := &Custodian{clientset: clientset, logger: testLogger()}
+
+	report, err := cus.FindCertificates(context.Background(), []string{"dev"}, now)
+	require.NoError(t, err)
+	require.Len(t, report.Certificates, 3)
+	assert.Equal(t, "broken-tls", report.Certificates[0].Secret)
+	assert.NotEmpty(t, report.Certificates[0].Error)
+	assert.Equal(t, "minio-tls", report.Certificates[1].Secret)
+	assert.Equal(t, 5, report.Certificates[1].DaysLeft)
+	assert.Equal(t, []string{"frontend"}, report.Certificates[2].Ingresses)
+	assert.ElementsMatch(t, []MissingHost{
+		{Namespace: "dev", Ingress: "frontend", Host: "www.dictybase.dev", Secret: "dictybase-tls", Reason: HostNotCovered},
+		{Namespace: "dev", Ingress: "frontend", Host: "api.dictybase.dev", Secret: "api-tls", Reason: HostMissingSecret},
+	}, report.MissingHosts)
+
+	expiring := report.Expiring(14)
+	require.Len(t, expiring, 2)
+	assert.Equal(t, "minio-tls", expiring[1].Secret)
+}
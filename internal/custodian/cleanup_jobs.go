@@ -0,0 +1,169 @@
+package custodian
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	batchv1 "k8s.io/api/batch/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// CleanupJobsOptions selects the finished jobs to delete
+type CleanupJobsOptions struct {
+	OlderThan time.Duration
+	Completed bool
+	Failed    bool
+	// KeepLast keeps this many of the most recent finished jobs of every
+	// CronJob, regardless of their age
+	KeepLast int
+	// ArchiveDir, when set, receives the job logs before deletion
+	ArchiveDir string
+	DryRun     bool
+}
+
+// CleanupJobs deletes the completed and/or failed jobs matching the label
+// that finished before the given age, together with their pods
+func (cus *Custodian) CleanupJobs(
+	ctx context.Context,
+	opts CleanupJobsOptions,
+) error {
+	jobs, err := cus.clientset.BatchV1().
+		Jobs(cus.namespace).
+		List(ctx, metav1.ListOptions{LabelSelector: cus.label})
+	if err != nil {
+		return fmt.Errorf("failed to list jobs: %w", err)
+	}
+
+	candidates := selectJobsForCleanup(jobs.Items, opts, time.Now())
+	if len(candidates) == 0 {
+		cus.logger.Info(
+			"No jobs to clean up",
+			"label", cus.label,
+			"namespace", cus.namespace,
+		)
+		return nil
+	}
+
+	var errs []error
+	for idx := range candidates {
+		if err := cus.cleanupJob(ctx, &candidates[idx], opts); err != nil {
+			cus.logger.Error(
+				"Error cleaning up job",
+				"name", candidates[idx].Name,
+				"error", err,
+			)
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (cus *Custodian) cleanupJob(
+	ctx context.Context,
+	job *batchv1.Job,
+	opts CleanupJobsOptions,
+) error {
+	if opts.DryRun {
+		cus.logger.Info(
+			"Dry run: would delete job",
+			"name", job.Name,
+			"status", jobStatus(job),
+			"cronjob", ownerName(job.OwnerReferences, "CronJob"),
+			"archive", len(opts.ArchiveDir) > 0,
+		)
+		return nil
+	}
+
+	if len(opts.ArchiveDir) > 0 {
+		pods, err := cus.listPodsForJob(job.Name)
+		if err != nil {
+			return fmt.Errorf(
+				"error listing pods for job %s: %w",
+				job.Name,
+				err,
+			)
+		}
+		if err := cus.writeJobLogs(opts.ArchiveDir, job.Name, pods); err != nil {
+			return fmt.Errorf("failed to archive logs of job %s: %w", job.Name, err)
+		}
+	}
+
+	propagation := metav1.DeletePropagationBackground
+	err := cus.clientset.BatchV1().
+		Jobs(job.Namespace).
+		Delete(ctx, job.Name, metav1.DeleteOptions{
+			PropagationPolicy: &propagation,
+		})
+	if err != nil {
+		return fmt.Errorf("failed to delete job %s: %w", job.Name, err)
+	}
+	cus.logger.Info("Deleted job", "name", job.Name, "status", jobStatus(job))
+	return nil
+}
+
+// selectJobsForCleanup returns the finished jobs with a matching status that
+// are older than the cutoff and not among the most recent ones of their
+// CronJob
+func selectJobsForCleanup(
+	jobs []batchv1.Job,
+	opts CleanupJobsOptions,
+	now time.Time,
+) []batchv1.Job {
+	protected := recentCronJobRuns(jobs, opts.KeepLast)
+	cutoff := now.Add(-opts.OlderThan)
+
+	var candidates []batchv1.Job
+	for _, job := range jobs {
+		switch jobStatus(&job) {
+		case JobStatusComplete:
+			if !opts.Completed {
+				continue
+			}
+		case JobStatusFailed:
+			if !opts.Failed {
+				continue
+			}
+		default:
+			continue
+		}
+		finished := jobFinishTime(&job)
+		if finished == nil || finished.After(cutoff) {
+			continue
+		}
+		if protected[job.Name] {
+			continue
+		}
+		candidates = append(candidates, job)
+	}
+	return candidates
+}
+
+// recentCronJobRuns returns the names of the newest finished jobs of every
+// CronJob owner
+func recentCronJobRuns(jobs []batchv1.Job, keep int) map[string]bool {
+	protected := make(map[string]bool)
+	if keep <= 0 {
+		return protected
+	}
+
+	byOwner := make(map[string][]batchv1.Job)
+	for _, job := range jobs {
+		owner := ownerName(job.OwnerReferences, "CronJob")
+		if len(owner) == 0 || jobFinishTime(&job) == nil {
+			continue
+		}
+		byOwner[owner] = append(byOwner[owner], job)
+	}
+	for _, owned := range byOwner {
+		sort.Slice(owned, func(i, j int) bool {
+			return jobFinishTime(&owned[i]).After(*jobFinishTime(&owned[j]))
+		})
+		for idx := 0; idx < keep && idx < len(owned); idx++ {
+			protected[owned[idx].Name] = true
+		}
+	}
+	return protected
+}
@@ -0,0 +1,139 @@
+package custodian
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func finishedJob(
+	name, cronJob string,
+	condType batchv1.JobConditionType,
+	finished time.Time,
+) batchv1.Job {
+	job := batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "dev"},
+		Status: batchv1.JobStatus{
+			Conditions: []batchv1.JobCondition{{
+				Type:               condType,
+				Status:             corev1.ConditionTrue,
+				LastTransitionTime: metav1.NewTime(finished),
+			}},
+		},
+	}
+	if condType == batchv1.JobComplete {
+		completion := metav1.NewTime(finished)
+		job.Status.CompletionTime = &completion
+	}
+	if len(cronJob) > 0 {
+		job.OwnerReferences = []metav1.OwnerReference{
+			{Kind: "CronJob", Name: cronJob},
+		}
+	}
+	return job
+}
+
+func TestSelectJobsForCleanup(t *testing.T) {
+	now := time.Now()
+	day := 24 * time.Hour
+	jobs := []batchv1.Job{
+		finishedJob("backup-1", "backup", batchv1.JobComplete, now.Add(-3*day)),
+		finishedJob("backup-2", "backup", batchv1.JobComplete, now.Add(-2*day)),
+		finishedJob("backup-3", "backup", batchv1.JobFailed, now.Add(-2*day-time.Hour)),
+		finishedJob("backup-4", "backup", batchv1.JobComplete, now.Add(-time.Hour)),
+		finishedJob("create-databases", "", batchv1.JobComplete, now.Add(-5*day)),
+		{ObjectMeta: metav1.ObjectMeta{Name: "running", Namespace: "dev"}},
+	}
+	tests := []struct {
+		name     string
+		opts     CleanupJobsOptions
+		expected []string
+	}{
+		{
+			name:     "completed older than a day",
+			opts:     CleanupJobsOptions{OlderThan: day, Completed: true},
+			expected: []string{"backup-1", "backup-2", "create-databases"},
+		},
+		{
+			name:     "failed only",
+			opts:     CleanupJobsOptions{OlderThan: day, Failed: true},
+			expected: []string{"backup-3"},
+		},
+		{
+			name: "keep last two per cronjob",
+			opts: CleanupJobsOptions{
+				OlderThan: day,
+				Completed: true,
+				Failed:    true,
+				KeepLast:  2,
+			},
+			expected: []string{"backup-1", "backup-3", "create-databases"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var names []string
+			for _, job := range selectJobsForCleanup(jobs, test.opts, now) {
+				names = append(names, job.Name)
+			}
+			assert.ElementsMatch(t, test.expected, names)
+		})
+	}
+}
+
+func TestCleanupJobsKeepsJobWhenArchiveFails(t *testing.T) {
+	job := finishedJob("load-uniprot", "", batchv1.JobComplete, time.Now().Add(-48*time.Hour))
+	job.Labels = map[string]string{"app": "loader"}
+	opts := CleanupJobsOptions{OlderThan: 24 * time.Hour, Completed: true}
+	tests := []struct {
+		name      string
+		clientset func(*fake.Clientset) kubernetes.Interface
+		deleted   bool
+	}{
+		{
+			name:      "archived",
+			clientset: func(cs *fake.Clientset) kubernetes.Interface { return cs },
+			deleted:   true,
+		},
+		{
+			name: "log stream fails",
+			clientset: func(cs *fake.Clientset) kubernetes.Interface {
+				return failingLogsClientset{cs}
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			client := fake.NewSimpleClientset(job.DeepCopy(), jobPod())
+			cus := &Custodian{
+				clientset: test.clientset(client),
+				namespace: "dev",
+				label:     "app=loader",
+				logger:    testLogger(),
+			}
+			opts := opts
+			opts.ArchiveDir = t.TempDir()
+			err := cus.CleanupJobs(context.Background(), opts)
+
+			jobs, listErr := client.BatchV1().Jobs("dev").List(context.Background(), metav1.ListOptions{})
+			require.NoError(t, listErr)
+			if test.deleted {
+				require.NoError(t, err)
+				assert.Empty(t, jobs.Items)
+				return
+			}
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), "failed to archive logs of job load-uniprot")
+			assert.Contains(t, err.Error(), "connection reset by peer")
+			assert.Len(t, jobs.Items, 1, "the job is kept when its logs were not archived")
+		})
+	}
+}
@@ -0,0 +1,212 @@
+package custodian
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+// KubeContexts returns the names of the contexts of the kubeconfig, the
+// default loading rules are used if no path is given
+func KubeContexts(kubeconfigPath string) ([]string, error) {
+	rules := clientcmd.NewDefaultClientConfigLoadingRules()
+	rules.ExplicitPath = kubeconfigPath
+	config, err := rules.Load()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load kubeconfig: %w", err)
+	}
+	contexts := make([]string, 0, len(config.Contexts))
+	for name := range config.Contexts {
+		contexts = append(contexts, name)
+	}
+	if len(contexts) == 0 {
+		return nil, fmt.Errorf("no contexts found in kubeconfig")
+	}
+	sort.Strings(contexts)
+	return contexts, nil
+}
+
+// ClusterOutput collects what a command writes for one cluster, tables and
+// JSON documents are kept apart so that the output of several clusters can
+// be merged by WriteClusterOutputs
+type ClusterOutput struct {
+	Cluster  string
+	Err      error
+	mu       sync.Mutex
+	segments []outputSegment
+	// live receives the text as it is written when streaming, liveMu is
+	// shared by the clusters so that their lines do not interleave
+	live    io.Writer
+	liveMu  *sync.Mutex
+	partial []byte
+}
+
+// StreamClusterOutput returns the output of a cluster that writes its text
+// to out line by line as it comes, prefixed with the cluster, instead of
+// keeping it until every cluster is done. Tables and JSON documents are
+// still kept for WriteClusterOutputs.
+func StreamClusterOutput(cluster string, out io.Writer, mu *sync.Mutex) *ClusterOutput {
+	return &ClusterOutput{Cluster: cluster, live: out, liveMu: mu}
+}
+
+// outputSegment is either a table, a JSON document or free text
+type outputSegment struct {
+	headers []string
+	rows    [][]string
+	data    any
+	text    []byte
+}
+
+// Write keeps text that is not a table or JSON, such as diffs or logs, or
+// writes its complete lines when streaming
+func (co *ClusterOutput) Write(p []byte) (int, error) {
+	co.mu.Lock()
+	defer co.mu.Unlock()
+	if co.live != nil {
+		co.partial = append(co.partial, p...)
+		idx := bytes.LastIndexByte(co.partial, '\n')
+		if idx < 0 {
+			return len(p), nil
+		}
+		lines := co.partial[:idx+1]
+		co.partial = append([]byte{}, co.partial[idx+1:]...)
+		if err := co.writeLive(lines); err != nil {
+			return 0, err
+		}
+		return len(p), nil
+	}
+	if last := len(co.segments) - 1; last >= 0 && co.segments[last].text != nil {
+		co.segments[last].text = append(co.segments[last].text, p...)
+		return len(p), nil
+	}
+	co.segments = append(co.segments, outputSegment{text: append([]byte{}, p...)})
+	return len(p), nil
+}
+
+// Flush writes the last line of a streaming output when it did not end
+// with a newline
+func (co *ClusterOutput) Flush() error {
+	co.mu.Lock()
+	defer co.mu.Unlock()
+	if co.live == nil || len(co.partial) == 0 {
+		return nil
+	}
+	line := append(co.partial, '\n')
+	co.partial = nil
+	return co.writeLive(line)
+}
+
+func (co *ClusterOutput) writeLive(lines []byte) error {
+	var prefixed bytes.Buffer
+	for _, line := range bytes.SplitAfter(lines, []byte("\n")) {
+		if len(line) > 0 {
+			fmt.Fprintf(&prefixed, "[%s] %s", co.Cluster, line)
+		}
+	}
+	co.liveMu.Lock()
+	defer co.liveMu.Unlock()
+	if _, err := co.live.Write(prefixed.Bytes()); err != nil {
+		return fmt.Errorf("failed to write output: %w", err)
+	}
+	return nil
+}
+
+func (co *ClusterOutput) addTable(headers []string, rows [][]string) {
+	co.mu.Lock()
+	defer co.mu.Unlock()
+	co.segments = append(co.segments, outputSegment{headers: headers, rows: rows})
+}
+
+func (co *ClusterOutput) addJSON(data any) {
+	co.mu.Lock()
+	defer co.mu.Unlock()
+	co.segments = append(co.segments, outputSegment{data: data})
+}
+
+// clusterResult is the JSON output of one cluster, with every JSON
+// document the command wrote for it in order
+type clusterResult struct {
+	Cluster string `json:"cluster"`
+	Results []any  `json:"results,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+// WriteClusterOutputs merges the output of the clusters. Tables with the
+// same columns are joined with a leading cluster column, JSON documents are
+// wrapped with their cluster and error, and any other text is written under
+// a header naming its cluster. In JSON mode that text goes to errOut so that
+// out stays a single JSON document.
+func WriteClusterOutputs(out, errOut io.Writer, format string, outputs []*ClusterOutput) error {
+	if format == FormatJSON {
+		results := make([]clusterResult, 0, len(outputs))
+		for _, output := range outputs {
+			result := clusterResult{Cluster: output.Cluster}
+			for _, segment := range output.segments {
+				if segment.data != nil {
+					result.Results = append(result.Results, segment.data)
+				}
+			}
+			if output.Err != nil {
+				result.Error = output.Err.Error()
+			}
+			results = append(results, result)
+		}
+		if err := writeJSON(out, results); err != nil {
+			return err
+		}
+		return writeClusterTexts(errOut, format, outputs)
+	}
+
+	var order []string
+	headers := make(map[string][]string)
+	rows := make(map[string][][]string)
+	for _, output := range outputs {
+		for _, segment := range output.segments {
+			if segment.headers == nil {
+				continue
+			}
+			key := strings.Join(segment.headers, "\t")
+			if _, ok := headers[key]; !ok {
+				order = append(order, key)
+				headers[key] = append([]string{"CLUSTER"}, segment.headers...)
+			}
+			for _, row := range segment.rows {
+				rows[key] = append(rows[key], append([]string{output.Cluster}, row...))
+			}
+		}
+	}
+	for idx, key := range order {
+		if idx > 0 {
+			fmt.Fprintln(out)
+		}
+		if err := writeTable(out, format, headers[key], rows[key]); err != nil {
+			return err
+		}
+	}
+	return writeClusterTexts(out, format, outputs)
+}
+
+func writeClusterTexts(out io.Writer, format string, outputs []*ClusterOutput) error {
+	for _, output := range outputs {
+		var text []byte
+		for _, segment := range output.segments {
+			text = append(text, segment.text...)
+		}
+		if len(bytes.TrimSpace(text)) == 0 {
+			continue
+		}
+		header := fmt.Sprintf("==> %s <==", output.Cluster)
+		if format == FormatMarkdown {
+			header = "## " + output.Cluster
+		}
+		if _, err := fmt.Fprintf(out, "\n%s\n%s", header, text); err != nil {
+			return fmt.Errorf("failed to write output: %w", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,107 @@
+package custodian
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKubeContexts(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config")
+	require.NoError(t, os.WriteFile(path, []byte(`
+apiVersion: v1
+kind: Config
+clusters:
+  - name: gke
+    cluster: {server: https://127.0.0.1:6443}
+users:
+  - name: admin
+    user: {token: secret}
+contexts:
+  - name: staging
+    context: {cluster: gke, user: admin}
+  - name: dev
+    context: {cluster: gke, user: admin}
+current-context: dev
+`), 0o600))
+
+	contexts, err := KubeContexts(path)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"dev", "staging"}, contexts)
+}
+
+func TestWriteClusterOutputs(t *testing.T) {
+	dev := &ClusterOutput{Cluster: "dev"}
+	require.NoError(t, WriteOrphans(dev, FormatTable, []Orphan{{
+		Kind: "PersistentVolumeClaim", Namespace: "dev", Name: "scratch",
+	}}))
+	staging := &ClusterOutput{Cluster: "staging"}
+	require.NoError(t, WriteOrphans(staging, FormatTable, []Orphan{{
+		Kind: "PersistentVolume", Name: "pv-released",
+	}}))
+	broken := &ClusterOutput{Cluster: "experiments", Err: errors.New("connection refused")}
+
+	var out bytes.Buffer
+	outputs := []*ClusterOutput{dev, staging, broken}
+	require.NoError(t, WriteClusterOutputs(&out, io.Discard, FormatTable, outputs))
+	lines := bytes.Split(bytes.TrimSpace(out.Bytes()), []byte("\n"))
+	require.Len(t, lines, 3)
+	assert.True(t, bytes.HasPrefix(lines[0], []byte("CLUSTER  KIND")))
+	assert.True(t, bytes.HasPrefix(lines[1], []byte("dev      PersistentVolumeClaim")))
+	assert.True(t, bytes.HasPrefix(lines[2], []byte("staging  PersistentVolume ")))
+
+	out.Reset()
+	require.NoError(t, WriteClusterOutputs(&out, io.Discard, FormatJSON, outputs))
+	assert.Contains(t, out.String(), `"cluster": "staging"`)
+	assert.Contains(t, out.String(), `"error": "connection refused"`)
+}
+
+func TestWriteClusterOutputsKeepsEveryJSONDocument(t *testing.T) {
+	dev := &ClusterOutput{Cluster: "dev"}
+	dev.addJSON(map[string]string{"job": "backup"})
+	fmt.Fprintln(dev, "following logs")
+	dev.addJSON(map[string]string{"job": "restore"})
+
+	var out, errOut bytes.Buffer
+	require.NoError(t, WriteClusterOutputs(&out, &errOut, FormatJSON, []*ClusterOutput{dev}))
+	var results []struct {
+		Cluster string              `json:"cluster"`
+		Results []map[string]string `json:"results"`
+	}
+	require.NoError(t, json.Unmarshal(out.Bytes(), &results), "stdout is only JSON")
+	require.Len(t, results, 1)
+	assert.Equal(t, []map[string]string{{"job": "backup"}, {"job": "restore"}}, results[0].Results)
+	assert.Equal(t, "\n==> dev <==\nfollowing logs\n", errOut.String())
+}
+
+func TestStreamClusterOutput(t *testing.T) {
+	var out bytes.Buffer
+	var mu sync.Mutex
+	dev := StreamClusterOutput("dev", &out, &mu)
+	staging := StreamClusterOutput("staging", &out, &mu)
+
+	fmt.Fprint(dev, "backup-1 started\nbackup-1 dump")
+	assert.Equal(t, "[dev] backup-1 started\n", out.String(), "complete lines are written right away")
+	fmt.Fprintln(staging, "restore-1 started")
+	fmt.Fprintln(dev, "ing")
+	fmt.Fprint(staging, "restore-1 done")
+	require.NoError(t, dev.Flush())
+	require.NoError(t, staging.Flush())
+	assert.Equal(t, "[dev] backup-1 started\n"+
+		"[staging] restore-1 started\n"+
+		"[dev] backup-1 dumping\n"+
+		"[staging] restore-1 done\n", out.String())
+
+	out.Reset()
+	require.NoError(t, WriteClusterOutputs(&out, io.Discard, FormatTable, []*ClusterOutput{dev, staging}))
+	assert.Empty(t, out.String(), "streamed text is not written again")
+}
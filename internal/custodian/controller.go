@@ -0,0 +1,407 @@
+package custodian
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+)
+
+// Rules applied by the controller
+const (
+	RuleBackupLabel    = "backup-label"
+	RuleVolumeExcludes = "volume-excludes"
+)
+
+// ControllerOptions configures the rules, the watched namespaces and the
+// leader election of the controller
+type ControllerOptions struct {
+	// Namespaces to watch, an empty list means all namespaces
+	Namespaces []string
+	// Resources gets the backup exclusion label, given as resource or
+	// resource.group, e.g. pods or statefulsets.apps
+	Resources []string
+	Label     BackupLabelOptions
+	// Volumes are merged into the volume excludes annotation, the rule is
+	// disabled without volume types and storage classes
+	Volumes VolumeExcludeOptions
+	// Address serves /healthz, /readyz and /metrics
+	Address string
+	Resync  time.Duration
+	// LeaderElection runs a single active replica holding the lease
+	LeaderElection bool
+	LeaseName      string
+	LeaseNamespace string
+	Identity       string
+}
+
+type controller struct {
+	cus     *Custodian
+	opts    ControllerOptions
+	metrics *controllerMetrics
+	// volumes is shared by the pod handlers of every namespace
+	volumes *volumeExcluder
+	leading atomic.Bool
+	synced  atomic.Bool
+}
+
+// RunController watches the namespaces and applies the backup exclusion
+// label and volume excludes annotation to resources as they are created or
+// updated, until the context is cancelled
+func (cus *Custodian) RunController(
+	ctx context.Context,
+	opts ControllerOptions,
+) error {
+	ctrl := &controller{
+		cus:     cus,
+		opts:    opts,
+		metrics: newControllerMetrics(),
+	}
+	server := &http.Server{
+		Addr:              opts.Address,
+		Handler:           ctrl.handler(),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	serverErr := make(chan error, 1)
+	go func() {
+		cus.logger.Info("Serving health and metrics", "address", opts.Address)
+		if err := server.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+			serverErr <- err
+		}
+		close(serverErr)
+	}()
+
+	ctx, cancel := context.WithCancel(ctx)
+	go func() {
+		if err := <-serverErr; err != nil {
+			cus.logger.Error("Health and metrics server failed", "error", err)
+			cancel()
+		}
+	}()
+
+	var err error
+	if opts.LeaderElection {
+		err = ctrl.runWithLeaderElection(ctx)
+	} else {
+		ctrl.leading.Store(true)
+		ctrl.metrics.leader.Store(1)
+		err = ctrl.run(ctx)
+	}
+	cancel()
+
+	shutdownCtx, shutdownCancel := context.WithTimeout(
+		context.Background(),
+		5*time.Second,
+	)
+	defer shutdownCancel()
+	return errors.Join(err, server.Shutdown(shutdownCtx))
+}
+
+func (ctrl *controller) runWithLeaderElection(ctx context.Context) error {
+	lock := &resourcelock.LeaseLock{
+		LeaseMeta: metav1.ObjectMeta{
+			Name:      ctrl.opts.LeaseName,
+			Namespace: ctrl.opts.LeaseNamespace,
+		},
+		Client: ctrl.cus.clientset.CoordinationV1(),
+		LockConfig: resourcelock.ResourceLockConfig{
+			Identity: ctrl.opts.Identity,
+		},
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var started atomic.Bool
+	runErr := make(chan error, 1)
+	elector, err := leaderelection.NewLeaderElector(leaderelection.LeaderElectionConfig{
+		Lock:            lock,
+		LeaseDuration:   15 * time.Second,
+		RenewDeadline:   10 * time.Second,
+		RetryPeriod:     2 * time.Second,
+		ReleaseOnCancel: true,
+		Name:            ctrl.opts.LeaseName,
+		Callbacks: leaderelection.LeaderCallbacks{
+			OnStartedLeading: func(ctx context.Context) {
+				ctrl.leading.Store(true)
+				ctrl.metrics.leader.Store(1)
+				started.Store(true)
+				err := ctrl.run(ctx)
+				if err != nil {
+					// give up the lease so another replica can take over
+					cancel()
+				}
+				runErr <- err
+			},
+			OnStoppedLeading: func() {
+				ctrl.leading.Store(false)
+				ctrl.metrics.leader.Store(0)
+				ctrl.cus.logger.Info("Stopped leading", "identity", ctrl.opts.Identity)
+			},
+			OnNewLeader: func(identity string) {
+				ctrl.cus.logger.Info("Current leader", "identity", identity)
+			},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to set up leader election: %w", err)
+	}
+	elector.Run(ctx)
+	if !started.Load() {
+		return nil
+	}
+	return <-runErr
+}
+
+// run starts the informers and blocks until the context is cancelled
+func (ctrl *controller) run(ctx context.Context) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	resources, err := ctrl.cus.resolveResources(ctrl.opts.Resources)
+	if err != nil {
+		return err
+	}
+	namespaces := ctrl.opts.Namespaces
+	if len(namespaces) == 0 {
+		namespaces = []string{metav1.NamespaceAll}
+	}
+	ctrl.volumes = ctrl.cus.newVolumeExcluder(ctrl.opts.Volumes)
+
+	var synced []cache.InformerSynced
+	var factories []interface {
+		Start(stopCh <-chan struct{})
+		Shutdown()
+	}
+	for _, namespace := range namespaces {
+		dynamicFactory := dynamicinformer.NewFilteredDynamicSharedInformerFactory(
+			ctrl.cus.dynamicClient,
+			ctrl.opts.Resync,
+			namespace,
+			func(opts *metav1.ListOptions) {
+				opts.LabelSelector = ctrl.opts.Label.Selector
+			},
+		)
+		for _, gvr := range resources {
+			informer := dynamicFactory.ForResource(gvr).Informer()
+			err := ctrl.addHandler(informer, ctrl.resourceHandler(ctx, gvr), nil)
+			if err != nil {
+				return err
+			}
+			synced = append(synced, informer.HasSynced)
+		}
+		factories = append(factories, dynamicFactory)
+
+		if !ctrl.volumeRuleEnabled() {
+			continue
+		}
+		factory := informers.NewSharedInformerFactoryWithOptions(
+			ctrl.cus.clientset,
+			ctrl.opts.Resync,
+			informers.WithNamespace(namespace),
+		)
+		informer := factory.Core().V1().Pods().Informer()
+		if err := ctrl.addHandler(informer, ctrl.podHandler(ctx), podChanged); err != nil {
+			return err
+		}
+		synced = append(synced, informer.HasSynced)
+		factories = append(factories, factory)
+	}
+
+	for _, factory := range factories {
+		factory.Start(ctx.Done())
+	}
+	defer func() {
+		cancel()
+		for _, factory := range factories {
+			factory.Shutdown()
+		}
+	}()
+	if !cache.WaitForCacheSync(ctx.Done(), synced...) {
+		return ctx.Err()
+	}
+	ctrl.synced.Store(true)
+	ctrl.cus.logger.Info(
+		"Controller started",
+		"namespaces", namespaces,
+		"resources", ctrl.opts.Resources,
+		"volume-excludes", ctrl.volumeRuleEnabled(),
+	)
+	<-ctx.Done()
+	ctrl.synced.Store(false)
+	return nil
+}
+
+func (ctrl *controller) volumeRuleEnabled() bool {
+	return len(ctrl.opts.Volumes.VolumeTypes) > 0 ||
+		len(ctrl.opts.Volumes.StorageClasses) > 0
+}
+
+// addHandler handles added and updated objects, updates are skipped when
+// changed is given and reports no change
+func (ctrl *controller) addHandler(
+	informer cache.SharedIndexInformer,
+	handle func(obj any),
+	changed func(old, obj any) bool,
+) error {
+	_, err := informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: handle,
+		UpdateFunc: func(old, obj any) {
+			if changed == nil || changed(old, obj) {
+				handle(obj)
+			}
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to add event handler: %w", err)
+	}
+	return nil
+}
+
+func (ctrl *controller) resourceHandler(
+	ctx context.Context,
+	gvr schema.GroupVersionResource,
+) func(obj any) {
+	change := excludeLabelChange(ctrl.opts.Label)
+	return func(obj any) {
+		item, ok := obj.(*unstructured.Unstructured)
+		if !ok {
+			return
+		}
+		ctrl.metrics.events.inc(RuleBackupLabel)
+		value, needed := change(item.GetLabels())
+		if !needed {
+			return
+		}
+		err := ctrl.cus.patchResourceLabel(
+			ctx,
+			gvr,
+			item,
+			gvr.Resource,
+			ctrl.opts.Label,
+			value,
+		)
+		if err != nil {
+			ctrl.metrics.errors.inc(RuleBackupLabel)
+			ctrl.cus.logger.Error(
+				"Error applying backup label",
+				"resource", gvr.Resource,
+				"namespace", item.GetNamespace(),
+				"name", item.GetName(),
+				"error", err,
+			)
+			return
+		}
+		ctrl.metrics.patches.inc(RuleBackupLabel)
+	}
+}
+
+func (ctrl *controller) podHandler(ctx context.Context) func(obj any) {
+	return func(obj any) {
+		pod, ok := obj.(*corev1.Pod)
+		if !ok || pod.DeletionTimestamp != nil {
+			return
+		}
+		ctrl.metrics.events.inc(RuleVolumeExcludes)
+		if err := ctrl.volumes.processPod(ctx, pod); err != nil {
+			ctrl.metrics.errors.inc(RuleVolumeExcludes)
+			ctrl.cus.logger.Error(
+				"Error applying volume excludes",
+				"namespace", pod.Namespace,
+				"pod", pod.Name,
+				"error", err,
+			)
+		}
+	}
+}
+
+// podChanged skips the pod updates, mostly status changes, that leave its
+// volumes and annotations as they were
+func podChanged(old, obj any) bool {
+	oldPod, ok := old.(*corev1.Pod)
+	if !ok {
+		return true
+	}
+	pod, ok := obj.(*corev1.Pod)
+	if !ok {
+		return true
+	}
+	return !equality.Semantic.DeepEqual(oldPod.Spec.Volumes, pod.Spec.Volumes) ||
+		!equality.Semantic.DeepEqual(oldPod.Annotations, pod.Annotations)
+}
+
+// resolveResources maps resource or resource.group names to the preferred
+// version served by the cluster
+func (cus *Custodian) resolveResources(
+	names []string,
+) ([]schema.GroupVersionResource, error) {
+	lists, err := cus.discoveryClient.ServerPreferredNamespacedResources()
+	if err != nil {
+		if !discovery.IsGroupDiscoveryFailedError(err) {
+			return nil, fmt.Errorf("failed to get server resources: %w", err)
+		}
+		cus.logger.Warn("Some API groups could not be discovered", "error", err)
+	}
+
+	gvrs := make([]schema.GroupVersionResource, 0, len(names))
+	for _, name := range names {
+		resource, group, _ := strings.Cut(name, ".")
+		gvr, found := findResource(lists, resource, group)
+		if !found {
+			return nil, fmt.Errorf("resource %s is not served by the cluster", name)
+		}
+		gvrs = append(gvrs, gvr)
+	}
+	return gvrs, nil
+}
+
+func findResource(
+	lists []*metav1.APIResourceList,
+	resource, group string,
+) (schema.GroupVersionResource, bool) {
+	for _, list := range lists {
+		gv, err := schema.ParseGroupVersion(list.GroupVersion)
+		if err != nil || gv.Group != group {
+			continue
+		}
+		for _, apiResource := range list.APIResources {
+			if apiResource.Name == resource {
+				return gv.WithResource(resource), true
+			}
+		}
+	}
+	return schema.GroupVersionResource{}, false
+}
+
+func (ctrl *controller) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, _ *http.Request) {
+		fmt.Fprintln(w, "ok")
+	})
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, _ *http.Request) {
+		// standby replicas are ready, the leader once its caches synced
+		if ctrl.leading.Load() && !ctrl.synced.Load() {
+			http.Error(w, "informers not synced", http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprintln(w, "ok")
+	})
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		ctrl.metrics.write(w)
+	})
+	return mux
+}
@@ -0,0 +1,194 @@
+package custodian
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	fakediscovery "k8s.io/client-go/discovery/fake"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// preferredDiscovery serves the fake resources as the preferred ones, which
+// the fake discovery client leaves empty
+type preferredDiscovery struct {
+	*fakediscovery.FakeDiscovery
+}
+
+func (dsc preferredDiscovery) ServerPreferredNamespacedResources() (
+	[]*metav1.APIResourceList,
+	error,
+) {
+	return dsc.Resources, nil
+}
+
+func TestControllerLabelsMatchingResources(t *testing.T) {
+	secret := &unstructured.Unstructured{Object: map[string]any{
+		"apiVersion": "v1",
+		"kind":       "Secret",
+		"metadata": map[string]any{
+			"name":      "arangodb-jwt",
+			"namespace": "dev",
+			"labels":    map[string]any{"app.kubernetes.io/name": "kube-arangodb"},
+		},
+	}}
+	secretsGVR := schema.GroupVersionResource{Version: "v1", Resource: "secrets"}
+	dynamicClient := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(
+		runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{secretsGVR: "SecretList"},
+		secret,
+	)
+	clientset := fake.NewSimpleClientset()
+	discoveryClient := clientset.Discovery().(*fakediscovery.FakeDiscovery)
+	discoveryClient.Resources = []*metav1.APIResourceList{{
+		GroupVersion: "v1",
+		APIResources: []metav1.APIResource{
+			{Name: "secrets", Namespaced: true, Kind: "Secret"},
+		},
+	}}
+	cus := &Custodian{
+		clientset:       clientset,
+		dynamicClient:   dynamicClient,
+		discoveryClient: preferredDiscovery{discoveryClient},
+		logger:          testLogger(),
+	}
+	ctrl := &controller{
+		cus: cus,
+		opts: ControllerOptions{
+			Namespaces: []string{"dev"},
+			Resources:  []string{"secrets"},
+			Label: BackupLabelOptions{
+				Selector:   "app.kubernetes.io/name=kube-arangodb",
+				LabelKey:   "velero.io/exclude-from-backup",
+				LabelValue: "true",
+			},
+		},
+		metrics: newControllerMetrics(),
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	done := make(chan error, 1)
+	go func() { done <- ctrl.run(ctx) }()
+
+	require.Eventually(t, func() bool {
+		item, err := dynamicClient.Resource(secretsGVR).
+			Namespace("dev").
+			Get(ctx, "arangodb-jwt", metav1.GetOptions{})
+		return err == nil &&
+			item.GetLabels()["velero.io/exclude-from-backup"] == "true"
+	}, 5*time.Second, 50*time.Millisecond)
+	assert.Eventually(t, func() bool {
+		return ctrl.metrics.patches.get(RuleBackupLabel) == 1
+	}, 5*time.Second, 50*time.Millisecond)
+
+	ctrl.leading.Store(true)
+	require.Eventually(t, ctrl.synced.Load, 5*time.Second, 50*time.Millisecond)
+	recorder := httptest.NewRecorder()
+	ctrl.handler().ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+	assert.Contains(
+		t,
+		recorder.Body.String(),
+		`custodian_controller_patches_total{rule="backup-label"} 1`,
+	)
+	recorder = httptest.NewRecorder()
+	ctrl.handler().ServeHTTP(recorder, httptest.NewRequest("GET", "/readyz", nil))
+	assert.Equal(t, 200, recorder.Code)
+
+	cancel()
+	require.NoError(t, <-done)
+}
+
+func TestControllerPodHandlerReusesLookups(t *testing.T) {
+	isController := true
+	volumes := []corev1.Volume{{
+		Name:         "scratch",
+		VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}},
+	}}
+	replicaSetPod := func(name, hash string) *corev1.Pod {
+		return &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      name,
+				Namespace: "dev",
+				Labels:    map[string]string{appsv1.DefaultDeploymentUniqueLabelKey: hash},
+				OwnerReferences: []metav1.OwnerReference{
+					{Kind: "ReplicaSet", Name: "api-" + hash, Controller: &isController},
+				},
+			},
+			Spec: corev1.PodSpec{Volumes: volumes},
+		}
+	}
+	replicaSet := func(hash string) *appsv1.ReplicaSet {
+		return &appsv1.ReplicaSet{ObjectMeta: metav1.ObjectMeta{
+			Name:      "api-" + hash,
+			Namespace: "dev",
+			OwnerReferences: []metav1.OwnerReference{
+				{Kind: "Deployment", Name: "api", Controller: &isController},
+			},
+		}}
+	}
+	clientset := fake.NewSimpleClientset(
+		&appsv1.Deployment{
+			ObjectMeta: metav1.ObjectMeta{Name: "api", Namespace: "dev"},
+			Spec: appsv1.DeploymentSpec{Template: corev1.PodTemplateSpec{
+				Spec: corev1.PodSpec{Volumes: volumes},
+			}},
+		},
+		replicaSet("123"),
+		replicaSet("456"),
+	)
+	opts := VolumeExcludeOptions{VolumeTypes: []string{VolumeTypeEmptyDir}}
+	ctrl := &controller{
+		cus:     &Custodian{clientset: clientset, logger: testLogger()},
+		opts:    ControllerOptions{Volumes: opts},
+		metrics: newControllerMetrics(),
+	}
+	ctrl.volumes = ctrl.cus.newVolumeExcluder(opts)
+	handle := ctrl.podHandler(context.Background())
+
+	handle(replicaSetPod("api-123-abcde", "123"))
+	handle(replicaSetPod("api-123-fghij", "123"))
+	handle(replicaSetPod("api-123-abcde", "123"))
+	gets := func(resource string) int {
+		count := 0
+		for _, action := range clientset.Actions() {
+			if action.GetVerb() == "get" && action.GetResource().Resource == resource {
+				count++
+			}
+		}
+		return count
+	}
+	assert.Equal(t, 1, gets("replicasets"), "the owner of a replicaset is looked up once")
+	assert.Equal(t, 1, gets("deployments"), "the template is looked up once")
+
+	handle(replicaSetPod("api-456-abcde", "456"))
+	assert.Equal(t, 2, gets("deployments"), "a rollout looks up the new template")
+	assert.Zero(t, ctrl.metrics.errors.get(RuleVolumeExcludes))
+}
+
+func TestPodChanged(t *testing.T) {
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "api", Namespace: "dev"},
+		Spec: corev1.PodSpec{Volumes: []corev1.Volume{{
+			Name:         "scratch",
+			VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}},
+		}}},
+	}
+	running := pod.DeepCopy()
+	running.Status.Phase = corev1.PodRunning
+	assert.False(t, podChanged(pod, running), "a status update is skipped")
+
+	annotated := running.DeepCopy()
+	annotated.Annotations = map[string]string{VolumesExcludesAnnotation: "other"}
+	assert.True(t, podChanged(running, annotated))
+}
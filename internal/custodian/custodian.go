@@ -3,6 +3,8 @@ package custodian
 import (
 	"bytes"
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log/slog"
@@ -26,25 +28,32 @@ import (
 
 // Custodian represents the main structure for the custodian operations
 type Custodian struct {
-	clientset       *kubernetes.Clientset
+	clientset       kubernetes.Interface
 	dynamicClient   dynamic.Interface
-	discoveryClient *discovery.DiscoveryClient
+	discoveryClient discovery.DiscoveryInterface
 	namespace       string
 	label           string
+	logOptions      LogOptions
 	logger          *slog.Logger
 }
 
 // CustodianConfig holds the configuration for creating a new Custodian
 type CustodianConfig struct {
 	KubeconfigPath string
-	Namespace      string
-	Label          string
-	Logger         *slog.Logger
+	// Context selects a kubeconfig context instead of the current one
+	Context    string
+	Namespace  string
+	Label      string
+	LogOptions LogOptions
+	Logger     *slog.Logger
 }
 
 // NewCustodian creates a new Custodian instance
 func NewCustodian(config CustodianConfig) (*Custodian, error) {
-	clientset, cfg, err := createKubernetesClient(config.KubeconfigPath)
+	clientset, cfg, err := createKubernetesClient(
+		config.KubeconfigPath,
+		config.Context,
+	)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create Kubernetes client: %w", err)
 	}
@@ -65,6 +74,7 @@ func NewCustodian(config CustodianConfig) (*Custodian, error) {
 		discoveryClient: discoveryClient,
 		namespace:       config.Namespace,
 		label:           config.Label,
+		logOptions:      config.LogOptions,
 		logger:          config.Logger,
 	}, nil
 }
@@ -98,24 +108,30 @@ func (cus *Custodian) listJobs() (*batchv1.JobList, error) {
 		})
 }
 
+// processJobs extracts the logs of every job, a failing job does not stop
+// the others and all errors are returned
 func (cus *Custodian) processJobs(jobs *batchv1.JobList) error {
+	var errs []error
 	for _, job := range jobs.Items {
 		cus.logger.Info("Found job", "name", job.Name)
 
 		pods, err := cus.listPodsForJob(job.Name)
 		if err != nil {
-			return fmt.Errorf(
+			errs = append(errs, fmt.Errorf(
 				"error listing pods for job %s: %w",
 				job.Name,
 				err,
-			)
+			))
+			continue
 		}
 
-		if err := cus.processPodsForJob(pods); err != nil {
-			return err
+		if len(cus.logOptions.OutputDir) > 0 {
+			errs = append(errs, cus.writeJobLogs(cus.logOptions.OutputDir, job.Name, pods))
+			continue
 		}
+		errs = append(errs, cus.processPodsForJob(pods))
 	}
-	return nil
+	return errors.Join(errs...)
 }
 
 func (cus *Custodian) listPodsForJob(jobName string) (*corev1.PodList, error) {
@@ -127,6 +143,7 @@ func (cus *Custodian) listPodsForJob(jobName string) (*corev1.PodList, error) {
 }
 
 func (cus *Custodian) processPodsForJob(pods *corev1.PodList) error {
+	var errs []error
 	for idx := range pods.Items {
 		pod := &pods.Items[idx]
 		logs, err := cus.getPodLogs(pod)
@@ -138,18 +155,19 @@ func (cus *Custodian) processPodsForJob(pods *corev1.PodList) error {
 				"error",
 				err,
 			)
+			errs = append(errs, fmt.Errorf("failed to get logs of pod %s: %w", pod.Name, err))
 			continue
 		}
 
 		cus.logger.Info("Pod logs", "pod", pod.Name, "logs", logs)
 	}
-	return nil
+	return errors.Join(errs...)
 }
 
 func (cus *Custodian) getPodLogs(pod *corev1.Pod) (string, error) {
 	req := cus.clientset.CoreV1().
-		Pods(cus.namespace).
-		GetLogs(pod.Name, &corev1.PodLogOptions{})
+		Pods(pod.Namespace).
+		GetLogs(pod.Name, cus.podLogOptions("", false))
 	podLogs, err := req.Stream(context.TODO())
 	if err != nil {
 		return "", fmt.Errorf("error in opening stream: %w", err)
@@ -169,11 +187,11 @@ func (cus *Custodian) getPodLogs(pod *corev1.Pod) (string, error) {
 }
 
 func createKubernetesClient(
-	kubeconfigPath string,
+	kubeconfigPath, kubeContext string,
 ) (*kubernetes.Clientset, *rest.Config, error) {
-	cfg, err := clientcmd.BuildConfigFromFlags("", kubeconfigPath)
+	cfg, err := restConfig(kubeconfigPath, kubeContext)
 	if err != nil {
-		return nil, nil, fmt.Errorf("error building kubeconfig: %w", err)
+		return nil, nil, err
 	}
 
 	clientset, err := kubernetes.NewForConfig(cfg)
@@ -184,14 +202,104 @@ func createKubernetesClient(
 	return clientset, cfg, nil
 }
 
-// ExcludeFromBackup adds the 'velero.io/exclude-from-backup=true' label to resources
-// labeled with 'app.kubernetes.io/name=kube-arangodb'
-func (cus *Custodian) ExcludeFromBackup() error {
-	resources, err := cus.discoveryClient.ServerPreferredResources()
+// restConfig uses the in-cluster configuration when running in a pod
+// without an explicit kubeconfig or context, otherwise the kubeconfig file,
+// or the default loading rules if no path is given, with the context
+// overriding its current one
+func restConfig(kubeconfigPath, kubeContext string) (*rest.Config, error) {
+	if len(kubeconfigPath) == 0 && len(kubeContext) == 0 {
+		cfg, err := rest.InClusterConfig()
+		if err == nil {
+			return cfg, nil
+		}
+		if !errors.Is(err, rest.ErrNotInCluster) {
+			return nil, fmt.Errorf("error building in-cluster config: %w", err)
+		}
+	}
+
+	rules := clientcmd.NewDefaultClientConfigLoadingRules()
+	rules.ExplicitPath = kubeconfigPath
+	cfg, err := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(
+		rules,
+		&clientcmd.ConfigOverrides{CurrentContext: kubeContext},
+	).ClientConfig()
+	if err != nil {
+		return nil, fmt.Errorf("error building kubeconfig: %w", err)
+	}
+	return cfg, nil
+}
+
+// BackupLabelOptions selects the resources to label and the label to set
+// or remove
+type BackupLabelOptions struct {
+	Selector   string
+	LabelKey   string
+	LabelValue string
+	// Namespaces to process, an empty list means all namespaces
+	Namespaces []string
+	// Resources limits the labelled resources, given as resource or
+	// resource.group, an empty list means every namespaced resource
+	Resources []string
+	DryRun    bool
+}
+
+// ParseLabel splits a key=value label, the value is empty if not given
+func ParseLabel(label string) (string, string, error) {
+	key, value, _ := strings.Cut(label, "=")
+	if len(key) == 0 {
+		return "", "", fmt.Errorf("invalid label %q", label)
+	}
+	return key, value, nil
+}
+
+// ExcludeFromBackup adds the backup exclusion label, by default
+// 'velero.io/exclude-from-backup=true', to the resources matching the
+// selector, by default 'app.kubernetes.io/name=kube-arangodb'
+func (cus *Custodian) ExcludeFromBackup(opts BackupLabelOptions) error {
+	return cus.patchBackupLabel(opts, excludeLabelChange(opts))
+}
+
+// IncludeInBackup removes the backup exclusion label from the resources
+// matching the selector
+func (cus *Custodian) IncludeInBackup(opts BackupLabelOptions) error {
+	return cus.patchBackupLabel(opts, func(labels map[string]string) (any, bool) {
+		if _, ok := labels[opts.LabelKey]; !ok {
+			return nil, false
+		}
+		return nil, true
+	})
+}
+
+// labelChange returns the new label value, nil to remove the label, and
+// whether the resource needs a patch at all
+type labelChange func(labels map[string]string) (any, bool)
+
+func excludeLabelChange(opts BackupLabelOptions) labelChange {
+	value := opts.LabelValue
+	return func(labels map[string]string) (any, bool) {
+		if current, ok := labels[opts.LabelKey]; ok && current == value {
+			return nil, false
+		}
+		return value, true
+	}
+}
+
+func (cus *Custodian) patchBackupLabel(
+	opts BackupLabelOptions,
+	change labelChange,
+) error {
+	resources, err := cus.discoveryClient.ServerPreferredNamespacedResources()
 	if err != nil {
-		return fmt.Errorf("failed to get server resources: %w", err)
+		if !discovery.IsGroupDiscoveryFailedError(err) {
+			return fmt.Errorf("failed to get server resources: %w", err)
+		}
+		cus.logger.Warn("Some API groups could not be discovered", "error", err)
 	}
 
+	namespaces := opts.Namespaces
+	if len(namespaces) == 0 {
+		namespaces = []string{metav1.NamespaceAll}
+	}
 	for _, list := range resources {
 		gv, err := schema.ParseGroupVersion(list.GroupVersion)
 		if err != nil {
@@ -203,9 +311,14 @@ func (cus *Custodian) ExcludeFromBackup() error {
 		}
 
 		for _, resource := range list.APIResources {
-			err := cus.processAPIResource(gv, resource)
-			if err != nil {
-				return err
+			if !matchesAny(opts.Resources, true, resource.Name, resource.Name+"."+gv.Group) {
+				continue
+			}
+			for _, namespace := range namespaces {
+				err := cus.processAPIResource(gv, resource, namespace, opts, change)
+				if err != nil {
+					return err
+				}
 			}
 		}
 	}
@@ -216,9 +329,13 @@ func (cus *Custodian) ExcludeFromBackup() error {
 func (cus *Custodian) processAPIResource(
 	gv schema.GroupVersion,
 	resource metav1.APIResource,
+	namespace string,
+	opts BackupLabelOptions,
+	change labelChange,
 ) error {
-	if !cus.hasVerbs(resource, "list", "update") {
-		return nil // No error; resource doesn't have required verbs
+	if strings.Contains(resource.Name, "/") ||
+		!cus.hasVerbs(resource, "list", "patch") {
+		return nil // No error; subresource or missing required verbs
 	}
 
 	gvr := schema.GroupVersionResource{
@@ -228,9 +345,9 @@ func (cus *Custodian) processAPIResource(
 	}
 
 	unstructuredList, err := cus.dynamicClient.
-		Resource(gvr).Namespace(cus.namespace).
+		Resource(gvr).Namespace(namespace).
 		List(context.TODO(), metav1.ListOptions{
-			LabelSelector: "app.kubernetes.io/name=kube-arangodb",
+			LabelSelector: opts.Selector,
 		})
 	if err != nil {
 		// Log a warning if the resource is not found and return
@@ -252,7 +369,18 @@ func (cus *Custodian) processAPIResource(
 
 	for idx := range unstructuredList.Items {
 		item := &unstructuredList.Items[idx]
-		err := cus.updateResourceLabel(gvr, item, resource.Name)
+		value, needed := change(item.GetLabels())
+		if !needed {
+			continue
+		}
+		err := cus.patchResourceLabel(
+			context.TODO(),
+			gvr,
+			item,
+			resource.Name,
+			opts,
+			value,
+		)
 		if err != nil {
 			return fmt.Errorf(
 				"failed to update resource %s: %w",
@@ -264,24 +392,49 @@ func (cus *Custodian) processAPIResource(
 	return nil
 }
 
-func (cus *Custodian) updateResourceLabel(
+// patchResourceLabel sets the label with a JSON merge patch, a nil value
+// removes it
+func (cus *Custodian) patchResourceLabel(
+	ctx context.Context,
 	gvr schema.GroupVersionResource,
 	item *unstructured.Unstructured,
 	resourceName string,
+	opts BackupLabelOptions,
+	value any,
 ) error {
-	labels := item.GetLabels()
-	if labels == nil {
-		labels = make(map[string]string)
+	if opts.DryRun {
+		cus.logger.Info(
+			"Dry run: would update resource",
+			"resource", resourceName,
+			"namespace", item.GetNamespace(),
+			"name", item.GetName(),
+			"label", opts.LabelKey,
+			"value", value,
+		)
+		return nil
 	}
-	labels["velero.io/exclude-from-backup"] = "true"
-	item.SetLabels(labels)
 
-	_, err := cus.dynamicClient.Resource(gvr).
-		Namespace(cus.namespace).
-		Update(context.TODO(), item, metav1.UpdateOptions{})
+	patchData, err := json.Marshal(map[string]any{
+		"metadata": map[string]any{
+			"labels": map[string]any{opts.LabelKey: value},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create label patch: %w", err)
+	}
+
+	_, err = cus.dynamicClient.Resource(gvr).
+		Namespace(item.GetNamespace()).
+		Patch(
+			ctx,
+			item.GetName(),
+			types.MergePatchType,
+			patchData,
+			metav1.PatchOptions{},
+		)
 	if err != nil {
 		return fmt.Errorf(
-			"failed to update resource %s/%s: %w",
+			"failed to patch resource %s/%s: %w",
 			resourceName,
 			item.GetName(),
 			err,
@@ -289,10 +442,11 @@ func (cus *Custodian) updateResourceLabel(
 	}
 	cus.logger.Info(
 		"Updated resource",
-		"resource",
-		resourceName,
-		"name",
-		item.GetName(),
+		"resource", resourceName,
+		"namespace", item.GetNamespace(),
+		"name", item.GetName(),
+		"label", opts.LabelKey,
+		"value", value,
 	)
 	return nil
 }
@@ -309,76 +463,3 @@ func (cus *Custodian) hasVerbs(
 	}
 	return true
 }
-
-func (cus *Custodian) ExcludeVolumesFromBackup() error {
-	pods, err := cus.listPods()
-	if err != nil {
-		return fmt.Errorf("failed to list pods: %w", err)
-	}
-
-	for i := range pods.Items {
-		pod := &pods.Items[i] // Create a new variable for each iteration
-		if err := cus.excludeVolumesForPod(pod); err != nil {
-			return fmt.Errorf(
-				"failed to exclude volumes for pod %s: %w",
-				pod.Name,
-				err,
-			)
-		}
-	}
-
-	return nil
-}
-
-func (cus *Custodian) listPods() (*corev1.PodList, error) {
-	return cus.clientset.CoreV1().
-		Pods(cus.namespace).
-		List(context.Background(), metav1.ListOptions{})
-}
-
-func (cus *Custodian) excludeVolumesForPod(pod *corev1.Pod) error {
-	volumeNames := cus.getVolumeNames(pod)
-	if len(volumeNames) == 0 {
-		return nil // No volumes to exclude
-	}
-
-	annotationValue := strings.Join(volumeNames, ",")
-	patchData := []byte(
-		fmt.Sprintf(
-			`{"metadata":{"annotations":{"backup.velero.io/backup-volumes-excludes":"%s"}}}`,
-			annotationValue,
-		),
-	)
-
-	_, err := cus.clientset.CoreV1().Pods(cus.namespace).Patch(
-		context.Background(),
-		pod.Name,
-		types.StrategicMergePatchType,
-		patchData,
-		metav1.PatchOptions{},
-	)
-	if err != nil {
-		return fmt.Errorf(
-			"failed to add annotation to pod %s: %w",
-			pod.Name,
-			err,
-		)
-	}
-
-	cus.logger.Info(
-		"Added backup volume exclude annotation",
-		"pod",
-		pod.Name,
-		"volumes",
-		annotationValue,
-	)
-	return nil
-}
-
-func (cus *Custodian) getVolumeNames(pod *corev1.Pod) []string {
-	var volumeNames []string
-	for _, volume := range pod.Spec.Volumes {
-		volumeNames = append(volumeNames, volume.Name)
-	}
-	return volumeNames
-}
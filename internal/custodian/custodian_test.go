@@ -0,0 +1,204 @@
+package custodian
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	fakediscovery "k8s.io/client-go/discovery/fake"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+var (
+	backupLabelDeploymentGVR = schema.GroupVersionResource{
+		Group:    "database.arangodb.com",
+		Version:  "v1",
+		Resource: "arangodeployments",
+	}
+	backupLabelPVCGVR = schema.GroupVersionResource{
+		Version:  "v1",
+		Resource: "persistentvolumeclaims",
+	}
+)
+
+func labelledObject(apiVersion, kind, name string, labels map[string]any) *unstructured.Unstructured {
+	return &unstructured.Unstructured{Object: map[string]any{
+		"apiVersion": apiVersion,
+		"kind":       kind,
+		"metadata": map[string]any{
+			"name":      name,
+			"namespace": "dev",
+			"labels":    labels,
+		},
+	}}
+}
+
+// backupLabelCustodian serves an unlabelled arango deployment, a volume
+// claim that is already excluded and one that does not match the selector
+func backupLabelCustodian(t *testing.T) (*Custodian, *dynamicfake.FakeDynamicClient) {
+	t.Helper()
+	dynamicClient := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(
+		runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{
+			backupLabelDeploymentGVR: "ArangoDeploymentList",
+			backupLabelPVCGVR:        "PersistentVolumeClaimList",
+		},
+		labelledObject("database.arangodb.com/v1", "ArangoDeployment", "arangodb", map[string]any{
+			"app.kubernetes.io/name": "kube-arangodb",
+		}),
+		labelledObject("v1", "PersistentVolumeClaim", "arangodb-data", map[string]any{
+			"app.kubernetes.io/name":        "kube-arangodb",
+			"velero.io/exclude-from-backup": "true",
+		}),
+		labelledObject("v1", "PersistentVolumeClaim", "redis-data", map[string]any{
+			"app.kubernetes.io/name": "redis",
+		}),
+	)
+	discoveryClient := fake.NewSimpleClientset().Discovery().(*fakediscovery.FakeDiscovery)
+	discoveryClient.Resources = []*metav1.APIResourceList{
+		{
+			GroupVersion: "database.arangodb.com/v1",
+			APIResources: []metav1.APIResource{{
+				Name:       "arangodeployments",
+				Namespaced: true,
+				Kind:       "ArangoDeployment",
+				Verbs:      []string{"list", "patch"},
+			}},
+		},
+		{
+			GroupVersion: "v1",
+			APIResources: []metav1.APIResource{
+				{
+					Name:       "persistentvolumeclaims",
+					Namespaced: true,
+					Kind:       "PersistentVolumeClaim",
+					Verbs:      []string{"list", "patch"},
+				},
+				{
+					Name:       "persistentvolumeclaims/status",
+					Namespaced: true,
+					Kind:       "PersistentVolumeClaim",
+					Verbs:      []string{"patch"},
+				},
+			},
+		},
+	}
+	return &Custodian{
+		dynamicClient:   dynamicClient,
+		discoveryClient: preferredDiscovery{discoveryClient},
+		logger:          testLogger(),
+	}, dynamicClient
+}
+
+func backupLabelOptions() BackupLabelOptions {
+	return BackupLabelOptions{
+		Selector:   "app.kubernetes.io/name=kube-arangodb",
+		LabelKey:   "velero.io/exclude-from-backup",
+		LabelValue: "true",
+		Namespaces: []string{"dev"},
+	}
+}
+
+func patchedNames(dynamicClient *dynamicfake.FakeDynamicClient) []string {
+	var names []string
+	for _, action := range dynamicClient.Actions() {
+		if action.GetVerb() == "patch" {
+			names = append(names, action.(k8stesting.PatchAction).GetName())
+		}
+	}
+	return names
+}
+
+func backupLabel(
+	t *testing.T,
+	dynamicClient *dynamicfake.FakeDynamicClient,
+	gvr schema.GroupVersionResource,
+	name string,
+) (string, bool) {
+	t.Helper()
+	obj, err := dynamicClient.Resource(gvr).
+		Namespace("dev").
+		Get(context.Background(), name, metav1.GetOptions{})
+	require.NoError(t, err)
+	value, ok := obj.GetLabels()["velero.io/exclude-from-backup"]
+	return value, ok
+}
+
+func TestExcludeFromBackup(t *testing.T) {
+	cus, dynamicClient := backupLabelCustodian(t)
+	require.NoError(t, cus.ExcludeFromBackup(backupLabelOptions()))
+
+	assert.Equal(t, []string{"arangodb"}, patchedNames(dynamicClient),
+		"the already labelled claim and the unmatched one are left alone")
+	value, ok := backupLabel(t, dynamicClient, backupLabelDeploymentGVR, "arangodb")
+	assert.True(t, ok)
+	assert.Equal(t, "true", value)
+	_, ok = backupLabel(t, dynamicClient, backupLabelPVCGVR, "redis-data")
+	assert.False(t, ok)
+}
+
+func TestExcludeFromBackupUpdatesDifferentValue(t *testing.T) {
+	cus, dynamicClient := backupLabelCustodian(t)
+	opts := backupLabelOptions()
+	opts.LabelValue = "yes"
+	require.NoError(t, cus.ExcludeFromBackup(opts))
+
+	assert.ElementsMatch(t, []string{"arangodb", "arangodb-data"}, patchedNames(dynamicClient))
+	value, _ := backupLabel(t, dynamicClient, backupLabelPVCGVR, "arangodb-data")
+	assert.Equal(t, "yes", value)
+}
+
+func TestIncludeInBackup(t *testing.T) {
+	cus, dynamicClient := backupLabelCustodian(t)
+	require.NoError(t, cus.IncludeInBackup(backupLabelOptions()))
+
+	assert.Equal(t, []string{"arangodb-data"}, patchedNames(dynamicClient),
+		"only the labelled resource is patched")
+	_, ok := backupLabel(t, dynamicClient, backupLabelPVCGVR, "arangodb-data")
+	assert.False(t, ok, "the exclusion label is removed")
+	claim, err := dynamicClient.Resource(backupLabelPVCGVR).
+		Namespace("dev").
+		Get(context.Background(), "arangodb-data", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, "kube-arangodb", claim.GetLabels()["app.kubernetes.io/name"])
+}
+
+func TestBackupLabelDryRun(t *testing.T) {
+	cus, dynamicClient := backupLabelCustodian(t)
+	opts := backupLabelOptions()
+	opts.DryRun = true
+	require.NoError(t, cus.ExcludeFromBackup(opts))
+	require.NoError(t, cus.IncludeInBackup(opts))
+
+	assert.Empty(t, patchedNames(dynamicClient))
+	_, ok := backupLabel(t, dynamicClient, backupLabelDeploymentGVR, "arangodb")
+	assert.False(t, ok)
+	_, ok = backupLabel(t, dynamicClient, backupLabelPVCGVR, "arangodb-data")
+	assert.True(t, ok)
+}
+
+func TestExcludeFromBackupLimitsResources(t *testing.T) {
+	cus, dynamicClient := backupLabelCustodian(t)
+	opts := backupLabelOptions()
+	opts.LabelValue = "yes"
+	opts.Resources = []string{"persistentvolumeclaims"}
+	require.NoError(t, cus.ExcludeFromBackup(opts))
+
+	assert.Equal(t, []string{"arangodb-data"}, patchedNames(dynamicClient))
+	for _, action := range dynamicClient.Actions() {
+		assert.Equal(t, "persistentvolumeclaims", action.GetResource().Resource,
+			"only the given resources are listed")
+	}
+
+	cus, dynamicClient = backupLabelCustodian(t)
+	opts.Resources = []string{"arangodeployments.database.arangodb.com"}
+	require.NoError(t, cus.ExcludeFromBackup(opts))
+	assert.Equal(t, []string{"arangodb"}, patchedNames(dynamicClient))
+}
@@ -0,0 +1,499 @@
+package custodian
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// PhaseHibernated is reported for databases stopped by hibernate
+const PhaseHibernated = "Hibernated"
+
+const (
+	cnpgHealthyPhase   = "Cluster in healthy state"
+	arangoRunningPhase = "Running"
+)
+
+var (
+	cnpgClusterGVR = schema.GroupVersionResource{
+		Group:    "postgresql.cnpg.io",
+		Version:  "v1",
+		Resource: "clusters",
+	}
+	cnpgBackupGVR = schema.GroupVersionResource{
+		Group:    "postgresql.cnpg.io",
+		Version:  "v1",
+		Resource: "backups",
+	}
+	cnpgScheduledBackupGVR = schema.GroupVersionResource{
+		Group:    "postgresql.cnpg.io",
+		Version:  "v1",
+		Resource: "scheduledbackups",
+	}
+	arangoDeploymentGVR = schema.GroupVersionResource{
+		Group:    "database.arangodb.com",
+		Version:  "v1",
+		Resource: "arangodeployments",
+	}
+	redisGVR = schema.GroupVersionResource{
+		Group:    "redis.redis.opstreelabs.in",
+		Version:  "v1beta2",
+		Resource: "redis",
+	}
+)
+
+// arangoMemberGroups are the groups of status.members of an ArangoDeployment
+var arangoMemberGroups = []string{
+	"single", "agents", "dbservers", "coordinators", "syncmasters", "syncworkers",
+}
+
+// DatabaseStatus summarizes the health of a database managed by an operator,
+// Problems lists why it is unhealthy
+type DatabaseStatus struct {
+	Kind                 string     `json:"kind"`
+	Namespace            string     `json:"namespace"`
+	Name                 string     `json:"name"`
+	Phase                string     `json:"phase"`
+	Instances            int64      `json:"instances"`
+	ReadyInstances       int64      `json:"readyInstances"`
+	Primary              string     `json:"primary,omitempty"`
+	LastSuccessfulBackup *time.Time `json:"lastSuccessfulBackup,omitempty"`
+	LastFailedBackup     *time.Time `json:"lastFailedBackup,omitempty"`
+	ScheduledBackups     []string   `json:"scheduledBackups,omitempty"`
+	ContinuousArchiving  string     `json:"continuousArchiving,omitempty"`
+	PendingConditions    []string   `json:"pendingConditions,omitempty"`
+	Problems             []string   `json:"problems,omitempty"`
+	Healthy              bool       `json:"healthy"`
+}
+
+// cnpgBackups are the backups and schedules of a CNPG cluster
+type cnpgBackups struct {
+	lastSuccessful *time.Time
+	lastFailed     *time.Time
+	schedules      []string
+}
+
+// DatabaseStatuses reads the CNPG clusters with their backups, the
+// ArangoDeployments and the Redis resources of the namespaces. Resources of
+// operators that are not installed are skipped.
+func (cus *Custodian) DatabaseStatuses(
+	ctx context.Context,
+	namespaces []string,
+) ([]DatabaseStatus, error) {
+	if len(namespaces) == 0 {
+		namespaces = []string{metav1.NamespaceAll}
+	}
+	var statuses []DatabaseStatus
+	for _, namespace := range namespaces {
+		postgres, err := cus.postgresStatuses(ctx, namespace)
+		if err != nil {
+			return nil, err
+		}
+		arango, err := cus.arangoStatuses(ctx, namespace)
+		if err != nil {
+			return nil, err
+		}
+		redis, err := cus.redisStatuses(ctx, namespace)
+		if err != nil {
+			return nil, err
+		}
+		statuses = append(statuses, postgres...)
+		statuses = append(statuses, arango...)
+		statuses = append(statuses, redis...)
+	}
+	for idx := range statuses {
+		statuses[idx].Healthy = len(statuses[idx].Problems) == 0
+	}
+	sort.SliceStable(statuses, func(i, j int) bool {
+		left, right := statuses[i], statuses[j]
+		if left.Namespace != right.Namespace {
+			return left.Namespace < right.Namespace
+		}
+		if left.Kind != right.Kind {
+			return left.Kind < right.Kind
+		}
+		return left.Name < right.Name
+	})
+	return statuses, nil
+}
+
+// UnhealthyDatabases returns the databases with problems
+func UnhealthyDatabases(statuses []DatabaseStatus) []DatabaseStatus {
+	var unhealthy []DatabaseStatus
+	for _, status := range statuses {
+		if !status.Healthy {
+			unhealthy = append(unhealthy, status)
+		}
+	}
+	return unhealthy
+}
+
+// listOperatorResources lists a custom resource, nil is returned when its
+// definition is not installed
+func (cus *Custodian) listOperatorResources(
+	ctx context.Context,
+	gvr schema.GroupVersionResource,
+	namespace string,
+) ([]unstructured.Unstructured, error) {
+	list, err := cus.dynamicClient.Resource(gvr).
+		Namespace(namespace).
+		List(ctx, metav1.ListOptions{})
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			cus.logger.Debug(
+				"Resource is not installed",
+				"resource", gvr.Resource,
+				"group", gvr.Group,
+			)
+			return nil, nil
+		}
+		return nil, fmt.Errorf(
+			"failed to list %s.%s: %w",
+			gvr.Resource,
+			gvr.Group,
+			err,
+		)
+	}
+	return list.Items, nil
+}
+
+func (cus *Custodian) postgresStatuses(
+	ctx context.Context,
+	namespace string,
+) ([]DatabaseStatus, error) {
+	clusters, err := cus.listOperatorResources(ctx, cnpgClusterGVR, namespace)
+	if err != nil || len(clusters) == 0 {
+		return nil, err
+	}
+	backups, err := cus.postgresBackups(ctx, namespace)
+	if err != nil {
+		return nil, err
+	}
+	statuses := make([]DatabaseStatus, 0, len(clusters))
+	for idx := range clusters {
+		cluster := &clusters[idx]
+		key := cluster.GetNamespace() + "/" + cluster.GetName()
+		statuses = append(statuses, postgresStatus(cluster, backups[key]))
+	}
+	return statuses, nil
+}
+
+// postgresBackups collects the latest backups and the schedules by
+// namespace/cluster
+func (cus *Custodian) postgresBackups(
+	ctx context.Context,
+	namespace string,
+) (map[string]*cnpgBackups, error) {
+	backups := make(map[string]*cnpgBackups)
+	entry := func(obj *unstructured.Unstructured) *cnpgBackups {
+		cluster, _, _ := unstructured.NestedString(obj.Object, "spec", "cluster", "name")
+		key := obj.GetNamespace() + "/" + cluster
+		if _, ok := backups[key]; !ok {
+			backups[key] = &cnpgBackups{}
+		}
+		return backups[key]
+	}
+
+	items, err := cus.listOperatorResources(ctx, cnpgBackupGVR, namespace)
+	if err != nil {
+		return nil, err
+	}
+	for idx := range items {
+		backup := &items[idx]
+		finished := nestedTime(backup.Object, "status", "stoppedAt")
+		if finished == nil {
+			created := backup.GetCreationTimestamp().Time
+			finished = &created
+		}
+		phase, _, _ := unstructured.NestedString(backup.Object, "status", "phase")
+		switch phase {
+		case "completed":
+			entry(backup).lastSuccessful = latestTime(entry(backup).lastSuccessful, finished)
+		case "failed":
+			entry(backup).lastFailed = latestTime(entry(backup).lastFailed, finished)
+		}
+	}
+
+	schedules, err := cus.listOperatorResources(ctx, cnpgScheduledBackupGVR, namespace)
+	if err != nil {
+		return nil, err
+	}
+	for idx := range schedules {
+		schedule := &schedules[idx]
+		cron, _, _ := unstructured.NestedString(schedule.Object, "spec", "schedule")
+		description := fmt.Sprintf("%s (%s)", schedule.GetName(), cron)
+		if suspended, _, _ := unstructured.NestedBool(schedule.Object, "spec", "suspend"); suspended {
+			description = fmt.Sprintf("%s (suspended)", schedule.GetName())
+		}
+		entry(schedule).schedules = append(entry(schedule).schedules, description)
+	}
+	return backups, nil
+}
+
+func postgresStatus(cluster *unstructured.Unstructured, backups *cnpgBackups) DatabaseStatus {
+	obj := cluster.Object
+	status := DatabaseStatus{
+		Kind:      cluster.GetKind(),
+		Namespace: cluster.GetNamespace(),
+		Name:      cluster.GetName(),
+	}
+	status.Phase, _, _ = unstructured.NestedString(obj, "status", "phase")
+	status.Instances, _, _ = unstructured.NestedInt64(obj, "spec", "instances")
+	status.ReadyInstances, _, _ = unstructured.NestedInt64(obj, "status", "readyInstances")
+	status.Primary, _, _ = unstructured.NestedString(obj, "status", "currentPrimary")
+	status.PendingConditions = pendingConditions(cluster)
+	if backups == nil {
+		backups = &cnpgBackups{}
+	}
+	// the operator also records the latest backups in the cluster status
+	status.LastSuccessfulBackup = latestTime(
+		backups.lastSuccessful,
+		nestedTime(obj, "status", "lastSuccessfulBackup"),
+	)
+	status.LastFailedBackup = latestTime(
+		backups.lastFailed,
+		nestedTime(obj, "status", "lastFailedBackup"),
+	)
+	status.ScheduledBackups = backups.schedules
+	if archiving, ok := findCondition(cluster, "ContinuousArchiving"); ok {
+		status.ContinuousArchiving = "ok"
+		if archiving["status"] != "True" {
+			status.ContinuousArchiving = "failing"
+			status.Problems = append(status.Problems, conditionText(archiving))
+		}
+	}
+
+	if cluster.GetAnnotations()[CNPGHibernationAnnotation] == "on" {
+		status.Phase = PhaseHibernated
+		status.Problems = nil
+		return status
+	}
+	if status.Phase != cnpgHealthyPhase {
+		status.Problems = append(status.Problems, "phase: "+valueOrDash(status.Phase))
+	}
+	if status.ReadyInstances < status.Instances {
+		status.Problems = append(status.Problems, fmt.Sprintf(
+			"%d of %d instances ready",
+			status.ReadyInstances,
+			status.Instances,
+		))
+	}
+	switch {
+	case status.LastFailedBackup != nil &&
+		(status.LastSuccessfulBackup == nil ||
+			status.LastFailedBackup.After(*status.LastSuccessfulBackup)):
+		status.Problems = append(status.Problems, "last backup failed")
+	case status.LastSuccessfulBackup == nil && len(status.ScheduledBackups) > 0:
+		status.Problems = append(status.Problems, "no successful backup")
+	}
+	return status
+}
+
+func (cus *Custodian) arangoStatuses(
+	ctx context.Context,
+	namespace string,
+) ([]DatabaseStatus, error) {
+	deployments, err := cus.listOperatorResources(ctx, arangoDeploymentGVR, namespace)
+	if err != nil {
+		return nil, err
+	}
+	statuses := make([]DatabaseStatus, 0, len(deployments))
+	for idx := range deployments {
+		statuses = append(statuses, arangoStatus(&deployments[idx]))
+	}
+	return statuses, nil
+}
+
+func arangoStatus(deployment *unstructured.Unstructured) DatabaseStatus {
+	obj := deployment.Object
+	status := DatabaseStatus{
+		Kind:              deployment.GetKind(),
+		Namespace:         deployment.GetNamespace(),
+		Name:              deployment.GetName(),
+		PendingConditions: pendingConditions(deployment),
+	}
+	status.Phase, _, _ = unstructured.NestedString(obj, "status", "phase")
+	for _, group := range arangoMemberGroups {
+		members, _, _ := unstructured.NestedSlice(obj, "status", "members", group)
+		for _, raw := range members {
+			member, ok := raw.(map[string]any)
+			if !ok {
+				continue
+			}
+			status.Instances++
+			if memberReady(member) {
+				status.ReadyInstances++
+			}
+		}
+	}
+
+	if status.Phase != arangoRunningPhase {
+		status.Problems = append(status.Problems, "phase: "+valueOrDash(status.Phase))
+	}
+	if ready, ok := findCondition(deployment, "Ready"); ok && ready["status"] != "True" {
+		status.Problems = append(status.Problems, conditionText(ready))
+	}
+	if status.ReadyInstances < status.Instances {
+		status.Problems = append(status.Problems, fmt.Sprintf(
+			"%d of %d members ready",
+			status.ReadyInstances,
+			status.Instances,
+		))
+	}
+	return status
+}
+
+func memberReady(member map[string]any) bool {
+	conditions, _, _ := unstructured.NestedSlice(member, "conditions")
+	for _, raw := range conditions {
+		condition, ok := raw.(map[string]any)
+		if ok && condition["type"] == "Ready" {
+			return condition["status"] == "True"
+		}
+	}
+	return false
+}
+
+// redisStatuses reports the Redis resources through the statefulsets the
+// operator creates for them, the resources themselves carry no status
+func (cus *Custodian) redisStatuses(
+	ctx context.Context,
+	namespace string,
+) ([]DatabaseStatus, error) {
+	items, err := cus.listOperatorResources(ctx, redisGVR, namespace)
+	if err != nil {
+		return nil, err
+	}
+	statuses := make([]DatabaseStatus, 0, len(items))
+	for idx := range items {
+		redis := &items[idx]
+		status := DatabaseStatus{
+			Kind:      redis.GetKind(),
+			Namespace: redis.GetNamespace(),
+			Name:      redis.GetName(),
+		}
+		// hibernate scales the statefulset down through the resource
+		if _, ok := redis.GetAnnotations()[HibernationAnnotation]; ok {
+			status.Phase = PhaseHibernated
+			statuses = append(statuses, status)
+			continue
+		}
+		sts, err := cus.clientset.AppsV1().
+			StatefulSets(redis.GetNamespace()).
+			Get(ctx, redis.GetName(), metav1.GetOptions{})
+		switch {
+		case apierrors.IsNotFound(err):
+			status.Phase = "Pending"
+			status.Problems = append(status.Problems, "statefulset not found")
+			statuses = append(statuses, status)
+			continue
+		case err != nil:
+			return nil, fmt.Errorf(
+				"failed to get statefulset of redis %s: %w",
+				redis.GetName(),
+				err,
+			)
+		}
+		status.Instances = 1
+		if sts.Spec.Replicas != nil {
+			status.Instances = int64(*sts.Spec.Replicas)
+		}
+		status.ReadyInstances = int64(sts.Status.ReadyReplicas)
+		switch {
+		case status.Instances > 0 && status.ReadyInstances >= status.Instances:
+			status.Phase = "Ready"
+		default:
+			status.Phase = "NotReady"
+			status.Problems = append(status.Problems, fmt.Sprintf(
+				"%d of %d instances ready",
+				status.ReadyInstances,
+				status.Instances,
+			))
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses, nil
+}
+
+func findCondition(obj *unstructured.Unstructured, conditionType string) (map[string]any, bool) {
+	conditions, _, _ := unstructured.NestedSlice(obj.Object, "status", "conditions")
+	for _, raw := range conditions {
+		condition, ok := raw.(map[string]any)
+		if ok && condition["type"] == conditionType {
+			return condition, true
+		}
+	}
+	return nil, false
+}
+
+// pendingConditions lists the conditions that are not true
+func pendingConditions(obj *unstructured.Unstructured) []string {
+	conditions, _, _ := unstructured.NestedSlice(obj.Object, "status", "conditions")
+	var pending []string
+	for _, raw := range conditions {
+		condition, ok := raw.(map[string]any)
+		if !ok || condition["status"] == "True" {
+			continue
+		}
+		pending = append(pending, conditionText(condition))
+	}
+	return pending
+}
+
+func conditionText(condition map[string]any) string {
+	text := fmt.Sprintf("%v=%v", condition["type"], condition["status"])
+	for _, field := range []string{"reason", "message"} {
+		if value, ok := condition[field].(string); ok && len(value) > 0 {
+			return text + ": " + value
+		}
+	}
+	return text
+}
+
+func latestTime(times ...*time.Time) *time.Time {
+	var latest *time.Time
+	for _, value := range times {
+		if value != nil && (latest == nil || value.After(*latest)) {
+			latest = value
+		}
+	}
+	return latest
+}
+
+// WriteDatabaseStatuses writes the database statuses in the format
+func WriteDatabaseStatuses(out io.Writer, format string, statuses []DatabaseStatus) error {
+	if format == FormatJSON {
+		if statuses == nil {
+			statuses = []DatabaseStatus{}
+		}
+		return writeJSON(out, statuses)
+	}
+	rows := make([][]string, 0, len(statuses))
+	for _, status := range statuses {
+		rows = append(rows, []string{
+			status.Namespace,
+			status.Kind,
+			status.Name,
+			valueOrDash(status.Phase),
+			fmt.Sprintf("%d/%d", status.ReadyInstances, status.Instances),
+			valueOrDash(status.Primary),
+			valueOrDash(formatTime(status.LastSuccessfulBackup)),
+			valueOrDash(formatTime(status.LastFailedBackup)),
+			valueOrDash(status.ContinuousArchiving),
+			valueOrDash(strings.Join(status.PendingConditions, "; ")),
+			valueOrDash(strings.Join(status.Problems, "; ")),
+		})
+	}
+	return writeTable(out, format, []string{
+		"NAMESPACE", "KIND", "NAME", "PHASE", "READY", "PRIMARY",
+		"LAST BACKUP", "LAST FAILED", "ARCHIVING", "PENDING", "PROBLEMS",
+	}, rows)
+}
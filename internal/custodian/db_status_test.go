@@ -0,0 +1,161 @@
+package custodian
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func unstructuredObject(apiVersion, kind, name string, fields map[string]any) *unstructured.Unstructured {
+	obj := map[string]any{
+		"apiVersion": apiVersion,
+		"kind":       kind,
+		"metadata": map[string]any{
+			"name":              name,
+			"namespace":         "dictybase",
+			"creationTimestamp": "2026-10-01T00:00:00Z",
+		},
+	}
+	for key, value := range fields {
+		obj[key] = value
+	}
+	return &unstructured.Unstructured{Object: obj}
+}
+
+func condition(conditionType, status, message string) map[string]any {
+	return map[string]any{"type": conditionType, "status": status, "message": message}
+}
+
+func TestDatabaseStatuses(t *testing.T) {
+	cnpg := "postgresql.cnpg.io/v1"
+	hibernated := unstructuredObject(cnpg, "Cluster", "pg-archive", map[string]any{
+		"spec":   map[string]any{"instances": int64(1)},
+		"status": map[string]any{"phase": "Cluster in healthy state"},
+	})
+	hibernated.SetAnnotations(map[string]string{CNPGHibernationAnnotation: "on"})
+	dynamicClient := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(
+		runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{
+			cnpgClusterGVR:         "ClusterList",
+			cnpgBackupGVR:          "BackupList",
+			cnpgScheduledBackupGVR: "ScheduledBackupList",
+			arangoDeploymentGVR:    "ArangoDeploymentList",
+			redisGVR:               "RedisList",
+		},
+		unstructuredObject(cnpg, "Cluster", "pg", map[string]any{
+			"spec": map[string]any{"instances": int64(2)},
+			"status": map[string]any{
+				"phase":          "Cluster in healthy state",
+				"readyInstances": int64(2),
+				"currentPrimary": "pg-1",
+				"conditions": []any{
+					condition("Ready", "True", ""),
+					condition("ContinuousArchiving", "True", ""),
+				},
+			},
+		}),
+		unstructuredObject(cnpg, "Cluster", "pg-legacy", map[string]any{
+			"spec": map[string]any{"instances": int64(2)},
+			"status": map[string]any{
+				"phase":          "Waiting for the instances to become active",
+				"readyInstances": int64(1),
+				"currentPrimary": "pg-legacy-2",
+				"conditions": []any{
+					condition("Ready", "False", "Cluster Is Not Ready"),
+					condition("ContinuousArchiving", "False", "unexpected failure invoking barman-cloud-wal-archive"),
+				},
+			},
+		}),
+		hibernated,
+		unstructuredObject(cnpg, "Backup", "pg-20261018", map[string]any{
+			"spec":   map[string]any{"cluster": map[string]any{"name": "pg"}},
+			"status": map[string]any{"phase": "completed", "stoppedAt": "2026-10-18T02:00:00Z"},
+		}),
+		unstructuredObject(cnpg, "Backup", "pg-legacy-20261017", map[string]any{
+			"spec":   map[string]any{"cluster": map[string]any{"name": "pg-legacy"}},
+			"status": map[string]any{"phase": "completed", "stoppedAt": "2026-10-17T02:00:00Z"},
+		}),
+		unstructuredObject(cnpg, "Backup", "pg-legacy-20261018", map[string]any{
+			"spec":   map[string]any{"cluster": map[string]any{"name": "pg-legacy"}},
+			"status": map[string]any{"phase": "failed", "stoppedAt": "2026-10-18T02:00:00Z"},
+		}),
+		unstructuredObject(cnpg, "ScheduledBackup", "pg-daily", map[string]any{
+			"spec": map[string]any{"cluster": map[string]any{"name": "pg"}, "schedule": "0 0 2 * * *"},
+		}),
+		unstructuredObject("database.arangodb.com/v1", "ArangoDeployment", "arangodb", map[string]any{
+			"status": map[string]any{
+				"phase": "Running",
+				"members": map[string]any{
+					"single": []any{map[string]any{
+						"id":         "SNGL-1",
+						"conditions": []any{condition("Ready", "False", "")},
+					}},
+				},
+			},
+		}),
+	)
+	// the plural of redis can not be guessed from its kind
+	require.NoError(t, dynamicClient.Tracker().Create(
+		redisGVR,
+		unstructuredObject("redis.redis.opstreelabs.in/v1beta2", "Redis", "redis", nil),
+		"dictybase",
+	))
+	hibernatedRedis := unstructuredObject("redis.redis.opstreelabs.in/v1beta2", "Redis", "redis-cache", nil)
+	hibernatedRedis.SetAnnotations(map[string]string{HibernationAnnotation: "{}"})
+	require.NoError(t, dynamicClient.Tracker().Create(redisGVR, hibernatedRedis, "dictybase"))
+	clientset := fake.NewSimpleClientset(&appsv1.StatefulSet{
+		ObjectMeta: metav1.ObjectMeta{Name: "redis", Namespace: "dictybase"},
+		Status:     appsv1.StatefulSetStatus{ReadyReplicas: 1},
+	})
+	cus := &Custodian{
+		clientset:     clientset,
+		dynamicClient: dynamicClient,
+		logger:        testLogger(),
+	}
+
+	statuses, err := cus.DatabaseStatuses(context.Background(), []string{"dictybase"})
+	require.NoError(t, err)
+	require.Len(t, statuses, 6)
+	byName := make(map[string]DatabaseStatus)
+	for _, status := range statuses {
+		byName[status.Name] = status
+	}
+
+	pg := byName["pg"]
+	assert.True(t, pg.Healthy)
+	assert.Equal(t, "pg-1", pg.Primary)
+	assert.Equal(t, "ok", pg.ContinuousArchiving)
+	assert.Equal(t, "2026-10-18T02:00:00Z", formatTime(pg.LastSuccessfulBackup))
+	assert.Equal(t, []string{"pg-daily (0 0 2 * * *)"}, pg.ScheduledBackups)
+
+	legacy := byName["pg-legacy"]
+	assert.False(t, legacy.Healthy)
+	assert.Equal(t, "failing", legacy.ContinuousArchiving)
+	assert.Len(t, legacy.PendingConditions, 2)
+	assert.Contains(t, legacy.Problems, "1 of 2 instances ready")
+	assert.Contains(t, legacy.Problems, "last backup failed")
+
+	assert.True(t, byName["pg-archive"].Healthy)
+	assert.Equal(t, PhaseHibernated, byName["pg-archive"].Phase)
+
+	arango := byName["arangodb"]
+	assert.False(t, arango.Healthy)
+	assert.Equal(t, []string{"0 of 1 members ready"}, arango.Problems)
+
+	assert.True(t, byName["redis"].Healthy)
+	assert.Equal(t, "Ready", byName["redis"].Phase)
+
+	assert.True(t, byName["redis-cache"].Healthy, "a hibernated redis has no statefulset to check")
+	assert.Equal(t, PhaseHibernated, byName["redis-cache"].Phase)
+
+	assert.Len(t, UnhealthyDatabases(statuses), 2)
+}
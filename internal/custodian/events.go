@@ -0,0 +1,385 @@
+package custodian
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Reasons reported from the pod statuses, the kubelet does not always emit
+// an event for them
+const (
+	ReasonOOMKilled        = "OOMKilled"
+	ReasonCrashLoopBackOff = "CrashLoopBackOff"
+)
+
+// EventOptions selects the warnings and restarts to report
+type EventOptions struct {
+	// Namespaces to report, an empty list means all namespaces
+	Namespaces []string
+	// Reasons limits the warnings to these reasons, all warnings are
+	// reported when empty
+	Reasons []string
+	// Since ignores warnings and restarts older than the duration, zero
+	// keeps all of them
+	Since time.Duration
+	// MinRestarts is the restart count from which a recently restarted
+	// container is flagged
+	MinRestarts int32
+}
+
+// EventGroup is a repeated warning of an object
+type EventGroup struct {
+	Namespace string     `json:"namespace"`
+	Kind      string     `json:"kind"`
+	Name      string     `json:"name"`
+	Reason    string     `json:"reason"`
+	Count     int32      `json:"count"`
+	FirstSeen *time.Time `json:"firstSeen,omitempty"`
+	LastSeen  *time.Time `json:"lastSeen,omitempty"`
+	Message   string     `json:"message,omitempty"`
+}
+
+// RestartingContainer is a container whose restart count is climbing,
+// Increase counts the restarts since a watch started
+type RestartingContainer struct {
+	Namespace   string     `json:"namespace"`
+	Pod         string     `json:"pod"`
+	Container   string     `json:"container"`
+	Restarts    int32      `json:"restarts"`
+	Increase    int32      `json:"increase,omitempty"`
+	LastReason  string     `json:"lastReason,omitempty"`
+	LastRestart *time.Time `json:"lastRestart,omitempty"`
+	Waiting     string     `json:"waiting,omitempty"`
+}
+
+// EventReport holds the grouped warnings and the restarting containers
+type EventReport struct {
+	Time       time.Time             `json:"time"`
+	Warnings   []EventGroup          `json:"warnings"`
+	Restarting []RestartingContainer `json:"restarting"`
+}
+
+// EventReport groups the warning events of the namespaces by object and
+// reason and lists the containers that keep restarting
+func (cus *Custodian) EventReport(
+	ctx context.Context,
+	opts EventOptions,
+	now time.Time,
+) (*EventReport, error) {
+	return cus.eventReport(ctx, opts, now, nil)
+}
+
+// WatchEvents builds a report every interval until the context is done.
+// Containers restarting after the watch started are flagged with their
+// increase regardless of their restart count.
+func (cus *Custodian) WatchEvents(
+	ctx context.Context,
+	opts EventOptions,
+	interval time.Duration,
+	handle func(*EventReport) error,
+) error {
+	baseline, err := cus.restartCounts(ctx, opts.Namespaces)
+	if err != nil {
+		return err
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		report, err := cus.eventReport(ctx, opts, time.Now(), baseline)
+		if err != nil {
+			return err
+		}
+		if err := handle(report); err != nil {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+func (cus *Custodian) eventReport(
+	ctx context.Context,
+	opts EventOptions,
+	now time.Time,
+	baseline map[string]int32,
+) (*EventReport, error) {
+	namespaces := opts.Namespaces
+	if len(namespaces) == 0 {
+		namespaces = []string{metav1.NamespaceAll}
+	}
+	report := &EventReport{
+		Time:       now,
+		Warnings:   []EventGroup{},
+		Restarting: []RestartingContainer{},
+	}
+	groups := make(map[string]*EventGroup)
+	for _, namespace := range namespaces {
+		events, err := cus.clientset.CoreV1().
+			Events(namespace).
+			List(ctx, metav1.ListOptions{FieldSelector: "type=" + corev1.EventTypeWarning})
+		if err != nil {
+			return nil, fmt.Errorf("failed to list events in namespace %s: %w", namespace, err)
+		}
+		for idx := range events.Items {
+			event := &events.Items[idx]
+			if event.Type != corev1.EventTypeWarning {
+				continue
+			}
+			first, last := eventTimes(event)
+			addWarning(groups, opts, now, EventGroup{
+				Namespace: event.InvolvedObject.Namespace,
+				Kind:      event.InvolvedObject.Kind,
+				Name:      event.InvolvedObject.Name,
+				Reason:    event.Reason,
+				Count:     eventCount(event),
+				FirstSeen: first,
+				LastSeen:  last,
+				Message:   event.Message,
+			})
+		}
+
+		pods, err := cus.clientset.CoreV1().
+			Pods(namespace).
+			List(ctx, metav1.ListOptions{})
+		if err != nil {
+			return nil, fmt.Errorf("failed to list pods in namespace %s: %w", namespace, err)
+		}
+		for idx := range pods.Items {
+			pod := &pods.Items[idx]
+			statuses := append(
+				append([]corev1.ContainerStatus{}, pod.Status.InitContainerStatuses...),
+				pod.Status.ContainerStatuses...,
+			)
+			for _, status := range statuses {
+				if terminated := status.LastTerminationState.Terminated; terminated != nil &&
+					terminated.Reason == ReasonOOMKilled {
+					finished := terminated.FinishedAt.Time
+					addWarning(groups, opts, now, EventGroup{
+						Namespace: pod.Namespace,
+						Kind:      "Pod",
+						Name:      pod.Name,
+						Reason:    ReasonOOMKilled,
+						Count:     1,
+						FirstSeen: &finished,
+						LastSeen:  &finished,
+						Message:   "container " + status.Name + " was killed for running out of memory",
+					})
+				}
+				restarting, ok := restartingContainer(pod, status, opts, now, baseline)
+				if ok {
+					report.Restarting = append(report.Restarting, restarting)
+				}
+			}
+		}
+	}
+
+	for _, key := range sortedKeys(groups) {
+		report.Warnings = append(report.Warnings, *groups[key])
+	}
+	sort.SliceStable(report.Warnings, func(i, j int) bool {
+		return report.Warnings[i].Count > report.Warnings[j].Count
+	})
+	sort.SliceStable(report.Restarting, func(i, j int) bool {
+		return report.Restarting[i].Restarts > report.Restarting[j].Restarts
+	})
+	return report, nil
+}
+
+// addWarning merges the warning into the group of its object and reason
+func addWarning(
+	groups map[string]*EventGroup,
+	opts EventOptions,
+	now time.Time,
+	warning EventGroup,
+) {
+	if len(opts.Reasons) > 0 && !matchesAny(opts.Reasons, false, warning.Reason) {
+		return
+	}
+	if opts.Since > 0 && warning.LastSeen != nil &&
+		warning.LastSeen.Before(now.Add(-opts.Since)) {
+		return
+	}
+	key := fmt.Sprintf(
+		"%s/%s/%s/%s",
+		warning.Namespace,
+		warning.Kind,
+		warning.Name,
+		warning.Reason,
+	)
+	group, ok := groups[key]
+	if !ok {
+		groups[key] = &warning
+		return
+	}
+	group.Count += warning.Count
+	if warning.FirstSeen != nil &&
+		(group.FirstSeen == nil || warning.FirstSeen.Before(*group.FirstSeen)) {
+		group.FirstSeen = warning.FirstSeen
+	}
+	if warning.LastSeen != nil &&
+		(group.LastSeen == nil || warning.LastSeen.After(*group.LastSeen)) {
+		group.LastSeen = warning.LastSeen
+		group.Message = warning.Message
+	}
+}
+
+// eventTimes returns when the event was first and last seen, events from
+// the events API only fill in the event time and series
+func eventTimes(event *corev1.Event) (*time.Time, *time.Time) {
+	var first, last *time.Time
+	switch {
+	case !event.FirstTimestamp.IsZero():
+		first = &event.FirstTimestamp.Time
+	case !event.EventTime.IsZero():
+		first = &event.EventTime.Time
+	}
+	switch {
+	case event.Series != nil && !event.Series.LastObservedTime.IsZero():
+		last = &event.Series.LastObservedTime.Time
+	case !event.LastTimestamp.IsZero():
+		last = &event.LastTimestamp.Time
+	default:
+		last = first
+	}
+	return first, last
+}
+
+func eventCount(event *corev1.Event) int32 {
+	switch {
+	case event.Series != nil:
+		return event.Series.Count
+	case event.Count > 0:
+		return event.Count
+	}
+	return 1
+}
+
+// restartingContainer flags a container waiting in a crash loop, restarted
+// recently at least the minimum number of times or, while watching,
+// restarted since the first report
+func restartingContainer(
+	pod *corev1.Pod,
+	status corev1.ContainerStatus,
+	opts EventOptions,
+	now time.Time,
+	baseline map[string]int32,
+) (RestartingContainer, bool) {
+	if status.RestartCount == 0 {
+		return RestartingContainer{}, false
+	}
+	container := RestartingContainer{
+		Namespace: pod.Namespace,
+		Pod:       pod.Name,
+		Container: status.Name,
+		Restarts:  status.RestartCount,
+	}
+	if terminated := status.LastTerminationState.Terminated; terminated != nil {
+		container.LastReason = terminated.Reason
+		if !terminated.FinishedAt.IsZero() {
+			container.LastRestart = &terminated.FinishedAt.Time
+		}
+	}
+	if waiting := status.State.Waiting; waiting != nil {
+		container.Waiting = waiting.Reason
+	}
+	if previous, ok := baseline[restartKey(pod, status.Name)]; ok {
+		container.Increase = status.RestartCount - previous
+	}
+
+	recent := opts.Since == 0 ||
+		(container.LastRestart != nil && container.LastRestart.After(now.Add(-opts.Since)))
+	flagged := container.Waiting == ReasonCrashLoopBackOff ||
+		container.Increase > 0 ||
+		(status.RestartCount >= opts.MinRestarts && recent)
+	return container, flagged
+}
+
+func (cus *Custodian) restartCounts(
+	ctx context.Context,
+	namespaces []string,
+) (map[string]int32, error) {
+	if len(namespaces) == 0 {
+		namespaces = []string{metav1.NamespaceAll}
+	}
+	counts := make(map[string]int32)
+	for _, namespace := range namespaces {
+		pods, err := cus.clientset.CoreV1().
+			Pods(namespace).
+			List(ctx, metav1.ListOptions{})
+		if err != nil {
+			return nil, fmt.Errorf("failed to list pods in namespace %s: %w", namespace, err)
+		}
+		for idx := range pods.Items {
+			pod := &pods.Items[idx]
+			for _, status := range pod.Status.InitContainerStatuses {
+				counts[restartKey(pod, status.Name)] = status.RestartCount
+			}
+			for _, status := range pod.Status.ContainerStatuses {
+				counts[restartKey(pod, status.Name)] = status.RestartCount
+			}
+		}
+	}
+	return counts, nil
+}
+
+func restartKey(pod *corev1.Pod, container string) string {
+	return pod.Namespace + "/" + pod.Name + "/" + container
+}
+
+// WriteEvents writes the grouped warnings followed by the restarting
+// containers
+func WriteEvents(out io.Writer, format string, report *EventReport) error {
+	if format == FormatJSON {
+		return writeJSON(out, report)
+	}
+	warningRows := make([][]string, 0, len(report.Warnings))
+	for _, warning := range report.Warnings {
+		warningRows = append(warningRows, []string{
+			warning.Namespace,
+			warning.Kind,
+			warning.Name,
+			warning.Reason,
+			fmt.Sprintf("%d", warning.Count),
+			valueOrDash(formatTime(warning.FirstSeen)),
+			valueOrDash(formatTime(warning.LastSeen)),
+			valueOrDash(warning.Message),
+		})
+	}
+	err := writeTable(out, format, []string{
+		"NAMESPACE", "KIND", "NAME", "REASON", "COUNT", "FIRST SEEN", "LAST SEEN", "MESSAGE",
+	}, warningRows)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(out)
+
+	restartRows := make([][]string, 0, len(report.Restarting))
+	for _, container := range report.Restarting {
+		increase := "-"
+		if container.Increase > 0 {
+			increase = fmt.Sprintf("+%d", container.Increase)
+		}
+		restartRows = append(restartRows, []string{
+			container.Namespace,
+			container.Pod,
+			container.Container,
+			fmt.Sprintf("%d", container.Restarts),
+			increase,
+			valueOrDash(container.LastReason),
+			valueOrDash(formatTime(container.LastRestart)),
+			valueOrDash(container.Waiting),
+		})
+	}
+	return writeTable(out, format, []string{
+		"NAMESPACE", "POD", "CONTAINER", "RESTARTS", "INCREASE", "LAST REASON", "LAST RESTART", "WAITING",
+	}, restartRows)
+}
@@ -0,0 +1,138 @@
+package custodian
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func warningEvent(name, pod, reason string, count int32, first, last time.Time) *corev1.Event {
+	return &corev1.Event{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "dev"},
+		InvolvedObject: corev1.ObjectReference{
+			Kind:      "Pod",
+			Namespace: "dev",
+			Name:      pod,
+		},
+		Type:           corev1.EventTypeWarning,
+		Reason:         reason,
+		Message:        reason + " of " + pod,
+		Count:          count,
+		FirstTimestamp: metav1.NewTime(first),
+		LastTimestamp:  metav1.NewTime(last),
+	}
+}
+
+func TestEventReport(t *testing.T) {
+	now := time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC)
+	crashing := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "modware-annotation-7d9f", Namespace: "dev"},
+		Status: corev1.PodStatus{ContainerStatuses: []corev1.ContainerStatus{{
+			Name:         "api-server",
+			RestartCount: 12,
+			State: corev1.ContainerState{
+				Waiting: &corev1.ContainerStateWaiting{Reason: ReasonCrashLoopBackOff},
+			},
+			LastTerminationState: corev1.ContainerState{
+				Terminated: &corev1.ContainerStateTerminated{
+					Reason:     ReasonOOMKilled,
+					FinishedAt: metav1.NewTime(now.Add(-2 * time.Minute)),
+				},
+			},
+		}}},
+	}
+	settled := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "modware-stock-5c8b", Namespace: "dev"},
+		Status: corev1.PodStatus{ContainerStatuses: []corev1.ContainerStatus{{
+			Name:         "api-server",
+			RestartCount: 4,
+			LastTerminationState: corev1.ContainerState{
+				Terminated: &corev1.ContainerStateTerminated{
+					Reason:     "Error",
+					FinishedAt: metav1.NewTime(now.Add(-48 * time.Hour)),
+				},
+			},
+		}}},
+	}
+	clientset := fake.NewSimpleClientset(
+		crashing,
+		settled,
+		warningEvent("backoff-1", crashing.Name, "BackOff", 30, now.Add(-time.Hour), now.Add(-30*time.Minute)),
+		warningEvent("backoff-2", crashing.Name, "BackOff", 10, now.Add(-20*time.Minute), now.Add(-time.Minute)),
+		warningEvent("mount-1", settled.Name, "FailedMount", 2, now.Add(-3*time.Hour), now.Add(-2*time.Hour)),
+		&corev1.Event{
+			ObjectMeta:     metav1.ObjectMeta{Name: "pulled", Namespace: "dev"},
+			InvolvedObject: corev1.ObjectReference{Kind: "Pod", Namespace: "dev", Name: settled.Name},
+			Type:           corev1.EventTypeNormal,
+			Reason:         "Pulled",
+		},
+	)
+	cus := &Custodian{clientset: clientset, logger: testLogger()}
+	opts := EventOptions{Namespaces: []string{"dev"}, MinRestarts: 3}
+
+	report, err := cus.EventReport(context.Background(), opts, now)
+	require.NoError(t, err)
+	require.Len(t, report.Warnings, 3)
+	backoff := report.Warnings[0]
+	assert.Equal(t, "BackOff", backoff.Reason)
+	assert.Equal(t, int32(40), backoff.Count)
+	assert.Equal(t, now.Add(-time.Hour), *backoff.FirstSeen)
+	assert.Equal(t, now.Add(-time.Minute), *backoff.LastSeen)
+	assert.Equal(t, "BackOff of "+crashing.Name, backoff.Message)
+	assert.Equal(t, "FailedMount", report.Warnings[1].Reason)
+	assert.Equal(t, ReasonOOMKilled, report.Warnings[2].Reason)
+	assert.Len(t, report.Restarting, 2)
+
+	opts.Since = time.Hour
+	opts.Reasons = []string{"BackOff", "FailedMount", "OOMKilled"}
+	report, err = cus.EventReport(context.Background(), opts, now)
+	require.NoError(t, err)
+	assert.Len(t, report.Warnings, 2, "the failed mount is older than an hour")
+	require.Len(t, report.Restarting, 1, "the old restarts of the settled pod are not flagged")
+	assert.Equal(t, crashing.Name, report.Restarting[0].Pod)
+	assert.Equal(t, ReasonCrashLoopBackOff, report.Restarting[0].Waiting)
+}
+
+func TestWatchEventsFlagsNewRestarts(t *testing.T) {
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "modware-order-6f7a", Namespace: "dev"},
+		Status: corev1.PodStatus{ContainerStatuses: []corev1.ContainerStatus{{
+			Name:         "api-server",
+			RestartCount: 1,
+		}}},
+	}
+	clientset := fake.NewSimpleClientset(pod)
+	cus := &Custodian{clientset: clientset, logger: testLogger()}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var reports []*EventReport
+	err := cus.WatchEvents(
+		ctx,
+		EventOptions{Namespaces: []string{"dev"}, MinRestarts: 5},
+		time.Millisecond,
+		func(report *EventReport) error {
+			reports = append(reports, report)
+			if len(reports) == 1 {
+				restarted := pod.DeepCopy()
+				restarted.Status.ContainerStatuses[0].RestartCount = 3
+				_, err := clientset.CoreV1().Pods("dev").
+					Update(ctx, restarted, metav1.UpdateOptions{})
+				return err
+			}
+			cancel()
+			return nil
+		},
+	)
+	require.NoError(t, err)
+	require.Len(t, reports, 2)
+	assert.Empty(t, reports[0].Restarting)
+	require.Len(t, reports[1].Restarting, 1)
+	assert.Equal(t, int32(2), reports[1].Restarting[0].Increase)
+}
@@ -0,0 +1,296 @@
+package custodian
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/pmezard/go-difflib/difflib"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/discovery"
+	"sigs.k8s.io/yaml"
+)
+
+// RedactedValue replaces the values of exported secrets
+const RedactedValue = "REDACTED"
+
+// lastAppliedAnnotation holds a full copy of the object, including the data
+// of secrets
+const lastAppliedAnnotation = "kubectl.kubernetes.io/last-applied-configuration"
+
+// skippedExportResources change all the time and are recreated by the
+// cluster, they are not worth exporting
+var skippedExportResources = []string{"events", "endpoints", "endpointslices", "leases"}
+
+// strippedFields are set by the api server and differ between clusters
+var strippedFields = [][]string{
+	{"status"},
+	{"metadata", "managedFields"},
+	{"metadata", "resourceVersion"},
+	{"metadata", "uid"},
+	{"metadata", "creationTimestamp"},
+	{"metadata", "generation"},
+	{"metadata", "selfLink"},
+	{"metadata", "annotations", lastAppliedAnnotation},
+}
+
+// Changes found when comparing two exports
+const (
+	ExportAdded   = "added"
+	ExportRemoved = "removed"
+	ExportChanged = "changed"
+)
+
+// ExportOptions selects the namespaces to export and whether the data of
+// secrets is kept
+type ExportOptions struct {
+	Namespaces    []string
+	RevealSecrets bool
+}
+
+// ExportDiff is a manifest that differs between two exports
+type ExportDiff struct {
+	Path   string `json:"path"`
+	Change string `json:"change"`
+	Diff   string `json:"diff"`
+}
+
+// Export writes the namespaced resources as one YAML file per object to
+// dir/<namespace>/<resource>.<group>/<name>.yaml. Objects owned by another
+// object are left out as their owner recreates them. The directory must be
+// empty or missing, so that an export never keeps manifests of objects
+// deleted since an earlier one.
+func (cus *Custodian) Export(
+	ctx context.Context,
+	opts ExportOptions,
+	dir string,
+) (int, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return 0, fmt.Errorf("failed to read export directory: %w", err)
+	}
+	if len(entries) > 0 {
+		return 0, fmt.Errorf("export directory %s is not empty", dir)
+	}
+	manifests, err := cus.LiveExport(ctx, opts)
+	if err != nil {
+		return 0, err
+	}
+	for _, path := range sortedKeys(manifests) {
+		file := filepath.Join(dir, filepath.FromSlash(path))
+		if err := os.MkdirAll(filepath.Dir(file), 0o755); err != nil {
+			return 0, fmt.Errorf("failed to create export directory: %w", err)
+		}
+		if err := os.WriteFile(file, manifests[path], 0o600); err != nil {
+			return 0, fmt.Errorf("failed to write %s: %w", file, err)
+		}
+	}
+	cus.logger.Info("Exported resources", "count", len(manifests), "dir", dir)
+	return len(manifests), nil
+}
+
+// LiveExport returns the cleaned manifests of the namespaces keyed by their
+// path in an export
+func (cus *Custodian) LiveExport(
+	ctx context.Context,
+	opts ExportOptions,
+) (map[string][]byte, error) {
+	lists, err := cus.discoveryClient.ServerPreferredNamespacedResources()
+	if err != nil {
+		if !discovery.IsGroupDiscoveryFailedError(err) {
+			return nil, fmt.Errorf("failed to get server resources: %w", err)
+		}
+		cus.logger.Warn("Some API groups could not be discovered", "error", err)
+	}
+	namespaces := opts.Namespaces
+	if len(namespaces) == 0 {
+		namespaces = []string{metav1.NamespaceAll}
+	}
+	manifests := make(map[string][]byte)
+	for _, list := range lists {
+		gv, err := schema.ParseGroupVersion(list.GroupVersion)
+		if err != nil {
+			continue
+		}
+		for _, resource := range list.APIResources {
+			if strings.Contains(resource.Name, "/") ||
+				slices.Contains(skippedExportResources, resource.Name) ||
+				!cus.hasVerbs(resource, "list") {
+				continue
+			}
+			gvr := gv.WithResource(resource.Name)
+			for _, namespace := range namespaces {
+				if err := cus.exportResource(ctx, gvr, namespace, opts, manifests); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+	return manifests, nil
+}
+
+func (cus *Custodian) exportResource(
+	ctx context.Context,
+	gvr schema.GroupVersionResource,
+	namespace string,
+	opts ExportOptions,
+	manifests map[string][]byte,
+) error {
+	items, err := cus.dynamicClient.Resource(gvr).
+		Namespace(namespace).
+		List(ctx, metav1.ListOptions{})
+	if err != nil {
+		if apierrors.IsNotFound(err) || apierrors.IsMethodNotSupported(err) ||
+			apierrors.IsForbidden(err) {
+			cus.logger.Warn("Skipping resource", "resource", gvr.Resource, "error", err)
+			return nil
+		}
+		return fmt.Errorf("failed to list %s: %w", gvr.Resource, err)
+	}
+	for idx := range items.Items {
+		item := &items.Items[idx]
+		if metav1.GetControllerOf(item) != nil {
+			continue
+		}
+		cleanManifest(item, opts.RevealSecrets)
+		data, err := yaml.Marshal(item.Object)
+		if err != nil {
+			return fmt.Errorf("failed to encode %s %s: %w", gvr.Resource, item.GetName(), err)
+		}
+		manifests[exportPath(gvr, item)] = data
+	}
+	return nil
+}
+
+// cleanManifest removes the fields set by the api server and redacts the
+// values of secrets unless they are revealed
+func cleanManifest(item *unstructured.Unstructured, revealSecrets bool) {
+	for _, field := range strippedFields {
+		unstructured.RemoveNestedField(item.Object, field...)
+	}
+	if len(item.GetAnnotations()) == 0 {
+		unstructured.RemoveNestedField(item.Object, "metadata", "annotations")
+	}
+	if item.GetKind() != "Secret" || revealSecrets {
+		return
+	}
+	for _, field := range []string{"data", "stringData"} {
+		values, ok, _ := unstructured.NestedMap(item.Object, field)
+		if !ok {
+			continue
+		}
+		for key := range values {
+			values[key] = RedactedValue
+		}
+		_ = unstructured.SetNestedMap(item.Object, values, field)
+	}
+}
+
+func exportPath(gvr schema.GroupVersionResource, item *unstructured.Unstructured) string {
+	resource := gvr.Resource
+	if gvr.Group != "" {
+		resource += "." + gvr.Group
+	}
+	return strings.Join([]string{item.GetNamespace(), resource, item.GetName() + ".yaml"}, "/")
+}
+
+// ReadExport loads the manifests of an export keyed by their path
+func ReadExport(dir string) (map[string][]byte, error) {
+	manifests := make(map[string][]byte)
+	err := filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() || filepath.Ext(path) != ".yaml" {
+			return err
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		manifests[filepath.ToSlash(rel)] = data
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read export %s: %w", dir, err)
+	}
+	return manifests, nil
+}
+
+// ExportNamespaces returns the namespaces found in the export
+func ExportNamespaces(manifests map[string][]byte) []string {
+	var namespaces []string
+	for path := range manifests {
+		namespace, _, _ := strings.Cut(path, "/")
+		if !slices.Contains(namespaces, namespace) {
+			namespaces = append(namespaces, namespace)
+		}
+	}
+	slices.Sort(namespaces)
+	return namespaces
+}
+
+// DiffExports compares the manifests of two exports and returns a unified
+// diff for every manifest that was added, removed or changed
+func DiffExports(from, to map[string][]byte, fromName, toName string) ([]ExportDiff, error) {
+	paths := sortedKeys(from)
+	for path := range to {
+		if _, ok := from[path]; !ok {
+			paths = append(paths, path)
+		}
+	}
+	slices.Sort(paths)
+	var diffs []ExportDiff
+	for _, path := range paths {
+		before, inFrom := from[path]
+		after, inTo := to[path]
+		change := ExportChanged
+		switch {
+		case !inFrom:
+			change = ExportAdded
+		case !inTo:
+			change = ExportRemoved
+		case string(before) == string(after):
+			continue
+		}
+		text, err := difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+			A:        difflib.SplitLines(string(before)),
+			B:        difflib.SplitLines(string(after)),
+			FromFile: fromName + "/" + path,
+			ToFile:   toName + "/" + path,
+			Context:  3,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to diff %s: %w", path, err)
+		}
+		diffs = append(diffs, ExportDiff{Path: path, Change: change, Diff: text})
+	}
+	return diffs, nil
+}
+
+// WriteExportDiffs renders the diffs as unified diffs, or as a list for the
+// json format
+func WriteExportDiffs(out io.Writer, format string, diffs []ExportDiff) error {
+	if format == FormatJSON {
+		if diffs == nil {
+			diffs = []ExportDiff{}
+		}
+		return writeJSON(out, diffs)
+	}
+	for _, diff := range diffs {
+		if _, err := fmt.Fprint(out, diff.Diff); err != nil {
+			return fmt.Errorf("failed to write diff: %w", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,121 @@
+package custodian
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	fakediscovery "k8s.io/client-go/discovery/fake"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestExportAndDiff(t *testing.T) {
+	secretsGVR := schema.GroupVersionResource{Version: "v1", Resource: "secrets"}
+	configMapsGVR := schema.GroupVersionResource{Version: "v1", Resource: "configmaps"}
+	configMap := &unstructured.Unstructured{Object: map[string]any{
+		"apiVersion": "v1",
+		"kind":       "ConfigMap",
+		"metadata": map[string]any{
+			"name":            "log-to",
+			"namespace":       "dev",
+			"uid":             "1234",
+			"resourceVersion": "42",
+			"managedFields":   []any{map[string]any{"manager": "pulumi"}},
+		},
+		"data": map[string]any{"level": "info"},
+	}}
+	owned := &unstructured.Unstructured{Object: map[string]any{
+		"apiVersion": "v1",
+		"kind":       "ConfigMap",
+		"metadata": map[string]any{
+			"name":      "pg-ca",
+			"namespace": "dev",
+			"ownerReferences": []any{map[string]any{
+				"apiVersion": "postgresql.cnpg.io/v1",
+				"kind":       "Cluster",
+				"name":       "pg",
+				"uid":        "5678",
+				"controller": true,
+			}},
+		},
+	}}
+	secret := &unstructured.Unstructured{Object: map[string]any{
+		"apiVersion": "v1",
+		"kind":       "Secret",
+		"metadata": map[string]any{
+			"name":        "arangodb-secret",
+			"namespace":   "dev",
+			"annotations": map[string]any{lastAppliedAnnotation: `{"data":{}}`},
+		},
+		"data": map[string]any{"password": "c2VjcmV0"},
+	}}
+	dynamicClient := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(
+		runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{
+			secretsGVR:    "SecretList",
+			configMapsGVR: "ConfigMapList",
+		},
+		configMap, owned, secret,
+	)
+	discoveryClient := fake.NewSimpleClientset().Discovery().(*fakediscovery.FakeDiscovery)
+	discoveryClient.Resources = []*metav1.APIResourceList{{
+		GroupVersion: "v1",
+		APIResources: []metav1.APIResource{
+			{Name: "secrets", Namespaced: true, Kind: "Secret", Verbs: []string{"list"}},
+			{Name: "configmaps", Namespaced: true, Kind: "ConfigMap", Verbs: []string{"list"}},
+			{Name: "events", Namespaced: true, Kind: "Event", Verbs: []string{"list"}},
+		},
+	}}
+	cus := &Custodian{
+		dynamicClient:   dynamicClient,
+		discoveryClient: preferredDiscovery{discoveryClient},
+		logger:          testLogger(),
+	}
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	count, err := cus.Export(ctx, ExportOptions{Namespaces: []string{"dev"}}, dir)
+	require.NoError(t, err)
+	assert.Equal(t, 2, count)
+	data, err := os.ReadFile(filepath.Join(dir, "dev", "secrets", "arangodb-secret.yaml"))
+	require.NoError(t, err)
+	assert.Contains(t, string(data), "password: "+RedactedValue)
+	assert.NotContains(t, string(data), lastAppliedAnnotation)
+	data, err = os.ReadFile(filepath.Join(dir, "dev", "configmaps", "log-to.yaml"))
+	require.NoError(t, err)
+	assert.NotContains(t, string(data), "resourceVersion")
+	assert.NotContains(t, string(data), "managedFields")
+
+	exported, err := ReadExport(dir)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"dev"}, ExportNamespaces(exported))
+
+	configMap.Object["data"] = map[string]any{"level": "debug"}
+	_, err = dynamicClient.Resource(configMapsGVR).
+		Namespace("dev").
+		Update(ctx, configMap, metav1.UpdateOptions{})
+	require.NoError(t, err)
+	require.NoError(t, dynamicClient.Resource(secretsGVR).
+		Namespace("dev").
+		Delete(ctx, "arangodb-secret", metav1.DeleteOptions{}))
+	live, err := cus.LiveExport(ctx, ExportOptions{Namespaces: []string{"dev"}})
+	require.NoError(t, err)
+
+	diffs, err := DiffExports(exported, live, "export", "live")
+	require.NoError(t, err)
+	require.Len(t, diffs, 2)
+	assert.Equal(t, ExportChanged, diffs[0].Change)
+	assert.Contains(t, diffs[0].Diff, "-  level: info\n+  level: debug")
+	assert.Equal(t, ExportRemoved, diffs[1].Change)
+
+	_, err = cus.Export(ctx, ExportOptions{Namespaces: []string{"dev"}}, dir)
+	assert.ErrorContains(t, err, "is not empty", "stale manifests would be kept")
+}
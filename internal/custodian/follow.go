@@ -0,0 +1,343 @@
+package custodian
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/tools/cache"
+)
+
+const (
+	// streamDrainTimeout bounds the wait for log streams once all jobs ended
+	streamDrainTimeout = 30 * time.Second
+	colorReset         = "\033[0m"
+	maxLogLineSize     = 1024 * 1024
+)
+
+var streamColors = []string{
+	"\033[36m", // cyan
+	"\033[33m", // yellow
+	"\033[32m", // green
+	"\033[35m", // magenta
+	"\033[34m", // blue
+	"\033[31m", // red
+}
+
+// jobFollower tracks the jobs matching the label, attaches to each of their
+// containers as it starts and multiplexes the log lines to a single writer
+type jobFollower struct {
+	ctx      context.Context
+	cus      *Custodian
+	out      io.Writer
+	podStore cache.Store
+	mu       sync.Mutex
+	jobs     map[string]*batchv1.Job
+	// synced is set once the handler has seen the initial list of jobs,
+	// completion is not evaluated before that
+	synced bool
+	// deleted jobs are gone, they end the follow whatever their state
+	deleted  map[string]bool
+	attached map[string]bool
+	streams  int
+	wg       sync.WaitGroup
+	done     chan struct{}
+	doneOnce sync.Once
+}
+
+// FollowJobLogs streams the logs of the jobs matching the label until every
+// one of them has completed, failed or was deleted. It returns an error if no
+// job matches, or if any job failed or was deleted before it finished.
+func (cus *Custodian) FollowJobLogs(ctx context.Context, out io.Writer) error {
+	return cus.followJobs(ctx, cus.label, out)
+}
+
+// followJobs streams the logs of the jobs matching the selector until all
+// of them have finished
+func (cus *Custodian) followJobs(
+	ctx context.Context,
+	selector string,
+	out io.Writer,
+) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	flw := &jobFollower{
+		ctx:      ctx,
+		cus:      cus,
+		out:      out,
+		jobs:     make(map[string]*batchv1.Job),
+		deleted:  make(map[string]bool),
+		attached: make(map[string]bool),
+		done:     make(chan struct{}),
+	}
+
+	jobFactory := informers.NewSharedInformerFactoryWithOptions(
+		cus.clientset,
+		0,
+		informers.WithNamespace(cus.namespace),
+		informers.WithTweakListOptions(func(opts *metav1.ListOptions) {
+			opts.LabelSelector = selector
+		}),
+	)
+	podFactory := informers.NewSharedInformerFactoryWithOptions(
+		cus.clientset,
+		0,
+		informers.WithNamespace(cus.namespace),
+		informers.WithTweakListOptions(func(opts *metav1.ListOptions) {
+			opts.LabelSelector = "job-name"
+		}),
+	)
+	podInformer := podFactory.Core().V1().Pods().Informer()
+	flw.podStore = podInformer.GetStore()
+	if _, err := podInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    flw.onPod,
+		UpdateFunc: func(_, obj any) { flw.onPod(obj) },
+	}); err != nil {
+		return fmt.Errorf("failed to watch pods: %w", err)
+	}
+	jobInformer := jobFactory.Batch().V1().Jobs().Informer()
+	jobHandler, err := jobInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    flw.onJob,
+		UpdateFunc: func(_, obj any) { flw.onJob(obj) },
+		DeleteFunc: flw.onJobDeleted,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to watch jobs: %w", err)
+	}
+
+	podFactory.Start(ctx.Done())
+	jobFactory.Start(ctx.Done())
+	defer func() {
+		cancel()
+		podFactory.Shutdown()
+		jobFactory.Shutdown()
+	}()
+	podFactory.WaitForCacheSync(ctx.Done())
+	jobFactory.WaitForCacheSync(ctx.Done())
+	if cache.WaitForCacheSync(ctx.Done(), jobHandler.HasSynced) {
+		if flw.markSynced() == 0 {
+			return fmt.Errorf(
+				"no jobs match %s in namespace %s",
+				selector,
+				cus.namespace,
+			)
+		}
+	}
+
+	cus.logger.Info(
+		"Waiting for jobs",
+		"label", selector,
+		"namespace", cus.namespace,
+	)
+	select {
+	case <-flw.done:
+	case <-ctx.Done():
+		flw.wg.Wait()
+		return ctx.Err()
+	}
+	flw.waitForStreams()
+	return flw.result()
+}
+
+func (flw *jobFollower) onJob(obj any) {
+	job, ok := obj.(*batchv1.Job)
+	if !ok {
+		return
+	}
+	flw.mu.Lock()
+	_, known := flw.jobs[job.Name]
+	flw.jobs[job.Name] = job
+	allFinished := flw.synced && flw.allJobsFinished()
+	flw.mu.Unlock()
+
+	if !known {
+		flw.cus.logger.Info("Following job", "name", job.Name)
+		// pods seen before their job was known are picked up here
+		for _, item := range flw.podStore.List() {
+			flw.onPod(item)
+		}
+	}
+	if finished, failed := jobFinished(job); finished {
+		flw.cus.logger.Info("Job finished", "name", job.Name, "failed", failed)
+	}
+	if allFinished {
+		flw.doneOnce.Do(func() { close(flw.done) })
+	}
+}
+
+// onJobDeleted ends the follow of a job that is gone, e.g. removed by its
+// ttlSecondsAfterFinished before the last update was seen
+func (flw *jobFollower) onJobDeleted(obj any) {
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+	job, ok := obj.(*batchv1.Job)
+	if !ok {
+		return
+	}
+	flw.mu.Lock()
+	flw.jobs[job.Name] = job
+	flw.deleted[job.Name] = true
+	allFinished := flw.synced && flw.allJobsFinished()
+	flw.mu.Unlock()
+
+	flw.cus.logger.Info("Job deleted", "name", job.Name)
+	if allFinished {
+		flw.doneOnce.Do(func() { close(flw.done) })
+	}
+}
+
+// markSynced evaluates completion once the initial list of jobs was
+// handled, a finished job listed before a running one must not end the
+// follow. It returns the number of listed jobs.
+func (flw *jobFollower) markSynced() int {
+	flw.mu.Lock()
+	flw.synced = true
+	count := len(flw.jobs)
+	allFinished := flw.allJobsFinished()
+	flw.mu.Unlock()
+	if allFinished {
+		flw.doneOnce.Do(func() { close(flw.done) })
+	}
+	return count
+}
+
+func (flw *jobFollower) onPod(obj any) {
+	pod, ok := obj.(*corev1.Pod)
+	if !ok {
+		return
+	}
+	flw.mu.Lock()
+	defer flw.mu.Unlock()
+	if _, ok := flw.jobs[pod.Labels["job-name"]]; !ok {
+		return
+	}
+
+	for _, status := range podContainerStatuses(pod) {
+		if status.State.Running == nil && status.State.Terminated == nil {
+			continue
+		}
+		key := fmt.Sprintf("%s/%s/%d", pod.Name, status.Name, status.RestartCount)
+		if flw.attached[key] {
+			continue
+		}
+		flw.attached[key] = true
+		color := streamColors[flw.streams%len(streamColors)]
+		flw.streams++
+		flw.wg.Add(1)
+		go flw.stream(pod.DeepCopy(), status.Name, color)
+	}
+}
+
+func (flw *jobFollower) stream(pod *corev1.Pod, container, color string) {
+	defer flw.wg.Done()
+	opts := flw.cus.podLogOptions(container, false)
+	opts.Follow = true
+	podLogs, err := flw.cus.clientset.CoreV1().
+		Pods(pod.Namespace).
+		GetLogs(pod.Name, opts).
+		Stream(flw.ctx)
+	if err != nil {
+		flw.cus.logger.Error(
+			"Error in opening stream",
+			"pod", pod.Name,
+			"container", container,
+			"error", err,
+		)
+		return
+	}
+	defer podLogs.Close()
+
+	prefix := fmt.Sprintf("[%s/%s]", pod.Name, container)
+	if !flw.cus.logOptions.NoColor {
+		prefix = color + prefix + colorReset
+	}
+	scanner := bufio.NewScanner(podLogs)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxLogLineSize)
+	for scanner.Scan() {
+		flw.writeLine(prefix, scanner.Text())
+	}
+	if err := scanner.Err(); err != nil && flw.ctx.Err() == nil {
+		flw.cus.logger.Error(
+			"Error reading log stream",
+			"pod", pod.Name,
+			"container", container,
+			"error", err,
+		)
+	}
+}
+
+func (flw *jobFollower) writeLine(prefix, line string) {
+	flw.mu.Lock()
+	defer flw.mu.Unlock()
+	fmt.Fprintf(flw.out, "%s %s\n", prefix, line)
+}
+
+// waitForStreams gives the open streams time to deliver the last lines of
+// the finished containers
+func (flw *jobFollower) waitForStreams() {
+	drained := make(chan struct{})
+	go func() {
+		flw.wg.Wait()
+		close(drained)
+	}()
+	select {
+	case <-drained:
+	case <-time.After(streamDrainTimeout):
+		flw.cus.logger.Warn("Timed out waiting for log streams to finish")
+	}
+}
+
+func (flw *jobFollower) allJobsFinished() bool {
+	if len(flw.jobs) == 0 {
+		return false
+	}
+	for name, job := range flw.jobs {
+		if finished, _ := jobFinished(job); !finished && !flw.deleted[name] {
+			return false
+		}
+	}
+	return true
+}
+
+func (flw *jobFollower) result() error {
+	flw.mu.Lock()
+	defer flw.mu.Unlock()
+	var errs []error
+	for name, job := range flw.jobs {
+		finished, failed := jobFinished(job)
+		switch {
+		case failed:
+			errs = append(errs, fmt.Errorf("job %s failed", name))
+		case !finished && flw.deleted[name]:
+			errs = append(errs, fmt.Errorf("job %s was deleted before it finished", name))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// jobFinished reports whether the job reached a terminal condition and
+// whether that condition is a failure
+func jobFinished(job *batchv1.Job) (bool, bool) {
+	for _, cond := range job.Status.Conditions {
+		if cond.Status != corev1.ConditionTrue {
+			continue
+		}
+		switch cond.Type {
+		case batchv1.JobComplete:
+			return true, false
+		case batchv1.JobFailed:
+			return true, true
+		}
+	}
+	return false, false
+}
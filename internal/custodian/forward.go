@@ -0,0 +1,512 @@
+package custodian
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"golang.org/x/time/rate"
+	"google.golang.org/protobuf/encoding/protowire"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// DefaultAlertSubject is the subject of the order events, the gh-issue and
+// send-email consumers of event-messenger handle the alerts published to it
+const DefaultAlertSubject = "OrderService.Create"
+
+// DefaultAlertStateConfigMap keeps the published alerts of forward
+const DefaultAlertStateConfigMap = "custodian-forward-state"
+
+// Field numbers of the dictybase.order Order and OrderAttributes messages
+// the consumers of the order subject decode, an alert is sent as an order
+// with the alert as its comments
+const (
+	orderFieldType            = 1
+	orderFieldID              = 2
+	orderFieldAttributes      = 3
+	orderAttrFieldComments    = 3
+	orderAttrFieldConsumer    = 7
+	orderAttrFieldPayer       = 8
+	orderAttrFieldPurchaser   = 9
+	orderAttrFieldCreatedAt   = 11
+	orderAttrFieldUpdatedAt   = 12
+	timestampFieldSeconds     = 1
+	timestampFieldNanoseconds = 2
+)
+
+// Sources of the forwarded alerts
+const (
+	AlertSourceEvent    = "event"
+	AlertSourceJob      = "job"
+	AlertSourceDatabase = "database"
+)
+
+// Publisher publishes a message to a subject, it is satisfied by a NATS
+// connection
+type Publisher interface {
+	Publish(subject string, data []byte) error
+}
+
+// ForwardOptions controls what is forwarded and how often
+type ForwardOptions struct {
+	// Namespaces to watch, an empty list means all namespaces
+	Namespaces []string
+	// Reasons limits the warning events to these reasons
+	Reasons []string
+	Subject string
+	// Recipient is the email address the alert orders are made out to, the
+	// email consumer sends them there
+	Recipient string
+	// StateConfigMap keeps the published alerts in the StateNamespace so
+	// that a restart does not publish them again, the state is only kept in
+	// memory when empty
+	StateConfigMap string
+	StateNamespace string
+	// Cluster names the cluster in the alerts
+	Cluster  string
+	Interval time.Duration
+	// Since ignores warnings and failed jobs older than the duration
+	Since time.Duration
+	// RepeatAfter is the minimum time before an alert whose state changed
+	// is published again
+	RepeatAfter time.Duration
+	// MaxPerMinute limits the published alerts, the others are retried on
+	// the next interval
+	MaxPerMinute int
+}
+
+// Alert is a problem of the cluster, it is published as an order whose
+// comments are the Title and Body, the other fields identify the problem.
+type Alert struct {
+	Fingerprint string
+	Source      string
+	Cluster     string
+	Namespace   string
+	Kind        string
+	Name        string
+	Reason      string
+	Count       int32
+	FirstSeen   *time.Time
+	LastSeen    *time.Time
+	Title       string
+	Body        string
+	// state changes when the problem changes, e.g. the count of an event
+	state string
+}
+
+// sentAlert records when an alert was published and its state at the time
+type sentAlert struct {
+	At    time.Time `json:"at"`
+	State string    `json:"state"`
+}
+
+// alertForwarder deduplicates and rate limits the alerts
+type alertForwarder struct {
+	publisher Publisher
+	opts      ForwardOptions
+	limiter   *rate.Limiter
+	sent      map[string]sentAlert
+	// state persists sent, it is nil when the state is kept in memory
+	state *alertState
+}
+
+// alertState keeps the published alerts in a config map, one key per
+// fingerprint, so that a restarted or newly elected forwarder does not
+// publish them again
+type alertState struct {
+	cus       *Custodian
+	namespace string
+	name      string
+}
+
+// ForwardAlerts publishes an alert for the warning events, failed jobs and
+// unhealthy databases every interval until the context is done. An alert is
+// published again only when its state changes and RepeatAfter has passed,
+// alerts of problems that went away are forgotten.
+func (cus *Custodian) ForwardAlerts(
+	ctx context.Context,
+	publisher Publisher,
+	opts ForwardOptions,
+) error {
+	forwarder := newAlertForwarder(publisher, opts)
+	if len(opts.StateConfigMap) > 0 {
+		state := &alertState{cus: cus, namespace: opts.StateNamespace, name: opts.StateConfigMap}
+		if err := forwarder.restore(ctx, state); err != nil {
+			return err
+		}
+	}
+	ticker := time.NewTicker(opts.Interval)
+	defer ticker.Stop()
+	for {
+		now := time.Now()
+		alerts, err := cus.collectAlerts(ctx, opts, now)
+		if err != nil {
+			cus.logger.Error("Failed to collect alerts", "error", err)
+		} else {
+			published, skipped, err := forwarder.forward(ctx, alerts, now)
+			if err != nil {
+				return err
+			}
+			if skipped > 0 {
+				cus.logger.Warn("Alert rate limit reached", "skipped", skipped)
+			}
+			cus.logger.Debug(
+				"Forwarded alerts",
+				"alerts", len(alerts),
+				"published", published,
+			)
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+func newAlertForwarder(publisher Publisher, opts ForwardOptions) *alertForwarder {
+	limit := rate.Inf
+	if opts.MaxPerMinute > 0 {
+		limit = rate.Every(time.Minute / time.Duration(opts.MaxPerMinute))
+	}
+	return &alertForwarder{
+		publisher: publisher,
+		opts:      opts,
+		limiter:   rate.NewLimiter(limit, max(opts.MaxPerMinute, 1)),
+		sent:      make(map[string]sentAlert),
+	}
+}
+
+// restore reads the alerts published before a restart and persists the
+// published alerts in the state from now on
+func (fwd *alertForwarder) restore(ctx context.Context, state *alertState) error {
+	sent, err := state.load(ctx)
+	if err != nil {
+		return err
+	}
+	fwd.sent = sent
+	fwd.state = state
+	return nil
+}
+
+// forward publishes the new and changed alerts, it returns the number of
+// published alerts and of alerts held back by the rate limit
+func (fwd *alertForwarder) forward(
+	ctx context.Context,
+	alerts []Alert,
+	now time.Time,
+) (int, int, error) {
+	current := make(map[string]bool, len(alerts))
+	var published, skipped int
+	changed := false
+	for _, alert := range alerts {
+		current[alert.Fingerprint] = true
+		previous, ok := fwd.sent[alert.Fingerprint]
+		if ok && (previous.State == alert.state ||
+			now.Sub(previous.At) < fwd.opts.RepeatAfter) {
+			continue
+		}
+		if !fwd.limiter.AllowN(now, 1) {
+			skipped++
+			continue
+		}
+		data := encodeAlertOrder(alert, fwd.opts.Recipient, now)
+		if err := fwd.publisher.Publish(fwd.opts.Subject, data); err != nil {
+			return published, skipped, fmt.Errorf(
+				"failed to publish alert to %s: %w",
+				fwd.opts.Subject,
+				err,
+			)
+		}
+		fwd.sent[alert.Fingerprint] = sentAlert{At: now, State: alert.state}
+		published++
+		changed = true
+	}
+	for fingerprint := range fwd.sent {
+		if !current[fingerprint] {
+			delete(fwd.sent, fingerprint)
+			changed = true
+		}
+	}
+	if changed && fwd.state != nil {
+		if err := fwd.state.save(ctx, fwd.sent); err != nil {
+			return published, skipped, err
+		}
+	}
+	return published, skipped, nil
+}
+
+// load returns the published alerts of the config map, none if it does not
+// exist yet
+func (st *alertState) load(ctx context.Context) (map[string]sentAlert, error) {
+	sent := make(map[string]sentAlert)
+	configMap, err := st.cus.clientset.CoreV1().
+		ConfigMaps(st.namespace).
+		Get(ctx, st.name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return sent, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get alert state %s/%s: %w", st.namespace, st.name, err)
+	}
+	for fingerprint, value := range configMap.Data {
+		var alert sentAlert
+		if err := json.Unmarshal([]byte(value), &alert); err != nil {
+			return nil, fmt.Errorf("failed to decode alert state of %s: %w", fingerprint, err)
+		}
+		sent[fingerprint] = alert
+	}
+	return sent, nil
+}
+
+// save replaces the published alerts of the config map
+func (st *alertState) save(ctx context.Context, sent map[string]sentAlert) error {
+	data := make(map[string]string, len(sent))
+	for fingerprint, alert := range sent {
+		value, err := json.Marshal(alert)
+		if err != nil {
+			return fmt.Errorf("failed to encode alert state of %s: %w", fingerprint, err)
+		}
+		data[fingerprint] = string(value)
+	}
+	configMap := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: st.name, Namespace: st.namespace},
+		Data:       data,
+	}
+	configMaps := st.cus.clientset.CoreV1().ConfigMaps(st.namespace)
+	_, err := configMaps.Update(ctx, configMap, metav1.UpdateOptions{})
+	if apierrors.IsNotFound(err) {
+		_, err = configMaps.Create(ctx, configMap, metav1.CreateOptions{})
+	}
+	if err != nil {
+		return fmt.Errorf("failed to save alert state %s/%s: %w", st.namespace, st.name, err)
+	}
+	return nil
+}
+
+// encodeAlertOrder writes the alert in the protobuf wire format of an order,
+// the fingerprint is its id and the title and body its comments. The
+// consumers of the order subject open an issue for it and mail it to the
+// recipient.
+func encodeAlertOrder(alert Alert, recipient string, now time.Time) []byte {
+	var attrs []byte
+	attrs = appendStringField(attrs, orderAttrFieldComments, alert.Title+"\n\n"+alert.Body)
+	for _, field := range []protowire.Number{
+		orderAttrFieldConsumer,
+		orderAttrFieldPayer,
+		orderAttrFieldPurchaser,
+	} {
+		attrs = appendStringField(attrs, field, recipient)
+	}
+	var timestamp []byte
+	timestamp = protowire.AppendTag(timestamp, timestampFieldSeconds, protowire.VarintType)
+	timestamp = protowire.AppendVarint(timestamp, uint64(now.Unix()))
+	timestamp = protowire.AppendTag(timestamp, timestampFieldNanoseconds, protowire.VarintType)
+	timestamp = protowire.AppendVarint(timestamp, uint64(now.Nanosecond()))
+	for _, field := range []protowire.Number{orderAttrFieldCreatedAt, orderAttrFieldUpdatedAt} {
+		attrs = protowire.AppendTag(attrs, field, protowire.BytesType)
+		attrs = protowire.AppendBytes(attrs, timestamp)
+	}
+
+	var order []byte
+	order = appendStringField(order, orderFieldType, "order")
+	order = appendStringField(order, orderFieldID, alert.Fingerprint)
+	order = protowire.AppendTag(order, orderFieldAttributes, protowire.BytesType)
+	return protowire.AppendBytes(order, attrs)
+}
+
+// appendStringField leaves out empty strings like proto3 does
+func appendStringField(buf []byte, field protowire.Number, value string) []byte {
+	if len(value) == 0 {
+		return buf
+	}
+	buf = protowire.AppendTag(buf, field, protowire.BytesType)
+	return protowire.AppendString(buf, value)
+}
+
+// collectAlerts gathers the alerts of the warning events, failed jobs and
+// unhealthy databases
+func (cus *Custodian) collectAlerts(
+	ctx context.Context,
+	opts ForwardOptions,
+	now time.Time,
+) ([]Alert, error) {
+	report, err := cus.EventReport(ctx, EventOptions{
+		Namespaces: opts.Namespaces,
+		Reasons:    opts.Reasons,
+		Since:      opts.Since,
+	}, now)
+	if err != nil {
+		return nil, err
+	}
+	var alerts []Alert
+	for _, warning := range report.Warnings {
+		alerts = append(alerts, eventAlert(opts.Cluster, warning))
+	}
+
+	jobs, err := cus.failedJobAlerts(ctx, opts, now)
+	if err != nil {
+		return nil, err
+	}
+	alerts = append(alerts, jobs...)
+
+	statuses, err := cus.DatabaseStatuses(ctx, opts.Namespaces)
+	if err != nil {
+		return nil, err
+	}
+	for _, status := range UnhealthyDatabases(statuses) {
+		alerts = append(alerts, databaseAlert(opts.Cluster, status))
+	}
+	return alerts, nil
+}
+
+func (cus *Custodian) failedJobAlerts(
+	ctx context.Context,
+	opts ForwardOptions,
+	now time.Time,
+) ([]Alert, error) {
+	namespaces := opts.Namespaces
+	if len(namespaces) == 0 {
+		namespaces = []string{metav1.NamespaceAll}
+	}
+	var alerts []Alert
+	for _, namespace := range namespaces {
+		jobs, err := cus.clientset.BatchV1().
+			Jobs(namespace).
+			List(ctx, metav1.ListOptions{})
+		if err != nil {
+			return nil, fmt.Errorf("failed to list jobs in namespace %s: %w", namespace, err)
+		}
+		for idx := range jobs.Items {
+			job := &jobs.Items[idx]
+			cond := jobCondition(job, batchv1.JobFailed)
+			if cond == nil {
+				continue
+			}
+			failed := cond.LastTransitionTime.Time
+			if opts.Since > 0 && failed.Before(now.Add(-opts.Since)) {
+				continue
+			}
+			alerts = append(alerts, jobAlert(opts.Cluster, job, cond, failed))
+		}
+	}
+	return alerts, nil
+}
+
+func eventAlert(cluster string, warning EventGroup) Alert {
+	alert := newAlert(
+		AlertSourceEvent,
+		cluster,
+		warning.Namespace,
+		warning.Kind,
+		warning.Name,
+		warning.Reason,
+	)
+	alert.Count = warning.Count
+	alert.FirstSeen = warning.FirstSeen
+	alert.LastSeen = warning.LastSeen
+	alert.Title = alertTitle(alert, warning.Reason)
+	alert.Body = alertBody(alert, warning.Message, [][2]string{
+		{"Count", fmt.Sprintf("%d", warning.Count)},
+		{"First seen", valueOrDash(formatTime(warning.FirstSeen))},
+		{"Last seen", valueOrDash(formatTime(warning.LastSeen))},
+	})
+	alert.state = fmt.Sprintf("%d", warning.Count)
+	return alert
+}
+
+func jobAlert(
+	cluster string,
+	job *batchv1.Job,
+	cond *batchv1.JobCondition,
+	failed time.Time,
+) Alert {
+	alert := newAlert(AlertSourceJob, cluster, job.Namespace, "Job", job.Name, cond.Reason)
+	alert.FirstSeen = &failed
+	alert.LastSeen = &failed
+	alert.Title = alertTitle(alert, "failed")
+	alert.Body = alertBody(alert, cond.Message, [][2]string{
+		{"CronJob", valueOrDash(ownerName(job.OwnerReferences, "CronJob"))},
+		{"Retries", fmt.Sprintf("%d", job.Status.Failed)},
+		{"Failed", formatTime(&failed)},
+	})
+	alert.state = cond.Reason
+	return alert
+}
+
+func databaseAlert(cluster string, status DatabaseStatus) Alert {
+	alert := newAlert(
+		AlertSourceDatabase,
+		cluster,
+		status.Namespace,
+		status.Kind,
+		status.Name,
+		"Unhealthy",
+	)
+	problems := strings.Join(status.Problems, "; ")
+	alert.Title = alertTitle(alert, "unhealthy: "+problems)
+	alert.Body = alertBody(alert, problems, [][2]string{
+		{"Phase", valueOrDash(status.Phase)},
+		{"Ready", fmt.Sprintf("%d/%d", status.ReadyInstances, status.Instances)},
+		{"Primary", valueOrDash(status.Primary)},
+		{"Last backup", valueOrDash(formatTime(status.LastSuccessfulBackup))},
+		{"Last failed backup", valueOrDash(formatTime(status.LastFailedBackup))},
+		{"Pending conditions", valueOrDash(strings.Join(status.PendingConditions, "; "))},
+	})
+	alert.state = problems
+	return alert
+}
+
+// newAlert fills in the identity of an alert, the fingerprint is the same
+// for every occurrence of the problem
+func newAlert(source, cluster, namespace, kind, name, reason string) Alert {
+	sum := sha256.Sum256([]byte(strings.Join(
+		[]string{source, cluster, namespace, kind, name, reason},
+		"/",
+	)))
+	return Alert{
+		Fingerprint: hex.EncodeToString(sum[:8]),
+		Source:      source,
+		Cluster:     cluster,
+		Namespace:   namespace,
+		Kind:        kind,
+		Name:        name,
+		Reason:      reason,
+	}
+}
+
+func alertTitle(alert Alert, summary string) string {
+	title := fmt.Sprintf("%s %s/%s %s", alert.Kind, alert.Namespace, alert.Name, summary)
+	if len(alert.Cluster) > 0 {
+		title = "[" + alert.Cluster + "] " + title
+	}
+	return title
+}
+
+// alertBody renders the alert as markdown, the format both a github issue
+// and an email can show
+func alertBody(alert Alert, message string, details [][2]string) string {
+	var body strings.Builder
+	if len(message) > 0 {
+		fmt.Fprintf(&body, "%s\n\n", message)
+	}
+	rows := [][2]string{
+		{"Cluster", valueOrDash(alert.Cluster)},
+		{"Namespace", valueOrDash(alert.Namespace)},
+		{"Object", alert.Kind + "/" + alert.Name},
+		{"Reason", valueOrDash(alert.Reason)},
+	}
+	body.WriteString("| | |\n|---|---|\n")
+	for _, row := range append(rows, details...) {
+		fmt.Fprintf(&body, "| %s | %s |\n", row[0], row[1])
+	}
+	fmt.Fprintf(&body, "\nFingerprint: `%s`\n", alert.Fingerprint)
+	return body.String()
+}
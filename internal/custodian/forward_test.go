@@ -0,0 +1,327 @@
+package custodian
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/encoding/protowire"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func natsConnection(t *testing.T) *nats.Conn {
+	t.Helper()
+	natsServer, err := server.NewServer(&server.Options{Host: "127.0.0.1", Port: -1})
+	require.NoError(t, err)
+	go natsServer.Start()
+	t.Cleanup(natsServer.Shutdown)
+	require.True(t, natsServer.ReadyForConnections(5*time.Second))
+	conn, err := nats.Connect(natsServer.ClientURL())
+	require.NoError(t, err)
+	t.Cleanup(conn.Close)
+	return conn
+}
+
+// alertOrder holds the fields of an order published for an alert
+type alertOrder struct {
+	Type      string
+	ID        string
+	Comments  string
+	Consumer  string
+	Payer     string
+	Purchaser string
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// decodeAlertOrder reads the order wire format the way the consumers of the
+// order subject do, fields it does not know are rejected
+func decodeAlertOrder(data []byte) (alertOrder, error) {
+	var order alertOrder
+	var attrs []byte
+	err := consumeFields(data, func(field protowire.Number, value []byte) error {
+		switch field {
+		case orderFieldType:
+			order.Type = string(value)
+		case orderFieldID:
+			order.ID = string(value)
+		case orderFieldAttributes:
+			attrs = value
+		default:
+			return fmt.Errorf("unknown order field %d", field)
+		}
+		return nil
+	})
+	if err != nil {
+		return order, err
+	}
+	err = consumeFields(attrs, func(field protowire.Number, value []byte) error {
+		switch field {
+		case orderAttrFieldComments:
+			order.Comments = string(value)
+		case orderAttrFieldConsumer:
+			order.Consumer = string(value)
+		case orderAttrFieldPayer:
+			order.Payer = string(value)
+		case orderAttrFieldPurchaser:
+			order.Purchaser = string(value)
+		case orderAttrFieldCreatedAt, orderAttrFieldUpdatedAt:
+			var seconds, nanos uint64
+			for len(value) > 0 {
+				num, _, n := protowire.ConsumeTag(value)
+				if n < 0 {
+					return protowire.ParseError(n)
+				}
+				value = value[n:]
+				varint, n := protowire.ConsumeVarint(value)
+				if n < 0 {
+					return protowire.ParseError(n)
+				}
+				value = value[n:]
+				if num == timestampFieldSeconds {
+					seconds = varint
+				} else {
+					nanos = varint
+				}
+			}
+			stamp := time.Unix(int64(seconds), int64(nanos))
+			if field == orderAttrFieldCreatedAt {
+				order.CreatedAt = stamp
+			} else {
+				order.UpdatedAt = stamp
+			}
+		default:
+			return fmt.Errorf("unknown order attribute %d", field)
+		}
+		return nil
+	})
+	return order, err
+}
+
+// consumeFields calls fn with every length delimited field of a message
+func consumeFields(data []byte, fn func(protowire.Number, []byte) error) error {
+	for len(data) > 0 {
+		field, typ, n := protowire.ConsumeTag(data)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		if typ != protowire.BytesType {
+			return fmt.Errorf("field %d is not length delimited", field)
+		}
+		data = data[n:]
+		value, n := protowire.ConsumeBytes(data)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		data = data[n:]
+		if err := fn(field, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// recordingPublisher keeps the published orders
+type recordingPublisher struct {
+	orders []alertOrder
+}
+
+func (rec *recordingPublisher) Publish(_ string, data []byte) error {
+	order, err := decodeAlertOrder(data)
+	if err != nil {
+		return err
+	}
+	rec.orders = append(rec.orders, order)
+	return nil
+}
+
+func forwardCustodian(t *testing.T, now time.Time) *Custodian {
+	t.Helper()
+	failed := metav1.NewTime(now.Add(-10 * time.Minute))
+	clientset := fake.NewSimpleClientset(
+		warningEvent("mount-1", "modware-content-6b4c", "FailedMount", 3, now.Add(-time.Hour), now.Add(-5*time.Minute)),
+		&batchv1.Job{
+			ObjectMeta: metav1.ObjectMeta{Name: "backup-28815840", Namespace: "dev"},
+			Status: batchv1.JobStatus{
+				Failed: 6,
+				Conditions: []batchv1.JobCondition{{
+					Type:               batchv1.JobFailed,
+					Status:             corev1.ConditionTrue,
+					Reason:             "BackoffLimitExceeded",
+					Message:            "Job has reached the specified backoff limit",
+					LastTransitionTime: failed,
+				}},
+			},
+		},
+	)
+	dynamicClient := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(
+		runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{
+			cnpgClusterGVR:         "ClusterList",
+			cnpgBackupGVR:          "BackupList",
+			cnpgScheduledBackupGVR: "ScheduledBackupList",
+			arangoDeploymentGVR:    "ArangoDeploymentList",
+			redisGVR:               "RedisList",
+		},
+	)
+	require.NoError(t, dynamicClient.Tracker().Create(
+		redisGVR,
+		unstructuredObject("redis.redis.opstreelabs.in/v1beta2", "Redis", "redis", map[string]any{
+			"metadata": map[string]any{"name": "redis", "namespace": "dev"},
+		}),
+		"dev",
+	))
+	return &Custodian{
+		clientset:     clientset,
+		dynamicClient: dynamicClient,
+		logger:        testLogger(),
+	}
+}
+
+func TestForwardAlertsPublishesToNats(t *testing.T) {
+	conn := natsConnection(t)
+	sub, err := conn.SubscribeSync(DefaultAlertSubject)
+	require.NoError(t, err)
+	require.NoError(t, conn.Flush())
+
+	cus := forwardCustodian(t, time.Now())
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- cus.ForwardAlerts(ctx, conn, ForwardOptions{
+			Namespaces:     []string{"dev"},
+			Subject:        DefaultAlertSubject,
+			Recipient:      "ops@dictybase.org",
+			StateConfigMap: DefaultAlertStateConfigMap,
+			StateNamespace: "dev",
+			Cluster:        "dicty-prod",
+			Interval:       10 * time.Millisecond,
+			Since:          time.Hour,
+			RepeatAfter:    time.Hour,
+		})
+	}()
+
+	orders := make(map[string]alertOrder)
+	for idx := 0; idx < 3; idx++ {
+		msg, err := sub.NextMsg(5 * time.Second)
+		require.NoError(t, err)
+		order, err := decodeAlertOrder(msg.Data)
+		require.NoError(t, err)
+		title, _, _ := strings.Cut(order.Comments, "\n")
+		orders[title] = order
+	}
+	// the following checks publish nothing new
+	_, err = sub.NextMsg(100 * time.Millisecond)
+	assert.ErrorIs(t, err, nats.ErrTimeout)
+	cancel()
+	require.NoError(t, <-done)
+
+	event, ok := orders["[dicty-prod] Pod dev/modware-content-6b4c FailedMount"]
+	require.True(t, ok)
+	assert.Equal(t, "order", event.Type)
+	assert.Len(t, event.ID, 16, "the fingerprint is the id of the order")
+	assert.Contains(t, event.Comments, "FailedMount of modware-content-6b4c")
+	assert.Contains(t, event.Comments, "| Count | 3 |")
+	assert.Equal(t, "ops@dictybase.org", event.Consumer)
+	assert.Equal(t, "ops@dictybase.org", event.Purchaser)
+	assert.False(t, event.CreatedAt.IsZero())
+	job, ok := orders["[dicty-prod] Job dev/backup-28815840 failed"]
+	require.True(t, ok)
+	assert.Contains(t, job.Comments, "| Reason | BackoffLimitExceeded |")
+	assert.Contains(t, job.Comments, "| Retries | 6 |")
+	assert.Contains(t, orders, "[dicty-prod] Redis dev/redis unhealthy: statefulset not found")
+}
+
+func TestAlertForwarderKeepsStateAcrossRestarts(t *testing.T) {
+	now := time.Now()
+	cus := forwardCustodian(t, now)
+	opts := ForwardOptions{Namespaces: []string{"dev"}, Since: time.Hour, RepeatAfter: time.Hour}
+	alerts, err := cus.collectAlerts(context.Background(), opts, now)
+	require.NoError(t, err)
+	require.Len(t, alerts, 3)
+	state := &alertState{cus: cus, namespace: "dev", name: DefaultAlertStateConfigMap}
+
+	publisher := &recordingPublisher{}
+	forwarder := newAlertForwarder(publisher, opts)
+	require.NoError(t, forwarder.restore(context.Background(), state))
+	published, _, err := forwarder.forward(context.Background(), alerts, now)
+	require.NoError(t, err)
+	assert.Equal(t, 3, published)
+
+	// a restarted forwarder reads what was published before
+	restarted := newAlertForwarder(publisher, opts)
+	require.NoError(t, restarted.restore(context.Background(), state))
+	published, _, err = restarted.forward(context.Background(), alerts, now.Add(time.Minute))
+	require.NoError(t, err)
+	assert.Zero(t, published, "the alerts are not published again after a restart")
+
+	published, _, err = restarted.forward(context.Background(), alerts[1:], now.Add(2*time.Minute))
+	require.NoError(t, err)
+	assert.Zero(t, published)
+	configMap, err := cus.clientset.CoreV1().
+		ConfigMaps("dev").
+		Get(context.Background(), DefaultAlertStateConfigMap, metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Len(t, configMap.Data, 2, "alerts that went away are removed from the state")
+	assert.NotContains(t, configMap.Data, alerts[0].Fingerprint)
+	assert.Len(t, publisher.orders, 3)
+}
+
+func TestAlertForwarderDeduplicatesAndRateLimits(t *testing.T) {
+	now := time.Now()
+	alerts, err := forwardCustodian(t, now).collectAlerts(
+		context.Background(),
+		ForwardOptions{Namespaces: []string{"dev"}, Since: time.Hour},
+		now,
+	)
+	require.NoError(t, err)
+	require.Len(t, alerts, 3)
+
+	publisher := &recordingPublisher{}
+	forwarder := newAlertForwarder(publisher, ForwardOptions{
+		RepeatAfter:  time.Hour,
+		MaxPerMinute: 2,
+	})
+	published, skipped, err := forwarder.forward(context.Background(), alerts, now)
+	require.NoError(t, err)
+	assert.Equal(t, 2, published)
+	assert.Equal(t, 1, skipped, "the third alert exceeds the rate limit")
+
+	later := now.Add(time.Minute)
+	published, skipped, err = forwarder.forward(context.Background(), alerts, later)
+	require.NoError(t, err)
+	assert.Equal(t, 1, published, "only the held back alert is published")
+	assert.Zero(t, skipped)
+
+	changed := append([]Alert{}, alerts...)
+	changed[0] = eventAlert("", EventGroup{
+		Namespace: "dev", Kind: "Pod", Name: "modware-content-6b4c",
+		Reason: "FailedMount", Count: 9,
+	})
+	published, _, err = forwarder.forward(context.Background(), changed, later.Add(time.Minute))
+	require.NoError(t, err)
+	assert.Zero(t, published, "a changed alert waits for the repeat interval")
+	published, _, err = forwarder.forward(context.Background(), changed, later.Add(2*time.Hour))
+	require.NoError(t, err)
+	assert.Equal(t, 1, published)
+
+	published, _, err = forwarder.forward(context.Background(), alerts[1:], later.Add(3*time.Hour))
+	require.NoError(t, err)
+	assert.Zero(t, published)
+	published, _, err = forwarder.forward(context.Background(), alerts, later.Add(3*time.Hour))
+	require.NoError(t, err)
+	assert.Equal(t, 1, published, "an alert that went away is published when it comes back")
+	assert.Len(t, publisher.orders, 5)
+}
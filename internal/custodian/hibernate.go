@@ -0,0 +1,441 @@
+package custodian
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// HibernationAnnotation records the fields hibernate changed with their
+// previous values, resume restores them from it
+const HibernationAnnotation = "cluster-ops.dictybase.org/hibernation"
+
+// CNPGHibernationAnnotation makes the CloudNativePG operator shut down a
+// cluster while keeping its volumes
+const CNPGHibernationAnnotation = "cnpg.io/hibernation"
+
+// RedisSkipReconcileAnnotation pauses the redis operator for a Redis, so
+// that the statefulset it created can be scaled without being reverted
+const RedisSkipReconcileAnnotation = "redis.opstreelabs.in/skip-reconcile"
+
+// Actions reported by hibernate and resume
+const (
+	HibernateScaled      = "hibernated"
+	HibernateResumed     = "resumed"
+	HibernateSkipped     = "skipped"
+	HibernateUnsupported = "unsupported"
+)
+
+var statefulSetsGVR = schema.GroupVersionResource{Group: "apps", Version: "v1", Resource: "statefulsets"}
+
+// relatedGVRs are the kinds of the resources an operator creates with the
+// name of its custom resource
+var relatedGVRs = map[string]schema.GroupVersionResource{
+	"StatefulSet": statefulSetsGVR,
+}
+
+// fieldChange is a field of a resource and the value it is set to
+type fieldChange struct {
+	// Object is empty for a field of the resource itself, or the kind of
+	// the resource of the same name its operator created, see relatedGVRs
+	Object string   `json:"object,omitempty"`
+	Path   []string `json:"path"`
+	Value  any      `json:"value"`
+}
+
+// hibernationTarget is a kind of resource hibernate scales down, fields
+// returns the values that stop the resource. Kinds that cannot be stopped
+// explain why in unsupported, hibernate fails as they keep running.
+type hibernationTarget struct {
+	kind        string
+	gvr         schema.GroupVersionResource
+	fields      func(obj *unstructured.Unstructured) []fieldChange
+	unsupported string
+}
+
+// hibernationTargets are scaled in order, operator managed databases go
+// through their custom resources instead of their pods
+var hibernationTargets = []hibernationTarget{
+	{
+		kind:   "Deployment",
+		gvr:    schema.GroupVersionResource{Group: "apps", Version: "v1", Resource: "deployments"},
+		fields: replicasField,
+	},
+	{
+		kind:   "StatefulSet",
+		gvr:    statefulSetsGVR,
+		fields: replicasField,
+	},
+	{
+		kind: "CronJob",
+		gvr:  schema.GroupVersionResource{Group: "batch", Version: "v1", Resource: "cronjobs"},
+		fields: func(*unstructured.Unstructured) []fieldChange {
+			return []fieldChange{{Path: []string{"spec", "suspend"}, Value: true}}
+		},
+	},
+	{
+		kind: "Cluster",
+		gvr:  schema.GroupVersionResource{Group: "postgresql.cnpg.io", Version: "v1", Resource: "clusters"},
+		fields: func(*unstructured.Unstructured) []fieldChange {
+			return []fieldChange{{
+				Path:  []string{"metadata", "annotations", CNPGHibernationAnnotation},
+				Value: "on",
+			}}
+		},
+	},
+	{
+		// the operator is paused before the statefulset of the Redis is
+		// scaled down, and resumed after it is scaled up again
+		kind: "Redis",
+		gvr:  redisGVR,
+		fields: func(*unstructured.Unstructured) []fieldChange {
+			return []fieldChange{
+				{
+					Path:  []string{"metadata", "annotations", RedisSkipReconcileAnnotation},
+					Value: "true",
+				},
+				{Object: "StatefulSet", Path: []string{"spec", "replicas"}, Value: 0},
+			}
+		},
+	},
+	{
+		// the operator rejects a count of zero members and has no
+		// hibernation of its own
+		kind:        "ArangoDeployment",
+		gvr:         arangoDeploymentGVR,
+		unsupported: "not supported by operator, ArangoDB keeps running",
+	},
+}
+
+func replicasField(*unstructured.Unstructured) []fieldChange {
+	return []fieldChange{{Path: []string{"spec", "replicas"}, Value: 0}}
+}
+
+// HibernateOptions selects the namespace to hibernate or resume
+type HibernateOptions struct {
+	Namespace string
+	DryRun    bool
+}
+
+// HibernationChange is a resource hibernate or resume acted on
+type HibernationChange struct {
+	Kind    string   `json:"kind"`
+	Name    string   `json:"name"`
+	Action  string   `json:"action"`
+	Changes []string `json:"changes,omitempty"`
+	Reason  string   `json:"reason,omitempty"`
+}
+
+// Hibernate scales the deployments and statefulsets of the namespace to
+// zero, suspends its cronjobs and hibernates CloudNativePG clusters and
+// Redis resources. The previous values are kept in an annotation of each
+// resource. Resources already hibernated or owned by another resource are
+// skipped. ArangoDeployments cannot be stopped through their operator, they
+// are reported as unsupported and an error is returned after the other
+// resources were hibernated.
+func (cus *Custodian) Hibernate(
+	ctx context.Context,
+	opts HibernateOptions,
+) ([]HibernationChange, error) {
+	changes, err := cus.eachHibernationTarget(ctx, opts, cus.hibernateResource)
+	if err != nil {
+		return changes, err
+	}
+	var running []string
+	for _, change := range changes {
+		if change.Action == HibernateUnsupported {
+			running = append(running, change.Kind+" "+change.Name)
+		}
+	}
+	if len(running) > 0 {
+		return changes, fmt.Errorf(
+			"namespace %s is not fully hibernated, still running: %s",
+			opts.Namespace,
+			strings.Join(running, ", "),
+		)
+	}
+	return changes, nil
+}
+
+// Resume restores the values recorded by Hibernate and removes the
+// annotation, resources without the annotation are left alone
+func (cus *Custodian) Resume(
+	ctx context.Context,
+	opts HibernateOptions,
+) ([]HibernationChange, error) {
+	return cus.eachHibernationTarget(ctx, opts, cus.resumeResource)
+}
+
+type hibernationFunc func(
+	ctx context.Context,
+	target hibernationTarget,
+	obj *unstructured.Unstructured,
+	dryRun bool,
+) (*HibernationChange, error)
+
+func (cus *Custodian) eachHibernationTarget(
+	ctx context.Context,
+	opts HibernateOptions,
+	handle hibernationFunc,
+) ([]HibernationChange, error) {
+	var changes []HibernationChange
+	for _, target := range hibernationTargets {
+		list, err := cus.dynamicClient.Resource(target.gvr).
+			Namespace(opts.Namespace).
+			List(ctx, metav1.ListOptions{})
+		if apierrors.IsNotFound(err) {
+			cus.logger.Debug("Resource not served, skipping", "resource", target.gvr.Resource)
+			continue
+		}
+		if err != nil {
+			return changes, fmt.Errorf("failed to list %s: %w", target.gvr.Resource, err)
+		}
+		for idx := range list.Items {
+			change, err := handle(ctx, target, &list.Items[idx], opts.DryRun)
+			if err != nil {
+				return changes, err
+			}
+			if change != nil {
+				changes = append(changes, *change)
+			}
+		}
+	}
+	return changes, nil
+}
+
+func (cus *Custodian) hibernateResource(
+	ctx context.Context,
+	target hibernationTarget,
+	obj *unstructured.Unstructured,
+	dryRun bool,
+) (*HibernationChange, error) {
+	change := &HibernationChange{Kind: target.kind, Name: obj.GetName()}
+	if len(target.unsupported) > 0 {
+		change.Action = HibernateUnsupported
+		change.Reason = target.unsupported
+		return change, nil
+	}
+	if _, ok := obj.GetAnnotations()[HibernationAnnotation]; ok {
+		return nil, nil
+	}
+	if owner := metav1.GetControllerOf(obj); owner != nil {
+		change.Action = HibernateSkipped
+		change.Reason = fmt.Sprintf("managed by %s %s", owner.Kind, owner.Name)
+		return change, nil
+	}
+	fields := target.fields(obj)
+	objects, err := cus.relatedObjects(ctx, obj, fields)
+	if apierrors.IsNotFound(err) {
+		change.Action = HibernateSkipped
+		change.Reason = err.Error()
+		return change, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	previous := make([]fieldChange, 0, len(fields))
+	patches := make(map[string]map[string]any)
+	for _, field := range fields {
+		value, _, _ := unstructured.NestedFieldNoCopy(objects[field.Object].Object, field.Path...)
+		previous = append(previous, fieldChange{Object: field.Object, Path: field.Path, Value: value})
+		change.Changes = append(change.Changes, describeFieldChange(field, value, field.Value))
+		setPatchValue(objectPatch(patches, field.Object), field.Path, field.Value)
+	}
+	state, err := json.Marshal(previous)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode hibernation state: %w", err)
+	}
+	setPatchValue(
+		objectPatch(patches, ""),
+		[]string{"metadata", "annotations", HibernationAnnotation},
+		string(state),
+	)
+	change.Action = HibernateScaled
+	// the resource pauses its operator before the related resources change
+	return change, cus.patchHibernation(ctx, target, obj, patches, false, dryRun)
+}
+
+func (cus *Custodian) resumeResource(
+	ctx context.Context,
+	target hibernationTarget,
+	obj *unstructured.Unstructured,
+	dryRun bool,
+) (*HibernationChange, error) {
+	state, ok := obj.GetAnnotations()[HibernationAnnotation]
+	if !ok || len(target.unsupported) > 0 {
+		return nil, nil
+	}
+	var previous []fieldChange
+	if err := json.Unmarshal([]byte(state), &previous); err != nil {
+		return nil, fmt.Errorf(
+			"failed to decode hibernation state of %s %s: %w",
+			target.kind,
+			obj.GetName(),
+			err,
+		)
+	}
+	objects, err := cus.relatedObjects(ctx, obj, previous)
+	if err != nil {
+		return nil, err
+	}
+	change := &HibernationChange{
+		Kind:   target.kind,
+		Name:   obj.GetName(),
+		Action: HibernateResumed,
+	}
+	patches := make(map[string]map[string]any)
+	for _, field := range previous {
+		current, _, _ := unstructured.NestedFieldNoCopy(objects[field.Object].Object, field.Path...)
+		change.Changes = append(change.Changes, describeFieldChange(field, current, field.Value))
+		setPatchValue(objectPatch(patches, field.Object), field.Path, field.Value)
+	}
+	setPatchValue(
+		objectPatch(patches, ""),
+		[]string{"metadata", "annotations", HibernationAnnotation},
+		nil,
+	)
+	// the related resources are restored before the operator resumes
+	return change, cus.patchHibernation(ctx, target, obj, patches, true, dryRun)
+}
+
+// relatedObjects returns the resource, keyed by the empty kind, and the
+// resources of the same name the fields refer to
+func (cus *Custodian) relatedObjects(
+	ctx context.Context,
+	obj *unstructured.Unstructured,
+	fields []fieldChange,
+) (map[string]*unstructured.Unstructured, error) {
+	objects := map[string]*unstructured.Unstructured{"": obj}
+	for _, field := range fields {
+		if _, ok := objects[field.Object]; ok {
+			continue
+		}
+		gvr, ok := relatedGVRs[field.Object]
+		if !ok {
+			return nil, fmt.Errorf("unknown related kind %s", field.Object)
+		}
+		related, err := cus.dynamicClient.Resource(gvr).
+			Namespace(obj.GetNamespace()).
+			Get(ctx, obj.GetName(), metav1.GetOptions{})
+		if err != nil {
+			return nil, fmt.Errorf(
+				"failed to get %s of %s %s: %w",
+				strings.ToLower(field.Object),
+				obj.GetKind(),
+				obj.GetName(),
+				err,
+			)
+		}
+		objects[field.Object] = related
+	}
+	return objects, nil
+}
+
+func objectPatch(patches map[string]map[string]any, object string) map[string]any {
+	if _, ok := patches[object]; !ok {
+		patches[object] = make(map[string]any)
+	}
+	return patches[object]
+}
+
+// patchHibernation patches the resource and its related resources, the
+// related ones first when relatedFirst is set
+func (cus *Custodian) patchHibernation(
+	ctx context.Context,
+	target hibernationTarget,
+	obj *unstructured.Unstructured,
+	patches map[string]map[string]any,
+	relatedFirst bool,
+	dryRun bool,
+) error {
+	objects := make([]string, 0, len(patches))
+	for object := range patches {
+		if len(object) > 0 {
+			objects = append(objects, object)
+		}
+	}
+	sort.Strings(objects)
+	if relatedFirst {
+		objects = append(objects, "")
+	} else {
+		objects = append([]string{""}, objects...)
+	}
+	for _, object := range objects {
+		kind, gvr := target.kind, target.gvr
+		if len(object) > 0 {
+			kind, gvr = object, relatedGVRs[object]
+		}
+		if dryRun {
+			cus.logger.Info("Dry run, not patching", "kind", kind, "name", obj.GetName())
+			continue
+		}
+		data, err := json.Marshal(patches[object])
+		if err != nil {
+			return fmt.Errorf("failed to create hibernation patch: %w", err)
+		}
+		_, err = cus.dynamicClient.Resource(gvr).
+			Namespace(obj.GetNamespace()).
+			Patch(ctx, obj.GetName(), types.MergePatchType, data, metav1.PatchOptions{})
+		if err != nil {
+			return fmt.Errorf("failed to patch %s %s: %w", kind, obj.GetName(), err)
+		}
+		cus.logger.Info("Patched", "kind", kind, "name", obj.GetName())
+	}
+	return nil
+}
+
+// setPatchValue sets the value at the path of a merge patch, a nil value
+// removes the field
+func setPatchValue(patch map[string]any, path []string, value any) {
+	for _, key := range path[:len(path)-1] {
+		next, ok := patch[key].(map[string]any)
+		if !ok {
+			next = make(map[string]any)
+			patch[key] = next
+		}
+		patch = next
+	}
+	patch[path[len(path)-1]] = value
+}
+
+func describeFieldChange(field fieldChange, from, to any) string {
+	format := func(value any) string {
+		if value == nil {
+			return "unset"
+		}
+		return fmt.Sprint(value)
+	}
+	path := strings.Join(field.Path, ".")
+	if len(field.Object) > 0 {
+		path = strings.ToLower(field.Object) + " " + path
+	}
+	return fmt.Sprintf("%s: %s -> %s", path, format(from), format(to))
+}
+
+// WriteHibernation renders the resources hibernate or resume acted on
+func WriteHibernation(out io.Writer, format string, changes []HibernationChange) error {
+	if format == FormatJSON {
+		if changes == nil {
+			changes = []HibernationChange{}
+		}
+		return writeJSON(out, changes)
+	}
+	rows := make([][]string, 0, len(changes))
+	for _, change := range changes {
+		detail := strings.Join(change.Changes, ", ")
+		if change.Reason != "" {
+			detail = change.Reason
+		}
+		rows = append(rows, []string{change.Kind, change.Name, change.Action, detail})
+	}
+	return writeTable(out, format, []string{"KIND", "NAME", "ACTION", "DETAIL"}, rows)
+}
@@ -0,0 +1,123 @@
+package custodian
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+)
+
+func TestHibernateAndResume(t *testing.T) {
+	listKinds := make(map[schema.GroupVersionResource]string)
+	for _, target := range hibernationTargets {
+		listKinds[target.gvr] = target.kind + "List"
+	}
+	controller := true
+	redisStatefulSet := unstructuredObject("apps/v1", "StatefulSet", "redis", map[string]any{
+		"spec": map[string]any{"replicas": int64(1)},
+	})
+	redisStatefulSet.SetOwnerReferences([]metav1.OwnerReference{{
+		APIVersion: "redis.redis.opstreelabs.in/v1beta2",
+		Kind:       "Redis",
+		Name:       "redis",
+		Controller: &controller,
+	}})
+	dynamicClient := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(
+		runtime.NewScheme(),
+		listKinds,
+		unstructuredObject("apps/v1", "Deployment", "frontend", map[string]any{
+			"spec": map[string]any{"replicas": int64(3)},
+		}),
+		unstructuredObject("apps/v1", "Deployment", "graphql-server", map[string]any{
+			"spec": map[string]any{},
+		}),
+		redisStatefulSet,
+		unstructuredObject("batch/v1", "CronJob", "backup", map[string]any{
+			"spec": map[string]any{"suspend": false},
+		}),
+		unstructuredObject("postgresql.cnpg.io/v1", "Cluster", "pg", map[string]any{
+			"spec": map[string]any{"instances": int64(2)},
+		}),
+		unstructuredObject("database.arangodb.com/v1", "ArangoDeployment", "arangodb", map[string]any{
+			"spec": map[string]any{
+				"mode":   "Single",
+				"single": map[string]any{"count": int64(1)},
+			},
+		}),
+	)
+	// the plural of redis can not be guessed from its kind
+	require.NoError(t, dynamicClient.Tracker().Create(
+		redisGVR,
+		unstructuredObject("redis.redis.opstreelabs.in/v1beta2", "Redis", "redis", nil),
+		"dictybase",
+	))
+	cus := &Custodian{dynamicClient: dynamicClient, logger: testLogger()}
+	opts := HibernateOptions{Namespace: "dictybase"}
+	get := func(gvr schema.GroupVersionResource, name string) *unstructured.Unstructured {
+		obj, err := dynamicClient.Resource(gvr).
+			Namespace("dictybase").
+			Get(context.Background(), name, metav1.GetOptions{})
+		require.NoError(t, err)
+		return obj
+	}
+	deployments := hibernationTargets[0].gvr
+	cronJobs := hibernationTargets[2].gvr
+	clusters := hibernationTargets[3].gvr
+	actions := func(changes []HibernationChange) map[string]string {
+		byResource := make(map[string]string)
+		for _, change := range changes {
+			byResource[change.Kind+" "+change.Name] = change.Action
+		}
+		return byResource
+	}
+
+	changes, err := cus.Hibernate(context.Background(), opts)
+	assert.EqualError(t, err, "namespace dictybase is not fully hibernated, still running: ArangoDeployment arangodb")
+	assert.Equal(t, map[string]string{
+		"Deployment frontend":       HibernateScaled,
+		"Deployment graphql-server": HibernateScaled,
+		"StatefulSet redis":         HibernateSkipped,
+		"CronJob backup":            HibernateScaled,
+		"Cluster pg":                HibernateScaled,
+		"Redis redis":               HibernateScaled,
+		"ArangoDeployment arangodb": HibernateUnsupported,
+	}, actions(changes))
+	replicas, _, _ := unstructured.NestedInt64(get(deployments, "frontend").Object, "spec", "replicas")
+	assert.Zero(t, replicas)
+	suspend, _, _ := unstructured.NestedBool(get(cronJobs, "backup").Object, "spec", "suspend")
+	assert.True(t, suspend)
+	assert.Equal(t, "on", get(clusters, "pg").GetAnnotations()[CNPGHibernationAnnotation])
+	assert.Equal(t, "true", get(redisGVR, "redis").GetAnnotations()[RedisSkipReconcileAnnotation])
+	replicas, _, _ = unstructured.NestedInt64(get(statefulSetsGVR, "redis").Object, "spec", "replicas")
+	assert.Zero(t, replicas, "the statefulset of redis is scaled through its resource")
+	count, _, _ := unstructured.NestedInt64(get(arangoDeploymentGVR, "arangodb").Object, "spec", "single", "count")
+	assert.Equal(t, int64(1), count, "the arango deployment keeps running")
+	assert.Empty(t, get(arangoDeploymentGVR, "arangodb").GetAnnotations())
+
+	changes, err = cus.Hibernate(context.Background(), opts)
+	assert.Error(t, err)
+	assert.Equal(t, map[string]string{
+		"StatefulSet redis":         HibernateSkipped,
+		"ArangoDeployment arangodb": HibernateUnsupported,
+	}, actions(changes), "hibernated resources keep their recorded state")
+
+	changes, err = cus.Resume(context.Background(), opts)
+	require.NoError(t, err)
+	assert.Len(t, changes, 5)
+	replicas, _, _ = unstructured.NestedInt64(get(deployments, "frontend").Object, "spec", "replicas")
+	assert.Equal(t, int64(3), replicas)
+	_, found, _ := unstructured.NestedFieldNoCopy(get(deployments, "graphql-server").Object, "spec", "replicas")
+	assert.False(t, found)
+	suspend, _, _ = unstructured.NestedBool(get(cronJobs, "backup").Object, "spec", "suspend")
+	assert.False(t, suspend)
+	assert.Empty(t, get(clusters, "pg").GetAnnotations())
+	replicas, _, _ = unstructured.NestedInt64(get(statefulSetsGVR, "redis").Object, "spec", "replicas")
+	assert.Equal(t, int64(1), replicas)
+	assert.Empty(t, get(redisGVR, "redis").GetAnnotations())
+}
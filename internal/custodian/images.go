@@ -0,0 +1,314 @@
+package custodian
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"slices"
+	"sort"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// mutableTags are tags that are moved to new builds, images without a tag
+// resolve to latest
+var mutableTags = []string{"", "latest", "develop", "main", "master", "staging", "edge", "nightly"}
+
+// Flags of an image in the inventory
+const (
+	ImageMutable = "mutable"
+	ImageDrifted = "drift"
+)
+
+// WorkloadImage is the image of one container of a workload
+type WorkloadImage struct {
+	Cluster    string   `json:"cluster,omitempty"`
+	Namespace  string   `json:"namespace"`
+	Kind       string   `json:"kind"`
+	Workload   string   `json:"workload"`
+	Container  string   `json:"container"`
+	Image      string   `json:"image"`
+	Repository string   `json:"repository"`
+	Tag        string   `json:"tag,omitempty"`
+	Digest     string   `json:"digest,omitempty"`
+	Running    []string `json:"runningDigests,omitempty"`
+	Flags      []string `json:"flags,omitempty"`
+}
+
+// location identifies where the workload runs when comparing images
+func (img WorkloadImage) location() string {
+	if img.Cluster == "" {
+		return img.Namespace
+	}
+	return img.Cluster + "/" + img.Namespace
+}
+
+// ImageDrift is a container whose image differs between locations
+type ImageDrift struct {
+	Kind      string            `json:"kind"`
+	Workload  string            `json:"workload"`
+	Container string            `json:"container"`
+	Images    map[string]string `json:"images"`
+}
+
+// ImageReport holds the image inventory and the drift between locations
+type ImageReport struct {
+	Images []WorkloadImage `json:"images"`
+	Drift  []ImageDrift    `json:"drift"`
+}
+
+// ListImages lists the container images of the deployments, statefulsets,
+// daemonsets and cronjobs of the namespaces. With resolveDigests the
+// digests reported by the running pods are added.
+func (cus *Custodian) ListImages(
+	ctx context.Context,
+	namespaces []string,
+	resolveDigests bool,
+) ([]WorkloadImage, error) {
+	if len(namespaces) == 0 {
+		namespaces = []string{metav1.NamespaceAll}
+	}
+	var images []WorkloadImage
+	for _, namespace := range namespaces {
+		templates, err := cus.podTemplates(ctx, namespace)
+		if err != nil {
+			return nil, err
+		}
+		var running map[string][]string
+		if resolveDigests {
+			running, err = cus.runningDigests(ctx, namespace)
+			if err != nil {
+				return nil, err
+			}
+		}
+		for _, tmpl := range templates {
+			containers := append(
+				slices.Clone(tmpl.spec.InitContainers),
+				tmpl.spec.Containers...,
+			)
+			for _, container := range containers {
+				repository, tag, digest := parseImage(container.Image)
+				image := WorkloadImage{
+					Namespace:  tmpl.namespace,
+					Kind:       tmpl.kind,
+					Workload:   tmpl.name,
+					Container:  container.Name,
+					Image:      container.Image,
+					Repository: repository,
+					Tag:        tag,
+					Digest:     digest,
+					Running:    running[tmpl.namespace+"/"+container.Image],
+				}
+				if digest == "" && slices.Contains(mutableTags, tag) {
+					image.Flags = append(image.Flags, ImageMutable)
+				}
+				images = append(images, image)
+			}
+		}
+	}
+	return images, nil
+}
+
+type podTemplate struct {
+	kind      string
+	namespace string
+	name      string
+	spec      corev1.PodSpec
+}
+
+func (cus *Custodian) podTemplates(
+	ctx context.Context,
+	namespace string,
+) ([]podTemplate, error) {
+	var templates []podTemplate
+	apps := cus.clientset.AppsV1()
+	deployments, err := apps.Deployments(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list deployments: %w", err)
+	}
+	for _, item := range deployments.Items {
+		templates = append(templates, podTemplate{
+			"Deployment", item.Namespace, item.Name, item.Spec.Template.Spec,
+		})
+	}
+	statefulSets, err := apps.StatefulSets(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list statefulsets: %w", err)
+	}
+	for _, item := range statefulSets.Items {
+		templates = append(templates, podTemplate{
+			"StatefulSet", item.Namespace, item.Name, item.Spec.Template.Spec,
+		})
+	}
+	daemonSets, err := apps.DaemonSets(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list daemonsets: %w", err)
+	}
+	for _, item := range daemonSets.Items {
+		templates = append(templates, podTemplate{
+			"DaemonSet", item.Namespace, item.Name, item.Spec.Template.Spec,
+		})
+	}
+	cronJobs, err := cus.clientset.BatchV1().
+		CronJobs(namespace).
+		List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list cronjobs: %w", err)
+	}
+	for _, item := range cronJobs.Items {
+		templates = append(templates, podTemplate{
+			"CronJob", item.Namespace, item.Name,
+			item.Spec.JobTemplate.Spec.Template.Spec,
+		})
+	}
+	return templates, nil
+}
+
+// runningDigests maps namespace/image to the digests the kubelets pulled
+// for it
+func (cus *Custodian) runningDigests(
+	ctx context.Context,
+	namespace string,
+) (map[string][]string, error) {
+	pods, err := cus.clientset.CoreV1().
+		Pods(namespace).
+		List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list pods: %w", err)
+	}
+	digests := make(map[string][]string)
+	for _, pod := range pods.Items {
+		specImages := make(map[string]string)
+		for _, container := range append(
+			slices.Clone(pod.Spec.InitContainers),
+			pod.Spec.Containers...,
+		) {
+			specImages[container.Name] = container.Image
+		}
+		for _, status := range append(
+			slices.Clone(pod.Status.InitContainerStatuses),
+			pod.Status.ContainerStatuses...,
+		) {
+			_, digest, ok := strings.Cut(status.ImageID, "@")
+			image, known := specImages[status.Name]
+			if !ok || !known {
+				continue
+			}
+			key := pod.Namespace + "/" + image
+			if !slices.Contains(digests[key], digest) {
+				digests[key] = append(digests[key], digest)
+			}
+		}
+	}
+	return digests, nil
+}
+
+// parseImage splits an image reference into repository, tag and digest
+func parseImage(ref string) (repository, tag, digest string) {
+	repository, digest, _ = strings.Cut(ref, "@")
+	slash := strings.LastIndex(repository, "/")
+	if colon := strings.LastIndex(repository, ":"); colon > slash {
+		repository, tag = repository[:colon], repository[colon+1:]
+	}
+	return repository, tag, digest
+}
+
+// FindImageDrift compares the images of containers with the same workload
+// and container name across namespaces and clusters, and flags the images
+// that differ
+func FindImageDrift(images []WorkloadImage) []ImageDrift {
+	groups := make(map[string][]int)
+	for idx, image := range images {
+		key := strings.Join([]string{image.Kind, image.Workload, image.Container}, "/")
+		groups[key] = append(groups[key], idx)
+	}
+	var drift []ImageDrift
+	for _, key := range sortedKeys(groups) {
+		members := groups[key]
+		refs := make(map[string]string, len(members))
+		distinct := make(map[string]bool)
+		for _, idx := range members {
+			refs[images[idx].location()] = images[idx].Image
+			distinct[images[idx].Image] = true
+		}
+		if len(distinct) < 2 {
+			continue
+		}
+		for _, idx := range members {
+			images[idx].Flags = append(images[idx].Flags, ImageDrifted)
+		}
+		first := images[members[0]]
+		drift = append(drift, ImageDrift{
+			Kind:      first.Kind,
+			Workload:  first.Workload,
+			Container: first.Container,
+			Images:    refs,
+		})
+	}
+	return drift
+}
+
+// WriteImages renders the image inventory followed by the drift between
+// locations
+func WriteImages(out io.Writer, format string, report ImageReport) error {
+	if format == FormatJSON {
+		if report.Images == nil {
+			report.Images = []WorkloadImage{}
+		}
+		if report.Drift == nil {
+			report.Drift = []ImageDrift{}
+		}
+		return writeJSON(out, report)
+	}
+	sort.SliceStable(report.Images, func(i, j int) bool {
+		left, right := report.Images[i], report.Images[j]
+		if left.Workload != right.Workload {
+			return left.Workload < right.Workload
+		}
+		return left.location() < right.location()
+	})
+	rows := make([][]string, 0, len(report.Images))
+	for _, image := range report.Images {
+		digests := image.Running
+		if len(digests) == 0 && image.Digest != "" {
+			digests = []string{image.Digest}
+		}
+		rows = append(rows, []string{
+			valueOrDash(image.Cluster),
+			image.Namespace,
+			image.Kind,
+			image.Workload,
+			image.Container,
+			image.Repository,
+			valueOrDash(image.Tag),
+			valueOrDash(strings.Join(digests, ",")),
+			valueOrDash(strings.Join(image.Flags, ",")),
+		})
+	}
+	err := writeTable(out, format, []string{
+		"CLUSTER", "NAMESPACE", "KIND", "WORKLOAD", "CONTAINER",
+		"REPOSITORY", "TAG", "DIGEST", "FLAGS",
+	}, rows)
+	if err != nil || len(report.Drift) == 0 {
+		return err
+	}
+	fmt.Fprintln(out)
+	drift := make([][]string, 0, len(report.Drift))
+	for _, item := range report.Drift {
+		var images []string
+		for _, location := range sortedKeys(item.Images) {
+			images = append(images, location+"="+item.Images[location])
+		}
+		drift = append(drift, []string{
+			item.Kind,
+			item.Workload,
+			item.Container,
+			strings.Join(images, " "),
+		})
+	}
+	return writeTable(out, format, []string{
+		"KIND", "WORKLOAD", "CONTAINER", "IMAGES",
+	}, drift)
+}
@@ -0,0 +1,72 @@
+package custodian
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestParseImage(t *testing.T) {
+	cases := map[string][3]string{
+		"nginx":                                  {"nginx", "", ""},
+		"dictybase/graphql-server:develop-f7329": {"dictybase/graphql-server", "develop-f7329", ""},
+		"localhost:5000/frontend":                {"localhost:5000/frontend", "", ""},
+		"redis:7.4.0@sha256:abc":                 {"redis", "7.4.0", "sha256:abc"},
+	}
+	for ref, want := range cases {
+		repository, tag, digest := parseImage(ref)
+		assert.Equal(t, want, [3]string{repository, tag, digest}, ref)
+	}
+}
+
+func TestListImagesAndDrift(t *testing.T) {
+	deployment := func(namespace, image string) *appsv1.Deployment {
+		return &appsv1.Deployment{
+			ObjectMeta: metav1.ObjectMeta{Name: "graphql-server", Namespace: namespace},
+			Spec: appsv1.DeploymentSpec{Template: corev1.PodTemplateSpec{
+				Spec: corev1.PodSpec{Containers: []corev1.Container{{
+					Name:  "api",
+					Image: image,
+				}}},
+			}},
+		}
+	}
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "graphql-server-abc", Namespace: "dev"},
+		Spec: corev1.PodSpec{Containers: []corev1.Container{{
+			Name:  "api",
+			Image: "dictybase/graphql-server:develop",
+		}}},
+		Status: corev1.PodStatus{ContainerStatuses: []corev1.ContainerStatus{{
+			Name:    "api",
+			ImageID: "docker.io/dictybase/graphql-server@sha256:1234",
+		}}},
+	}
+	clientset := fake.NewSimpleClientset(
+		deployment("dev", "dictybase/graphql-server:develop"),
+		deployment("staging", "dictybase/graphql-server:develop-f732922"),
+		pod,
+	)
+	cus := &Custodian{clientset: clientset, logger: testLogger()}
+
+	images, err := cus.ListImages(context.Background(), []string{"dev", "staging"}, true)
+	require.NoError(t, err)
+	require.Len(t, images, 2)
+	assert.Equal(t, []string{"sha256:1234"}, images[0].Running)
+	assert.Equal(t, []string{ImageMutable}, images[0].Flags)
+	assert.Empty(t, images[1].Flags)
+
+	drift := FindImageDrift(images)
+	require.Len(t, drift, 1)
+	assert.Equal(t, map[string]string{
+		"dev":     "dictybase/graphql-server:develop",
+		"staging": "dictybase/graphql-server:develop-f732922",
+	}, drift[0].Images)
+	assert.Equal(t, []string{ImageMutable, ImageDrifted}, images[0].Flags)
+}
@@ -0,0 +1,500 @@
+package custodian
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Job statuses used in the reports
+const (
+	JobStatusRunning   = "Running"
+	JobStatusComplete  = "Complete"
+	JobStatusFailed    = "Failed"
+	JobStatusSuspended = "Suspended"
+)
+
+// JobReportOptions controls how much detail is gathered for failed jobs
+type JobReportOptions struct {
+	Format     string
+	FailedOnly bool
+	LogLines   int64
+	EventCount int
+}
+
+// JobReport summarizes a single job
+type JobReport struct {
+	Name      string      `json:"name"`
+	Namespace string      `json:"namespace"`
+	CronJob   string      `json:"cronJob,omitempty"`
+	Status    string      `json:"status"`
+	Started   *time.Time  `json:"started,omitempty"`
+	Finished  *time.Time  `json:"finished,omitempty"`
+	Duration  string      `json:"duration"`
+	Active    int32       `json:"active"`
+	Succeeded int32       `json:"succeeded"`
+	Retries   int32       `json:"retries"`
+	Failure   *JobFailure `json:"failure,omitempty"`
+}
+
+// JobFailure holds the triage details of a failed job
+type JobFailure struct {
+	Reason  string         `json:"reason"`
+	Message string         `json:"message"`
+	Pods    []PodFailure   `json:"pods"`
+	Events  []EventSummary `json:"events"`
+}
+
+// PodFailure describes a terminated container of a failed job
+type PodFailure struct {
+	Pod       string   `json:"pod"`
+	Container string   `json:"container"`
+	ExitCode  int32    `json:"exitCode"`
+	Reason    string   `json:"reason"`
+	Logs      []string `json:"logs"`
+}
+
+// EventSummary is a condensed Kubernetes event
+type EventSummary struct {
+	LastSeen time.Time `json:"lastSeen"`
+	Type     string    `json:"type"`
+	Reason   string    `json:"reason"`
+	Object   string    `json:"object"`
+	Count    int32     `json:"count"`
+	Message  string    `json:"message"`
+}
+
+// JobsReport lists the jobs in the namespace, including the ones created by
+// cronjobs, and gathers triage details for the failed ones
+func (cus *Custodian) JobsReport(
+	ctx context.Context,
+	opts JobReportOptions,
+	out io.Writer,
+) error {
+	reports, err := cus.buildJobReports(ctx, opts)
+	if err != nil {
+		return err
+	}
+	switch opts.Format {
+	case FormatJSON:
+		return writeJSON(out, reports)
+	case FormatMarkdown:
+		return writeJobReportMarkdown(out, reports)
+	default:
+		return writeJobReportTable(out, reports)
+	}
+}
+
+func (cus *Custodian) buildJobReports(
+	ctx context.Context,
+	opts JobReportOptions,
+) ([]JobReport, error) {
+	jobs, err := cus.clientset.BatchV1().
+		Jobs(cus.namespace).
+		List(ctx, metav1.ListOptions{LabelSelector: cus.label})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list jobs: %w", err)
+	}
+	var events []corev1.Event
+	reports := make([]JobReport, 0, len(jobs.Items))
+	for idx := range jobs.Items {
+		job := &jobs.Items[idx]
+		report := newJobReport(job)
+		if opts.FailedOnly && report.Status != JobStatusFailed {
+			continue
+		}
+		if report.Status == JobStatusFailed {
+			if events == nil {
+				events, err = cus.listEvents(ctx)
+				if err != nil {
+					return nil, err
+				}
+			}
+			failure, err := cus.jobFailure(ctx, job, events, opts)
+			if err != nil {
+				return nil, err
+			}
+			report.Failure = failure
+		}
+		reports = append(reports, report)
+	}
+	sort.Slice(reports, func(i, j int) bool {
+		return startedBefore(reports[j], reports[i])
+	})
+	return reports, nil
+}
+
+func startedBefore(first, second JobReport) bool {
+	if first.Started == nil || second.Started == nil {
+		return first.Started == nil && second.Started != nil
+	}
+	return first.Started.Before(*second.Started)
+}
+
+func newJobReport(job *batchv1.Job) JobReport {
+	report := JobReport{
+		Name:      job.Name,
+		Namespace: job.Namespace,
+		CronJob:   ownerName(job.OwnerReferences, "CronJob"),
+		Status:    jobStatus(job),
+		Active:    job.Status.Active,
+		Succeeded: job.Status.Succeeded,
+		Retries:   job.Status.Failed,
+	}
+	if job.Status.StartTime != nil {
+		started := job.Status.StartTime.Time
+		report.Started = &started
+	}
+	if finished := jobFinishTime(job); finished != nil {
+		report.Finished = finished
+	}
+	report.Duration = formatJobDuration(report.Started, report.Finished)
+	return report
+}
+
+func jobStatus(job *batchv1.Job) string {
+	if finished, failed := jobFinished(job); finished {
+		if failed {
+			return JobStatusFailed
+		}
+		return JobStatusComplete
+	}
+	if job.Spec.Suspend != nil && *job.Spec.Suspend {
+		return JobStatusSuspended
+	}
+	return JobStatusRunning
+}
+
+func jobFinishTime(job *batchv1.Job) *time.Time {
+	if job.Status.CompletionTime != nil {
+		finished := job.Status.CompletionTime.Time
+		return &finished
+	}
+	if cond := jobCondition(job, batchv1.JobFailed); cond != nil {
+		finished := cond.LastTransitionTime.Time
+		return &finished
+	}
+	return nil
+}
+
+func jobCondition(
+	job *batchv1.Job,
+	condType batchv1.JobConditionType,
+) *batchv1.JobCondition {
+	for idx := range job.Status.Conditions {
+		cond := &job.Status.Conditions[idx]
+		if cond.Type == condType && cond.Status == corev1.ConditionTrue {
+			return cond
+		}
+	}
+	return nil
+}
+
+func formatJobDuration(started, finished *time.Time) string {
+	if started == nil {
+		return ""
+	}
+	end := time.Now()
+	if finished != nil {
+		end = *finished
+	}
+	return end.Sub(*started).Round(time.Second).String()
+}
+
+func ownerName(owners []metav1.OwnerReference, kind string) string {
+	for _, owner := range owners {
+		if owner.Kind == kind {
+			return owner.Name
+		}
+	}
+	return ""
+}
+
+func (cus *Custodian) jobFailure(
+	ctx context.Context,
+	job *batchv1.Job,
+	events []corev1.Event,
+	opts JobReportOptions,
+) (*JobFailure, error) {
+	failure := &JobFailure{}
+	if cond := jobCondition(job, batchv1.JobFailed); cond != nil {
+		failure.Reason = cond.Reason
+		failure.Message = cond.Message
+	}
+	pods, err := cus.listPodsForJob(job.Name)
+	if err != nil {
+		return nil, fmt.Errorf(
+			"error listing pods for job %s: %w",
+			job.Name,
+			err,
+		)
+	}
+
+	objects := map[string]bool{"Job/" + job.Name: true}
+	for idx := range pods.Items {
+		pod := &pods.Items[idx]
+		objects["Pod/"+pod.Name] = true
+		failure.Pods = append(
+			failure.Pods,
+			cus.podFailures(ctx, pod, opts.LogLines)...,
+		)
+	}
+	failure.Events = recentEvents(events, objects, opts.EventCount)
+	return failure, nil
+}
+
+// podFailures returns the containers that terminated unsuccessfully, with
+// the last lines of their logs
+func (cus *Custodian) podFailures(
+	ctx context.Context,
+	pod *corev1.Pod,
+	logLines int64,
+) []PodFailure {
+	var failures []PodFailure
+	for _, status := range podContainerStatuses(pod) {
+		terminated := status.State.Terminated
+		previous := false
+		if terminated == nil || terminated.ExitCode == 0 {
+			terminated = status.LastTerminationState.Terminated
+			previous = true
+		}
+		if terminated == nil || terminated.ExitCode == 0 {
+			continue
+		}
+		failure := PodFailure{
+			Pod:       pod.Name,
+			Container: status.Name,
+			ExitCode:  terminated.ExitCode,
+			Reason:    terminated.Reason,
+		}
+		if logLines > 0 {
+			logs, err := cus.tailLogs(ctx, pod, status.Name, previous, logLines)
+			if err != nil {
+				cus.logger.Warn(
+					"Error getting logs for container",
+					"pod", pod.Name,
+					"container", status.Name,
+					"error", err,
+				)
+			}
+			failure.Logs = logs
+		}
+		failures = append(failures, failure)
+	}
+	return failures
+}
+
+func (cus *Custodian) tailLogs(
+	ctx context.Context,
+	pod *corev1.Pod,
+	container string,
+	previous bool,
+	lines int64,
+) ([]string, error) {
+	podLogs, err := cus.clientset.CoreV1().
+		Pods(pod.Namespace).
+		GetLogs(pod.Name, &corev1.PodLogOptions{
+			Container: container,
+			Previous:  previous,
+			TailLines: &lines,
+		}).
+		Stream(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("error in opening stream: %w", err)
+	}
+	defer podLogs.Close()
+
+	var logs []string
+	scanner := bufio.NewScanner(podLogs)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxLogLineSize)
+	for scanner.Scan() {
+		logs = append(logs, scanner.Text())
+	}
+	return logs, scanner.Err()
+}
+
+func (cus *Custodian) listEvents(ctx context.Context) ([]corev1.Event, error) {
+	events, err := cus.clientset.CoreV1().
+		Events(cus.namespace).
+		List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list events: %w", err)
+	}
+	return events.Items, nil
+}
+
+// recentEvents returns the latest events about the given Kind/name objects
+func recentEvents(
+	events []corev1.Event,
+	objects map[string]bool,
+	limit int,
+) []EventSummary {
+	var summaries []EventSummary
+	for idx := range events {
+		event := &events[idx]
+		object := event.InvolvedObject.Kind + "/" + event.InvolvedObject.Name
+		if !objects[object] {
+			continue
+		}
+		summaries = append(summaries, EventSummary{
+			LastSeen: eventTime(event),
+			Type:     event.Type,
+			Reason:   event.Reason,
+			Object:   object,
+			Count:    event.Count,
+			Message:  event.Message,
+		})
+	}
+	sort.Slice(summaries, func(i, j int) bool {
+		return summaries[i].LastSeen.Before(summaries[j].LastSeen)
+	})
+	if limit > 0 && len(summaries) > limit {
+		summaries = summaries[len(summaries)-limit:]
+	}
+	return summaries
+}
+
+// eventTime picks the most precise timestamp set on the event
+func eventTime(event *corev1.Event) time.Time {
+	switch {
+	case !event.LastTimestamp.IsZero():
+		return event.LastTimestamp.Time
+	case !event.EventTime.IsZero():
+		return event.EventTime.Time
+	default:
+		return event.CreationTimestamp.Time
+	}
+}
+
+func jobReportRows(reports []JobReport) [][]string {
+	rows := make([][]string, 0, len(reports))
+	for _, report := range reports {
+		reason := ""
+		if report.Failure != nil {
+			reason = report.Failure.Reason
+		}
+		rows = append(rows, []string{
+			report.Name,
+			valueOrDash(report.CronJob),
+			report.Status,
+			valueOrDash(formatTime(report.Started)),
+			valueOrDash(report.Duration),
+			strconv.Itoa(int(report.Retries)),
+			valueOrDash(reason),
+		})
+	}
+	return rows
+}
+
+var jobReportHeaders = []string{
+	"NAME", "CRONJOB", "STATUS", "STARTED", "DURATION", "RETRIES", "REASON",
+}
+
+func formatTime(value *time.Time) string {
+	if value == nil {
+		return ""
+	}
+	return value.UTC().Format(time.RFC3339)
+}
+
+func writeJobReportTable(out io.Writer, reports []JobReport) error {
+	if err := writeTable(out, FormatTable, jobReportHeaders, jobReportRows(reports)); err != nil {
+		return err
+	}
+	for _, report := range reports {
+		if report.Failure == nil {
+			continue
+		}
+		fmt.Fprintf(
+			out,
+			"\nJob %s failed: %s\n",
+			report.Name,
+			strings.TrimSpace(report.Failure.Reason+" "+report.Failure.Message),
+		)
+		for _, pod := range report.Failure.Pods {
+			fmt.Fprintf(
+				out,
+				"  %s/%s exit code %d (%s)\n",
+				pod.Pod,
+				pod.Container,
+				pod.ExitCode,
+				valueOrDash(pod.Reason),
+			)
+			for _, line := range pod.Logs {
+				fmt.Fprintf(out, "    | %s\n", line)
+			}
+		}
+		for _, event := range report.Failure.Events {
+			fmt.Fprintf(
+				out,
+				"  %s %s %s %s: %s\n",
+				event.LastSeen.UTC().Format(time.RFC3339),
+				event.Type,
+				event.Object,
+				event.Reason,
+				event.Message,
+			)
+		}
+	}
+	return nil
+}
+
+func writeJobReportMarkdown(out io.Writer, reports []JobReport) error {
+	if err := writeTable(out, FormatMarkdown, jobReportHeaders, jobReportRows(reports)); err != nil {
+		return err
+	}
+	for _, report := range reports {
+		if report.Failure == nil {
+			continue
+		}
+		fmt.Fprintf(out, "\n### Job `%s` failed\n\n", report.Name)
+		fmt.Fprintf(out, "**Reason:** %s\n\n", valueOrDash(markdownText(strings.TrimSpace(
+			report.Failure.Reason+" "+report.Failure.Message,
+		))))
+		for _, pod := range report.Failure.Pods {
+			fmt.Fprintf(
+				out,
+				"- `%s/%s` exit code %d (%s)\n",
+				pod.Pod,
+				pod.Container,
+				pod.ExitCode,
+				valueOrDash(pod.Reason),
+			)
+			if len(pod.Logs) > 0 {
+				fence := markdownFence(pod.Logs)
+				fmt.Fprintf(out, "\n%s\n%s\n%s\n\n", fence, strings.Join(pod.Logs, "\n"), fence)
+			}
+		}
+		if len(report.Failure.Events) == 0 {
+			continue
+		}
+		rows := make([][]string, 0, len(report.Failure.Events))
+		for _, event := range report.Failure.Events {
+			rows = append(rows, []string{
+				event.LastSeen.UTC().Format(time.RFC3339),
+				event.Type,
+				event.Object,
+				event.Reason,
+				event.Message,
+			})
+		}
+		fmt.Fprintln(out)
+		if err := writeMarkdownTable(
+			out,
+			[]string{"LAST SEEN", "TYPE", "OBJECT", "REASON", "MESSAGE"},
+			rows,
+		); err != nil {
+			return err
+		}
+	}
+	return nil
+}
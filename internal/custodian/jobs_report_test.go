@@ -0,0 +1,193 @@
+package custodian
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func reportJob(name string, started time.Time, status batchv1.JobStatus) *batchv1.Job {
+	startTime := metav1.NewTime(started)
+	status.StartTime = &startTime
+	return &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: "dev",
+			Labels:    map[string]string{"app": "loader"},
+			OwnerReferences: []metav1.OwnerReference{
+				{Kind: "CronJob", Name: "loader"},
+			},
+		},
+		Status: status,
+	}
+}
+
+func jobsReportCustodian() *Custodian {
+	started := time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC)
+	completed := metav1.NewTime(started.Add(5 * time.Minute))
+	suspend := true
+	suspended := reportJob("load-ontology", started.Add(-time.Hour), batchv1.JobStatus{})
+	suspended.Spec.Suspend = &suspend
+	clientset := fake.NewSimpleClientset(
+		reportJob("load-uniprot", started, batchv1.JobStatus{
+			Failed: 2,
+			Conditions: []batchv1.JobCondition{{
+				Type:               batchv1.JobFailed,
+				Status:             corev1.ConditionTrue,
+				Reason:             "BackoffLimitExceeded",
+				Message:            "Job has reached the specified backoff limit",
+				LastTransitionTime: completed,
+			}},
+		}),
+		reportJob("load-stock", started.Add(-2*time.Hour), batchv1.JobStatus{
+			Succeeded:      1,
+			CompletionTime: &completed,
+			Conditions: []batchv1.JobCondition{
+				{Type: batchv1.JobComplete, Status: corev1.ConditionTrue},
+			},
+		}),
+		reportJob("load-strain", started.Add(-3*time.Hour), batchv1.JobStatus{Active: 1}),
+		suspended,
+		jobPod(),
+		&corev1.Event{
+			ObjectMeta:     metav1.ObjectMeta{Name: "backoff", Namespace: "dev"},
+			InvolvedObject: corev1.ObjectReference{Kind: "Job", Name: "load-uniprot"},
+			Type:           corev1.EventTypeWarning,
+			Reason:         "BackoffLimitExceeded",
+			Message:        "Job has reached the specified backoff limit\n```\nretry | 2",
+			Count:          1,
+			LastTimestamp:  completed,
+		},
+		&corev1.Event{
+			ObjectMeta:     metav1.ObjectMeta{Name: "scheduled", Namespace: "dev"},
+			InvolvedObject: corev1.ObjectReference{Kind: "Job", Name: "load-stock"},
+			Type:           corev1.EventTypeNormal,
+			Reason:         "Completed",
+		},
+	)
+	return &Custodian{
+		clientset: clientset,
+		namespace: "dev",
+		label:     "app=loader",
+		logger:    testLogger(),
+	}
+}
+
+func TestJobsReportClassifiesJobs(t *testing.T) {
+	cus := jobsReportCustodian()
+	reports, err := cus.buildJobReports(context.Background(), JobReportOptions{
+		LogLines:   10,
+		EventCount: 5,
+	})
+	require.NoError(t, err)
+	require.Len(t, reports, 4)
+
+	statuses := make(map[string]string)
+	for _, report := range reports {
+		statuses[report.Name] = report.Status
+	}
+	assert.Equal(t, map[string]string{
+		"load-uniprot":  JobStatusFailed,
+		"load-stock":    JobStatusComplete,
+		"load-strain":   JobStatusRunning,
+		"load-ontology": JobStatusSuspended,
+	}, statuses)
+	assert.Equal(t, "load-uniprot", reports[0].Name, "the latest job comes first")
+
+	failed := reports[0]
+	assert.Equal(t, "loader", failed.CronJob)
+	assert.Equal(t, int32(2), failed.Retries)
+	assert.Equal(t, "5m0s", failed.Duration)
+	require.NotNil(t, failed.Failure)
+	assert.Equal(t, "BackoffLimitExceeded", failed.Failure.Reason)
+	require.Len(t, failed.Failure.Pods, 2)
+	assert.Equal(t, "init", failed.Failure.Pods[0].Container)
+	assert.Equal(t, int32(1), failed.Failure.Pods[0].ExitCode)
+	assert.Equal(t, []string{"fake logs"}, failed.Failure.Pods[0].Logs)
+	assert.Equal(t, "loader", failed.Failure.Pods[1].Container)
+	require.Len(t, failed.Failure.Events, 1, "only the events of the failed job")
+	assert.Equal(t, "Job/load-uniprot", failed.Failure.Events[0].Object)
+	for _, report := range reports[1:] {
+		assert.Nil(t, report.Failure, report.Name)
+	}
+
+	reports, err = cus.buildJobReports(context.Background(), JobReportOptions{FailedOnly: true})
+	require.NoError(t, err)
+	require.Len(t, reports, 1)
+	assert.Nil(t, reports[0].Failure.Pods[0].Logs, "no logs without log lines")
+}
+
+func TestJobsReportFormats(t *testing.T) {
+	cus := jobsReportCustodian()
+	opts := JobReportOptions{LogLines: 10, EventCount: 5}
+
+	t.Run("table", func(t *testing.T) {
+		out := &bytes.Buffer{}
+		require.NoError(t, cus.JobsReport(context.Background(), opts, out))
+		lines := strings.Split(out.String(), "\n")
+		assert.Regexp(t, `^NAME\s+CRONJOB\s+STATUS\s+STARTED\s+DURATION\s+RETRIES\s+REASON`, lines[0])
+		assert.Regexp(t, `^load-uniprot\s+loader\s+Failed\s+2026-10-19T12:00:00Z\s+5m0s\s+2\s+BackoffLimitExceeded`, lines[1])
+		assert.Contains(t, out.String(), "Job load-uniprot failed: BackoffLimitExceeded Job has reached")
+		assert.Contains(t, out.String(), "  load-uniprot-abcde/init exit code 1 (-)\n    | fake logs\n")
+	})
+
+	t.Run("markdown", func(t *testing.T) {
+		opts := opts
+		opts.Format = FormatMarkdown
+		out := &bytes.Buffer{}
+		require.NoError(t, cus.JobsReport(context.Background(), opts, out))
+		assert.Contains(t, out.String(), "| NAME | CRONJOB | STATUS |")
+		assert.Contains(t, out.String(), "| load-strain | loader | Running |")
+		assert.Contains(t, out.String(), "### Job `load-uniprot` failed")
+		assert.Contains(t, out.String(), "\n```\nfake logs\n```\n")
+		assert.Contains(
+			t,
+			out.String(),
+			"| Job/load-uniprot | BackoffLimitExceeded | Job has reached the specified backoff limit<br>\\`\\`\\`<br>retry \\| 2 |",
+			"the event message stays in its table row",
+		)
+	})
+
+	t.Run("json", func(t *testing.T) {
+		opts := opts
+		opts.Format = FormatJSON
+		out := &bytes.Buffer{}
+		require.NoError(t, cus.JobsReport(context.Background(), opts, out))
+		var reports []JobReport
+		require.NoError(t, json.Unmarshal(out.Bytes(), &reports))
+		require.Len(t, reports, 4)
+		assert.Equal(t, JobStatusFailed, reports[0].Status)
+		assert.Equal(t, "BackoffLimitExceeded", reports[0].Failure.Reason)
+		assert.Equal(t, []string{"fake logs"}, reports[0].Failure.Pods[0].Logs)
+	})
+}
+
+func TestJobReportMarkdownFencesLogs(t *testing.T) {
+	out := &bytes.Buffer{}
+	require.NoError(t, writeJobReportMarkdown(out, []JobReport{{
+		Name:   "load-uniprot",
+		Status: JobStatusFailed,
+		Failure: &JobFailure{
+			Reason:  "BackoffLimitExceeded",
+			Message: "first line\nsecond line",
+			Pods: []PodFailure{{
+				Pod:       "load-uniprot-abcde",
+				Container: "loader",
+				ExitCode:  1,
+				Logs:      []string{"```", "error: ````quoted````"},
+			}},
+		},
+	}}))
+	assert.Contains(t, out.String(), "**Reason:** BackoffLimitExceeded first line<br>second line\n")
+	assert.Contains(t, out.String(), "\n`````\n```\nerror: ````quoted````\n`````\n")
+}
@@ -0,0 +1,203 @@
+package custodian
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// LogOptions controls which pod logs are extracted and where they are written
+type LogOptions struct {
+	// OutputDir, when set, receives one file per container as
+	// <job>/<pod>/<container>[.previous].log
+	OutputDir  string
+	Since      time.Duration
+	Tail       int64
+	Timestamps bool
+	// NoColor disables the colored prefixes in follow mode
+	NoColor bool
+}
+
+// podLogOptions builds the log request for a container, an empty container
+// name selects the default container of the pod
+func (cus *Custodian) podLogOptions(
+	container string,
+	previous bool,
+) *corev1.PodLogOptions {
+	opts := &corev1.PodLogOptions{
+		Container:  container,
+		Previous:   previous,
+		Timestamps: cus.logOptions.Timestamps,
+	}
+	if cus.logOptions.Since > 0 {
+		seconds := int64(cus.logOptions.Since.Seconds())
+		opts.SinceSeconds = &seconds
+	}
+	if cus.logOptions.Tail > 0 {
+		tail := cus.logOptions.Tail
+		opts.TailLines = &tail
+	}
+	return opts
+}
+
+func (cus *Custodian) writeJobLogs(
+	outputDir, jobName string,
+	pods *corev1.PodList,
+) error {
+	var errs []error
+	for idx := range pods.Items {
+		errs = append(errs, cus.writePodLogs(outputDir, jobName, &pods.Items[idx]))
+	}
+	return errors.Join(errs...)
+}
+
+// writePodLogs writes the current and, for restarted containers, the
+// previous logs of every init and regular container in the pod. It keeps
+// going when a container fails and returns the errors of all of them.
+func (cus *Custodian) writePodLogs(
+	outputDir, jobName string,
+	pod *corev1.Pod,
+) error {
+	dir := filepath.Join(outputDir, jobName, pod.Name)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return fmt.Errorf("failed to create log directory %s: %w", dir, err)
+	}
+
+	var errs []error
+	for _, status := range podContainerStatuses(pod) {
+		if !hasStarted(status) {
+			cus.logger.Warn(
+				"Skipping container that has not started",
+				"pod", pod.Name,
+				"container", status.Name,
+			)
+			continue
+		}
+		errs = append(errs, cus.writeContainerLog(pod, status.Name, false, dir))
+		if hasPreviousRun(status) {
+			errs = append(errs, cus.writeContainerLog(pod, status.Name, true, dir))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (cus *Custodian) writeContainerLog(
+	pod *corev1.Pod,
+	container string,
+	previous bool,
+	dir string,
+) error {
+	name := container + ".log"
+	if previous {
+		name = container + ".previous.log"
+	}
+	path := filepath.Join(dir, name)
+	written, err := cus.streamLogToFile(
+		pod,
+		cus.podLogOptions(container, previous),
+		path,
+	)
+	if err != nil {
+		cus.logger.Error(
+			"Error writing logs for container",
+			"pod", pod.Name,
+			"container", container,
+			"previous", previous,
+			"error", err,
+		)
+		return fmt.Errorf(
+			"failed to write logs of container %s in pod %s: %w",
+			container,
+			pod.Name,
+			err,
+		)
+	}
+	cus.logger.Info(
+		"Wrote container logs",
+		"pod", pod.Name,
+		"container", container,
+		"file", path,
+		"bytes", written,
+	)
+	return nil
+}
+
+func (cus *Custodian) streamLogToFile(
+	pod *corev1.Pod,
+	opts *corev1.PodLogOptions,
+	path string,
+) (int64, error) {
+	podLogs, err := cus.clientset.CoreV1().
+		Pods(pod.Namespace).
+		GetLogs(pod.Name, opts).
+		Stream(context.TODO())
+	if err != nil {
+		return 0, fmt.Errorf("error in opening stream: %w", err)
+	}
+	defer podLogs.Close()
+
+	file, err := os.Create(path)
+	if err != nil {
+		return 0, fmt.Errorf("error in creating log file: %w", err)
+	}
+	defer file.Close()
+
+	written, err := io.Copy(file, podLogs)
+	if err != nil {
+		return written, fmt.Errorf("error in copying logs to %s: %w", path, err)
+	}
+	return written, nil
+}
+
+// podContainerStatuses returns the statuses of the init containers followed
+// by the regular containers. Containers without a status yet are included
+// with an empty state.
+func podContainerStatuses(pod *corev1.Pod) []corev1.ContainerStatus {
+	known := make(map[string]corev1.ContainerStatus)
+	for _, list := range [][]corev1.ContainerStatus{
+		pod.Status.InitContainerStatuses,
+		pod.Status.ContainerStatuses,
+	} {
+		for _, status := range list {
+			known[status.Name] = status
+		}
+	}
+
+	var statuses []corev1.ContainerStatus
+	for _, container := range podContainers(pod) {
+		status, ok := known[container.Name]
+		if !ok {
+			status = corev1.ContainerStatus{Name: container.Name}
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses
+}
+
+func hasStarted(status corev1.ContainerStatus) bool {
+	return status.State.Running != nil ||
+		status.State.Terminated != nil ||
+		status.LastTerminationState.Terminated != nil
+}
+
+func hasPreviousRun(status corev1.ContainerStatus) bool {
+	return status.RestartCount > 0 ||
+		status.LastTerminationState.Terminated != nil
+}
+
+// podContainers returns the init containers followed by the regular ones
+func podContainers(pod *corev1.Pod) []corev1.Container {
+	containers := make(
+		[]corev1.Container,
+		0,
+		len(pod.Spec.InitContainers)+len(pod.Spec.Containers),
+	)
+	containers = append(containers, pod.Spec.InitContainers...)
+	return append(containers, pod.Spec.Containers...)
+}
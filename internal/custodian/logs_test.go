@@ -0,0 +1,333 @@
+package custodian
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/kubernetes/scheme"
+	corev1client "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/rest"
+	fakerest "k8s.io/client-go/rest/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func testLogger() *slog.Logger {
+	return slog.New(slog.NewTextHandler(io.Discard, nil))
+}
+
+func jobPod() *corev1.Pod {
+	terminated := &corev1.ContainerStateTerminated{ExitCode: 1}
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "load-uniprot-abcde",
+			Namespace: "dev",
+			Labels:    map[string]string{"job-name": "load-uniprot"},
+		},
+		Spec: corev1.PodSpec{
+			InitContainers: []corev1.Container{{Name: "init"}},
+			Containers: []corev1.Container{
+				{Name: "loader"},
+				{Name: "sidecar"},
+			},
+		},
+		Status: corev1.PodStatus{
+			InitContainerStatuses: []corev1.ContainerStatus{
+				{Name: "init", State: corev1.ContainerState{Terminated: terminated}},
+			},
+			ContainerStatuses: []corev1.ContainerStatus{
+				{
+					Name:                 "loader",
+					RestartCount:         1,
+					State:                corev1.ContainerState{Running: &corev1.ContainerStateRunning{}},
+					LastTerminationState: corev1.ContainerState{Terminated: terminated},
+				},
+				{
+					Name:  "sidecar",
+					State: corev1.ContainerState{Waiting: &corev1.ContainerStateWaiting{}},
+				},
+			},
+		},
+	}
+}
+
+func TestSearchAndExtractLogsToOutputDir(t *testing.T) {
+	job := &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "load-uniprot",
+			Namespace: "dev",
+			Labels:    map[string]string{"app": "loader"},
+		},
+	}
+	client := fake.NewSimpleClientset(job, jobPod())
+	outputDir := t.TempDir()
+	cus := &Custodian{
+		clientset: client,
+		namespace: "dev",
+		label:     "app=loader",
+		logger:    testLogger(),
+		logOptions: LogOptions{
+			OutputDir:  outputDir,
+			Since:      time.Hour,
+			Tail:       100,
+			Timestamps: true,
+		},
+	}
+
+	require.NoError(t, cus.SearchAndExtractLogs(nil))
+
+	podDir := filepath.Join(outputDir, "load-uniprot", "load-uniprot-abcde")
+	for _, name := range []string{"init.log", "loader.log", "loader.previous.log"} {
+		content, err := os.ReadFile(filepath.Join(podDir, name))
+		require.NoError(t, err, name)
+		assert.Equal(t, "fake logs", string(content))
+	}
+	_, err := os.Stat(filepath.Join(podDir, "sidecar.log"))
+	assert.True(t, os.IsNotExist(err), "waiting container has no log file")
+
+	var logOpts []*corev1.PodLogOptions
+	for _, action := range client.Actions() {
+		if action.GetSubresource() != "log" {
+			continue
+		}
+		generic, ok := action.(k8stesting.GenericAction)
+		require.True(t, ok)
+		logOpts = append(logOpts, generic.GetValue().(*corev1.PodLogOptions))
+	}
+	require.Len(t, logOpts, 3)
+	for _, opts := range logOpts {
+		assert.True(t, opts.Timestamps)
+		assert.Equal(t, int64(3600), *opts.SinceSeconds)
+		assert.Equal(t, int64(100), *opts.TailLines)
+	}
+	assert.True(t, logOpts[2].Previous)
+	assert.Equal(t, "loader", logOpts[2].Container)
+}
+
+// failingLogsClientset is a fake clientset whose pod log streams fail
+type failingLogsClientset struct {
+	*fake.Clientset
+}
+
+func (cs failingLogsClientset) CoreV1() corev1client.CoreV1Interface {
+	return failingLogsCoreV1{cs.Clientset.CoreV1()}
+}
+
+type failingLogsCoreV1 struct {
+	corev1client.CoreV1Interface
+}
+
+func (core failingLogsCoreV1) Pods(namespace string) corev1client.PodInterface {
+	return failingLogsPods{core.CoreV1Interface.Pods(namespace)}
+}
+
+type failingLogsPods struct {
+	corev1client.PodInterface
+}
+
+func (pods failingLogsPods) GetLogs(name string, _ *corev1.PodLogOptions) *rest.Request {
+	client := &fakerest.RESTClient{
+		Client: fakerest.CreateHTTPClient(func(*http.Request) (*http.Response, error) {
+			return nil, errors.New("connection reset by peer")
+		}),
+		NegotiatedSerializer: scheme.Codecs.WithoutConversion(),
+		GroupVersion:         corev1.SchemeGroupVersion,
+		VersionedAPIPath:     "/api/v1/namespaces/dev/pods/" + name + "/log",
+	}
+	return client.Request()
+}
+
+func TestSearchAndExtractLogsReturnsStreamErrors(t *testing.T) {
+	job := &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "load-uniprot",
+			Namespace: "dev",
+			Labels:    map[string]string{"app": "loader"},
+		},
+	}
+	for _, outputDir := range []string{t.TempDir(), ""} {
+		cus := &Custodian{
+			clientset:  failingLogsClientset{fake.NewSimpleClientset(job, jobPod())},
+			namespace:  "dev",
+			label:      "app=loader",
+			logger:     testLogger(),
+			logOptions: LogOptions{OutputDir: outputDir},
+		}
+		err := cus.SearchAndExtractLogs(nil)
+		require.Error(t, err, "output dir %q", outputDir)
+		assert.Contains(t, err.Error(), "connection reset by peer")
+	}
+}
+
+func TestFollowJobLogsReturnsJobStatus(t *testing.T) {
+	job := &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "load-uniprot",
+			Namespace: "dev",
+			Labels:    map[string]string{"app": "loader"},
+		},
+	}
+	client := fake.NewSimpleClientset(job, jobPod())
+	cus := &Custodian{
+		clientset:  client,
+		namespace:  "dev",
+		label:      "app=loader",
+		logger:     testLogger(),
+		logOptions: LogOptions{NoColor: true},
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	out := &syncBuffer{}
+	errc := make(chan error, 1)
+	go func() { errc <- cus.FollowJobLogs(ctx, out) }()
+
+	require.Eventually(t, func() bool {
+		return strings.Contains(out.String(), "[load-uniprot-abcde/loader] fake logs")
+	}, 5*time.Second, 50*time.Millisecond)
+
+	job.Status.Conditions = []batchv1.JobCondition{
+		{Type: batchv1.JobFailed, Status: corev1.ConditionTrue},
+	}
+	_, err := client.BatchV1().
+		Jobs("dev").
+		UpdateStatus(ctx, job, metav1.UpdateOptions{})
+	require.NoError(t, err)
+
+	err = <-errc
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "job load-uniprot failed")
+	assert.Contains(t, out.String(), "[load-uniprot-abcde/init] fake logs")
+}
+
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (sbf *syncBuffer) Write(p []byte) (int, error) {
+	sbf.mu.Lock()
+	defer sbf.mu.Unlock()
+	return sbf.buf.Write(p)
+}
+
+func (sbf *syncBuffer) String() string {
+	sbf.mu.Lock()
+	defer sbf.mu.Unlock()
+	return sbf.buf.String()
+}
+
+func TestFollowJobLogsWaitsForEveryListedJob(t *testing.T) {
+	completed := &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "load-uniprot",
+			Namespace: "dev",
+			Labels:    map[string]string{"app": "loader"},
+		},
+		Status: batchv1.JobStatus{Conditions: []batchv1.JobCondition{
+			{Type: batchv1.JobComplete, Status: corev1.ConditionTrue},
+		}},
+	}
+	running := &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "load-ontology",
+			Namespace: "dev",
+			Labels:    map[string]string{"app": "loader"},
+		},
+	}
+	client := fake.NewSimpleClientset(completed, running)
+	cus := &Custodian{
+		clientset:  client,
+		namespace:  "dev",
+		label:      "app=loader",
+		logger:     testLogger(),
+		logOptions: LogOptions{NoColor: true},
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	errc := make(chan error, 1)
+	go func() { errc <- cus.FollowJobLogs(ctx, &syncBuffer{}) }()
+
+	select {
+	case err := <-errc:
+		t.Fatalf("follow returned while a job is running: %v", err)
+	case <-time.After(500 * time.Millisecond):
+	}
+
+	running.Status.Conditions = []batchv1.JobCondition{
+		{Type: batchv1.JobComplete, Status: corev1.ConditionTrue},
+	}
+	_, err := client.BatchV1().
+		Jobs("dev").
+		UpdateStatus(ctx, running, metav1.UpdateOptions{})
+	require.NoError(t, err)
+	require.NoError(t, <-errc)
+}
+
+func TestFollowJobLogsEndsWhenJobIsDeleted(t *testing.T) {
+	running := &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "load-ontology",
+			Namespace: "dev",
+			Labels:    map[string]string{"app": "loader"},
+		},
+	}
+	client := fake.NewSimpleClientset(running)
+	cus := &Custodian{
+		clientset:  client,
+		namespace:  "dev",
+		label:      "app=loader",
+		logger:     testLogger(),
+		logOptions: LogOptions{NoColor: true},
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	errc := make(chan error, 1)
+	go func() { errc <- cus.FollowJobLogs(ctx, &syncBuffer{}) }()
+
+	select {
+	case err := <-errc:
+		t.Fatalf("follow returned while the job is running: %v", err)
+	case <-time.After(500 * time.Millisecond):
+	}
+
+	require.NoError(t, client.BatchV1().
+		Jobs("dev").
+		Delete(ctx, running.Name, metav1.DeleteOptions{}))
+	err := <-errc
+	assert.ErrorContains(t, err, "job load-ontology was deleted before it finished")
+	assert.NotErrorIs(t, err, context.DeadlineExceeded)
+}
+
+func TestFollowJobLogsWithoutMatchingJobs(t *testing.T) {
+	cus := &Custodian{
+		clientset:  fake.NewSimpleClientset(),
+		namespace:  "dev",
+		label:      "app=loader",
+		logger:     testLogger(),
+		logOptions: LogOptions{NoColor: true},
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	err := cus.FollowJobLogs(ctx, &syncBuffer{})
+	assert.ErrorContains(t, err, "no jobs match app=loader in namespace dev")
+	assert.NoError(t, ctx.Err(), "follow returns without waiting")
+}
@@ -0,0 +1,84 @@
+package custodian
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+// ruleCounter counts per rule, exposed as a labelled prometheus counter
+type ruleCounter struct {
+	name   string
+	help   string
+	mu     sync.Mutex
+	values map[string]int64
+}
+
+func newRuleCounter(name, help string) *ruleCounter {
+	return &ruleCounter{name: name, help: help, values: make(map[string]int64)}
+}
+
+func (rcn *ruleCounter) inc(rule string) {
+	rcn.mu.Lock()
+	defer rcn.mu.Unlock()
+	rcn.values[rule]++
+}
+
+func (rcn *ruleCounter) get(rule string) int64 {
+	rcn.mu.Lock()
+	defer rcn.mu.Unlock()
+	return rcn.values[rule]
+}
+
+func (rcn *ruleCounter) write(out io.Writer) {
+	rcn.mu.Lock()
+	defer rcn.mu.Unlock()
+	fmt.Fprintf(out, "# HELP %s %s\n# TYPE %s counter\n", rcn.name, rcn.help, rcn.name)
+	rules := make([]string, 0, len(rcn.values))
+	for rule := range rcn.values {
+		rules = append(rules, rule)
+	}
+	sort.Strings(rules)
+	for _, rule := range rules {
+		fmt.Fprintf(out, "%s{rule=%q} %d\n", rcn.name, rule, rcn.values[rule])
+	}
+}
+
+// controllerMetrics are served in the prometheus text format
+type controllerMetrics struct {
+	events  *ruleCounter
+	patches *ruleCounter
+	errors  *ruleCounter
+	leader  atomic.Int64
+}
+
+func newControllerMetrics() *controllerMetrics {
+	return &controllerMetrics{
+		events: newRuleCounter(
+			"custodian_controller_events_total",
+			"Add and update events handled by a rule.",
+		),
+		patches: newRuleCounter(
+			"custodian_controller_patches_total",
+			"Resources patched by a rule.",
+		),
+		errors: newRuleCounter(
+			"custodian_controller_errors_total",
+			"Errors applying a rule.",
+		),
+	}
+}
+
+func (mtr *controllerMetrics) write(out io.Writer) {
+	mtr.events.write(out)
+	mtr.patches.write(out)
+	mtr.errors.write(out)
+	fmt.Fprintf(
+		out,
+		"# HELP custodian_controller_leader Whether this replica is the active controller.\n"+
+			"# TYPE custodian_controller_leader gauge\ncustodian_controller_leader %d\n",
+		mtr.leader.Load(),
+	)
+}
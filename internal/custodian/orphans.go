@@ -0,0 +1,295 @@
+package custodian
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"sort"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// gceCSIDriver is the CSI driver of GCE persistent disks
+const gceCSIDriver = "pd.csi.storage.gke.io"
+
+// Reasons for reporting a volume as orphaned
+const (
+	OrphanNotMounted = "not mounted by any pod"
+	OrphanReleased   = "released"
+	OrphanFailed     = "failed"
+	OrphanUnclaimed  = "gce disk without claim"
+)
+
+// Orphan is a claim or volume that is probably no longer used
+type Orphan struct {
+	Kind         string    `json:"kind"`
+	Namespace    string    `json:"namespace,omitempty"`
+	Name         string    `json:"name"`
+	Reason       string    `json:"reason"`
+	Size         string    `json:"size"`
+	StorageClass string    `json:"storageClass"`
+	Disk         string    `json:"disk,omitempty"`
+	Created      time.Time `json:"created"`
+}
+
+// FindOrphans reports the claims of the namespaces not mounted by any
+// running pod, and the volumes that are released, failed or backed by a GCE
+// disk without a claim. Volumes are limited to the ones claimed from the
+// namespaces, unclaimed volumes are always included.
+func (cus *Custodian) FindOrphans(
+	ctx context.Context,
+	namespaces []string,
+) ([]Orphan, error) {
+	if len(namespaces) == 0 {
+		namespaces = []string{metav1.NamespaceAll}
+	}
+	var orphans []Orphan
+	for _, namespace := range namespaces {
+		claims, err := cus.unmountedClaims(ctx, namespace)
+		if err != nil {
+			return nil, err
+		}
+		orphans = append(orphans, claims...)
+	}
+
+	volumes, err := cus.orphanedVolumes(ctx, namespaces)
+	if err != nil {
+		return nil, err
+	}
+	orphans = append(orphans, volumes...)
+	sort.SliceStable(orphans, func(i, j int) bool {
+		return orphans[i].Created.Before(orphans[j].Created)
+	})
+	return orphans, nil
+}
+
+func (cus *Custodian) unmountedClaims(
+	ctx context.Context,
+	namespace string,
+) ([]Orphan, error) {
+	pods, err := cus.clientset.CoreV1().
+		Pods(namespace).
+		List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list pods: %w", err)
+	}
+	mounted := make(map[string]bool)
+	for _, pod := range pods.Items {
+		if pod.Status.Phase == corev1.PodSucceeded ||
+			pod.Status.Phase == corev1.PodFailed {
+			continue
+		}
+		for _, volume := range pod.Spec.Volumes {
+			if claim := volume.PersistentVolumeClaim; claim != nil {
+				mounted[pod.Namespace+"/"+claim.ClaimName] = true
+			}
+			if volume.Ephemeral != nil {
+				mounted[pod.Namespace+"/"+pod.Name+"-"+volume.Name] = true
+			}
+		}
+	}
+
+	claims, err := cus.clientset.CoreV1().
+		PersistentVolumeClaims(namespace).
+		List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list persistent volume claims: %w", err)
+	}
+	var orphans []Orphan
+	for _, claim := range claims.Items {
+		if mounted[claim.Namespace+"/"+claim.Name] {
+			continue
+		}
+		size := claim.Status.Capacity[corev1.ResourceStorage]
+		if size.IsZero() {
+			size = claim.Spec.Resources.Requests[corev1.ResourceStorage]
+		}
+		storageClass := ""
+		if claim.Spec.StorageClassName != nil {
+			storageClass = *claim.Spec.StorageClassName
+		}
+		orphans = append(orphans, Orphan{
+			Kind:         "PersistentVolumeClaim",
+			Namespace:    claim.Namespace,
+			Name:         claim.Name,
+			Reason:       OrphanNotMounted,
+			Size:         size.String(),
+			StorageClass: storageClass,
+			Created:      claim.CreationTimestamp.Time,
+		})
+	}
+	return orphans, nil
+}
+
+func (cus *Custodian) orphanedVolumes(
+	ctx context.Context,
+	namespaces []string,
+) ([]Orphan, error) {
+	volumes, err := cus.clientset.CoreV1().
+		PersistentVolumes().
+		List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list persistent volumes: %w", err)
+	}
+	var orphans []Orphan
+	for idx := range volumes.Items {
+		volume := &volumes.Items[idx]
+		claim := volume.Spec.ClaimRef
+		if claim != nil && !slices.Contains(namespaces, metav1.NamespaceAll) &&
+			!slices.Contains(namespaces, claim.Namespace) {
+			continue
+		}
+		reason, err := cus.volumeOrphanReason(ctx, volume)
+		if err != nil {
+			return nil, err
+		}
+		if len(reason) == 0 {
+			continue
+		}
+		size := volume.Spec.Capacity[corev1.ResourceStorage]
+		orphans = append(orphans, Orphan{
+			Kind:         "PersistentVolume",
+			Name:         volume.Name,
+			Reason:       reason,
+			Size:         size.String(),
+			StorageClass: volume.Spec.StorageClassName,
+			Disk:         gceDisk(volume),
+			Created:      volume.CreationTimestamp.Time,
+		})
+	}
+	return orphans, nil
+}
+
+// volumeOrphanReason returns why the volume is orphaned, empty if it is
+// not
+func (cus *Custodian) volumeOrphanReason(
+	ctx context.Context,
+	volume *corev1.PersistentVolume,
+) (string, error) {
+	switch volume.Status.Phase {
+	case corev1.VolumeReleased:
+		return OrphanReleased, nil
+	case corev1.VolumeFailed:
+		return OrphanFailed, nil
+	}
+	if len(gceDisk(volume)) == 0 {
+		return "", nil
+	}
+	claim := volume.Spec.ClaimRef
+	if claim == nil {
+		return OrphanUnclaimed, nil
+	}
+	_, err := cus.clientset.CoreV1().
+		PersistentVolumeClaims(claim.Namespace).
+		Get(ctx, claim.Name, metav1.GetOptions{})
+	switch {
+	case apierrors.IsNotFound(err):
+		return OrphanUnclaimed, nil
+	case err != nil:
+		return "", fmt.Errorf("failed to get claim %s: %w", claim.Name, err)
+	default:
+		return "", nil
+	}
+}
+
+// gceDisk returns the GCE disk backing the volume, empty for other volumes
+func gceDisk(volume *corev1.PersistentVolume) string {
+	if disk := volume.Spec.GCEPersistentDisk; disk != nil {
+		return disk.PDName
+	}
+	if csi := volume.Spec.CSI; csi != nil && csi.Driver == gceCSIDriver {
+		return csi.VolumeHandle
+	}
+	return ""
+}
+
+// DeleteOrphans deletes the claims first, then the volumes, so that
+// volumes released by a deleted claim are handled by their reclaim policy
+func (cus *Custodian) DeleteOrphans(
+	ctx context.Context,
+	orphans []Orphan,
+	dryRun bool,
+) error {
+	sorted := slices.Clone(orphans)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Kind == "PersistentVolumeClaim" &&
+			sorted[j].Kind != "PersistentVolumeClaim"
+	})
+
+	var errs []error
+	for _, orphan := range sorted {
+		if dryRun {
+			cus.logger.Info(
+				"Dry run: would delete",
+				"kind", orphan.Kind,
+				"namespace", orphan.Namespace,
+				"name", orphan.Name,
+				"size", orphan.Size,
+			)
+			continue
+		}
+		var err error
+		if orphan.Kind == "PersistentVolumeClaim" {
+			err = cus.clientset.CoreV1().
+				PersistentVolumeClaims(orphan.Namespace).
+				Delete(ctx, orphan.Name, metav1.DeleteOptions{})
+		} else {
+			err = cus.clientset.CoreV1().
+				PersistentVolumes().
+				Delete(ctx, orphan.Name, metav1.DeleteOptions{})
+		}
+		if err != nil && !apierrors.IsNotFound(err) {
+			cus.logger.Error(
+				"Error deleting orphan",
+				"kind", orphan.Kind,
+				"name", orphan.Name,
+				"error", err,
+			)
+			errs = append(errs, fmt.Errorf(
+				"failed to delete %s %s: %w",
+				orphan.Kind,
+				orphan.Name,
+				err,
+			))
+			continue
+		}
+		cus.logger.Info(
+			"Deleted",
+			"kind", orphan.Kind,
+			"namespace", orphan.Namespace,
+			"name", orphan.Name,
+		)
+	}
+	return errors.Join(errs...)
+}
+
+// WriteOrphans writes the orphans in the given format
+func WriteOrphans(out io.Writer, format string, orphans []Orphan) error {
+	if format == FormatJSON {
+		if orphans == nil {
+			orphans = []Orphan{}
+		}
+		return writeJSON(out, orphans)
+	}
+	rows := make([][]string, 0, len(orphans))
+	for _, orphan := range orphans {
+		rows = append(rows, []string{
+			orphan.Kind,
+			valueOrDash(orphan.Namespace),
+			orphan.Name,
+			orphan.Reason,
+			valueOrDash(orphan.Size),
+			valueOrDash(orphan.StorageClass),
+			formatAge(time.Since(orphan.Created)),
+			valueOrDash(orphan.Disk),
+		})
+	}
+	return writeTable(out, format, []string{
+		"KIND", "NAMESPACE", "NAME", "REASON", "SIZE", "STORAGE CLASS", "AGE", "DISK",
+	}, rows)
+}
@@ -0,0 +1,94 @@
+package custodian
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func gcePersistentVolume(
+	name string,
+	phase corev1.PersistentVolumePhase,
+	claim *corev1.ObjectReference,
+) *corev1.PersistentVolume {
+	return &corev1.PersistentVolume{
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+		Spec: corev1.PersistentVolumeSpec{
+			Capacity: corev1.ResourceList{
+				corev1.ResourceStorage: resource.MustParse("10Gi"),
+			},
+			PersistentVolumeSource: corev1.PersistentVolumeSource{
+				CSI: &corev1.CSIPersistentVolumeSource{
+					Driver:       gceCSIDriver,
+					VolumeHandle: "projects/dicty/zones/us-central1-a/disks/" + name,
+				},
+			},
+			ClaimRef:         claim,
+			StorageClassName: "standard-rwo",
+		},
+		Status: corev1.PersistentVolumeStatus{Phase: phase},
+	}
+}
+
+func TestFindOrphans(t *testing.T) {
+	claim := func(name string) *corev1.PersistentVolumeClaim {
+		return &corev1.PersistentVolumeClaim{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "dev"},
+		}
+	}
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "arangodb", Namespace: "dev"},
+		Spec: corev1.PodSpec{Volumes: []corev1.Volume{{
+			Name: "data",
+			VolumeSource: corev1.VolumeSource{
+				PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{
+					ClaimName: "arangodb-data",
+				},
+			},
+		}}},
+	}
+	clientset := fake.NewSimpleClientset(
+		pod,
+		claim("arangodb-data"),
+		claim("log-to-claim"),
+		gcePersistentVolume("pv-bound", corev1.VolumeBound, &corev1.ObjectReference{
+			Namespace: "dev",
+			Name:      "arangodb-data",
+		}),
+		gcePersistentVolume("pv-released", corev1.VolumeReleased, &corev1.ObjectReference{
+			Namespace: "dev",
+			Name:      "arangodb-backup-ephemeral",
+		}),
+		gcePersistentVolume("pv-available", corev1.VolumeAvailable, nil),
+		gcePersistentVolume("pv-staging", corev1.VolumeReleased, &corev1.ObjectReference{
+			Namespace: "staging",
+			Name:      "data",
+		}),
+	)
+	cus := &Custodian{clientset: clientset, logger: testLogger()}
+
+	orphans, err := cus.FindOrphans(context.Background(), []string{"dev"})
+	require.NoError(t, err)
+	found := make(map[string]string)
+	for _, orphan := range orphans {
+		found[orphan.Name] = orphan.Reason
+	}
+	assert.Equal(t, map[string]string{
+		"log-to-claim": OrphanNotMounted,
+		"pv-released":  OrphanReleased,
+		"pv-available": OrphanUnclaimed,
+	}, found)
+
+	require.NoError(t, cus.DeleteOrphans(context.Background(), orphans, false))
+	volumes, err := clientset.CoreV1().
+		PersistentVolumes().
+		List(context.Background(), metav1.ListOptions{})
+	require.NoError(t, err)
+	assert.Len(t, volumes.Items, 2)
+}
@@ -0,0 +1,150 @@
+package custodian
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// Output formats supported by the reporting commands
+const (
+	FormatTable    = "table"
+	FormatJSON     = "json"
+	FormatMarkdown = "markdown"
+)
+
+// ValidateFormat checks that the output format is one of the supported ones
+func ValidateFormat(format string) error {
+	switch format {
+	case FormatTable, FormatJSON, FormatMarkdown:
+		return nil
+	default:
+		return fmt.Errorf(
+			"unsupported output format %q, use %s, %s or %s",
+			format,
+			FormatTable,
+			FormatJSON,
+			FormatMarkdown,
+		)
+	}
+}
+
+func writeJSON(out io.Writer, data any) error {
+	if collector, ok := out.(*ClusterOutput); ok {
+		collector.addJSON(data)
+		return nil
+	}
+	enc := json.NewEncoder(out)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(data); err != nil {
+		return fmt.Errorf("failed to encode output: %w", err)
+	}
+	return nil
+}
+
+// writeTable renders the rows as aligned text columns, or as a markdown
+// table for the markdown format. Tables written to a ClusterOutput are kept
+// for merging.
+func writeTable(
+	out io.Writer,
+	format string,
+	headers []string,
+	rows [][]string,
+) error {
+	if collector, ok := out.(*ClusterOutput); ok {
+		collector.addTable(headers, rows)
+		return nil
+	}
+	if format == FormatMarkdown {
+		return writeMarkdownTable(out, headers, rows)
+	}
+	tw := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, strings.Join(headers, "\t"))
+	for _, row := range rows {
+		fmt.Fprintln(tw, strings.Join(row, "\t"))
+	}
+	if err := tw.Flush(); err != nil {
+		return fmt.Errorf("failed to write table: %w", err)
+	}
+	return nil
+}
+
+func writeMarkdownTable(out io.Writer, headers []string, rows [][]string) error {
+	separators := make([]string, len(headers))
+	for idx := range separators {
+		separators[idx] = "---"
+	}
+	lines := []string{
+		markdownRow(headers),
+		markdownRow(separators),
+	}
+	for _, row := range rows {
+		lines = append(lines, markdownRow(row))
+	}
+	_, err := fmt.Fprintln(out, strings.Join(lines, "\n"))
+	return err
+}
+
+func markdownRow(cells []string) string {
+	escaped := make([]string, len(cells))
+	for idx, cell := range cells {
+		escaped[idx] = strings.ReplaceAll(markdownText(cell), "|", "\\|")
+	}
+	return "| " + strings.Join(escaped, " | ") + " |"
+}
+
+// markdownText keeps a multi-line value on a single markdown line and
+// escapes its backticks, so it cannot open a code span or block
+func markdownText(value string) string {
+	value = strings.ReplaceAll(value, "`", "\\`")
+	value = strings.ReplaceAll(value, "\r\n", "\n")
+	return strings.ReplaceAll(value, "\n", "<br>")
+}
+
+// markdownFence returns a code fence longer than any backtick run of the
+// lines, so the lines cannot close the code block
+func markdownFence(lines []string) string {
+	longest := 0
+	for _, line := range lines {
+		run := 0
+		for _, char := range line {
+			if char != '`' {
+				run = 0
+				continue
+			}
+			run++
+			longest = max(longest, run)
+		}
+	}
+	return strings.Repeat("`", max(3, longest+1))
+}
+
+// valueOrDash keeps empty cells visible in the table output
+func valueOrDash(value string) string {
+	if len(value) == 0 {
+		return "-"
+	}
+	return value
+}
+
+// formatAge renders a duration the way kubectl shows ages, e.g. 3d4h or 25m
+func formatAge(age time.Duration) string {
+	switch {
+	case age >= 24*time.Hour:
+		days := int(age.Hours()) / 24
+		hours := int(age.Hours()) % 24
+		if hours == 0 {
+			return fmt.Sprintf("%dd", days)
+		}
+		return fmt.Sprintf("%dd%dh", days, hours)
+	case age >= time.Hour:
+		return fmt.Sprintf("%dh%dm", int(age.Hours()), int(age.Minutes())%60)
+	case age >= time.Minute:
+		return fmt.Sprintf("%dm", int(age.Minutes()))
+	default:
+		return fmt.Sprintf("%ds", int(age.Seconds()))
+	}
+}
@@ -0,0 +1,645 @@
+package custodian
+
+import (
+	"context"
+	"embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/discovery"
+	"sigs.k8s.io/yaml"
+)
+
+//go:embed policies/*.yaml
+var bundledPolicies embed.FS
+
+// Actions planned by the policy engine
+const (
+	ActionPatch       = "patch"
+	ActionDelete      = "delete"
+	ActionReport      = "report"
+	ActionExtractLogs = "extract-logs"
+)
+
+// Policy is a set of rules evaluated against the resources of the cluster
+type Policy struct {
+	Rules []PolicyRule `json:"rules"`
+}
+
+// PolicyRule applies its actions to every resource matched by it
+type PolicyRule struct {
+	Name              string            `json:"name"`
+	Match             PolicyMatch       `json:"match"`
+	EnsureLabels      map[string]string `json:"ensureLabels,omitempty"`
+	EnsureAnnotations map[string]string `json:"ensureAnnotations,omitempty"`
+	RemoveLabels      []string          `json:"removeLabels,omitempty"`
+	// ExcludeVolumes merges volumes into the velero volume excludes
+	// annotation of the pod, or of the pod template of a workload
+	ExcludeVolumes *ExcludeVolumesAction `json:"excludeVolumes,omitempty"`
+	Delete         *DeleteAction         `json:"delete,omitempty"`
+	// ExtractLogs writes the logs of the pods of the matched jobs
+	ExtractLogs *ExtractLogsAction `json:"extractLogs,omitempty"`
+	// RequireFields reports the resources missing any of these dot
+	// separated fields, e.g. spec.template.spec.securityContext
+	RequireFields []string `json:"requireFields,omitempty"`
+}
+
+// PolicyMatch selects resources by kind, label selector and namespace
+type PolicyMatch struct {
+	// Kinds to match as Kind.group, e.g. Job.batch, or Kind for the core
+	// group, all namespaced kinds if empty
+	Kinds    []string `json:"kinds,omitempty"`
+	Selector string   `json:"selector,omitempty"`
+	// Namespaces to match, all namespaces if empty
+	Namespaces []string `json:"namespaces,omitempty"`
+	// HasFields only matches resources having all of these fields
+	HasFields []string `json:"hasFields,omitempty"`
+	// Unowned only matches resources without a controller, e.g. bare pods
+	Unowned bool `json:"unowned,omitempty"`
+}
+
+// ExcludeVolumesAction selects the volumes to exclude from the file system
+// backup by their type
+type ExcludeVolumesAction struct {
+	VolumeTypes []string `json:"volumeTypes"`
+}
+
+// ExtractLogsAction writes the logs of every container of the pods of a job
+// to OutputDir as <job>/<pod>/<container>[.previous].log, or logs them when
+// no directory is given
+type ExtractLogsAction struct {
+	OutputDir string `json:"outputDir,omitempty"`
+}
+
+// DeleteAction deletes the resources older than the given age, measured
+// from the time field or the creation timestamp
+type DeleteAction struct {
+	OlderThan metav1.Duration `json:"olderThan"`
+	TimeField string          `json:"timeField,omitempty"`
+}
+
+// PolicyOptions controls how a policy is evaluated
+type PolicyOptions struct {
+	// Apply performs the planned changes, otherwise they are only shown
+	Apply  bool
+	Format string
+	// Namespaces overrides the namespaces of every rule when not empty
+	Namespaces []string
+}
+
+// PolicyAction is a change, or a finding, planned for a resource
+type PolicyAction struct {
+	Rule      string `json:"rule"`
+	Action    string `json:"action"`
+	Kind      string `json:"kind"`
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+	Detail    string `json:"detail"`
+	Result    string `json:"result,omitempty"`
+
+	gvr    schema.GroupVersionResource
+	patch  map[string]any
+	logDir string
+}
+
+// BundledPolicyNames lists the policies shipped with custodian
+func BundledPolicyNames() []string {
+	entries, _ := bundledPolicies.ReadDir("policies")
+	names := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		names = append(names, strings.TrimSuffix(entry.Name(), ".yaml"))
+	}
+	return names
+}
+
+// LoadBundledPolicy reads one of the policies shipped with custodian
+func LoadBundledPolicy(name string) (*Policy, error) {
+	content, err := bundledPolicies.ReadFile(path.Join("policies", name+".yaml"))
+	if err != nil {
+		return nil, fmt.Errorf(
+			"unknown bundled policy %q, use one of %s",
+			name,
+			strings.Join(BundledPolicyNames(), ", "),
+		)
+	}
+	return parsePolicy(content)
+}
+
+// LoadPolicy reads and validates a policy file
+func LoadPolicy(file string) (*Policy, error) {
+	content, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read policy file %s: %w", file, err)
+	}
+	return parsePolicy(content)
+}
+
+func parsePolicy(content []byte) (*Policy, error) {
+	policy := &Policy{}
+	if err := yaml.UnmarshalStrict(content, policy); err != nil {
+		return nil, fmt.Errorf("failed to parse policy: %w", err)
+	}
+	if err := policy.validate(); err != nil {
+		return nil, err
+	}
+	return policy, nil
+}
+
+func (pol *Policy) validate() error {
+	if len(pol.Rules) == 0 {
+		return errors.New("policy has no rules")
+	}
+	for idx, rule := range pol.Rules {
+		if len(rule.Name) == 0 {
+			return fmt.Errorf("rule %d has no name", idx+1)
+		}
+		if len(rule.EnsureLabels) == 0 && len(rule.EnsureAnnotations) == 0 &&
+			len(rule.RemoveLabels) == 0 && rule.ExcludeVolumes == nil &&
+			rule.Delete == nil && rule.ExtractLogs == nil &&
+			len(rule.RequireFields) == 0 {
+			return fmt.Errorf("rule %s has no action", rule.Name)
+		}
+		if rule.ExcludeVolumes != nil {
+			if len(rule.ExcludeVolumes.VolumeTypes) == 0 {
+				return fmt.Errorf("rule %s excludes volumes without volume types", rule.Name)
+			}
+			if err := ValidateVolumeTypes(rule.ExcludeVolumes.VolumeTypes); err != nil {
+				return fmt.Errorf("rule %s: %w", rule.Name, err)
+			}
+		}
+		if rule.ExtractLogs != nil && !slices.Equal(rule.Match.Kinds, []string{"Job.batch"}) {
+			return fmt.Errorf("rule %s extracts logs, it can only match Job.batch", rule.Name)
+		}
+		if rule.Delete != nil {
+			if len(rule.Match.Kinds) == 0 {
+				return fmt.Errorf("rule %s deletes without matching kinds", rule.Name)
+			}
+			if rule.Delete.OlderThan.Duration <= 0 {
+				return fmt.Errorf("rule %s needs a positive olderThan", rule.Name)
+			}
+		}
+	}
+	return nil
+}
+
+// RunPolicy plans the actions of every rule and applies them in apply mode
+func (cus *Custodian) RunPolicy(
+	ctx context.Context,
+	policy *Policy,
+	opts PolicyOptions,
+	out io.Writer,
+) error {
+	actions, err := cus.planPolicy(ctx, policy, opts, time.Now())
+	if err != nil {
+		return err
+	}
+	var errs []error
+	if opts.Apply {
+		// an earlier rule may delete a resource later rules also act on
+		deleted := make(map[string]bool)
+		for idx := range actions {
+			action := &actions[idx]
+			key := action.gvr.String() + "/" + action.Namespace + "/" + action.Name
+			if deleted[key] {
+				action.Result = "skipped, deleted"
+				continue
+			}
+			if err := cus.applyAction(ctx, action); err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			if action.Action == ActionDelete {
+				deleted[key] = true
+			}
+		}
+	}
+	if err := writePolicyActions(out, opts.Format, actions); err != nil {
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
+
+func (cus *Custodian) planPolicy(
+	ctx context.Context,
+	policy *Policy,
+	opts PolicyOptions,
+	now time.Time,
+) ([]PolicyAction, error) {
+	lists, err := cus.discoveryClient.ServerPreferredNamespacedResources()
+	if err != nil {
+		if !discovery.IsGroupDiscoveryFailedError(err) {
+			return nil, fmt.Errorf("failed to get server resources: %w", err)
+		}
+		cus.logger.Warn("Some API groups could not be discovered", "error", err)
+	}
+
+	var actions []PolicyAction
+	for _, rule := range policy.Rules {
+		resources, err := cus.ruleResources(lists, rule)
+		if err != nil {
+			return nil, err
+		}
+		namespaces := rule.Match.Namespaces
+		if len(opts.Namespaces) > 0 {
+			namespaces = opts.Namespaces
+		}
+		if len(namespaces) == 0 {
+			namespaces = []string{metav1.NamespaceAll}
+		}
+		for _, gvr := range resources {
+			for _, namespace := range namespaces {
+				items, err := cus.dynamicClient.Resource(gvr).
+					Namespace(namespace).
+					List(ctx, metav1.ListOptions{LabelSelector: rule.Match.Selector})
+				if err != nil {
+					if apierrors.IsNotFound(err) || apierrors.IsMethodNotSupported(err) {
+						continue
+					}
+					return nil, fmt.Errorf(
+						"rule %s: failed to list %s: %w",
+						rule.Name,
+						gvr.Resource,
+						err,
+					)
+				}
+				for idx := range items.Items {
+					item := &items.Items[idx]
+					if !hasFields(item, rule.Match.HasFields) {
+						continue
+					}
+					if rule.Match.Unowned && metav1.GetControllerOf(item) != nil {
+						continue
+					}
+					for _, action := range planRule(rule, item, now) {
+						action.gvr = gvr
+						actions = append(actions, action)
+					}
+				}
+			}
+		}
+	}
+	return actions, nil
+}
+
+// ruleResources resolves the kinds of the rule to resources that support
+// the verbs its actions need
+func (cus *Custodian) ruleResources(
+	lists []*metav1.APIResourceList,
+	rule PolicyRule,
+) ([]schema.GroupVersionResource, error) {
+	verbs := []string{"list"}
+	if len(rule.EnsureLabels) > 0 || len(rule.EnsureAnnotations) > 0 ||
+		len(rule.RemoveLabels) > 0 || rule.ExcludeVolumes != nil {
+		verbs = append(verbs, "patch")
+	}
+	if rule.Delete != nil {
+		verbs = append(verbs, "delete")
+	}
+
+	var gvrs []schema.GroupVersionResource
+	found := make(map[string]bool)
+	for _, list := range lists {
+		gv, err := schema.ParseGroupVersion(list.GroupVersion)
+		if err != nil {
+			continue
+		}
+		for _, resource := range list.APIResources {
+			if strings.Contains(resource.Name, "/") {
+				continue
+			}
+			kind := resource.Kind
+			if len(gv.Group) > 0 {
+				kind += "." + gv.Group
+			}
+			if len(rule.Match.Kinds) > 0 && !slices.Contains(rule.Match.Kinds, kind) {
+				continue
+			}
+			if !cus.hasVerbs(resource, verbs...) {
+				continue
+			}
+			found[kind] = true
+			gvrs = append(gvrs, gv.WithResource(resource.Name))
+		}
+	}
+	for _, kind := range rule.Match.Kinds {
+		if !found[kind] {
+			return nil, fmt.Errorf(
+				"rule %s: kind %s is not served with the verbs %s",
+				rule.Name,
+				kind,
+				strings.Join(verbs, ", "),
+			)
+		}
+	}
+	return gvrs, nil
+}
+
+// planRule returns the actions the rule needs for the resource, nothing if
+// it already complies
+func planRule(
+	rule PolicyRule,
+	item *unstructured.Unstructured,
+	now time.Time,
+) []PolicyAction {
+	newAction := func(action, detail string) PolicyAction {
+		return PolicyAction{
+			Rule:      rule.Name,
+			Action:    action,
+			Kind:      item.GetKind(),
+			Namespace: item.GetNamespace(),
+			Name:      item.GetName(),
+			Detail:    detail,
+		}
+	}
+
+	var actions []PolicyAction
+	if rule.Delete != nil {
+		if age, ok := resourceAge(item, rule.Delete.TimeField, now); ok &&
+			age > rule.Delete.OlderThan.Duration {
+			actions = append(actions, newAction(
+				ActionDelete,
+				fmt.Sprintf("older than %s", rule.Delete.OlderThan.Duration),
+			))
+			// nothing else matters for a resource that goes away
+			return actions
+		}
+	}
+
+	labels, labelChanges := mapChanges(item.GetLabels(), rule.EnsureLabels, rule.RemoveLabels)
+	annotations, annotationChanges := mapChanges(item.GetAnnotations(), rule.EnsureAnnotations, nil)
+	if len(labels) > 0 || len(annotations) > 0 {
+		metadata := make(map[string]any)
+		if len(labels) > 0 {
+			metadata["labels"] = labels
+		}
+		if len(annotations) > 0 {
+			metadata["annotations"] = annotations
+		}
+		action := newAction(
+			ActionPatch,
+			strings.Join(append(labelChanges, annotationChanges...), ", "),
+		)
+		action.patch = map[string]any{"metadata": metadata}
+		actions = append(actions, action)
+	}
+
+	if rule.ExcludeVolumes != nil {
+		if patch, volumes, ok := volumeExcludesPatch(item, rule.ExcludeVolumes); ok {
+			action := newAction(ActionPatch, "exclude volumes "+volumes)
+			action.patch = patch
+			actions = append(actions, action)
+		}
+	}
+
+	if rule.ExtractLogs != nil {
+		detail := "log the pod logs"
+		if len(rule.ExtractLogs.OutputDir) > 0 {
+			detail = "write the pod logs to " + rule.ExtractLogs.OutputDir
+		}
+		action := newAction(ActionExtractLogs, detail)
+		action.logDir = rule.ExtractLogs.OutputDir
+		actions = append(actions, action)
+	}
+
+	for _, field := range rule.RequireFields {
+		if !hasFields(item, []string{field}) {
+			actions = append(actions, newAction(ActionReport, "missing "+field))
+		}
+	}
+	return actions
+}
+
+// volumeExcludesPatch returns the merge patch adding the volumes of the
+// given types to the volume excludes annotation, of the pod or of the pod
+// template of a workload, and the resulting annotation value. It reports
+// false when the resource has no pod spec or nothing changes.
+func volumeExcludesPatch(
+	item *unstructured.Unstructured,
+	exclude *ExcludeVolumesAction,
+) (map[string]any, string, bool) {
+	path := podTemplatePath(item)
+	if path == nil {
+		return nil, "", false
+	}
+	rawVolumes, _, _ := unstructured.NestedSlice(
+		item.Object,
+		append(slices.Clone(path), "spec", "volumes")...,
+	)
+	var names []string
+	for _, raw := range rawVolumes {
+		content, ok := raw.(map[string]any)
+		if !ok {
+			continue
+		}
+		var volume corev1.Volume
+		err := runtime.DefaultUnstructuredConverter.FromUnstructured(content, &volume)
+		if err != nil {
+			continue
+		}
+		if slices.Contains(exclude.VolumeTypes, volumeType(volume)) {
+			names = append(names, volume.Name)
+		}
+	}
+	current, _, _ := unstructured.NestedString(
+		item.Object,
+		append(slices.Clone(path), "metadata", "annotations", VolumesExcludesAnnotation)...,
+	)
+	value, changed := mergeVolumeExcludes(current, names)
+	if !changed {
+		return nil, "", false
+	}
+	return annotationPatchObject(value, append(path, "metadata")...), value, true
+}
+
+// podTemplatePath returns the path of the pod template of a workload, an
+// empty path for a pod and nil for resources without pods
+func podTemplatePath(item *unstructured.Unstructured) []string {
+	for _, path := range [][]string{
+		{"spec", "jobTemplate", "spec", "template"},
+		{"spec", "template"},
+	} {
+		if _, found, _ := unstructured.NestedMap(
+			item.Object,
+			append(slices.Clone(path), "spec")...,
+		); found {
+			return path
+		}
+	}
+	if item.GetKind() == "Pod" {
+		return []string{}
+	}
+	return nil
+}
+
+// mapChanges returns the merge patch entries that set the wanted keys and
+// remove the unwanted ones, with a description of every change
+func mapChanges(
+	current, wanted map[string]string,
+	remove []string,
+) (map[string]any, []string) {
+	patch := make(map[string]any)
+	var changes []string
+	keys := make([]string, 0, len(wanted))
+	for key := range wanted {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if value, ok := current[key]; ok && value == wanted[key] {
+			continue
+		}
+		patch[key] = wanted[key]
+		changes = append(changes, fmt.Sprintf("set %s=%s", key, wanted[key]))
+	}
+	for _, key := range remove {
+		if _, ok := current[key]; !ok {
+			continue
+		}
+		patch[key] = nil
+		changes = append(changes, "remove "+key)
+	}
+	return patch, changes
+}
+
+// resourceAge measures the age from the time field, or the creation
+// timestamp without one
+func resourceAge(
+	item *unstructured.Unstructured,
+	timeField string,
+	now time.Time,
+) (time.Duration, bool) {
+	if len(timeField) == 0 {
+		return now.Sub(item.GetCreationTimestamp().Time), true
+	}
+	value := nestedTime(item.Object, strings.Split(timeField, ".")...)
+	if value == nil {
+		return 0, false
+	}
+	return now.Sub(*value), true
+}
+
+func hasFields(item *unstructured.Unstructured, fields []string) bool {
+	for _, field := range fields {
+		value, found, err := unstructured.NestedFieldNoCopy(
+			item.Object,
+			strings.Split(field, ".")...,
+		)
+		if err != nil || !found || value == nil {
+			return false
+		}
+	}
+	return true
+}
+
+func (cus *Custodian) applyAction(
+	ctx context.Context,
+	action *PolicyAction,
+) error {
+	client := cus.dynamicClient.Resource(action.gvr).Namespace(action.Namespace)
+	var err error
+	switch action.Action {
+	case ActionPatch:
+		var patchData []byte
+		patchData, err = json.Marshal(action.patch)
+		if err == nil {
+			_, err = client.Patch(
+				ctx,
+				action.Name,
+				types.MergePatchType,
+				patchData,
+				metav1.PatchOptions{},
+			)
+		}
+	case ActionDelete:
+		propagation := metav1.DeletePropagationBackground
+		err = client.Delete(ctx, action.Name, metav1.DeleteOptions{
+			PropagationPolicy: &propagation,
+		})
+	case ActionExtractLogs:
+		err = cus.extractJobLogs(ctx, action.Namespace, action.Name, action.logDir)
+	default:
+		return nil
+	}
+	if err != nil {
+		action.Result = "failed: " + err.Error()
+		return fmt.Errorf(
+			"rule %s: failed to %s %s %s: %w",
+			action.Rule,
+			action.Action,
+			action.Kind,
+			action.Name,
+			err,
+		)
+	}
+	action.Result = "applied"
+	cus.logger.Info(
+		"Applied policy action",
+		"rule", action.Rule,
+		"action", action.Action,
+		"kind", action.Kind,
+		"namespace", action.Namespace,
+		"name", action.Name,
+		"detail", action.Detail,
+	)
+	return nil
+}
+
+// extractJobLogs writes the logs of the pods of the job like extract-log
+func (cus *Custodian) extractJobLogs(
+	ctx context.Context,
+	namespace, job, outputDir string,
+) error {
+	pods, err := cus.clientset.CoreV1().
+		Pods(namespace).
+		List(ctx, metav1.ListOptions{LabelSelector: "job-name=" + job})
+	if err != nil {
+		return fmt.Errorf("failed to list pods of job %s: %w", job, err)
+	}
+	if len(outputDir) > 0 {
+		return cus.writeJobLogs(outputDir, job, pods)
+	}
+	return cus.processPodsForJob(pods)
+}
+
+func writePolicyActions(
+	out io.Writer,
+	format string,
+	actions []PolicyAction,
+) error {
+	if format == FormatJSON {
+		if actions == nil {
+			actions = []PolicyAction{}
+		}
+		return writeJSON(out, actions)
+	}
+	rows := make([][]string, 0, len(actions))
+	for _, action := range actions {
+		rows = append(rows, []string{
+			action.Rule,
+			action.Action,
+			action.Kind,
+			valueOrDash(action.Namespace),
+			action.Name,
+			action.Detail,
+			valueOrDash(action.Result),
+		})
+	}
+	return writeTable(out, format, []string{
+		"RULE", "ACTION", "KIND", "NAMESPACE", "NAME", "DETAIL", "RESULT",
+	}, rows)
+}
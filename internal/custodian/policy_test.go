@@ -0,0 +1,341 @@
+package custodian
+
+import (
+	"bytes"
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	fakediscovery "k8s.io/client-go/discovery/fake"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestBundledPoliciesAreValid(t *testing.T) {
+	names := BundledPolicyNames()
+	require.NotEmpty(t, names)
+	for _, name := range names {
+		_, err := LoadBundledPolicy(name)
+		assert.NoError(t, err, name)
+	}
+}
+
+func policyJob(name string, completed time.Time) *unstructured.Unstructured {
+	return &unstructured.Unstructured{Object: map[string]any{
+		"apiVersion": "batch/v1",
+		"kind":       "Job",
+		"metadata": map[string]any{
+			"name":      name,
+			"namespace": "dev",
+			"labels":    map[string]any{"app": "loader"},
+		},
+		"status": map[string]any{
+			"completionTime": completed.UTC().Format(time.RFC3339),
+		},
+	}}
+}
+
+func TestRunPolicyPlanAndApply(t *testing.T) {
+	now := time.Now()
+	jobsGVR := schema.GroupVersionResource{Group: "batch", Version: "v1", Resource: "jobs"}
+	dynamicClient := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(
+		runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{jobsGVR: "JobList"},
+		policyJob("load-old", now.Add(-48*time.Hour)),
+		policyJob("load-new", now.Add(-time.Hour)),
+	)
+	discoveryClient := fake.NewSimpleClientset().Discovery().(*fakediscovery.FakeDiscovery)
+	discoveryClient.Resources = []*metav1.APIResourceList{{
+		GroupVersion: "batch/v1",
+		APIResources: []metav1.APIResource{{
+			Name:       "jobs",
+			Namespaced: true,
+			Kind:       "Job",
+			Verbs:      []string{"list", "patch", "delete"},
+		}},
+	}}
+	cus := &Custodian{
+		dynamicClient:   dynamicClient,
+		discoveryClient: preferredDiscovery{discoveryClient},
+		logger:          testLogger(),
+	}
+	policy, err := parsePolicy([]byte(`
+rules:
+  - name: cleanup
+    match: {kinds: [Job.batch], hasFields: [status.completionTime]}
+    delete: {olderThan: 24h, timeField: status.completionTime}
+  - name: owner
+    match: {kinds: [Job.batch], selector: app=loader}
+    ensureLabels: {team: dicty}
+    requireFields: [spec.ttlSecondsAfterFinished]
+`))
+	require.NoError(t, err)
+
+	actions, err := cus.planPolicy(context.Background(), policy, PolicyOptions{}, now)
+	require.NoError(t, err)
+	var planned []string
+	for _, action := range actions {
+		planned = append(planned, action.Rule+" "+action.Action+" "+action.Name)
+	}
+	assert.ElementsMatch(t, []string{
+		"cleanup delete load-old",
+		"owner patch load-old",
+		"owner report load-old",
+		"owner patch load-new",
+		"owner report load-new",
+	}, planned)
+
+	var out bytes.Buffer
+	err = cus.RunPolicy(
+		context.Background(),
+		policy,
+		PolicyOptions{Apply: true, Namespaces: []string{"dev"}, Format: FormatTable},
+		&out,
+	)
+	require.NoError(t, err)
+	assert.Contains(t, out.String(), "applied")
+
+	jobs, err := dynamicClient.Resource(jobsGVR).
+		Namespace("dev").
+		List(context.Background(), metav1.ListOptions{})
+	require.NoError(t, err)
+	require.Len(t, jobs.Items, 1)
+	assert.Equal(t, "load-new", jobs.Items[0].GetName())
+	assert.Equal(t, "dicty", jobs.Items[0].GetLabels()["team"])
+}
+
+func TestPolicyExcludeVolumes(t *testing.T) {
+	deploymentsGVR := schema.GroupVersionResource{Group: "apps", Version: "v1", Resource: "deployments"}
+	deployment := &unstructured.Unstructured{Object: map[string]any{
+		"apiVersion": "apps/v1",
+		"kind":       "Deployment",
+		"metadata":   map[string]any{"name": "api", "namespace": "dev"},
+		"spec": map[string]any{
+			"template": map[string]any{
+				"metadata": map[string]any{
+					"annotations": map[string]any{VolumesExcludesAnnotation: "cache"},
+				},
+				"spec": map[string]any{
+					"volumes": []any{
+						map[string]any{"name": "config", "configMap": map[string]any{"name": "api"}},
+						map[string]any{
+							"name":                  "data",
+							"persistentVolumeClaim": map[string]any{"claimName": "api-data"},
+						},
+					},
+				},
+			},
+		},
+	}}
+	podsGVR := schema.GroupVersionResource{Version: "v1", Resource: "pods"}
+	scratchPod := func(name string, owners ...any) *unstructured.Unstructured {
+		metadata := map[string]any{"name": name, "namespace": "dev"}
+		if len(owners) > 0 {
+			metadata["ownerReferences"] = owners
+		}
+		return &unstructured.Unstructured{Object: map[string]any{
+			"apiVersion": "v1",
+			"kind":       "Pod",
+			"metadata":   metadata,
+			"spec": map[string]any{
+				"volumes": []any{
+					map[string]any{"name": "scratch", "emptyDir": map[string]any{}},
+				},
+			},
+		}}
+	}
+	dynamicClient := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(
+		runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{
+			deploymentsGVR: "DeploymentList",
+			{Group: "apps", Version: "v1", Resource: "statefulsets"}: "StatefulSetList",
+			podsGVR: "PodList",
+		},
+		deployment,
+		scratchPod("debug"),
+		scratchPod("api-5c1a-x2b9", map[string]any{
+			"apiVersion": "apps/v1",
+			"kind":       "ReplicaSet",
+			"name":       "api-5c1a",
+			"uid":        "1",
+			"controller": true,
+		}),
+	)
+	discoveryClient := fake.NewSimpleClientset().Discovery().(*fakediscovery.FakeDiscovery)
+	discoveryClient.Resources = []*metav1.APIResourceList{{
+		GroupVersion: "v1",
+		APIResources: []metav1.APIResource{{
+			Name:       "pods",
+			Namespaced: true,
+			Kind:       "Pod",
+			Verbs:      []string{"list", "patch"},
+		}},
+	}, {
+		GroupVersion: "apps/v1",
+		APIResources: []metav1.APIResource{
+			{
+				Name:       "deployments",
+				Namespaced: true,
+				Kind:       "Deployment",
+				Verbs:      []string{"list", "patch"},
+			},
+			{
+				Name:       "statefulsets",
+				Namespaced: true,
+				Kind:       "StatefulSet",
+				Verbs:      []string{"list", "patch"},
+			},
+		},
+	}}
+	cus := &Custodian{
+		dynamicClient:   dynamicClient,
+		discoveryClient: preferredDiscovery{discoveryClient},
+		logger:          testLogger(),
+	}
+	policy, err := LoadBundledPolicy("exclude-volumes-from-backup")
+	require.NoError(t, err)
+
+	var out bytes.Buffer
+	err = cus.RunPolicy(
+		context.Background(),
+		policy,
+		PolicyOptions{Apply: true, Format: FormatTable},
+		&out,
+	)
+	require.NoError(t, err)
+	assert.Contains(t, out.String(), "exclude volumes cache,config")
+
+	updated, err := dynamicClient.Resource(deploymentsGVR).
+		Namespace("dev").
+		Get(context.Background(), "api", metav1.GetOptions{})
+	require.NoError(t, err)
+	value, _, _ := unstructured.NestedString(
+		updated.Object,
+		"spec", "template", "metadata", "annotations", VolumesExcludesAnnotation,
+	)
+	assert.Equal(t, "cache,config", value)
+	pods, err := dynamicClient.Resource(podsGVR).
+		Namespace("dev").
+		List(context.Background(), metav1.ListOptions{})
+	require.NoError(t, err)
+	for _, pod := range pods.Items {
+		excludes := pod.GetAnnotations()[VolumesExcludesAnnotation]
+		if pod.GetName() == "debug" {
+			assert.Equal(t, "scratch", excludes, "bare pods are annotated")
+			continue
+		}
+		assert.Empty(t, excludes, "pods of workloads get it from their template")
+	}
+
+	actions, err := cus.planPolicy(context.Background(), policy, PolicyOptions{}, time.Now())
+	require.NoError(t, err)
+	assert.Empty(t, actions, "the annotated template needs no change")
+}
+
+func TestRuleResourcesMatchGroupAndKind(t *testing.T) {
+	cus := &Custodian{logger: testLogger()}
+	lists := []*metav1.APIResourceList{
+		{
+			GroupVersion: "postgresql.cnpg.io/v1",
+			APIResources: []metav1.APIResource{
+				{Name: "backups", Kind: "Backup", Verbs: []string{"list", "delete"}},
+			},
+		},
+		{
+			GroupVersion: "velero.io/v1",
+			APIResources: []metav1.APIResource{
+				{Name: "backups", Kind: "Backup", Verbs: []string{"list", "delete"}},
+			},
+		},
+	}
+	rule := PolicyRule{
+		Name:   "old-backups",
+		Match:  PolicyMatch{Kinds: []string{"Backup.postgresql.cnpg.io"}},
+		Delete: &DeleteAction{OlderThan: metav1.Duration{Duration: time.Hour}},
+	}
+	gvrs, err := cus.ruleResources(lists, rule)
+	require.NoError(t, err)
+	assert.Equal(t, []schema.GroupVersionResource{
+		{Group: "postgresql.cnpg.io", Version: "v1", Resource: "backups"},
+	}, gvrs)
+
+	rule.Match.Kinds = []string{"Backup"}
+	_, err = cus.ruleResources(lists, rule)
+	require.Error(t, err, "Backup alone names a core kind")
+}
+
+func TestPolicyValidatesExcludeVolumes(t *testing.T) {
+	_, err := parsePolicy([]byte(`
+rules:
+  - name: volumes
+    match: {kinds: [Deployment.apps]}
+    excludeVolumes: {volumeTypes: [hostPath]}
+`))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), `unsupported volume type "hostPath"`)
+}
+
+func TestPolicyExtractLogs(t *testing.T) {
+	jobsGVR := schema.GroupVersionResource{Group: "batch", Version: "v1", Resource: "jobs"}
+	dynamicClient := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(
+		runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{jobsGVR: "JobList"},
+		policyJob("load-uniprot", time.Now()),
+	)
+	discoveryClient := fake.NewSimpleClientset().Discovery().(*fakediscovery.FakeDiscovery)
+	discoveryClient.Resources = []*metav1.APIResourceList{{
+		GroupVersion: "batch/v1",
+		APIResources: []metav1.APIResource{{
+			Name:       "jobs",
+			Namespaced: true,
+			Kind:       "Job",
+			Verbs:      []string{"list"},
+		}},
+	}}
+	cus := &Custodian{
+		clientset:       fake.NewSimpleClientset(jobPod()),
+		dynamicClient:   dynamicClient,
+		discoveryClient: preferredDiscovery{discoveryClient},
+		logger:          testLogger(),
+	}
+	dir := t.TempDir()
+	policy, err := parsePolicy([]byte(`
+rules:
+  - name: logs
+    match: {kinds: [Job.batch], selector: app=loader}
+    extractLogs: {outputDir: ` + dir + `}
+`))
+	require.NoError(t, err)
+
+	var out bytes.Buffer
+	err = cus.RunPolicy(context.Background(), policy, PolicyOptions{Format: FormatTable}, &out)
+	require.NoError(t, err)
+	assert.Contains(t, out.String(), "write the pod logs to "+dir)
+	assert.NoDirExists(t, filepath.Join(dir, "load-uniprot"), "plan writes nothing")
+
+	err = cus.RunPolicy(
+		context.Background(),
+		policy,
+		PolicyOptions{Apply: true, Format: FormatTable},
+		&out,
+	)
+	require.NoError(t, err)
+	for _, file := range []string{"init.log", "loader.log", "loader.previous.log"} {
+		assert.FileExists(t, filepath.Join(dir, "load-uniprot", "load-uniprot-abcde", file))
+	}
+
+	_, err = parsePolicy([]byte(`
+rules:
+  - name: logs
+    match: {kinds: [Pod]}
+    extractLogs: {}
+`))
+	assert.ErrorContains(t, err, "it can only match Job.batch")
+}
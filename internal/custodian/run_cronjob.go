@@ -0,0 +1,137 @@
+package custodian
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	batchv1 "k8s.io/api/batch/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	utilrand "k8s.io/apimachinery/pkg/util/rand"
+)
+
+// RunLabel marks the jobs created by run-cronjob, its value is the job name
+const RunLabel = "cluster-ops.dictybase.org/run"
+
+// maxJobNameLength leaves room for the pod suffix the job controller adds
+const maxJobNameLength = 52
+
+// JobFailedError is returned when a job started by RunCronJob failed, the
+// exit code is the one of its last failed container
+type JobFailedError struct {
+	Job      string
+	ExitCode int
+}
+
+func (err *JobFailedError) Error() string {
+	return fmt.Sprintf("job %s failed with exit code %d", err.Job, err.ExitCode)
+}
+
+// RunCronJob creates a job from the template of the cronjob, streams its logs
+// and waits for it to finish within the timeout. The job is owned by the
+// cronjob like the ones it schedules. A failed job returns a
+// *JobFailedError.
+func (cus *Custodian) RunCronJob(
+	ctx context.Context,
+	name string,
+	timeout time.Duration,
+	out io.Writer,
+) error {
+	cronJob, err := cus.clientset.BatchV1().
+		CronJobs(cus.namespace).
+		Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to get cronjob %s: %w", name, err)
+	}
+	job, err := cus.clientset.BatchV1().
+		Jobs(cus.namespace).
+		Create(ctx, jobFromCronJob(cronJob), metav1.CreateOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to create job from cronjob %s: %w", name, err)
+	}
+	cus.logger.Info("Created job", "name", job.Name, "cronjob", name)
+
+	waitCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	selector := labels.Set{RunLabel: job.Name}.String()
+	err = cus.followJobs(waitCtx, selector, out)
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		return fmt.Errorf("job %s did not finish within %s", job.Name, timeout)
+	case err != nil && ctx.Err() != nil:
+		return err
+	case err != nil:
+		return &JobFailedError{Job: job.Name, ExitCode: cus.jobExitCode(ctx, job.Name)}
+	}
+	return nil
+}
+
+// jobFromCronJob builds a job like the cronjob controller would, with an
+// annotation marking it as started by hand
+func jobFromCronJob(cronJob *batchv1.CronJob) *batchv1.Job {
+	prefix := cronJob.Name
+	if len(prefix) > maxJobNameLength-len("-manual-xxxxx") {
+		prefix = prefix[:maxJobNameLength-len("-manual-xxxxx")]
+	}
+	name := fmt.Sprintf("%s-manual-%s", prefix, utilrand.String(5))
+	template := cronJob.Spec.JobTemplate
+	jobLabels := make(map[string]string, len(template.Labels)+1)
+	for key, value := range template.Labels {
+		jobLabels[key] = value
+	}
+	jobLabels[RunLabel] = name
+	annotations := make(map[string]string, len(template.Annotations)+1)
+	for key, value := range template.Annotations {
+		annotations[key] = value
+	}
+	annotations["cronjob.kubernetes.io/instantiate"] = "manual"
+	return &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        name,
+			Namespace:   cronJob.Namespace,
+			Labels:      jobLabels,
+			Annotations: annotations,
+			OwnerReferences: []metav1.OwnerReference{
+				*metav1.NewControllerRef(
+					cronJob,
+					batchv1.SchemeGroupVersion.WithKind("CronJob"),
+				),
+			},
+		},
+		Spec: *template.Spec.DeepCopy(),
+	}
+}
+
+// jobExitCode returns the exit code of the last failed container of the
+// job, or 1 when none is found
+func (cus *Custodian) jobExitCode(ctx context.Context, job string) int {
+	pods, err := cus.clientset.CoreV1().
+		Pods(cus.namespace).
+		List(ctx, metav1.ListOptions{
+			LabelSelector: labels.Set{"job-name": job}.String(),
+		})
+	if err != nil {
+		cus.logger.Warn("Failed to list job pods", "job", job, "error", err)
+		return 1
+	}
+	code := 1
+	var last time.Time
+	for _, pod := range pods.Items {
+		for _, status := range podContainerStatuses(&pod) {
+			terminated := status.State.Terminated
+			if terminated == nil {
+				terminated = status.LastTerminationState.Terminated
+			}
+			if terminated == nil || terminated.ExitCode == 0 ||
+				terminated.FinishedAt.Time.Before(last) {
+				continue
+			}
+			code = int(terminated.ExitCode)
+			last = terminated.FinishedAt.Time
+		}
+	}
+	return code
+}
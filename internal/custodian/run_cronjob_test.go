@@ -0,0 +1,84 @@
+package custodian
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestRunCronJobReturnsExitCode(t *testing.T) {
+	cronJob := &batchv1.CronJob{
+		ObjectMeta: metav1.ObjectMeta{Name: "arangodb-backup", Namespace: "dev"},
+		Spec: batchv1.CronJobSpec{JobTemplate: batchv1.JobTemplateSpec{
+			ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{"app": "backup"}},
+			Spec: batchv1.JobSpec{Template: corev1.PodTemplateSpec{
+				Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "backup"}}},
+			}},
+		}},
+	}
+	client := fake.NewSimpleClientset(cronJob)
+	cus := &Custodian{
+		clientset:  client,
+		namespace:  "dev",
+		logger:     testLogger(),
+		logOptions: LogOptions{NoColor: true},
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	out := &syncBuffer{}
+	errc := make(chan error, 1)
+	go func() { errc <- cus.RunCronJob(ctx, "arangodb-backup", 5*time.Second, out) }()
+
+	var job *batchv1.Job
+	require.Eventually(t, func() bool {
+		jobs, err := client.BatchV1().Jobs("dev").List(ctx, metav1.ListOptions{})
+		if err != nil || len(jobs.Items) == 0 {
+			return false
+		}
+		job = &jobs.Items[0]
+		return true
+	}, 5*time.Second, 50*time.Millisecond)
+	assert.True(t, strings.HasPrefix(job.Name, "arangodb-backup-manual-"))
+	assert.Equal(t, "backup", job.Labels["app"])
+	require.Len(t, job.OwnerReferences, 1)
+	assert.Equal(t, "CronJob", job.OwnerReferences[0].Kind)
+
+	_, err := client.CoreV1().Pods("dev").Create(ctx, &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      job.Name + "-abcde",
+			Namespace: "dev",
+			Labels:    map[string]string{"job-name": job.Name},
+		},
+		Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "backup"}}},
+		Status: corev1.PodStatus{ContainerStatuses: []corev1.ContainerStatus{{
+			Name: "backup",
+			State: corev1.ContainerState{
+				Terminated: &corev1.ContainerStateTerminated{ExitCode: 3},
+			},
+		}}},
+	}, metav1.CreateOptions{})
+	require.NoError(t, err)
+	require.Eventually(t, func() bool {
+		return strings.Contains(out.String(), "/backup] fake logs")
+	}, 5*time.Second, 50*time.Millisecond)
+
+	job.Status.Conditions = []batchv1.JobCondition{
+		{Type: batchv1.JobFailed, Status: corev1.ConditionTrue},
+	}
+	_, err = client.BatchV1().Jobs("dev").UpdateStatus(ctx, job, metav1.UpdateOptions{})
+	require.NoError(t, err)
+
+	err = <-errc
+	var failed *JobFailedError
+	require.ErrorAs(t, err, &failed)
+	assert.Equal(t, 3, failed.ExitCode)
+}
@@ -0,0 +1,422 @@
+package custodian
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"slices"
+	"sort"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// Secrets and config maps that are managed by kubernetes itself
+var (
+	ignoredSecretTypes = []corev1.SecretType{
+		corev1.SecretTypeServiceAccountToken,
+		"helm.sh/release.v1",
+	}
+	ignoredConfigMaps = []string{"kube-root-ca.crt"}
+)
+
+// crdReference is a field of a custom resource naming a secret or config
+// map in the namespace of the resource
+type crdReference struct {
+	gvr  schema.GroupVersionResource
+	kind string
+	path []string
+	// mapValues means the field is a map with the names as values
+	mapValues bool
+}
+
+// knownCRDReferences lists the custom resource fields of the operators in
+// use that refer to secrets
+var knownCRDReferences = []crdReference{
+	{
+		gvr:  schema.GroupVersionResource{Group: "postgresql.cnpg.io", Version: "v1", Resource: "clusters"},
+		kind: "Secret",
+		path: []string{"spec", "bootstrap", "initdb", "secret", "name"},
+	},
+	{
+		gvr:  schema.GroupVersionResource{Group: "postgresql.cnpg.io", Version: "v1", Resource: "clusters"},
+		kind: "Secret",
+		path: []string{"spec", "superuserSecret", "name"},
+	},
+	{
+		gvr:       schema.GroupVersionResource{Group: "database.arangodb.com", Version: "v1", Resource: "arangodeployments"},
+		kind:      "Secret",
+		path:      []string{"spec", "bootstrap", "passwordSecretNames"},
+		mapValues: true,
+	},
+	{
+		gvr:  schema.GroupVersionResource{Group: "database.arangodb.com", Version: "v1", Resource: "arangodeployments"},
+		kind: "Secret",
+		path: []string{"spec", "auth", "jwtSecretName"},
+	},
+	{
+		gvr:  schema.GroupVersionResource{Group: "database.arangodb.com", Version: "v1", Resource: "arangodeployments"},
+		kind: "Secret",
+		path: []string{"spec", "tls", "caSecretName"},
+	},
+}
+
+// UnusedConfig is a secret or config map nothing refers to
+type UnusedConfig struct {
+	Kind      string    `json:"kind"`
+	Namespace string    `json:"namespace"`
+	Name      string    `json:"name"`
+	Type      string    `json:"type,omitempty"`
+	Created   time.Time `json:"created"`
+}
+
+// configReferences is the set of referenced secrets and config maps, keyed
+// by kind, namespace and name
+type configReferences map[string]bool
+
+func (refs configReferences) add(kind, namespace, name string) {
+	if len(name) > 0 {
+		refs[kind+"/"+namespace+"/"+name] = true
+	}
+}
+
+func (refs configReferences) has(kind, namespace, name string) bool {
+	return refs[kind+"/"+namespace+"/"+name]
+}
+
+// FindUnusedConfig reports the secrets and config maps of the namespaces
+// that no pod, pod template, ingress, service account or known custom
+// resource refers to. Objects owned by another resource are left to their
+// owner.
+func (cus *Custodian) FindUnusedConfig(
+	ctx context.Context,
+	namespaces []string,
+) ([]UnusedConfig, error) {
+	if len(namespaces) == 0 {
+		namespaces = []string{metav1.NamespaceAll}
+	}
+	var unused []UnusedConfig
+	for _, namespace := range namespaces {
+		refs := make(configReferences)
+		if err := cus.collectReferences(ctx, namespace, refs); err != nil {
+			return nil, err
+		}
+		found, err := cus.unreferencedConfig(ctx, namespace, refs)
+		if err != nil {
+			return nil, err
+		}
+		unused = append(unused, found...)
+	}
+	sort.SliceStable(unused, func(i, j int) bool {
+		if unused[i].Namespace != unused[j].Namespace {
+			return unused[i].Namespace < unused[j].Namespace
+		}
+		return unused[i].Name < unused[j].Name
+	})
+	return unused, nil
+}
+
+func (cus *Custodian) collectReferences(
+	ctx context.Context,
+	namespace string,
+	refs configReferences,
+) error {
+	collectors := []func(context.Context, string, configReferences) error{
+		cus.podReferences,
+		cus.workloadReferences,
+		cus.ingressReferences,
+		cus.serviceAccountReferences,
+		cus.crdReferences,
+	}
+	for _, collect := range collectors {
+		if err := collect(ctx, namespace, refs); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (cus *Custodian) podReferences(
+	ctx context.Context,
+	namespace string,
+	refs configReferences,
+) error {
+	pods, err := cus.clientset.CoreV1().
+		Pods(namespace).
+		List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to list pods: %w", err)
+	}
+	for idx := range pods.Items {
+		podSpecReferences(pods.Items[idx].Namespace, &pods.Items[idx].Spec, refs)
+	}
+	return nil
+}
+
+// workloadReferences adds the references of the pod templates, which also
+// cover workloads scaled to zero
+func (cus *Custodian) workloadReferences(
+	ctx context.Context,
+	namespace string,
+	refs configReferences,
+) error {
+	apps := cus.clientset.AppsV1()
+	deployments, err := apps.Deployments(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to list deployments: %w", err)
+	}
+	for idx := range deployments.Items {
+		item := &deployments.Items[idx]
+		podSpecReferences(item.Namespace, &item.Spec.Template.Spec, refs)
+	}
+	statefulSets, err := apps.StatefulSets(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to list statefulsets: %w", err)
+	}
+	for idx := range statefulSets.Items {
+		item := &statefulSets.Items[idx]
+		podSpecReferences(item.Namespace, &item.Spec.Template.Spec, refs)
+	}
+	daemonSets, err := apps.DaemonSets(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to list daemonsets: %w", err)
+	}
+	for idx := range daemonSets.Items {
+		item := &daemonSets.Items[idx]
+		podSpecReferences(item.Namespace, &item.Spec.Template.Spec, refs)
+	}
+	cronJobs, err := cus.clientset.BatchV1().
+		CronJobs(namespace).
+		List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to list cronjobs: %w", err)
+	}
+	for idx := range cronJobs.Items {
+		item := &cronJobs.Items[idx]
+		podSpecReferences(
+			item.Namespace,
+			&item.Spec.JobTemplate.Spec.Template.Spec,
+			refs,
+		)
+	}
+	return nil
+}
+
+func podSpecReferences(
+	namespace string,
+	spec *corev1.PodSpec,
+	refs configReferences,
+) {
+	for _, secret := range spec.ImagePullSecrets {
+		refs.add("Secret", namespace, secret.Name)
+	}
+	for _, volume := range spec.Volumes {
+		if volume.Secret != nil {
+			refs.add("Secret", namespace, volume.Secret.SecretName)
+		}
+		if volume.ConfigMap != nil {
+			refs.add("ConfigMap", namespace, volume.ConfigMap.Name)
+		}
+		if volume.Projected == nil {
+			continue
+		}
+		for _, source := range volume.Projected.Sources {
+			if source.Secret != nil {
+				refs.add("Secret", namespace, source.Secret.Name)
+			}
+			if source.ConfigMap != nil {
+				refs.add("ConfigMap", namespace, source.ConfigMap.Name)
+			}
+		}
+	}
+	containers := append(slices.Clone(spec.InitContainers), spec.Containers...)
+	for _, container := range spec.EphemeralContainers {
+		containers = append(containers, corev1.Container{
+			Env:     container.Env,
+			EnvFrom: container.EnvFrom,
+		})
+	}
+	for _, container := range containers {
+		for _, env := range container.Env {
+			if env.ValueFrom == nil {
+				continue
+			}
+			if ref := env.ValueFrom.SecretKeyRef; ref != nil {
+				refs.add("Secret", namespace, ref.Name)
+			}
+			if ref := env.ValueFrom.ConfigMapKeyRef; ref != nil {
+				refs.add("ConfigMap", namespace, ref.Name)
+			}
+		}
+		for _, source := range container.EnvFrom {
+			if source.SecretRef != nil {
+				refs.add("Secret", namespace, source.SecretRef.Name)
+			}
+			if source.ConfigMapRef != nil {
+				refs.add("ConfigMap", namespace, source.ConfigMapRef.Name)
+			}
+		}
+	}
+}
+
+func (cus *Custodian) ingressReferences(
+	ctx context.Context,
+	namespace string,
+	refs configReferences,
+) error {
+	ingresses, err := cus.clientset.NetworkingV1().
+		Ingresses(namespace).
+		List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to list ingresses: %w", err)
+	}
+	for _, ingress := range ingresses.Items {
+		for _, tls := range ingress.Spec.TLS {
+			refs.add("Secret", ingress.Namespace, tls.SecretName)
+		}
+	}
+	return nil
+}
+
+func (cus *Custodian) serviceAccountReferences(
+	ctx context.Context,
+	namespace string,
+	refs configReferences,
+) error {
+	accounts, err := cus.clientset.CoreV1().
+		ServiceAccounts(namespace).
+		List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to list service accounts: %w", err)
+	}
+	for _, account := range accounts.Items {
+		for _, secret := range account.Secrets {
+			refs.add("Secret", account.Namespace, secret.Name)
+		}
+		for _, secret := range account.ImagePullSecrets {
+			refs.add("Secret", account.Namespace, secret.Name)
+		}
+	}
+	return nil
+}
+
+// crdReferences adds the references of the known custom resources, skipping
+// the ones not installed in the cluster
+func (cus *Custodian) crdReferences(
+	ctx context.Context,
+	namespace string,
+	refs configReferences,
+) error {
+	listed := make(map[schema.GroupVersionResource][]unstructured.Unstructured)
+	for _, ref := range knownCRDReferences {
+		items, ok := listed[ref.gvr]
+		if !ok {
+			list, err := cus.dynamicClient.Resource(ref.gvr).
+				Namespace(namespace).
+				List(ctx, metav1.ListOptions{})
+			if err != nil && !apierrors.IsNotFound(err) {
+				return fmt.Errorf("failed to list %s: %w", ref.gvr.Resource, err)
+			}
+			if list != nil {
+				items = list.Items
+			}
+			listed[ref.gvr] = items
+		}
+		for _, item := range items {
+			for _, name := range referencedNames(item.Object, ref) {
+				refs.add(ref.kind, item.GetNamespace(), name)
+			}
+		}
+	}
+	return nil
+}
+
+func referencedNames(obj map[string]any, ref crdReference) []string {
+	if !ref.mapValues {
+		name, _, _ := unstructured.NestedString(obj, ref.path...)
+		return []string{name}
+	}
+	values, _, _ := unstructured.NestedStringMap(obj, ref.path...)
+	names := make([]string, 0, len(values))
+	for _, name := range values {
+		names = append(names, name)
+	}
+	return names
+}
+
+func (cus *Custodian) unreferencedConfig(
+	ctx context.Context,
+	namespace string,
+	refs configReferences,
+) ([]UnusedConfig, error) {
+	var unused []UnusedConfig
+	secrets, err := cus.clientset.CoreV1().
+		Secrets(namespace).
+		List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list secrets: %w", err)
+	}
+	for _, secret := range secrets.Items {
+		if len(secret.OwnerReferences) > 0 ||
+			slices.Contains(ignoredSecretTypes, secret.Type) ||
+			refs.has("Secret", secret.Namespace, secret.Name) {
+			continue
+		}
+		unused = append(unused, UnusedConfig{
+			Kind:      "Secret",
+			Namespace: secret.Namespace,
+			Name:      secret.Name,
+			Type:      string(secret.Type),
+			Created:   secret.CreationTimestamp.Time,
+		})
+	}
+
+	configMaps, err := cus.clientset.CoreV1().
+		ConfigMaps(namespace).
+		List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list config maps: %w", err)
+	}
+	for _, configMap := range configMaps.Items {
+		if len(configMap.OwnerReferences) > 0 ||
+			slices.Contains(ignoredConfigMaps, configMap.Name) ||
+			refs.has("ConfigMap", configMap.Namespace, configMap.Name) {
+			continue
+		}
+		unused = append(unused, UnusedConfig{
+			Kind:      "ConfigMap",
+			Namespace: configMap.Namespace,
+			Name:      configMap.Name,
+			Created:   configMap.CreationTimestamp.Time,
+		})
+	}
+	return unused, nil
+}
+
+// WriteUnusedConfig writes the unreferenced secrets and config maps in the
+// given format
+func WriteUnusedConfig(out io.Writer, format string, unused []UnusedConfig) error {
+	if format == FormatJSON {
+		if unused == nil {
+			unused = []UnusedConfig{}
+		}
+		return writeJSON(out, unused)
+	}
+	rows := make([][]string, 0, len(unused))
+	for _, item := range unused {
+		rows = append(rows, []string{
+			item.Kind,
+			item.Namespace,
+			item.Name,
+			valueOrDash(item.Type),
+			formatAge(time.Since(item.Created)),
+		})
+	}
+	return writeTable(out, format, []string{
+		"KIND", "NAMESPACE", "NAME", "TYPE", "AGE",
+	}, rows)
+}
@@ -0,0 +1,86 @@
+package custodian
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestFindUnusedConfig(t *testing.T) {
+	secret := func(name string) *corev1.Secret {
+		return &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "dev"},
+			Type:       corev1.SecretTypeOpaque,
+		}
+	}
+	deployment := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: "issue", Namespace: "dev"},
+		Spec: appsv1.DeploymentSpec{
+			Replicas: new(int32),
+			Template: corev1.PodTemplateSpec{Spec: corev1.PodSpec{
+				Containers: []corev1.Container{{
+					Name: "issue",
+					Env: []corev1.EnvVar{{
+						Name: "GITHUB_TOKEN",
+						ValueFrom: &corev1.EnvVarSource{
+							SecretKeyRef: &corev1.SecretKeySelector{
+								LocalObjectReference: corev1.LocalObjectReference{
+									Name: "issue-secret",
+								},
+								Key: "token",
+							},
+						},
+					}},
+				}},
+			}},
+		},
+	}
+	clientset := fake.NewSimpleClientset(
+		deployment,
+		secret("issue-secret"),
+		secret("arangodb-secret"),
+		secret("old-email-secret"),
+		&corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{
+			Name:      "kube-root-ca.crt",
+			Namespace: "dev",
+		}},
+	)
+	arango := &unstructured.Unstructured{Object: map[string]any{
+		"apiVersion": "database.arangodb.com/v1",
+		"kind":       "ArangoDeployment",
+		"metadata":   map[string]any{"name": "arangodb", "namespace": "dev"},
+		"spec": map[string]any{
+			"bootstrap": map[string]any{
+				"passwordSecretNames": map[string]any{"root": "arangodb-secret"},
+			},
+		},
+	}}
+	dynamicClient := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(
+		runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{
+			knownCRDReferences[0].gvr: "ClusterList",
+			knownCRDReferences[2].gvr: "ArangoDeploymentList",
+		},
+		arango,
+	)
+	cus := &Custodian{
+		clientset:     clientset,
+		dynamicClient: dynamicClient,
+		logger:        testLogger(),
+	}
+
+	unused, err := cus.FindUnusedConfig(context.Background(), []string{"dev"})
+	require.NoError(t, err)
+	require.Len(t, unused, 1)
+	assert.Equal(t, "old-email-secret", unused[0].Name)
+}
@@ -0,0 +1,462 @@
+package custodian
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// VolumesExcludesAnnotation lists the pod volumes velero skips
+const VolumesExcludesAnnotation = "backup.velero.io/backup-volumes-excludes"
+
+// Volume types that can be excluded from backups
+const (
+	VolumeTypeSecret    = "secret"
+	VolumeTypeConfigMap = "configMap"
+	VolumeTypeProjected = "projected"
+	VolumeTypeEmptyDir  = "emptyDir"
+	VolumeTypeEphemeral = "ephemeral"
+)
+
+// DefaultVolumeTypes are the volume types without data worth backing up
+var DefaultVolumeTypes = []string{
+	VolumeTypeSecret,
+	VolumeTypeConfigMap,
+	VolumeTypeProjected,
+	VolumeTypeEmptyDir,
+}
+
+// VolumeExcludeOptions selects the volumes to exclude from backups
+type VolumeExcludeOptions struct {
+	VolumeTypes []string
+	// StorageClasses excludes persistent volume claims of these classes
+	StorageClasses []string
+	DryRun         bool
+}
+
+// ValidateVolumeTypes checks that every volume type is supported
+func ValidateVolumeTypes(volumeTypes []string) error {
+	supported := []string{
+		VolumeTypeSecret,
+		VolumeTypeConfigMap,
+		VolumeTypeProjected,
+		VolumeTypeEmptyDir,
+		VolumeTypeEphemeral,
+	}
+	for _, volumeType := range volumeTypes {
+		if !slices.Contains(supported, volumeType) {
+			return fmt.Errorf(
+				"unsupported volume type %q, use one of %s",
+				volumeType,
+				strings.Join(supported, ", "),
+			)
+		}
+	}
+	return nil
+}
+
+// volumeExcluder keeps track of the workloads, replicasets and claims
+// already looked up, it is shared by the pod handlers of the controller
+type volumeExcluder struct {
+	cus            *Custodian
+	opts           VolumeExcludeOptions
+	mu             sync.Mutex
+	workloads      map[string]bool
+	replicaSets    map[string]podOwner
+	storageClasses map[string]string
+}
+
+// podOwner is the workload managing the pod template of a pod
+type podOwner struct {
+	kind string
+	name string
+}
+
+// ExcludeVolumesFromBackup merges the selected volumes into the
+// 'backup.velero.io/backup-volumes-excludes' annotation. Pods managed by a
+// Deployment or StatefulSet are annotated through the pod template of their
+// owner, so the annotation survives restarts.
+func (cus *Custodian) ExcludeVolumesFromBackup(
+	ctx context.Context,
+	opts VolumeExcludeOptions,
+) error {
+	pods, err := cus.listPods(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to list pods: %w", err)
+	}
+
+	vex := cus.newVolumeExcluder(opts)
+	for i := range pods.Items {
+		pod := &pods.Items[i] // Create a new variable for each iteration
+		if err := vex.processPod(ctx, pod); err != nil {
+			return fmt.Errorf(
+				"failed to exclude volumes for pod %s: %w",
+				pod.Name,
+				err,
+			)
+		}
+	}
+
+	return nil
+}
+
+func (cus *Custodian) newVolumeExcluder(
+	opts VolumeExcludeOptions,
+) *volumeExcluder {
+	return &volumeExcluder{
+		cus:            cus,
+		opts:           opts,
+		workloads:      make(map[string]bool),
+		replicaSets:    make(map[string]podOwner),
+		storageClasses: make(map[string]string),
+	}
+}
+
+func (cus *Custodian) listPods(ctx context.Context) (*corev1.PodList, error) {
+	return cus.clientset.CoreV1().
+		Pods(cus.namespace).
+		List(ctx, metav1.ListOptions{})
+}
+
+func (vex *volumeExcluder) processPod(
+	ctx context.Context,
+	pod *corev1.Pod,
+) error {
+	vex.mu.Lock()
+	defer vex.mu.Unlock()
+	owner, err := vex.templateOwner(ctx, pod)
+	if err != nil {
+		return err
+	}
+	switch owner.kind {
+	case "Deployment", "StatefulSet":
+		// the template hash changes with every rollout, a new template is
+		// looked up again
+		key := fmt.Sprintf(
+			"%s/%s/%s/%s%s",
+			owner.kind,
+			pod.Namespace,
+			owner.name,
+			pod.Labels[appsv1.DefaultDeploymentUniqueLabelKey],
+			pod.Labels[appsv1.ControllerRevisionHashLabelKey],
+		)
+		if vex.workloads[key] {
+			return nil
+		}
+		err := vex.excludeVolumesForWorkload(ctx, owner.kind, pod.Namespace, owner.name)
+		if err != nil {
+			return err
+		}
+		vex.workloads[key] = true
+		return nil
+	default:
+		return vex.excludeVolumesForPod(ctx, pod)
+	}
+}
+
+// templateOwner looks up the owner of the pod template, the owners of
+// replicasets are cached as they never change
+func (vex *volumeExcluder) templateOwner(
+	ctx context.Context,
+	pod *corev1.Pod,
+) (podOwner, error) {
+	controllerRef := metav1.GetControllerOf(pod)
+	if controllerRef == nil || controllerRef.Kind != "ReplicaSet" {
+		kind, name, err := vex.cus.podTemplateOwner(ctx, pod)
+		return podOwner{kind: kind, name: name}, err
+	}
+	key := pod.Namespace + "/" + controllerRef.Name
+	if owner, ok := vex.replicaSets[key]; ok {
+		return owner, nil
+	}
+	kind, name, err := vex.cus.podTemplateOwner(ctx, pod)
+	if err != nil {
+		return podOwner{}, err
+	}
+	vex.replicaSets[key] = podOwner{kind: kind, name: name}
+	return vex.replicaSets[key], nil
+}
+
+// podTemplateOwner returns the Deployment or StatefulSet that manages the
+// pod, if any
+func (cus *Custodian) podTemplateOwner(
+	ctx context.Context,
+	pod *corev1.Pod,
+) (string, string, error) {
+	owner := metav1.GetControllerOf(pod)
+	if owner == nil {
+		return "", "", nil
+	}
+	switch owner.Kind {
+	case "StatefulSet":
+		return owner.Kind, owner.Name, nil
+	case "ReplicaSet":
+		rs, err := cus.clientset.AppsV1().
+			ReplicaSets(pod.Namespace).
+			Get(ctx, owner.Name, metav1.GetOptions{})
+		if err != nil {
+			return "", "", fmt.Errorf(
+				"failed to get replicaset %s: %w",
+				owner.Name,
+				err,
+			)
+		}
+		if rsOwner := metav1.GetControllerOf(rs); rsOwner != nil &&
+			rsOwner.Kind == "Deployment" {
+			return rsOwner.Kind, rsOwner.Name, nil
+		}
+	}
+	return "", "", nil
+}
+
+func (vex *volumeExcluder) excludeVolumesForWorkload(
+	ctx context.Context,
+	kind, namespace, name string,
+) error {
+	template, err := vex.getPodTemplate(ctx, kind, namespace, name)
+	if err != nil {
+		return err
+	}
+	volumes, err := vex.selectVolumes(ctx, namespace, template.Spec.Volumes)
+	if err != nil {
+		return err
+	}
+	value, changed := mergeVolumeExcludes(
+		template.Annotations[VolumesExcludesAnnotation],
+		volumes,
+	)
+	if !changed {
+		return nil
+	}
+	if vex.opts.DryRun {
+		vex.cus.logger.Info(
+			"Dry run: would add backup volume exclude annotation",
+			"kind", kind,
+			"name", name,
+			"volumes", value,
+		)
+		return nil
+	}
+
+	patchData, err := annotationPatch(value, "spec", "template", "metadata")
+	if err != nil {
+		return err
+	}
+	apps := vex.cus.clientset.AppsV1()
+	if kind == "Deployment" {
+		_, err = apps.Deployments(namespace).Patch(
+			ctx, name, types.MergePatchType, patchData, metav1.PatchOptions{},
+		)
+	} else {
+		_, err = apps.StatefulSets(namespace).Patch(
+			ctx, name, types.MergePatchType, patchData, metav1.PatchOptions{},
+		)
+	}
+	if err != nil {
+		return fmt.Errorf(
+			"failed to add annotation to %s %s: %w",
+			kind,
+			name,
+			err,
+		)
+	}
+
+	vex.cus.logger.Info(
+		"Added backup volume exclude annotation to pod template",
+		"kind", kind,
+		"name", name,
+		"volumes", value,
+	)
+	return nil
+}
+
+func (vex *volumeExcluder) getPodTemplate(
+	ctx context.Context,
+	kind, namespace, name string,
+) (*corev1.PodTemplateSpec, error) {
+	apps := vex.cus.clientset.AppsV1()
+	if kind == "Deployment" {
+		deployment, err := apps.Deployments(namespace).
+			Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return nil, fmt.Errorf("failed to get deployment %s: %w", name, err)
+		}
+		return &deployment.Spec.Template, nil
+	}
+	statefulSet, err := apps.StatefulSets(namespace).
+		Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get statefulset %s: %w", name, err)
+	}
+	return &statefulSet.Spec.Template, nil
+}
+
+func (vex *volumeExcluder) excludeVolumesForPod(
+	ctx context.Context,
+	pod *corev1.Pod,
+) error {
+	volumes, err := vex.selectVolumes(ctx, pod.Namespace, pod.Spec.Volumes)
+	if err != nil {
+		return err
+	}
+	annotationValue, changed := mergeVolumeExcludes(
+		pod.Annotations[VolumesExcludesAnnotation],
+		volumes,
+	)
+	if !changed {
+		return nil // No volumes to exclude
+	}
+	if vex.opts.DryRun {
+		vex.cus.logger.Info(
+			"Dry run: would add backup volume exclude annotation",
+			"pod", pod.Name,
+			"volumes", annotationValue,
+		)
+		return nil
+	}
+
+	patchData, err := annotationPatch(annotationValue, "metadata")
+	if err != nil {
+		return err
+	}
+	_, err = vex.cus.clientset.CoreV1().Pods(pod.Namespace).Patch(
+		ctx,
+		pod.Name,
+		types.MergePatchType,
+		patchData,
+		metav1.PatchOptions{},
+	)
+	if err != nil {
+		return fmt.Errorf(
+			"failed to add annotation to pod %s: %w",
+			pod.Name,
+			err,
+		)
+	}
+
+	vex.cus.logger.Info(
+		"Added backup volume exclude annotation",
+		"pod",
+		pod.Name,
+		"volumes",
+		annotationValue,
+	)
+	return nil
+}
+
+// selectVolumes returns the names of the volumes matching the configured
+// volume types and storage classes
+func (vex *volumeExcluder) selectVolumes(
+	ctx context.Context,
+	namespace string,
+	volumes []corev1.Volume,
+) ([]string, error) {
+	var names []string
+	for _, volume := range volumes {
+		if claim := volume.PersistentVolumeClaim; claim != nil {
+			class, err := vex.claimStorageClass(ctx, namespace, claim.ClaimName)
+			if err != nil {
+				return nil, err
+			}
+			if slices.Contains(vex.opts.StorageClasses, class) {
+				names = append(names, volume.Name)
+			}
+			continue
+		}
+		if slices.Contains(vex.opts.VolumeTypes, volumeType(volume)) {
+			names = append(names, volume.Name)
+		}
+	}
+	return names, nil
+}
+
+func (vex *volumeExcluder) claimStorageClass(
+	ctx context.Context,
+	namespace, claimName string,
+) (string, error) {
+	if len(vex.opts.StorageClasses) == 0 {
+		return "", nil
+	}
+	key := namespace + "/" + claimName
+	if class, ok := vex.storageClasses[key]; ok {
+		return class, nil
+	}
+	pvc, err := vex.cus.clientset.CoreV1().
+		PersistentVolumeClaims(namespace).
+		Get(ctx, claimName, metav1.GetOptions{})
+	if err != nil {
+		return "", fmt.Errorf("failed to get claim %s: %w", claimName, err)
+	}
+	class := ""
+	if pvc.Spec.StorageClassName != nil {
+		class = *pvc.Spec.StorageClassName
+	}
+	vex.storageClasses[key] = class
+	return class, nil
+}
+
+func volumeType(volume corev1.Volume) string {
+	switch {
+	case volume.Secret != nil:
+		return VolumeTypeSecret
+	case volume.ConfigMap != nil:
+		return VolumeTypeConfigMap
+	case volume.Projected != nil:
+		return VolumeTypeProjected
+	case volume.EmptyDir != nil:
+		return VolumeTypeEmptyDir
+	case volume.Ephemeral != nil:
+		return VolumeTypeEphemeral
+	default:
+		return ""
+	}
+}
+
+// mergeVolumeExcludes adds the volumes to the existing comma separated
+// annotation value, keeping its order, and reports whether it changed
+func mergeVolumeExcludes(existing string, volumes []string) (string, bool) {
+	var merged []string
+	for _, name := range strings.Split(existing, ",") {
+		name = strings.TrimSpace(name)
+		if len(name) > 0 && !slices.Contains(merged, name) {
+			merged = append(merged, name)
+		}
+	}
+	changed := false
+	for _, name := range volumes {
+		if !slices.Contains(merged, name) {
+			merged = append(merged, name)
+			changed = true
+		}
+	}
+	return strings.Join(merged, ","), changed
+}
+
+// annotationPatch builds a merge patch setting the volume excludes
+// annotation below the given path
+func annotationPatch(value string, path ...string) ([]byte, error) {
+	patchData, err := json.Marshal(annotationPatchObject(value, path...))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create annotation patch: %w", err)
+	}
+	return patchData, nil
+}
+
+// annotationPatchObject nests the volume excludes annotation below the path
+func annotationPatchObject(value string, path ...string) map[string]any {
+	patch := map[string]any{
+		"annotations": map[string]any{VolumesExcludesAnnotation: value},
+	}
+	for idx := len(path) - 1; idx >= 0; idx-- {
+		patch = map[string]any{path[idx]: patch}
+	}
+	return patch
+}
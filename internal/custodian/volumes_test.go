@@ -0,0 +1,90 @@
+package custodian
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestMergeVolumeExcludes(t *testing.T) {
+	value, changed := mergeVolumeExcludes("cache, data", []string{"data", "tls"})
+	assert.True(t, changed)
+	assert.Equal(t, "cache,data,tls", value)
+
+	value, changed = mergeVolumeExcludes("tls", []string{"tls"})
+	assert.False(t, changed)
+	assert.Equal(t, "tls", value)
+}
+
+func TestExcludeVolumesAnnotatesOwningDeployment(t *testing.T) {
+	isController := true
+	volumes := []corev1.Volume{
+		{
+			Name:         "config",
+			VolumeSource: corev1.VolumeSource{ConfigMap: &corev1.ConfigMapVolumeSource{}},
+		},
+		{
+			Name:         "scratch",
+			VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}},
+		},
+	}
+	deployment := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: "api", Namespace: "dev"},
+		Spec: appsv1.DeploymentSpec{
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Annotations: map[string]string{VolumesExcludesAnnotation: "cache"},
+				},
+				Spec: corev1.PodSpec{Volumes: volumes},
+			},
+		},
+	}
+	replicaSet := &appsv1.ReplicaSet{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "api-123",
+			Namespace: "dev",
+			OwnerReferences: []metav1.OwnerReference{
+				{Kind: "Deployment", Name: "api", Controller: &isController},
+			},
+		},
+	}
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "api-123-abcde",
+			Namespace: "dev",
+			OwnerReferences: []metav1.OwnerReference{
+				{Kind: "ReplicaSet", Name: "api-123", Controller: &isController},
+			},
+		},
+		Spec: corev1.PodSpec{Volumes: volumes},
+	}
+	clientset := fake.NewSimpleClientset(deployment, replicaSet, pod)
+	cus := &Custodian{clientset: clientset, namespace: "dev", logger: testLogger()}
+
+	err := cus.ExcludeVolumesFromBackup(
+		context.Background(),
+		VolumeExcludeOptions{VolumeTypes: []string{VolumeTypeEmptyDir}},
+	)
+	require.NoError(t, err)
+
+	updated, err := clientset.AppsV1().
+		Deployments("dev").
+		Get(context.Background(), "api", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(
+		t,
+		"cache,scratch",
+		updated.Spec.Template.Annotations[VolumesExcludesAnnotation],
+	)
+	unchanged, err := clientset.CoreV1().
+		Pods("dev").
+		Get(context.Background(), pod.Name, metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Empty(t, unchanged.Annotations)
+}
@@ -0,0 +1,292 @@
+package custodian
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// Settings a container is audited for
+const (
+	MissingCPURequest     = "cpu request"
+	MissingMemoryRequest  = "memory request"
+	MissingCPULimit       = "cpu limit"
+	MissingMemoryLimit    = "memory limit"
+	MissingLivenessProbe  = "liveness probe"
+	MissingReadinessProbe = "readiness probe"
+	MissingSecurityCtx    = "security context"
+)
+
+// requestHeadroom is added on top of the highest observed usage when
+// suggesting requests
+const requestHeadroom = 1.2
+
+var podMetricsGVR = schema.GroupVersionResource{
+	Group:    "metrics.k8s.io",
+	Version:  "v1beta1",
+	Resource: "pods",
+}
+
+// ContainerAudit lists the settings missing from a container of a workload
+type ContainerAudit struct {
+	Namespace       string   `json:"namespace"`
+	Kind            string   `json:"kind"`
+	Workload        string   `json:"workload"`
+	Container       string   `json:"container"`
+	Missing         []string `json:"missing"`
+	SuggestedCPU    string   `json:"suggestedCpu,omitempty"`
+	SuggestedMemory string   `json:"suggestedMemory,omitempty"`
+}
+
+// containerUsage is the highest usage observed for a container
+type containerUsage struct {
+	cpu    resource.Quantity
+	memory resource.Quantity
+}
+
+// AuditWorkloads reports the containers of the deployments, statefulsets,
+// daemonsets and cronjobs missing requests, limits, probes or a security
+// context. Missing requests come with a suggestion from the usage reported
+// by the metrics API when it is available.
+func (cus *Custodian) AuditWorkloads(
+	ctx context.Context,
+	namespaces []string,
+) ([]ContainerAudit, error) {
+	if len(namespaces) == 0 {
+		namespaces = []string{metav1.NamespaceAll}
+	}
+	var audits []ContainerAudit
+	for _, namespace := range namespaces {
+		templates, err := cus.podTemplates(ctx, namespace)
+		if err != nil {
+			return nil, err
+		}
+		usage, err := cus.observedUsage(ctx, namespace)
+		if err != nil {
+			return nil, err
+		}
+		for _, tmpl := range templates {
+			for _, container := range tmpl.spec.Containers {
+				missing := missingSettings(tmpl, container)
+				if len(missing) == 0 {
+					continue
+				}
+				audit := ContainerAudit{
+					Namespace: tmpl.namespace,
+					Kind:      tmpl.kind,
+					Workload:  tmpl.name,
+					Container: container.Name,
+					Missing:   missing,
+				}
+				observed, ok := usage[usageKey(tmpl.namespace, tmpl.kind, tmpl.name, container.Name)]
+				if ok {
+					if _, set := container.Resources.Requests[corev1.ResourceCPU]; !set {
+						audit.SuggestedCPU = suggestCPU(observed.cpu)
+					}
+					if _, set := container.Resources.Requests[corev1.ResourceMemory]; !set {
+						audit.SuggestedMemory = suggestMemory(observed.memory)
+					}
+				}
+				audits = append(audits, audit)
+			}
+		}
+	}
+	return audits, nil
+}
+
+func missingSettings(tmpl podTemplate, container corev1.Container) []string {
+	var missing []string
+	resources := []struct {
+		list corev1.ResourceList
+		name corev1.ResourceName
+		desc string
+	}{
+		{container.Resources.Requests, corev1.ResourceCPU, MissingCPURequest},
+		{container.Resources.Requests, corev1.ResourceMemory, MissingMemoryRequest},
+		{container.Resources.Limits, corev1.ResourceCPU, MissingCPULimit},
+		{container.Resources.Limits, corev1.ResourceMemory, MissingMemoryLimit},
+	}
+	for _, res := range resources {
+		if _, ok := res.list[res.name]; !ok {
+			missing = append(missing, res.desc)
+		}
+	}
+	// jobs run to completion, probes only make sense for long running pods
+	if tmpl.kind != "CronJob" {
+		if container.LivenessProbe == nil {
+			missing = append(missing, MissingLivenessProbe)
+		}
+		if container.ReadinessProbe == nil {
+			missing = append(missing, MissingReadinessProbe)
+		}
+	}
+	if container.SecurityContext == nil && !podSecuritySet(tmpl.spec.SecurityContext) {
+		missing = append(missing, MissingSecurityCtx)
+	}
+	return missing
+}
+
+// podSecuritySet tells whether the pod security context restricts the user
+// or the syscalls of its containers
+func podSecuritySet(psc *corev1.PodSecurityContext) bool {
+	return psc != nil &&
+		(psc.RunAsNonRoot != nil || psc.RunAsUser != nil || psc.SeccompProfile != nil)
+}
+
+func usageKey(namespace, kind, workload, container string) string {
+	return strings.Join([]string{namespace, kind, workload, container}, "/")
+}
+
+// observedUsage collects the highest usage per workload container from the
+// pod metrics, an unavailable metrics API gives no usage
+func (cus *Custodian) observedUsage(
+	ctx context.Context,
+	namespace string,
+) (map[string]containerUsage, error) {
+	metrics, err := cus.dynamicClient.Resource(podMetricsGVR).
+		Namespace(namespace).
+		List(ctx, metav1.ListOptions{})
+	if err != nil {
+		cus.logger.Warn(
+			"Pod metrics not available, requests will not be suggested",
+			"namespace", namespace,
+			"error", err,
+		)
+		return nil, nil
+	}
+	usage := make(map[string]containerUsage)
+	for _, item := range metrics.Items {
+		pod, err := cus.clientset.CoreV1().
+			Pods(item.GetNamespace()).
+			Get(ctx, item.GetName(), metav1.GetOptions{})
+		if err != nil {
+			cus.logger.Debug("Skipping metrics of missing pod", "pod", item.GetName())
+			continue
+		}
+		kind, name, err := cus.workloadOf(ctx, pod)
+		if err != nil {
+			// the replicaset of a pod goes away at the end of a rollout
+			if apierrors.IsNotFound(err) {
+				cus.logger.Debug("Skipping metrics of pod without owner", "pod", pod.Name)
+				continue
+			}
+			return nil, err
+		}
+		if kind == "" {
+			continue
+		}
+		containers, _, _ := unstructured.NestedSlice(item.Object, "containers")
+		for _, entry := range containers {
+			container, ok := entry.(map[string]any)
+			if !ok {
+				continue
+			}
+			cname, _, _ := unstructured.NestedString(container, "name")
+			key := usageKey(pod.Namespace, kind, name, cname)
+			observed := usage[key]
+			observed.cpu = maxQuantity(observed.cpu, container, "cpu")
+			observed.memory = maxQuantity(observed.memory, container, "memory")
+			usage[key] = observed
+		}
+	}
+	return usage, nil
+}
+
+// workloadOf returns the kind and name of the workload managing the pod
+func (cus *Custodian) workloadOf(
+	ctx context.Context,
+	pod *corev1.Pod,
+) (string, string, error) {
+	if owner := metav1.GetControllerOf(pod); owner != nil && owner.Kind == "DaemonSet" {
+		return owner.Kind, owner.Name, nil
+	}
+	return cus.podTemplateOwner(ctx, pod)
+}
+
+func maxQuantity(current resource.Quantity, container map[string]any, name string) resource.Quantity {
+	value, _, _ := unstructured.NestedString(container, "usage", name)
+	observed, err := resource.ParseQuantity(value)
+	if err != nil || observed.Cmp(current) <= 0 {
+		return current
+	}
+	return observed
+}
+
+// suggestCPU adds headroom to the usage, rounded up to 10 millicores
+func suggestCPU(usage resource.Quantity) string {
+	millis := int64(float64(usage.MilliValue()) * requestHeadroom)
+	millis = max((millis+9)/10*10, 10)
+	return resource.NewMilliQuantity(millis, resource.DecimalSI).String()
+}
+
+// suggestMemory adds headroom to the usage, rounded up to 16Mi
+func suggestMemory(usage resource.Quantity) string {
+	const chunk = 16 << 20
+	bytes := int64(float64(usage.Value()) * requestHeadroom)
+	bytes = max((bytes+chunk-1)/chunk*chunk, chunk)
+	return resource.NewQuantity(bytes, resource.BinarySI).String()
+}
+
+// WriteWorkloadAudit renders the audit, the markdown format is a task list
+// with one item per missing setting
+func WriteWorkloadAudit(out io.Writer, format string, audits []ContainerAudit) error {
+	switch format {
+	case FormatJSON:
+		if audits == nil {
+			audits = []ContainerAudit{}
+		}
+		return writeJSON(out, audits)
+	case FormatMarkdown:
+		return writeAuditChecklist(out, audits)
+	}
+	rows := make([][]string, 0, len(audits))
+	for _, audit := range audits {
+		rows = append(rows, []string{
+			audit.Namespace,
+			audit.Kind,
+			audit.Workload,
+			audit.Container,
+			strings.Join(audit.Missing, ","),
+			valueOrDash(audit.SuggestedCPU),
+			valueOrDash(audit.SuggestedMemory),
+		})
+	}
+	return writeTable(out, format, []string{
+		"NAMESPACE", "KIND", "WORKLOAD", "CONTAINER", "MISSING",
+		"SUGGESTED CPU", "SUGGESTED MEMORY",
+	}, rows)
+}
+
+func writeAuditChecklist(out io.Writer, audits []ContainerAudit) error {
+	var lines []string
+	for _, audit := range audits {
+		lines = append(lines, fmt.Sprintf(
+			"### %s/%s %s container %s",
+			audit.Namespace,
+			audit.Kind,
+			audit.Workload,
+			audit.Container,
+		))
+		for _, missing := range audit.Missing {
+			item := "- [ ] set " + missing
+			switch {
+			case missing == MissingCPURequest && audit.SuggestedCPU != "":
+				item += fmt.Sprintf(" (suggested %s)", audit.SuggestedCPU)
+			case missing == MissingMemoryRequest && audit.SuggestedMemory != "":
+				item += fmt.Sprintf(" (suggested %s)", audit.SuggestedMemory)
+			}
+			lines = append(lines, item)
+		}
+		lines = append(lines, "")
+	}
+	_, err := fmt.Fprint(out, strings.Join(lines, "\n"))
+	return err
+}
@@ -0,0 +1,107 @@
+package custodian
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestAuditWorkloads(t *testing.T) {
+	isController := true
+	deployment := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: "graphql-server", Namespace: "dev"},
+		Spec: appsv1.DeploymentSpec{Template: corev1.PodTemplateSpec{
+			Spec: corev1.PodSpec{Containers: []corev1.Container{{
+				Name: "api",
+				Resources: corev1.ResourceRequirements{
+					Limits: corev1.ResourceList{
+						corev1.ResourceMemory: resource.MustParse("512Mi"),
+					},
+				},
+				ReadinessProbe: &corev1.Probe{},
+			}}},
+		}},
+	}
+	replicaSet := &appsv1.ReplicaSet{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "graphql-server-7d9f",
+			Namespace: "dev",
+			OwnerReferences: []metav1.OwnerReference{{
+				Kind:       "Deployment",
+				Name:       "graphql-server",
+				Controller: &isController,
+			}},
+		},
+	}
+	pod := func(name string) *corev1.Pod {
+		return &corev1.Pod{ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: "dev",
+			OwnerReferences: []metav1.OwnerReference{{
+				Kind:       "ReplicaSet",
+				Name:       "graphql-server-7d9f",
+				Controller: &isController,
+			}},
+		}}
+	}
+	podMetrics := func(name, cpu, memory string) *unstructured.Unstructured {
+		return &unstructured.Unstructured{Object: map[string]any{
+			"apiVersion": "metrics.k8s.io/v1beta1",
+			"kind":       "PodMetrics",
+			"metadata":   map[string]any{"name": name, "namespace": "dev"},
+			"containers": []any{map[string]any{
+				"name":  "api",
+				"usage": map[string]any{"cpu": cpu, "memory": memory},
+			}},
+		}}
+	}
+	// the replicaset of the previous rollout is already deleted
+	previous := pod("graphql-server-5c1a-c")
+	previous.OwnerReferences[0].Name = "graphql-server-5c1a"
+	clientset := fake.NewSimpleClientset(
+		deployment, replicaSet, pod("graphql-server-7d9f-a"), pod("graphql-server-7d9f-b"), previous,
+	)
+	dynamicClient := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(
+		runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{podMetricsGVR: "PodMetricsList"},
+	)
+	// the fake guesses podmetrics as resource from the kind, metrics are
+	// served as pods
+	for _, obj := range []*unstructured.Unstructured{
+		podMetrics("graphql-server-7d9f-a", "41m", "100Mi"),
+		podMetrics("graphql-server-7d9f-b", "12m", "180Mi"),
+		podMetrics("graphql-server-5c1a-c", "900m", "2Gi"),
+	} {
+		require.NoError(t, dynamicClient.Tracker().Create(podMetricsGVR, obj, "dev"))
+	}
+	cus := &Custodian{clientset: clientset, dynamicClient: dynamicClient, logger: testLogger()}
+
+	audits, err := cus.AuditWorkloads(context.Background(), []string{"dev"})
+	require.NoError(t, err)
+	require.Len(t, audits, 1)
+	assert.Equal(t, []string{
+		MissingCPURequest,
+		MissingMemoryRequest,
+		MissingCPULimit,
+		MissingLivenessProbe,
+		MissingSecurityCtx,
+	}, audits[0].Missing)
+	assert.Equal(t, "50m", audits[0].SuggestedCPU)
+	assert.Equal(t, "224Mi", audits[0].SuggestedMemory)
+
+	var out bytes.Buffer
+	require.NoError(t, WriteWorkloadAudit(&out, FormatMarkdown, audits))
+	assert.Contains(t, out.String(), "- [ ] set cpu request (suggested 50m)")
+}
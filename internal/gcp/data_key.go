@@ -0,0 +1,261 @@
+package gcp
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log/slog"
+	"os"
+	"path"
+	"strings"
+
+	kms "cloud.google.com/go/kms/apiv1"
+	"cloud.google.com/go/kms/apiv1/kmspb"
+	"github.com/urfave/cli/v2"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+// dataKeySize is the number of random bytes used for a generated data key
+const dataKeySize = 32
+
+// DataKeyParams identifies the KMS key used to wrap and unwrap data keys
+type DataKeyParams struct {
+	Ctx     context.Context
+	Client  *kms.KeyManagementClient
+	KeyName string
+}
+
+// CryptoKeyName returns the full resource name of a KMS crypto key
+func CryptoKeyName(projectID, location, keyringName, keyName string) string {
+	return fmt.Sprintf(
+		"projects/%s/locations/%s/keyRings/%s/cryptoKeys/%s",
+		projectID,
+		location,
+		keyringName,
+		keyName,
+	)
+}
+
+// GenerateDataKey creates a random data key, wraps it with a KMS key and
+// writes the base64 encoded ciphertext to the output file
+func GenerateDataKey(cltx *cli.Context) error {
+	dataKey, err := newDataKey()
+	if err != nil {
+		return err
+	}
+	return wrapAndWrite(cltx, dataKey)
+}
+
+// WrapDataKey wraps an existing secret, such as a restic password, with a
+// KMS key and writes the base64 encoded ciphertext to the output file
+func WrapDataKey(cltx *cli.Context) error {
+	plaintext, err := readPlaintext(cltx.String("input"))
+	if err != nil {
+		return err
+	}
+	return wrapAndWrite(cltx, plaintext)
+}
+
+// RotateDataKey re-encrypts a wrapped data key with the primary version of
+// the KMS key, optionally creating a new primary version first. The data key
+// itself is unchanged, so anything protected by it stays readable.
+func RotateDataKey(cltx *cli.Context) error {
+	ctx := context.Background()
+	client, err := NewKMSClient(ctx, cltx.String("credentials"))
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	params := DataKeyParams{
+		Ctx:     ctx,
+		Client:  client,
+		KeyName: keyNameFromFlags(cltx),
+	}
+	file := cltx.String("file")
+	ciphertext, err := ReadWrappedDataKey(file)
+	if err != nil {
+		return err
+	}
+	plaintext, err := UnwrapDataKey(params, ciphertext)
+	if err != nil {
+		return err
+	}
+
+	if cltx.Bool("new-key-version") {
+		if err := rotateKeyVersion(params); err != nil {
+			return err
+		}
+	}
+
+	rewrapped, err := EncryptDataKey(params, plaintext)
+	if err != nil {
+		return err
+	}
+	if err := writeWrappedDataKey(file, rewrapped); err != nil {
+		return err
+	}
+	slog.Info("Rewrapped data key", "key", params.KeyName, "file", file)
+	return nil
+}
+
+// EncryptDataKey wraps the plaintext with the primary version of the KMS key
+func EncryptDataKey(params DataKeyParams, plaintext []byte) ([]byte, error) {
+	resp, err := params.Client.Encrypt(params.Ctx, &kmspb.EncryptRequest{
+		Name:            params.KeyName,
+		Plaintext:       plaintext,
+		PlaintextCrc32C: wrapperspb.Int64(checksum(plaintext)),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to encrypt data key: %w", err)
+	}
+	if !resp.VerifiedPlaintextCrc32C {
+		return nil, fmt.Errorf("encrypt request corrupted in transit")
+	}
+	if resp.CiphertextCrc32C == nil ||
+		resp.CiphertextCrc32C.Value != checksum(resp.Ciphertext) {
+		return nil, fmt.Errorf("encrypt response corrupted in transit")
+	}
+	return resp.Ciphertext, nil
+}
+
+// UnwrapDataKey decrypts a data key that was wrapped with the KMS key
+func UnwrapDataKey(params DataKeyParams, ciphertext []byte) ([]byte, error) {
+	resp, err := params.Client.Decrypt(params.Ctx, &kmspb.DecryptRequest{
+		Name:             params.KeyName,
+		Ciphertext:       ciphertext,
+		CiphertextCrc32C: wrapperspb.Int64(checksum(ciphertext)),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt data key: %w", err)
+	}
+	if resp.PlaintextCrc32C == nil ||
+		resp.PlaintextCrc32C.Value != checksum(resp.Plaintext) {
+		return nil, fmt.Errorf("decrypt response corrupted in transit")
+	}
+	return resp.Plaintext, nil
+}
+
+// ReadWrappedDataKey reads a base64 encoded wrapped data key from a file
+func ReadWrappedDataKey(file string) ([]byte, error) {
+	content, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read wrapped data key: %w", err)
+	}
+	return DecodeWrappedDataKey(string(content))
+}
+
+// DecodeWrappedDataKey decodes a base64 encoded wrapped data key
+func DecodeWrappedDataKey(encoded string) ([]byte, error) {
+	ciphertext, err := base64.StdEncoding.DecodeString(
+		strings.TrimSpace(encoded),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode wrapped data key: %w", err)
+	}
+	return ciphertext, nil
+}
+
+func wrapAndWrite(cltx *cli.Context, plaintext []byte) error {
+	ctx := context.Background()
+	client, err := NewKMSClient(ctx, cltx.String("credentials"))
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	params := DataKeyParams{
+		Ctx:     ctx,
+		Client:  client,
+		KeyName: keyNameFromFlags(cltx),
+	}
+	ciphertext, err := EncryptDataKey(params, plaintext)
+	if err != nil {
+		return err
+	}
+	output := cltx.String("output")
+	if err := writeWrappedDataKey(output, ciphertext); err != nil {
+		return err
+	}
+	slog.Info("Wrote wrapped data key", "key", params.KeyName, "file", output)
+	return nil
+}
+
+func rotateKeyVersion(params DataKeyParams) error {
+	version, err := params.Client.CreateCryptoKeyVersion(
+		params.Ctx,
+		&kmspb.CreateCryptoKeyVersionRequest{
+			Parent:           params.KeyName,
+			CryptoKeyVersion: &kmspb.CryptoKeyVersion{},
+		},
+	)
+	if err != nil {
+		return fmt.Errorf("failed to create key version: %w", err)
+	}
+	_, err = params.Client.UpdateCryptoKeyPrimaryVersion(
+		params.Ctx,
+		&kmspb.UpdateCryptoKeyPrimaryVersionRequest{
+			Name:               params.KeyName,
+			CryptoKeyVersionId: path.Base(version.Name),
+		},
+	)
+	if err != nil {
+		return fmt.Errorf("failed to set primary key version: %w", err)
+	}
+	slog.Info("Created new primary key version", "version", version.Name)
+	return nil
+}
+
+func keyNameFromFlags(cltx *cli.Context) string {
+	return CryptoKeyName(
+		cltx.String("project-id"),
+		cltx.String("location"),
+		cltx.String("keyring-name"),
+		cltx.String("key-name"),
+	)
+}
+
+// newDataKey returns random bytes encoded as base64 text, so the data key can
+// be used directly as a password
+func newDataKey() ([]byte, error) {
+	raw := make([]byte, dataKeySize)
+	if _, err := rand.Read(raw); err != nil {
+		return nil, fmt.Errorf("failed to generate data key: %w", err)
+	}
+	return []byte(base64.RawURLEncoding.EncodeToString(raw)), nil
+}
+
+func readPlaintext(input string) ([]byte, error) {
+	var (
+		content []byte
+		err     error
+	)
+	if input == "-" {
+		content, err = io.ReadAll(os.Stdin)
+	} else {
+		content, err = os.ReadFile(input)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read plaintext: %w", err)
+	}
+	plaintext := []byte(strings.TrimRight(string(content), "\r\n"))
+	if len(plaintext) == 0 {
+		return nil, fmt.Errorf("plaintext is empty")
+	}
+	return plaintext, nil
+}
+
+func writeWrappedDataKey(file string, ciphertext []byte) error {
+	encoded := base64.StdEncoding.EncodeToString(ciphertext) + "\n"
+	if err := os.WriteFile(file, []byte(encoded), 0o600); err != nil {
+		return fmt.Errorf("failed to write wrapped data key: %w", err)
+	}
+	return nil
+}
+
+func checksum(data []byte) int64 {
+	return int64(crc32.Checksum(data, crc32.MakeTable(crc32.Castagnoli)))
+}
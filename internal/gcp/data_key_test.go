@@ -0,0 +1,172 @@
+package gcp
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"cloud.google.com/go/kms/apiv1/kmspb"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/api/option"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+// fakeKMSServer wraps data by prefixing it with the primary key version
+type fakeKMSServer struct {
+	kmspb.UnimplementedKeyManagementServiceServer
+	mu       sync.Mutex
+	versions int
+	primary  int
+}
+
+func newFakeKMSServer() *fakeKMSServer {
+	return &fakeKMSServer{versions: 1, primary: 1}
+}
+
+func (srv *fakeKMSServer) Encrypt(
+	_ context.Context,
+	req *kmspb.EncryptRequest,
+) (*kmspb.EncryptResponse, error) {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	ciphertext := append(
+		[]byte(fmt.Sprintf("v%d:", srv.primary)),
+		req.Plaintext...,
+	)
+	return &kmspb.EncryptResponse{
+		Name:                    fmt.Sprintf("%s/cryptoKeyVersions/%d", req.Name, srv.primary),
+		Ciphertext:              ciphertext,
+		CiphertextCrc32C:        wrapperspb.Int64(checksum(ciphertext)),
+		VerifiedPlaintextCrc32C: req.PlaintextCrc32C.GetValue() == checksum(req.Plaintext),
+	}, nil
+}
+
+func (srv *fakeKMSServer) Decrypt(
+	_ context.Context,
+	req *kmspb.DecryptRequest,
+) (*kmspb.DecryptResponse, error) {
+	_, plaintext, found := bytes.Cut(req.Ciphertext, []byte(":"))
+	if !found {
+		return nil, status.Error(codes.InvalidArgument, "invalid ciphertext")
+	}
+	return &kmspb.DecryptResponse{
+		Plaintext:       plaintext,
+		PlaintextCrc32C: wrapperspb.Int64(checksum(plaintext)),
+	}, nil
+}
+
+func (srv *fakeKMSServer) CreateCryptoKeyVersion(
+	_ context.Context,
+	req *kmspb.CreateCryptoKeyVersionRequest,
+) (*kmspb.CryptoKeyVersion, error) {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	srv.versions++
+	return &kmspb.CryptoKeyVersion{
+		Name: fmt.Sprintf("%s/cryptoKeyVersions/%d", req.Parent, srv.versions),
+	}, nil
+}
+
+func (srv *fakeKMSServer) UpdateCryptoKeyPrimaryVersion(
+	_ context.Context,
+	req *kmspb.UpdateCryptoKeyPrimaryVersionRequest,
+) (*kmspb.CryptoKey, error) {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	if _, err := fmt.Sscanf(req.CryptoKeyVersionId, "%d", &srv.primary); err != nil {
+		return nil, status.Error(codes.InvalidArgument, "invalid version")
+	}
+	return &kmspb.CryptoKey{Name: req.Name}, nil
+}
+
+func setupFakeKMS(t *testing.T, srv *fakeKMSServer) DataKeyParams {
+	t.Helper()
+	listener := bufconn.Listen(1024 * 1024)
+	grpcServer := grpc.NewServer()
+	kmspb.RegisterKeyManagementServiceServer(grpcServer, srv)
+	go func() {
+		_ = grpcServer.Serve(listener)
+	}()
+	t.Cleanup(grpcServer.Stop)
+
+	conn, err := grpc.NewClient(
+		"passthrough:///bufnet",
+		grpc.WithContextDialer(
+			func(ctx context.Context, _ string) (net.Conn, error) {
+				return listener.DialContext(ctx)
+			},
+		),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	client, err := NewKMSClient(ctx, "", option.WithGRPCConn(conn))
+	require.NoError(t, err)
+	t.Cleanup(func() { client.Close() })
+
+	return DataKeyParams{
+		Ctx:     ctx,
+		Client:  client,
+		KeyName: CryptoKeyName("project", "us-central1", "keyring", "restic"),
+	}
+}
+
+func TestWrapAndUnwrapDataKey(t *testing.T) {
+	params := setupFakeKMS(t, newFakeKMSServer())
+	dataKey, err := newDataKey()
+	require.NoError(t, err)
+
+	ciphertext, err := EncryptDataKey(params, dataKey)
+	require.NoError(t, err)
+	assert.NotEqual(t, dataKey, ciphertext)
+
+	file := filepath.Join(t.TempDir(), "restic.key")
+	require.NoError(t, writeWrappedDataKey(file, ciphertext))
+	stored, err := ReadWrappedDataKey(file)
+	require.NoError(t, err)
+
+	plaintext, err := UnwrapDataKey(params, stored)
+	require.NoError(t, err)
+	assert.Equal(t, dataKey, plaintext)
+}
+
+func TestRotateKeyVersionRewrapsDataKey(t *testing.T) {
+	params := setupFakeKMS(t, newFakeKMSServer())
+	dataKey := []byte("static-restic-password")
+
+	ciphertext, err := EncryptDataKey(params, dataKey)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(string(ciphertext), "v1:"))
+
+	require.NoError(t, rotateKeyVersion(params))
+	rewrapped, err := EncryptDataKey(params, dataKey)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(string(rewrapped), "v2:"))
+
+	for _, wrapped := range [][]byte{ciphertext, rewrapped} {
+		plaintext, err := UnwrapDataKey(params, wrapped)
+		require.NoError(t, err)
+		assert.Equal(t, dataKey, plaintext)
+	}
+}
+
+func TestDecodeWrappedDataKey(t *testing.T) {
+	_, err := DecodeWrappedDataKey("not base64!")
+	assert.Error(t, err)
+
+	decoded, err := DecodeWrappedDataKey("dGVzdA==\n")
+	require.NoError(t, err)
+	assert.Equal(t, []byte("test"), decoded)
+}
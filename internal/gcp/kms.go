@@ -46,7 +46,7 @@ func CreateKeyringAndKey(cltx *cli.Context) error {
 		CredentialsFile: cltx.String("credentials"),
 	}
 
-	client, err := createKMSClient(ctx, params.CredentialsFile)
+	client, err := NewKMSClient(ctx, params.CredentialsFile)
 	if err != nil {
 		return err
 	}
@@ -84,14 +84,17 @@ func CreateKeyringAndKey(cltx *cli.Context) error {
 	return nil
 }
 
-func createKMSClient(
+// NewKMSClient creates a KMS client, using the credentials file when given
+// and the application default credentials otherwise
+func NewKMSClient(
 	ctx context.Context,
 	credentialsFile string,
+	opts ...option.ClientOption,
 ) (*kms.KeyManagementClient, error) {
-	client, err := kms.NewKeyManagementClient(
-		ctx,
-		option.WithCredentialsFile(credentialsFile),
-	)
+	if len(credentialsFile) > 0 {
+		opts = append(opts, option.WithCredentialsFile(credentialsFile))
+	}
+	client, err := kms.NewKeyManagementClient(ctx, opts...)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create KMS client: %v", err)
 	}